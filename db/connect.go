@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"golangphonebook/internal"
+	"golangphonebook/pkg/config"
+	"math/rand/v2"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Waits between connection attempts at startup, doubling from the first to the last
+const (
+	minConnectBackoff = 500 * time.Millisecond
+	maxConnectBackoff = 10 * time.Second
+)
+
+// Open the connection pool and wait for Postgres to answer, retrying with exponential backoff until
+// cfg.StartupTimeout has passed. Postgres often starts after the server when they are deployed together.
+func connect(cfg config.Database, gormConfig *gorm.Config) (*gorm.DB, error) {
+	// Connections are only made once they're needed, the ping below makes the first one
+	gormConfig.DisableAutomaticPing = true
+	db, err := gorm.Open(postgres.Open(cfg.DSN()), gormConfig)
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	deadline := time.Now().Add(cfg.StartupTimeout)
+	for attempt := 1; ; attempt++ {
+		err := ping(context.Background(), sqlDB, cfg.ConnectTimeout)
+		if err == nil {
+			if attempt > 1 {
+				internal.Logger.Info(fmt.Sprintf("Connected to the database after %d attempts", attempt))
+			}
+			return db, nil
+		}
+		wait := connectBackoff(attempt)
+		if time.Now().Add(wait).After(deadline) {
+			sqlDB.Close()
+			return nil, fmt.Errorf("gave up connecting to the database after %d attempts: %w", attempt, err)
+		}
+		internal.Logger.Warn(fmt.Sprintf("Database not reachable on attempt %d, retrying in %s: %v", attempt, wait.Round(time.Millisecond), err))
+		time.Sleep(wait)
+	}
+}
+
+// Wait after the failed attempt, minus up to a fifth at random so instances started together don't retry in step
+func connectBackoff(attempt int) time.Duration {
+	wait := minConnectBackoff
+	for i := 1; i < attempt && wait < maxConnectBackoff; i++ {
+		wait *= 2
+	}
+	wait = min(wait, maxConnectBackoff)
+	return wait - time.Duration(rand.Int64N(int64(wait)/5+1))
+}
+
+func ping(ctx context.Context, sqlDB *sql.DB, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return sqlDB.PingContext(ctx)
+}
+
+// Ping the database every interval until ctx ends, logging when it stops and starts answering again.
+// A connection Postgres dropped fails the ping and is replaced by the pool with a new one, so the server
+// reconnects by itself once the database is back rather than on the next request.
+func Monitor(ctx context.Context, db *gorm.DB, interval, timeout time.Duration) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		internal.Logger.ErrorContext(ctx, fmt.Sprintf("Can't health-check database connections: %v", err))
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var lostAt time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := ping(ctx, sqlDB, timeout)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil && lostAt.IsZero():
+			lostAt = time.Now()
+			internal.Logger.WarnContext(ctx, fmt.Sprintf("Lost the database connection, reconnecting: %v", err))
+		case err == nil && !lostAt.IsZero():
+			internal.Logger.InfoContext(ctx, fmt.Sprintf("Reconnected to the database after %s", time.Since(lostAt).Round(time.Second)))
+			lostAt = time.Time{}
+		}
+	}
+}
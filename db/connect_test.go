@@ -0,0 +1,32 @@
+package db
+
+import (
+	"golangphonebook/pkg/config"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestConnectBackoff(t *testing.T) {
+	for attempt, want := range map[int]time.Duration{1: 500 * time.Millisecond, 2: time.Second, 4: 4 * time.Second, 6: 10 * time.Second, 50: 10 * time.Second} {
+		wait := connectBackoff(attempt)
+		assert.LessOrEqual(t, wait, want, "attempt %d", attempt)
+		assert.GreaterOrEqual(t, wait, want*4/5, "attempt %d", attempt)
+	}
+}
+
+func TestConnectGivesUp(t *testing.T) {
+	cfg := config.Default().DB
+	cfg.Host = "127.0.0.1"
+	cfg.Port = 1 // Nothing listens there
+	cfg.StartupTimeout = 0
+
+	start := time.Now()
+	_, err := connect(cfg, &gorm.Config{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "after 1 attempts")
+	assert.Less(t, time.Since(start), cfg.ConnectTimeout, "no retries without a startup timeout")
+}
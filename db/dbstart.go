@@ -1,41 +1,63 @@
 package db
 
 import (
+	"context"
 	"fmt"
 	"golangphonebook/internal"
+	"golangphonebook/pkg/config"
 	"golangphonebook/pkg/contacts"
-	"os"
 
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
-func DBInit() (*gorm.DB, error) {
-	// Set up PostgreSQL connection
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
-	dbUser := os.Getenv("DB_USER")
-	dbPassword := os.Getenv("DB_PASSWORD")
-	dbName := os.Getenv("DB_NAME")
-
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		dbHost, dbPort, dbUser, dbPassword, dbName)
-
-	db, err := gorm.Open(postgres.Open(connStr), &gorm.Config{})
+// Whether every migration is applied, for the readiness probe
+func MigrationStatus(ctx context.Context, db *gorm.DB) error {
+	return Migrator(db).Check(ctx)
+}
 
+// Connect to the database without touching the schema, for the migrate command
+func Open(cfg config.Database) (*gorm.DB, error) {
+	db, err := connect(cfg, &gorm.Config{Logger: sqlLogger{level: logger.Warn}})
 	if err != nil {
 		internal.Logger.Error(fmt.Sprintf("Failed to connect to DB with error: %v", err))
 		return nil, err
 	}
-	db.Logger.LogMode(logger.Info)
-	err = db.AutoMigrate(&contacts.Contact{})
+	return db, nil
+}
+
+// Connect to the database and, unless cfg.AutoMigrate is off, apply the pending migrations
+func DBInit(cfg config.Database) (*gorm.DB, error) {
+	db, err := Open(cfg)
 	if err != nil {
-		internal.Logger.Error(fmt.Sprintf("Error migrating schema: %v\n", err))
+		return nil, err
 	}
 
-	internal.Logger.Info("Database schema created successfully.")
+	if cfg.AutoMigrate {
+		applied, err := Migrator(db).Up(context.Background())
+		if err != nil {
+			internal.Logger.Error(fmt.Sprintf("Error migrating schema: %v", err))
+			return nil, err
+		}
+		if applied > 0 {
+			internal.Logger.Info(fmt.Sprintf("Applied %d schema migrations", applied))
+		}
+	} else if err := MigrationStatus(context.Background(), db); err != nil {
+		// Readiness reports the same until the migrate command has run
+		internal.Logger.Warn(fmt.Sprintf("Schema isn't up to date, run the migrate command: %v", err))
+	}
 
-	return db, nil
+	// Contacts from before address books existed are only visible once they're given to a tenant
+	if legacyTenant := cfg.LegacyTenant; legacyTenant != "" {
+		assigned, err := contacts.AssignUnownedContacts(db, legacyTenant)
+		if err != nil {
+			internal.Logger.Error(fmt.Sprintf("Failed to assign unowned contacts to tenant %q: %v", legacyTenant, err))
+		} else if assigned > 0 {
+			internal.Logger.Info(fmt.Sprintf("Assigned %d unowned contacts to tenant %q", assigned, legacyTenant))
+		}
+	} else if unowned, err := contacts.CountUnownedContacts(db); err == nil && unowned > 0 {
+		internal.Logger.Warn(fmt.Sprintf("%d contacts don't belong to any address book, set LEGACY_TENANT to assign them to a tenant", unowned))
+	}
 
+	return db, nil
 }
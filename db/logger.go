@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golangphonebook/internal"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Queries slower than this are logged as warnings
+const slowQueryThreshold = 200 * time.Millisecond
+
+// Writes gorm's logs through internal.Logger, so SQL lines get the configured format, level and request fields
+// like every other line. Failed and slow queries are logged, and every query at debug level.
+type sqlLogger struct {
+	level logger.LogLevel
+}
+
+var _ logger.Interface = sqlLogger{}
+
+func (l sqlLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return sqlLogger{level: level}
+}
+
+func (l sqlLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= logger.Info {
+		internal.Logger.InfoContext(ctx, fmt.Sprintf(msg, data...))
+	}
+}
+
+func (l sqlLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= logger.Warn {
+		internal.Logger.WarnContext(ctx, fmt.Sprintf(msg, data...))
+	}
+}
+
+func (l sqlLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= logger.Error {
+		internal.Logger.ErrorContext(ctx, fmt.Sprintf(msg, data...))
+	}
+}
+
+func (l sqlLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if l.level <= logger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	level, msg := slog.LevelDebug, "Query ran"
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && l.level >= logger.Error:
+		level, msg = slog.LevelError, "Query failed"
+	case elapsed > slowQueryThreshold && l.level >= logger.Warn:
+		level, msg = slog.LevelWarn, "Slow query"
+	}
+	log := internal.Logger.Slog()
+	if !log.Enabled(ctx, level) {
+		return
+	}
+
+	sql, rows := fc()
+	attrs := []any{"sql", sql, "rows", rows, "duration_ms", elapsed.Milliseconds()}
+	if err != nil {
+		attrs = append(attrs, "error", err.Error())
+	}
+	log.Log(ctx, level, msg, attrs...)
+}
+
+// SQL values stay out of the logs unless redaction is switched off
+func (l sqlLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	if internal.Logger.Redaction.Disabled() {
+		return sql, params
+	}
+	return sql, nil
+}
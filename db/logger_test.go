@@ -0,0 +1,57 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"golangphonebook/internal"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestSQLLogger(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, internal.Logger.Configure(internal.FormatText, &out, &out))
+	t.Cleanup(func() { _ = internal.Logger.Configure(internal.FormatText, os.Stdout, os.Stderr) })
+
+	ctx := internal.WithLogFields(context.Background(), "request_id", "req-1")
+	query := func() (string, int64) { return "SELECT * FROM contacts", 2 }
+	log := sqlLogger{}.LogMode(logger.Warn)
+
+	log.Trace(ctx, time.Now(), query, nil)
+	log.Trace(ctx, time.Now(), query, gorm.ErrRecordNotFound)
+	assert.Empty(t, out.String(), "fast and not found queries are only logged at debug level")
+
+	log.Trace(ctx, time.Now().Add(-time.Second), query, nil)
+	assert.Contains(t, out.String(), `level=WARN msg="Slow query"`)
+	assert.Contains(t, out.String(), `sql="SELECT * FROM contacts" rows=2`)
+	assert.Contains(t, out.String(), "request_id=req-1")
+
+	out.Reset()
+	log.Trace(ctx, time.Now(), query, errors.New("connection reset"))
+	assert.Contains(t, out.String(), `level=ERROR msg="Query failed"`)
+	assert.Contains(t, out.String(), `error="connection reset"`)
+
+	out.Reset()
+	sqlLogger{}.LogMode(logger.Silent).Trace(ctx, time.Now(), query, errors.New("connection reset"))
+	assert.Empty(t, out.String())
+}
+
+func TestSQLLoggerParams(t *testing.T) {
+	redaction := internal.Logger.Redaction
+	t.Cleanup(func() { internal.Logger.Redaction = redaction })
+
+	internal.Logger.Redaction = internal.RedactionPolicy{Default: internal.RedactStrict}
+	_, params := sqlLogger{}.ParamsFilter(context.Background(), "SELECT $1", "Alice")
+	assert.Empty(t, params, "values stay out of the logs")
+
+	internal.Logger.Redaction = internal.RedactionPolicy{Default: internal.RedactOff}
+	_, params = sqlLogger{}.ParamsFilter(context.Background(), "SELECT $1", "Alice")
+	assert.Equal(t, []interface{}{"Alice"}, params)
+}
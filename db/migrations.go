@@ -0,0 +1,125 @@
+package db
+
+import (
+	"golangphonebook/pkg/migrate"
+
+	"gorm.io/gorm"
+)
+
+// The schema, one migration per change and never edited once released. A change to a model ships as a new
+// migration at the end of the list.
+//
+// Databases from before migrations were set up by AutoMigrate. Migrations up to 4 only create what is
+// missing, so those are taken over as they are, from any earlier version.
+var migrations = []migrate.Migration{
+	{
+		Version: 1,
+		Name:    "initial_schema",
+		Up: `
+CREATE TABLE IF NOT EXISTS "contacts" ("id" bigserial,"first_name" text NOT NULL,"last_name" text,"phone" text,"address" text,"last_modified" timestamptz,PRIMARY KEY ("id"));
+-- Added to contacts after the first version, and widened to text for encrypted values
+ALTER TABLE "contacts"
+	ADD COLUMN IF NOT EXISTS "address_book_id" bigint NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS "first_name_index" varchar(64),
+	ADD COLUMN IF NOT EXISTS "last_name_index" varchar(64),
+	ADD COLUMN IF NOT EXISTS "phone_index" varchar(64),
+	ADD COLUMN IF NOT EXISTS "address_index" varchar(64),
+	ALTER COLUMN "first_name" TYPE text,
+	ALTER COLUMN "last_name" TYPE text,
+	ALTER COLUMN "phone" TYPE text;
+CREATE INDEX IF NOT EXISTS "idx_first_last" ON "contacts" ("first_name","last_name","id");
+CREATE INDEX IF NOT EXISTS "idx_last_first" ON "contacts" ("last_name","id");
+CREATE INDEX IF NOT EXISTS "idx_contacts_last_modified" ON "contacts" ("last_modified");
+CREATE INDEX IF NOT EXISTS "idx_contacts_address_book_id" ON "contacts" ("address_book_id");
+CREATE INDEX IF NOT EXISTS "idx_contacts_first_name_index" ON "contacts" ("first_name_index");
+CREATE INDEX IF NOT EXISTS "idx_contacts_last_name_index" ON "contacts" ("last_name_index");
+CREATE INDEX IF NOT EXISTS "idx_contacts_phone_index" ON "contacts" ("phone_index");
+CREATE INDEX IF NOT EXISTS "idx_contacts_address_index" ON "contacts" ("address_index");
+
+CREATE TABLE IF NOT EXISTS "merge_records" ("id" bigserial,"survivor_id" bigint NOT NULL,"merged_id" bigint NOT NULL,"first_name" text,"last_name" text,"phone" text,"address" text,"original_last_modified" timestamptz,"merged_at" timestamptz,PRIMARY KEY ("id"));
+ALTER TABLE "merge_records"
+	ADD COLUMN IF NOT EXISTS "first_name_index" varchar(64),
+	ADD COLUMN IF NOT EXISTS "last_name_index" varchar(64),
+	ADD COLUMN IF NOT EXISTS "phone_index" varchar(64),
+	ADD COLUMN IF NOT EXISTS "address_index" varchar(64),
+	ALTER COLUMN "first_name" TYPE text,
+	ALTER COLUMN "last_name" TYPE text,
+	ALTER COLUMN "phone" TYPE text;
+CREATE INDEX IF NOT EXISTS "idx_merge_records_survivor_id" ON "merge_records" ("survivor_id");
+CREATE INDEX IF NOT EXISTS "idx_merge_records_merged_id" ON "merge_records" ("merged_id");
+CREATE INDEX IF NOT EXISTS "idx_merge_records_first_name_index" ON "merge_records" ("first_name_index");
+CREATE INDEX IF NOT EXISTS "idx_merge_records_last_name_index" ON "merge_records" ("last_name_index");
+CREATE INDEX IF NOT EXISTS "idx_merge_records_phone_index" ON "merge_records" ("phone_index");
+CREATE INDEX IF NOT EXISTS "idx_merge_records_address_index" ON "merge_records" ("address_index");
+
+CREATE TABLE IF NOT EXISTS "address_books" ("id" bigserial,"tenant_id" varchar(100) NOT NULL,"name" varchar(100) NOT NULL,"created_at" timestamptz,PRIMARY KEY ("id"));
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_tenant_book_name" ON "address_books" ("tenant_id","name");
+
+CREATE TABLE IF NOT EXISTS "audit_records" ("id" bigserial,"sequence" bigint NOT NULL,"timestamp" timestamptz NOT NULL,"actor" varchar(255),"tenant" varchar(100),"action" varchar(50),"target_ids" text,"request_id" varchar(100),"status" bigint,"outcome" varchar(20),"before" text,"after" text,"prev_hash" varchar(64) NOT NULL,"hash" varchar(64) NOT NULL,PRIMARY KEY ("id"));
+-- Added with erasure
+ALTER TABLE "audit_records"
+	ADD COLUMN IF NOT EXISTS "payload_hash" varchar(64),
+	ADD COLUMN IF NOT EXISTS "erased_at" timestamptz;
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_audit_records_sequence" ON "audit_records" ("sequence");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_audit_records_hash" ON "audit_records" ("hash");
+CREATE INDEX IF NOT EXISTS "idx_audit_records_timestamp" ON "audit_records" ("timestamp");
+CREATE INDEX IF NOT EXISTS "idx_audit_records_actor" ON "audit_records" ("actor");
+CREATE INDEX IF NOT EXISTS "idx_audit_records_tenant" ON "audit_records" ("tenant");
+CREATE INDEX IF NOT EXISTS "idx_audit_records_action" ON "audit_records" ("action");
+CREATE INDEX IF NOT EXISTS "idx_audit_records_request_id" ON "audit_records" ("request_id");
+CREATE INDEX IF NOT EXISTS "idx_audit_records_outcome" ON "audit_records" ("outcome");
+
+CREATE TABLE IF NOT EXISTS "erasures" ("id" bigserial,"tenant" varchar(100),"actor" varchar(255),"request_id" varchar(100),"erased_at" timestamptz NOT NULL,"contacts" bigint,"revisions" bigint,"audit_records" bigint,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_erasures_tenant" ON "erasures" ("tenant");
+CREATE INDEX IF NOT EXISTS "idx_erasures_erased_at" ON "erasures" ("erased_at");`,
+		Down: `DROP TABLE "erasures", "audit_records", "address_books", "merge_records", "contacts";`,
+	},
+	{
+		Version: 2,
+		Name:    "webhooks",
+		Up: `
+CREATE TABLE IF NOT EXISTS "webhook_subscriptions" ("id" bigserial,"tenant_id" varchar(100) NOT NULL,"url" text NOT NULL,"events" text,"address_book_id" bigint NOT NULL DEFAULT 0,"secret" varchar(100) NOT NULL,"created_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_webhook_subscriptions_tenant_id" ON "webhook_subscriptions" ("tenant_id");
+
+CREATE TABLE IF NOT EXISTS "webhook_deliveries" ("id" bigserial,"tenant_id" varchar(100) NOT NULL,"subscription_id" bigint NOT NULL,"event_type" varchar(50) NOT NULL,"contact_id" bigint NOT NULL,"payload" text,"status" varchar(20) NOT NULL,"attempts" bigint,"next_attempt_at" timestamptz NOT NULL,"created_at" timestamptz,"delivered_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_webhook_deliveries_tenant_id" ON "webhook_deliveries" ("tenant_id");
+CREATE INDEX IF NOT EXISTS "idx_webhook_deliveries_subscription_id" ON "webhook_deliveries" ("subscription_id");
+CREATE INDEX IF NOT EXISTS "idx_webhook_deliveries_contact_id" ON "webhook_deliveries" ("contact_id");
+CREATE INDEX IF NOT EXISTS "idx_webhook_due" ON "webhook_deliveries" ("status","next_attempt_at");
+
+CREATE TABLE IF NOT EXISTS "webhook_attempts" ("id" bigserial,"delivery_id" bigint NOT NULL,"number" bigint,"attempted_at" timestamptz,"status_code" bigint,"error" text,"duration_ms" bigint,PRIMARY KEY ("id"),CONSTRAINT "fk_webhook_deliveries_history" FOREIGN KEY ("delivery_id") REFERENCES "webhook_deliveries"("id"));
+CREATE INDEX IF NOT EXISTS "idx_webhook_attempts_delivery_id" ON "webhook_attempts" ("delivery_id");`,
+		Down: `DROP TABLE "webhook_attempts", "webhook_deliveries", "webhook_subscriptions";`,
+	},
+	{
+		Version: 3,
+		Name:    "sync",
+		Up: `
+-- Numbers every change to contacts, sync tokens are positions in it
+CREATE SEQUENCE IF NOT EXISTS "contact_change_seq";
+ALTER TABLE "contacts" ADD COLUMN IF NOT EXISTS "change_seq" bigint NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS "idx_contacts_change_seq" ON "contacts" ("change_seq");
+
+CREATE TABLE IF NOT EXISTS "tombstones" ("contact_id" bigint,"tenant_id" varchar(100) NOT NULL,"change_seq" bigint NOT NULL,"removed_at" timestamptz NOT NULL,PRIMARY KEY ("contact_id"));
+CREATE INDEX IF NOT EXISTS "idx_tombstones_tenant_id" ON "tombstones" ("tenant_id");
+CREATE INDEX IF NOT EXISTS "idx_tombstones_change_seq" ON "tombstones" ("change_seq");`,
+		Down: `
+DROP TABLE "tombstones";
+ALTER TABLE "contacts" DROP COLUMN "change_seq";
+DROP SEQUENCE "contact_change_seq";`,
+	},
+	{
+		Version: 4,
+		Name:    "idempotency_keys",
+		Up: `
+CREATE TABLE IF NOT EXISTS "idempotency_keys" ("id" bigserial,"tenant" varchar(100) NOT NULL,"key" varchar(255) NOT NULL,"request_hash" varchar(64) NOT NULL,"status" bigint,"header" text,"body" text,"created_at" timestamptz NOT NULL,"expires_at" timestamptz NOT NULL,PRIMARY KEY ("id"));
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_idempotency_key" ON "idempotency_keys" ("tenant","key");
+CREATE INDEX IF NOT EXISTS "idx_idempotency_keys_expires_at" ON "idempotency_keys" ("expires_at");`,
+		Down: `DROP TABLE "idempotency_keys";`,
+	},
+}
+
+// Migrator for the server's schema on db
+func Migrator(db *gorm.DB) *migrate.Migrator {
+	return migrate.New(db, migrations)
+}
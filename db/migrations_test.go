@@ -0,0 +1,77 @@
+package db
+
+import (
+	"golangphonebook/pkg/audit"
+	"golangphonebook/pkg/contacts"
+	"golangphonebook/pkg/idempotency"
+	"golangphonebook/pkg/privacy"
+	"golangphonebook/pkg/webhooks"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm/schema"
+)
+
+// Every table the server uses
+func models() []any {
+	return []any{&contacts.Contact{}, &contacts.MergeRecord{}, &contacts.AddressBook{}, &contacts.Tombstone{}, &audit.Record{}, &privacy.Erasure{},
+		&webhooks.Subscription{}, &webhooks.Delivery{}, &webhooks.Attempt{}, &idempotency.Record{}}
+}
+
+// A model change without a migration fails here rather than on the first query that needs it
+func TestMigrationsCoverModels(t *testing.T) {
+	// Statements creating or altering each table, from every migration
+	statements := make(map[string]string)
+	for _, m := range migrations {
+		for _, stmt := range strings.Split(m.Up, ";") {
+			for _, prefix := range []string{"CREATE TABLE IF NOT EXISTS ", "ALTER TABLE "} {
+				if rest, ok := strings.CutPrefix(strings.TrimSpace(stripComments(stmt)), prefix); ok {
+					table := strings.Trim(strings.Fields(rest)[0], `"`)
+					statements[table] += stmt
+				}
+			}
+		}
+	}
+	all := ""
+	for _, m := range migrations {
+		all += m.Up
+	}
+
+	for _, model := range models() {
+		s, err := schema.Parse(model, &sync.Map{}, schema.NamingStrategy{})
+		require.NoError(t, err)
+		created := statements[s.Table]
+		if !assert.Contains(t, created, `CREATE TABLE IF NOT EXISTS "`+s.Table+`"`) {
+			continue
+		}
+		for _, field := range s.Fields {
+			if field.DBName != "" {
+				assert.Contains(t, created, `"`+field.DBName+`" `, "column %s.%s", s.Table, field.DBName)
+			}
+		}
+		for _, index := range s.ParseIndexes() {
+			assert.Contains(t, all, `INDEX IF NOT EXISTS "`+index.Name+`" ON "`+s.Table+`"`, "index %s", index.Name)
+		}
+	}
+	assert.Contains(t, all, `CREATE SEQUENCE IF NOT EXISTS "contact_change_seq"`)
+}
+
+func stripComments(sql string) string {
+	var lines []string
+	for _, line := range strings.Split(sql, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestMigrationsReversible(t *testing.T) {
+	for _, m := range migrations {
+		assert.NotEmpty(t, m.Down, "migration %d %s", m.Version, m.Name)
+	}
+	assert.NotPanics(t, func() { Migrator(nil) }, "versions in order")
+}
@@ -2,9 +2,12 @@
 package internal
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -15,41 +18,193 @@ func init() {
 	Logger = NewConsoleLogger()
 }
 
+// Output formats of the logger
+const (
+	FormatText = "text" // key=value pairs, the default
+	FormatJSON = "json" // One JSON object per line
+)
+
 type ConsoleLogger struct {
-	debug *log.Logger
-	info  *log.Logger
-	warn  *log.Logger
-	error *log.Logger
+	slog  *slog.Logger
+	level *slog.LevelVar
+
+	Redaction RedactionPolicy // How personal data is masked before it's logged, set from LOG_REDACTION
 }
 
+// Text to stdout at info level, errors go to stderr
 func NewConsoleLogger() *ConsoleLogger {
-	return &ConsoleLogger{
-		debug: log.New(os.Stdout, "DEBUG: ", log.LstdFlags),
-		info:  log.New(os.Stdout, "INFO: ", log.LstdFlags),
-		warn:  log.New(os.Stdout, "WARN: ", log.LstdFlags),
-		error: log.New(os.Stderr, "ERROR: ", log.LstdFlags),
+	l := &ConsoleLogger{level: new(slog.LevelVar), Redaction: redactionPolicyFromEnv()}
+	l.slog = l.newSlog(FormatText, os.Stdout, os.Stderr)
+	return l
+}
+
+// Switch to the format, json or text, and writers, errors go to errOut
+func (l *ConsoleLogger) Configure(format string, out, errOut io.Writer) error {
+	if format != FormatText && format != FormatJSON {
+		return fmt.Errorf("invalid log format %q, must be text or json", format)
+	}
+	l.slog = l.newSlog(format, out, errOut)
+	return nil
+}
+
+func (l *ConsoleLogger) newSlog(format string, out, errOut io.Writer) *slog.Logger {
+	newHandler := func(w io.Writer) slog.Handler {
+		options := &slog.HandlerOptions{Level: l.level}
+		if format == FormatJSON {
+			return slog.NewJSONHandler(w, options)
+		}
+		return slog.NewTextHandler(w, options)
+	}
+	return slog.New(contextHandler{splitHandler{out: newHandler(out), err: newHandler(errOut)}})
+}
+
+// Messages below the level are dropped, can be changed while running
+func (l *ConsoleLogger) SetLevel(level slog.Level) {
+	l.level.Set(level)
+}
+
+// Parse a level like debug, info, warn or error
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return level, fmt.Errorf("invalid log level %q, must be debug, info, warn or error", name)
+	}
+	return level, nil
+}
+
+// The underlying slog logger, for code that logs structured attributes itself
+func (l *ConsoleLogger) Slog() *slog.Logger {
+	return l.slog
+}
+
+// Log the values like Println, without the trailing newline
+func (l *ConsoleLogger) log(ctx context.Context, level slog.Level, v ...interface{}) {
+	if !l.slog.Enabled(ctx, level) {
+		return
 	}
+	l.slog.Log(ctx, level, strings.TrimRight(fmt.Sprintln(v...), "\n"))
 }
 
 func (l *ConsoleLogger) Debug(v ...interface{}) {
-	l.debug.Println(v...)
+	l.log(context.Background(), slog.LevelDebug, v...)
 }
 
 func (l *ConsoleLogger) Info(v ...interface{}) {
-	l.info.Println(v...)
+	l.log(context.Background(), slog.LevelInfo, v...)
 }
 
 func (l *ConsoleLogger) Warn(v ...interface{}) {
-	l.warn.Println(v...)
+	l.log(context.Background(), slog.LevelWarn, v...)
 }
 
 func (l *ConsoleLogger) Error(v ...interface{}) {
-	l.error.Println(v...)
+	l.log(context.Background(), slog.LevelError, v...)
+}
+
+// Like Debug, with the fields of the request the context belongs to
+func (l *ConsoleLogger) DebugContext(ctx context.Context, v ...interface{}) {
+	l.log(ctx, slog.LevelDebug, v...)
+}
+
+func (l *ConsoleLogger) InfoContext(ctx context.Context, v ...interface{}) {
+	l.log(ctx, slog.LevelInfo, v...)
+}
+
+func (l *ConsoleLogger) WarnContext(ctx context.Context, v ...interface{}) {
+	l.log(ctx, slog.LevelWarn, v...)
+}
+
+func (l *ConsoleLogger) ErrorContext(ctx context.Context, v ...interface{}) {
+	l.log(ctx, slog.LevelError, v...)
+}
+
+// Redact a personal value before logging it, field is the json field name such as "phone"
+func (l *ConsoleLogger) Redact(field string, value string) string {
+	return l.Redaction.Redact(field, value)
 }
 
+// Redact a set of personal values before logging them
+func (l *ConsoleLogger) RedactFields(fields map[string]string) string {
+	return l.Redaction.RedactFields(fields)
+}
+
+type fieldsKey struct{}
+
+// Context whose log lines carry the given attributes too, like the request ID. Args are key value pairs as slog takes them.
+func WithLogFields(ctx context.Context, args ...any) context.Context {
+	fields, _ := ctx.Value(fieldsKey{}).([]slog.Attr)
+	record := slog.NewRecord(time.Time{}, 0, "", 0)
+	record.Add(args...)
+	record.Attrs(func(attr slog.Attr) bool {
+		fields = append(fields, attr)
+		return true
+	})
+	return context.WithValue(ctx, fieldsKey{}, fields[:len(fields):len(fields)])
+}
+
+// Attributes WithLogFields added to the context
+func LogFields(ctx context.Context) []slog.Attr {
+	fields, _ := ctx.Value(fieldsKey{}).([]slog.Attr)
+	return fields
+}
+
+// Adds the fields of the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if fields := LogFields(ctx); len(fields) > 0 {
+		record = record.Clone()
+		record.AddAttrs(fields...)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// Errors to one writer and everything else to another, like the logger always did
+type splitHandler struct {
+	out slog.Handler
+	err slog.Handler
+}
+
+func (h splitHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.out.Enabled(ctx, level)
+}
+
+func (h splitHandler) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level >= slog.LevelError {
+		return h.err.Handle(ctx, record)
+	}
+	return h.out.Handle(ctx, record)
+}
+
+func (h splitHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return splitHandler{out: h.out.WithAttrs(attrs), err: h.err.WithAttrs(attrs)}
+}
+
+func (h splitHandler) WithGroup(name string) slog.Handler {
+	return splitHandler{out: h.out.WithGroup(name), err: h.err.WithGroup(name)}
+}
+
+// Told how long every operation timed with Timer took. Set once at startup, main records them in the metrics.
+var OperationObserver func(name string, elapsed time.Duration)
+
+// Log how long the operation took and hand it to the OperationObserver, use as defer Timer("GetContacts")()
 func Timer(name string) func() {
 	start := time.Now()
 	return func() {
-		Logger.Info(fmt.Sprintf("%s took %v\n", name, time.Since(start)))
+		elapsed := time.Since(start)
+		if OperationObserver != nil {
+			OperationObserver(name, elapsed)
+		}
+		Logger.slog.Debug(name+" finished", "operation", name, "duration_ms", elapsed.Milliseconds())
 	}
 }
@@ -0,0 +1,79 @@
+package internal_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"golangphonebook/internal"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsoleLogger(t *testing.T) {
+	var out, errOut bytes.Buffer
+	logger := internal.NewConsoleLogger()
+	require.NoError(t, logger.Configure(internal.FormatJSON, &out, &errOut))
+
+	logger.Debug("not logged at the default level")
+	logger.Info("Contact", 7, "added\n")
+	logger.Error("Failed")
+	assert.NotContains(t, out.String(), "not logged")
+
+	var line map[string]any
+	require.NoError(t, json.Unmarshal(out.Bytes(), &line))
+	assert.Equal(t, "INFO", line["level"])
+	assert.Equal(t, "Contact 7 added", line["msg"], "values are joined like Println, without the newline")
+	assert.Contains(t, errOut.String(), `"msg":"Failed"`, "errors go to their own writer")
+	assert.NotContains(t, out.String(), "Failed")
+
+	out.Reset()
+	logger.SetLevel(slog.LevelDebug)
+	logger.Debug("logged now")
+	assert.Contains(t, out.String(), "logged now")
+
+	assert.Error(t, logger.Configure("xml", &out, &errOut))
+}
+
+func TestLogFields(t *testing.T) {
+	var out bytes.Buffer
+	logger := internal.NewConsoleLogger()
+	require.NoError(t, logger.Configure(internal.FormatText, &out, &out))
+
+	ctx := internal.WithLogFields(context.Background(), "request_id", "abc", "route", "/v2/contacts")
+	child := internal.WithLogFields(ctx, "client", "CN=alice")
+	internal.WithLogFields(ctx, "client", "CN=bob")
+
+	logger.WarnContext(child, "Slow query")
+	assert.Contains(t, out.String(), `level=WARN msg="Slow query" request_id=abc route=/v2/contacts client="CN=alice"`)
+	assert.NotContains(t, out.String(), "bob", "sibling contexts don't share fields")
+
+	out.Reset()
+	logger.InfoContext(context.Background(), "No request")
+	assert.False(t, strings.Contains(out.String(), "request_id"))
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := internal.ParseLevel("warn")
+	assert.NoError(t, err)
+	assert.Equal(t, slog.LevelWarn, level)
+
+	_, err = internal.ParseLevel("loud")
+	assert.Error(t, err)
+}
+
+func TestTimer(t *testing.T) {
+	var observed []string
+	internal.OperationObserver = func(name string, elapsed time.Duration) {
+		assert.GreaterOrEqual(t, elapsed, time.Duration(0))
+		observed = append(observed, name)
+	}
+	t.Cleanup(func() { internal.OperationObserver = nil })
+
+	internal.Timer("GetContacts")()
+	assert.Equal(t, []string{"GetContacts"}, observed)
+}
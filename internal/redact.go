@@ -0,0 +1,152 @@
+// Keep personal data out of the logs
+package internal
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+type RedactionMode string
+
+const (
+	RedactStrict RedactionMode = "strict" // Only log the field name, the default
+	RedactMask   RedactionMode = "mask"   // Log a partially masked value, enough to tell values apart
+	RedactOff    RedactionMode = "off"    // Log values as they are, for local development only
+)
+
+// Fields that hold personal data, everything else is logged as it is
+var personalFields = map[string]bool{
+	"first_name": true,
+	"last_name":  true,
+	"phone":      true,
+	"address":    true,
+}
+
+// Which redaction mode applies to which field
+type RedactionPolicy struct {
+	Default RedactionMode
+	Fields  map[string]RedactionMode // Overrides per field, keyed by the json field name
+}
+
+func (p RedactionPolicy) modeFor(field string) RedactionMode {
+	if mode, ok := p.Fields[field]; ok {
+		return mode
+	}
+	if p.Default == "" {
+		return RedactStrict
+	}
+	return p.Default
+}
+
+func validRedactionMode(mode RedactionMode) bool {
+	return mode == RedactStrict || mode == RedactMask || mode == RedactOff
+}
+
+// Parse a policy like "mask" or "strict,first_name=mask,address=off". An empty string gives the strict default.
+func ParseRedactionPolicy(spec string) (RedactionPolicy, error) {
+	policy := RedactionPolicy{Default: RedactStrict, Fields: map[string]RedactionMode{}}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		field, mode, isOverride := strings.Cut(part, "=")
+		if !isOverride {
+			mode, field = field, ""
+		}
+		if !validRedactionMode(RedactionMode(mode)) {
+			return policy, fmt.Errorf("invalid redaction mode %q, must be one of strict, mask or off", mode)
+		}
+
+		if isOverride {
+			policy.Fields[field] = RedactionMode(mode)
+		} else {
+			policy.Default = RedactionMode(mode)
+		}
+	}
+	return policy, nil
+}
+
+// Policy from the LOG_REDACTION environment variable, falling back to strict if it is invalid
+func redactionPolicyFromEnv() RedactionPolicy {
+	policy, err := ParseRedactionPolicy(os.Getenv("LOG_REDACTION"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ignoring LOG_REDACTION: %v\n", err)
+		policy, _ = ParseRedactionPolicy("")
+	}
+	return policy
+}
+
+// Redact a single value according to the policy for its field
+func (p RedactionPolicy) Redact(field string, value string) string {
+	if !personalFields[field] {
+		return value
+	}
+	switch p.modeFor(field) {
+	case RedactOff:
+		return value
+	case RedactMask:
+		if field == "phone" {
+			return maskPhone(value)
+		}
+		return maskWords(value)
+	default:
+		return "<" + field + ">"
+	}
+}
+
+// Is every field logged as it is
+func (p RedactionPolicy) Disabled() bool {
+	if p.modeFor("") != RedactOff {
+		return false
+	}
+	for _, mode := range p.Fields {
+		if mode != RedactOff {
+			return false
+		}
+	}
+	return true
+}
+
+// Redact every value of a set of fields, returned as "field=value" pairs in a consistent order
+func (p RedactionPolicy) RedactFields(fields map[string]string) string {
+	var parts []string
+	for field, value := range fields {
+		if value == "" {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s=%s", field, p.Redact(field, value)))
+	}
+	sort.Strings(parts)
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+// Keep the last 2 digits, "+1234567890" becomes "+********90"
+func maskPhone(phone string) string {
+	runes := []rune(phone)
+	digitsSeen := 0
+	for i := len(runes) - 1; i >= 0; i-- {
+		if !unicode.IsDigit(runes[i]) {
+			continue
+		}
+		digitsSeen++
+		if digitsSeen > 2 {
+			runes[i] = '*'
+		}
+	}
+	return string(runes)
+}
+
+// Keep the first character of every word, "123 Main St" becomes "1** M*** S*"
+func maskWords(value string) string {
+	words := strings.Fields(value)
+	for i, word := range words {
+		runes := []rune(word)
+		words[i] = string(runes[0]) + strings.Repeat("*", len(runes)-1)
+	}
+	return strings.Join(words, " ")
+}
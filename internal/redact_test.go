@@ -0,0 +1,63 @@
+package internal_test
+
+import (
+	"golangphonebook/internal"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRedactionPolicy(t *testing.T) {
+	policy, err := internal.ParseRedactionPolicy("")
+	assert.NoError(t, err)
+	assert.Equal(t, internal.RedactStrict, policy.Default)
+
+	policy, err = internal.ParseRedactionPolicy("mask, first_name=off ,address=strict")
+	assert.NoError(t, err)
+	assert.Equal(t, internal.RedactMask, policy.Default)
+	assert.Equal(t, internal.RedactOff, policy.Fields["first_name"])
+	assert.Equal(t, internal.RedactStrict, policy.Fields["address"])
+
+	_, err = internal.ParseRedactionPolicy("hide")
+	assert.ErrorContains(t, err, `invalid redaction mode "hide"`)
+	_, err = internal.ParseRedactionPolicy("phone=hide")
+	assert.ErrorContains(t, err, `invalid redaction mode "hide"`)
+}
+
+func TestRedact(t *testing.T) {
+	strict, _ := internal.ParseRedactionPolicy("")
+	mask, _ := internal.ParseRedactionPolicy("mask,last_name=off")
+	off, _ := internal.ParseRedactionPolicy("off")
+
+	tests := []struct {
+		name     string
+		policy   internal.RedactionPolicy
+		field    string
+		value    string
+		expected string
+	}{
+		{"Strict Phone", strict, "phone", "+1234567890", "<phone>"},
+		{"Strict Name", strict, "first_name", "John", "<first_name>"},
+		{"Masked Phone", mask, "phone", "+1 (234) 567-890", "+* (***) ***-*90"},
+		{"Masked Address", mask, "address", "123 Main St", "1** M*** S*"},
+		{"Field Override", mask, "last_name", "Doe", "Doe"},
+		{"Off", off, "address", "123 Main St", "123 Main St"},
+		{"Not Personal Data", strict, "sort_str", "last_name", "last_name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.policy.Redact(tt.field, tt.value))
+		})
+	}
+
+	assert.Equal(t, "[first_name=<first_name> page=2 phone=<phone>]", strict.RedactFields(map[string]string{
+		"phone":      "+1234567890",
+		"first_name": "John",
+		"last_name":  "",
+		"page":       "2",
+	}))
+
+	assert.True(t, off.Disabled())
+	assert.False(t, mask.Disabled())
+}
@@ -0,0 +1,21 @@
+package internal
+
+import (
+	"context"
+	"sync"
+)
+
+// Wait for the group to finish, or until the context is done, returning its error then
+func Wait(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
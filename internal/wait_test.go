@@ -0,0 +1,27 @@
+package internal_test
+
+import (
+	"context"
+	"golangphonebook/internal"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWait(t *testing.T) {
+	var wg sync.WaitGroup
+	assert.NoError(t, internal.Wait(context.Background(), &wg))
+
+	wg.Add(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, internal.Wait(ctx, &wg), context.DeadlineExceeded)
+
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		wg.Done()
+	}()
+	assert.NoError(t, internal.Wait(context.Background(), &wg))
+}
@@ -2,52 +2,166 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
 	"fmt"
-	"golangphonebook/db"
+	database "golangphonebook/db"
 	"golangphonebook/internal"
+	"golangphonebook/pkg/audit"
+	"golangphonebook/pkg/auth"
+	"golangphonebook/pkg/config"
 	"golangphonebook/pkg/contacts"
+	"golangphonebook/pkg/events"
+	"golangphonebook/pkg/fieldcrypt"
+	"golangphonebook/pkg/graphqlapi"
+	"golangphonebook/pkg/grpcapi"
+	"golangphonebook/pkg/health"
+	"golangphonebook/pkg/idempotency"
+	"golangphonebook/pkg/metrics"
+	"golangphonebook/pkg/privacy"
+	"golangphonebook/pkg/tracing"
+	"golangphonebook/pkg/webhooks"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
-	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
 )
 
+// Set up the logger as the config says, the settings are validated with the rest of it
+func configureLogging(cfg config.Log) {
+	level, _ := internal.ParseLevel(cfg.Level)
+	internal.Logger.SetLevel(level)
+	internal.Logger.Configure(cfg.Format, os.Stdout, os.Stderr)
+	internal.Logger.Redaction, _ = internal.ParseRedactionPolicy(cfg.Redaction)
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(migrateCommand(os.Args[2:]))
+	}
+
+	// Defaults, then the config file, then environment variables, then flags
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, config.ErrPrinted) || errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	configureLogging(cfg.Log)
+	internal.OperationObserver = metrics.ObserveOperation
+	contacts.PageSize = cfg.Contacts.PageSize
+	contacts.MaxBatchSize = cfg.Contacts.MaxBatchSize
+	events.Heartbeat = cfg.Events.Heartbeat
+	internal.Logger.Info(fmt.Sprintf("Effective config:\n%s", cfg))
+
+	flushTraces, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
 
-	db, err := db.DBInit()
+	db, err := database.DBInit(cfg.DB)
 	if err != nil {
 		internal.Logger.Error(fmt.Sprintf("DB connection init failed, shutting down: %s", err))
 		return
 	}
+	if err := db.Use(tracing.GormPlugin{}); err != nil {
+		log.Fatalf("Failed to trace database queries: %v", err)
+	}
+	if err := db.Use(contacts.CancellationPlugin{}); err != nil {
+		log.Fatalf("Failed to report canceled queries: %v", err)
+	}
+
+	// Encrypt personal data at rest when a key file is configured
+	keyFile := cfg.Encryption.KeyFile
+	if keyFile != "" {
+		keys, err := fieldcrypt.LoadKeyFile(keyFile)
+		if err != nil {
+			log.Fatalf("Failed to load encryption keys: %v", err)
+		}
+		contacts.EnableFieldEncryption(fieldcrypt.NewCipher(keys), cfg.Encryption.EncryptNames)
+		// Catch up on rows saved before encryption was enabled or under an older key
+		if _, err := contacts.RotateEncryption(db); err != nil {
+			log.Fatalf("Failed to encrypt existing contacts: %v", err)
+		}
+	} else {
+		internal.Logger.Warn("No encryption key file is configured, personal data is stored unencrypted")
+	}
 
-	// Initialize the db interaction functions
+	// Initialize the db interaction functions, publishing every change to the event streams
+	// and putting webhook deliveries in the outbox in the same transaction
+	bus := events.NewBus(cfg.Events.History)
+	webhookStore := webhooks.NewStore(db)
 	repo := contacts.NewSQLContactRepository(db)
+	repo.Publisher = bus
+	repo.Outbox = webhookStore
+
+	// Map client certificates to roles
+	authz, err := auth.NewAuthorizer(cfg.Auth.RolesFile)
+	if err != nil {
+		log.Fatalf("Failed to load role config: %v", err)
+	}
+
+	// Record every change in the audit log
+	auditStore := audit.NewStore(db)
+
+	// Responses kept for retries with the same Idempotency-Key
+	idempotencyStore := idempotency.NewStore(db)
 
-	router := mux.NewRouter()
-	// C
-	router.HandleFunc("/addContact", func(w http.ResponseWriter, r *http.Request) { contacts.PutContact(w, r, repo) }).Methods("PUT")
-	router.HandleFunc("/addContacts", func(w http.ResponseWriter, r *http.Request) { contacts.PutContacts(w, r, repo) }).Methods("PUT")
-	// R
-	router.HandleFunc("/getContacts", func(w http.ResponseWriter, r *http.Request) { contacts.GetContacts(w, r, repo) }).Methods("GET")
-	// U
-	router.HandleFunc("/updateContact/{id}", func(w http.ResponseWriter, r *http.Request) { contacts.UpdateContact(w, r, repo) }).Methods("POST")
-	// D
-	router.HandleFunc("/deleteContact/{id}", func(w http.ResponseWriter, r *http.Request) { contacts.DeleteContact(w, r, repo) }).Methods("DELETE")
-	router.HandleFunc("/deleteContacts", func(w http.ResponseWriter, r *http.Request) { contacts.DeleteContacts(w, r, repo) }).Methods("DELETE")
-	// // Add router for dynamic routes
-	// http.Handle("/", router)
+	srv := &server{
+		db:         db,
+		repo:       repo,
+		authz:      authz,
+		auditStore: auditStore,
+		// Subject access exports and erasures
+		privacy:  privacy.NewService(db, auditStore),
+		events:   bus,
+		webhooks: webhookStore,
+		keyFile:  keyFile,
+		// Retries of requests with an Idempotency-Key get the first response again
+		idempotency:    idempotencyStore,
+		idempotencyTTL: cfg.Idempotency.TTL,
+		// Database work of a request is canceled once its deadline passes
+		requestTimeout: cfg.Server.RequestTimeout,
+	}
+	// Validated with the rest of the config
+	srv.routeTimeouts, _ = config.ParseRouteTimeouts(cfg.Server.RouteTimeouts)
+	srv.privacy.Events = bus
+	srv.privacy.Outbox = webhookStore
+	srv.graphql, err = graphqlapi.NewHandler(srv.requestRepo, auditStore, graphqlapi.Limits{
+		MaxDepth:        cfg.GraphQL.MaxDepth,
+		MaxCost:         cfg.GraphQL.MaxCost,
+		MaxPageSize:     cfg.GraphQL.MaxPageSize,
+		DefaultPageSize: cfg.GraphQL.DefaultPageSize,
+		ListSize:        cfg.GraphQL.ListSize,
+	})
+	if err != nil {
+		log.Fatalf("Failed to build the GraphQL schema: %v", err)
+	}
+
+	// Routes and their documentation come from the same table, see routes.go
+	router, err := srv.router()
+	if err != nil {
+		log.Fatalf("Failed to generate the OpenAPI document: %v", err)
+	}
 
 	// Load the server's certificate and private key
-	cert, err := tls.LoadX509KeyPair("certs/server.crt", "certs/server.key")
+	cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
 	if err != nil {
 		log.Fatalf("Failed to load server certificate and key: %v", err)
 	}
 
-	clientCACert, err := os.ReadFile("certs/ca.crt")
+	clientCACert, err := os.ReadFile(cfg.TLS.ClientCAFile)
 	if err != nil {
 		log.Fatalf("Failed to load client CA certificate: %v", err)
 	}
@@ -62,16 +176,111 @@ func main() {
 		ClientAuth:   tls.RequireAndVerifyClientCert,
 	}
 
-	server := &http.Server{
-		Addr:      ":8443",
-		Handler:   router,
-		TLSConfig: tlsConfig,
+	// Send the webhook deliveries in the outbox
+	dispatcher := webhooks.NewDispatcher(db)
+	dispatcher.Client.Timeout = cfg.Webhooks.Timeout
+	dispatcher.Interval = cfg.Webhooks.Interval
+	dispatcher.BatchSize = cfg.Webhooks.BatchSize
+	dispatcher.MaxAttempts = cfg.Webhooks.MaxAttempts
+	dispatcher.MinBackoff = cfg.Webhooks.MinBackoff
+	dispatcher.MaxBackoff = cfg.Webhooks.MaxBackoff
+
+	// Readiness checks, served by the router and on the admin port
+	srv.health = health.NewChecker(cfg.Server.HealthTimeout)
+	srv.health.Add("database", health.Database(db))
+	srv.health.Add("migrations", health.Migrations(db, database.MigrationStatus))
+	srv.health.Add("server_certificate", health.Certificate(cert.Leaf))
+	var clientCA *x509.Certificate
+	if block, _ := pem.Decode(clientCACert); block != nil {
+		clientCA, _ = x509.ParseCertificate(block.Bytes)
 	}
+	srv.health.Add("client_ca", health.Certificate(clientCA))
+
+	// Contacts are counted when metrics are scraped rather than on every change
+	metrics.Default.BeforeScrape(contacts.CountContacts(srv.repo))
+
+	// Background workers stop on SIGTERM or SIGINT, a second signal exits straight away
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	var workers sync.WaitGroup
+	workers.Add(3)
+	go func() {
+		defer workers.Done()
+		dispatcher.Run(ctx)
+	}()
+	// Remove expired idempotency keys
+	go func() {
+		defer workers.Done()
+		idempotencyStore.Run(ctx, cfg.Idempotency.PurgeInterval)
+	}()
+	// Replace connections the database dropped before requests run into them
+	go func() {
+		defer workers.Done()
+		database.Monitor(ctx, db, cfg.DB.HealthCheckInterval, cfg.DB.ConnectTimeout)
+	}()
 
-	internal.Logger.Info("Ready to take secure requests on https://localhost:8443\n")
-	err = server.ListenAndServeTLS("", "")
+	// Listeners that stop serving end up here, so the others are still shut down gracefully
+	serveErr := make(chan error, 3)
+
+	// gRPC on its own port, with the same certificates, roles, tenants and audit log
+	grpcAddr := cfg.GRPC.Addr
+	grpcServer := grpcapi.NewServer(tlsConfig, authz, auditStore, grpcapi.Deadlines{Default: srv.requestTimeout, Routes: srv.routeTimeouts}, &grpcapi.Service{Repo: srv.requestRepo})
+	grpcListener, err := net.Listen("tcp", grpcAddr)
 	if err != nil {
-		log.Fatalf("Failed to start HTTPS server: %v", err)
+		log.Fatalf("Failed to listen for gRPC on %s: %v", grpcAddr, err)
+	}
+	go func() {
+		internal.Logger.Info(fmt.Sprintf("Ready to take gRPC requests on %s", grpcAddr))
+		// Returns nil once stopped during shutdown
+		if err := grpcServer.Serve(grpcListener); err != nil {
+			serveErr <- fmt.Errorf("gRPC port: %w", err)
+		}
+	}()
+
+	// Event streams lift the write timeout for themselves
+	server := &http.Server{
+		Addr:              cfg.Server.Addr,
+		Handler:           router,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+
+	// Probes and metrics over plain HTTP, for orchestrators and scrapers without a client certificate
+	var adminServer *http.Server
+	if cfg.Server.AdminAddr != "" {
+		adminMux := health.AdminHandler(srv.health)
+		adminMux.Handle("GET /metrics", metrics.Default)
+		adminServer = &http.Server{Addr: cfg.Server.AdminAddr, Handler: adminMux, ReadHeaderTimeout: 5 * time.Second}
+		go func() {
+			internal.Logger.Info(fmt.Sprintf("Serving health probes and metrics on http://%s", cfg.Server.AdminAddr))
+			if err := adminServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				serveErr <- fmt.Errorf("admin port: %w", err)
+			}
+		}()
+	}
+
+	go func() {
+		internal.Logger.Info(fmt.Sprintf("Ready to take secure requests on %s\n", cfg.Server.Addr))
+		if err := server.ListenAndServeTLS("", ""); !errors.Is(err, http.ErrServerClosed) {
+			serveErr <- err
+		}
+	}()
+
+	failed := false
+	select {
+	case <-ctx.Done():
+	case err := <-serveErr:
+		internal.Logger.Error(fmt.Sprintf("Failed to start serving: %v", err))
+		failed = true
+	}
+	// Stops the workers too
+	stop()
+	err = services{https: server, admin: adminServer, grpc: grpcServer, events: bus, health: srv.health, workers: &workers, db: db, traces: flushTraces}.shutdown(cfg.Server.ShutdownTimeout)
+	if err != nil || failed {
+		os.Exit(1)
 	}
 
 }
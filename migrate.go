@@ -0,0 +1,151 @@
+// The migrate command, applying and reverting schema migrations without starting the server
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	database "golangphonebook/db"
+	"golangphonebook/pkg/config"
+	"golangphonebook/pkg/migrate"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+const migrateUsage = `Usage: phonebook migrate [flags] COMMAND
+
+Commands:
+  status      List the migrations and whether they are applied
+  up          Apply every pending migration
+  down [N]    Revert the latest N applied migrations, 1 by default
+  to VERSION  Apply or revert migrations until the schema is at VERSION, 0 reverts them all
+
+Flags are the server's, run phonebook -h to list them.
+`
+
+// What to run, parsed from the arguments after the flags
+type migrateArgs struct {
+	command string
+	n       int64 // Migrations to revert for down, the version for to
+}
+
+func parseMigrateArgs(args []string) (migrateArgs, error) {
+	if len(args) == 0 {
+		return migrateArgs{}, errors.New("missing command")
+	}
+	m := migrateArgs{command: args[0]}
+	rest := args[1:]
+	switch m.command {
+	case "status", "up":
+		if len(rest) > 0 {
+			return migrateArgs{}, fmt.Errorf("%s takes no arguments", m.command)
+		}
+	case "down":
+		m.n = 1
+		if len(rest) > 1 {
+			return migrateArgs{}, errors.New("down takes at most one argument")
+		}
+		if len(rest) == 1 {
+			n, err := strconv.ParseInt(rest[0], 10, 64)
+			if err != nil || n < 1 {
+				return migrateArgs{}, fmt.Errorf("invalid number of migrations %q", rest[0])
+			}
+			m.n = n
+		}
+	case "to":
+		if len(rest) != 1 {
+			return migrateArgs{}, errors.New("to takes a version")
+		}
+		version, err := strconv.ParseInt(rest[0], 10, 64)
+		if err != nil || version < 0 {
+			return migrateArgs{}, fmt.Errorf("invalid version %q", rest[0])
+		}
+		m.n = version
+	default:
+		return migrateArgs{}, fmt.Errorf("unknown command %q", m.command)
+	}
+	return m, nil
+}
+
+// Run the migrate command, returns the exit status
+func migrateCommand(args []string) int {
+	cfg, rest, err := config.LoadWithArgs(args)
+	if errors.Is(err, config.ErrPrinted) {
+		return 0
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprint(os.Stderr, "\n"+migrateUsage)
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		return 2
+	}
+	m, err := parseMigrateArgs(rest)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n%s", err, migrateUsage)
+		return 2
+	}
+	configureLogging(cfg.Log)
+
+	db, err := database.Open(cfg.DB)
+	if err != nil {
+		return 1
+	}
+	defer func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}()
+
+	// Interrupting stops waiting for the lock, a migration that has started is rolled back
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	migrator := database.Migrator(db)
+
+	var ran int
+	switch m.command {
+	case "status":
+		var states []migrate.State
+		if states, err = migrator.Status(ctx); err == nil {
+			err = printMigrationStatus(os.Stdout, states)
+		}
+	case "up":
+		ran, err = migrator.Up(ctx)
+	case "down":
+		ran, err = migrator.Down(ctx, int(m.n))
+	case "to":
+		ran, err = migrator.To(ctx, m.n)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Migration failed: %v\n", err)
+		return 1
+	}
+	if m.command != "status" {
+		fmt.Fprintf(os.Stdout, "%d migrations run\n", ran)
+	}
+	return 0
+}
+
+// One line per migration, newest last
+func printMigrationStatus(w io.Writer, states []migrate.State) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+	for _, state := range states {
+		applied := "pending"
+		if state.AppliedAt != nil {
+			applied = state.AppliedAt.Local().Format(time.DateTime)
+		}
+		if state.Unknown {
+			applied += ", unknown to this version"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", state.Version, state.Name, applied)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"golangphonebook/pkg/migrate"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMigrateArgs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    migrateArgs
+		wantErr string
+	}{
+		{[]string{"status"}, migrateArgs{command: "status"}, ""},
+		{[]string{"up"}, migrateArgs{command: "up"}, ""},
+		{[]string{"down"}, migrateArgs{command: "down", n: 1}, ""},
+		{[]string{"down", "3"}, migrateArgs{command: "down", n: 3}, ""},
+		{[]string{"to", "0"}, migrateArgs{command: "to", n: 0}, ""},
+		{[]string{"to", "4"}, migrateArgs{command: "to", n: 4}, ""},
+		{nil, migrateArgs{}, "missing command"},
+		{[]string{"sideways"}, migrateArgs{}, `unknown command "sideways"`},
+		{[]string{"up", "2"}, migrateArgs{}, "up takes no arguments"},
+		{[]string{"down", "0"}, migrateArgs{}, `invalid number of migrations "0"`},
+		{[]string{"to"}, migrateArgs{}, "to takes a version"},
+		{[]string{"to", "-1"}, migrateArgs{}, `invalid version "-1"`},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			got, err := parseMigrateArgs(tt.args)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestPrintMigrationStatus(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	var out strings.Builder
+	require.NoError(t, printMigrationStatus(&out, []migrate.State{
+		{Migration: migrate.Migration{Version: 1, Name: "initial_schema"}, AppliedAt: &at},
+		{Migration: migrate.Migration{Version: 2, Name: "webhooks"}},
+		{Migration: migrate.Migration{Version: 9, Name: "later"}, AppliedAt: &at, Unknown: true},
+	}))
+	assert.Equal(t, `VERSION  NAME            APPLIED
+1        initial_schema  2024-05-01 12:00:00
+2        webhooks        pending
+9        later           2024-05-01 12:00:00, unknown to this version
+`, out.String())
+}
@@ -0,0 +1,280 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golangphonebook/pkg/auth"
+	"golangphonebook/pkg/contacts"
+	"golangphonebook/pkg/fieldcrypt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memorySink seals records the same way the Store does, without a database
+type memorySink struct {
+	records []Record
+}
+
+func (m *memorySink) Append(rec *Record) error {
+	if len(m.records) == 0 {
+		rec.seal(nil)
+	} else {
+		rec.seal(&m.records[len(m.records)-1])
+	}
+	m.records = append(m.records, *rec)
+	return nil
+}
+
+func buildChain(t *testing.T, n int) []Record {
+	sink := &memorySink{}
+	for i := 0; i < n; i++ {
+		assert.NoError(t, sink.Append(&Record{
+			Timestamp: time.Now(),
+			Actor:     "CN=frontend",
+			Action:    "update_contact",
+			TargetIDs: formatTargetIDs([]uint{uint(i + 1)}),
+			Status:    http.StatusOK,
+			Outcome:   "success",
+		}))
+	}
+	return sink.records
+}
+
+func TestVerifyChain(t *testing.T) {
+	t.Run("Valid Chain", func(t *testing.T) {
+		records := buildChain(t, 5)
+		result := &Verification{Valid: true}
+		assert.True(t, verifyChain(nil, records, result))
+		assert.Equal(t, int64(5), result.RecordsChecked)
+		assert.Equal(t, records[4].Hash, result.LastHash)
+	})
+
+	t.Run("Valid In Batches", func(t *testing.T) {
+		records := buildChain(t, 5)
+		result := &Verification{Valid: true}
+		assert.True(t, verifyChain(nil, records[:2], result))
+		assert.True(t, verifyChain(&records[1], records[2:], result))
+		assert.Equal(t, int64(5), result.RecordsChecked)
+	})
+
+	t.Run("Modified Record", func(t *testing.T) {
+		records := buildChain(t, 5)
+		records[2].Actor = "CN=someone-else"
+		result := &Verification{Valid: true}
+		assert.False(t, verifyChain(nil, records, result))
+		assert.Equal(t, int64(3), result.BrokenSequence)
+		assert.Equal(t, "record contents don't match its hash", result.Reason)
+	})
+
+	t.Run("Rehashed Record", func(t *testing.T) {
+		// Fixing up the hash of a modified record still breaks the link to the next one
+		records := buildChain(t, 5)
+		records[2].Outcome = "failure"
+		records[2].Hash = records[2].computeHash()
+		result := &Verification{Valid: true}
+		assert.False(t, verifyChain(nil, records, result))
+		assert.Equal(t, int64(4), result.BrokenSequence)
+	})
+
+	t.Run("Deleted Record", func(t *testing.T) {
+		records := buildChain(t, 5)
+		records = append(records[:1], records[2:]...)
+		result := &Verification{Valid: true}
+		assert.False(t, verifyChain(nil, records, result))
+		assert.Equal(t, int64(3), result.BrokenSequence)
+		assert.Equal(t, "expected sequence 2, found 3", result.Reason)
+	})
+}
+
+func TestVerifyErasedChain(t *testing.T) {
+	withPayloads := func(t *testing.T) []Record {
+		sink := &memorySink{}
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, sink.Append(&Record{
+				Timestamp: time.Now(),
+				Action:    "update_contact",
+				TargetIDs: formatTargetIDs([]uint{uint(i + 1)}),
+				Before:    `[{"phone":"+1234567890"}]`,
+				After:     `[{"phone":"+1234567891"}]`,
+			}))
+		}
+		return sink.records
+	}
+	erase := func(rec *Record) {
+		now := time.Now()
+		rec.Before, rec.After, rec.ErasedAt = "", "", &now
+	}
+
+	t.Run("Erased Payload", func(t *testing.T) {
+		records := withPayloads(t)
+		erase(&records[1])
+		result := &Verification{Valid: true}
+		assert.True(t, verifyChain(nil, records, result))
+		assert.Equal(t, int64(3), result.RecordsChecked)
+	})
+
+	t.Run("Modified Payload", func(t *testing.T) {
+		records := withPayloads(t)
+		records[1].After = `[{"phone":"+1999999999"}]`
+		result := &Verification{Valid: true}
+		assert.False(t, verifyChain(nil, records, result))
+		assert.Equal(t, "record payload doesn't match its hash", result.Reason)
+	})
+
+	t.Run("Payload Replaced After Erasure", func(t *testing.T) {
+		records := withPayloads(t)
+		erase(&records[1])
+		records[1].After = `[{"phone":"+1999999999"}]`
+		result := &Verification{Valid: true}
+		assert.False(t, verifyChain(nil, records, result))
+		assert.Equal(t, "erased record still has a payload", result.Reason)
+	})
+
+	t.Run("Legacy Record", func(t *testing.T) {
+		// Records from before payload hashes hash their payload directly
+		records := withPayloads(t)
+		records[0].PayloadHash = ""
+		records[0].Hash = records[0].computeHash()
+		for i := 1; i < len(records); i++ {
+			records[i].PrevHash = records[i-1].Hash
+			records[i].Hash = records[i].computeHash()
+		}
+		result := &Verification{Valid: true}
+		assert.True(t, verifyChain(nil, records, result))
+
+		records[0].Before = `[{"phone":"+1999999999"}]`
+		result = &Verification{Valid: true}
+		assert.False(t, verifyChain(nil, records, result))
+		assert.Equal(t, "record contents don't match its hash", result.Reason)
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	sink := &memorySink{}
+	authz := &auth.Authorizer{Config: auth.RoleConfig{Rules: []auth.RoleRule{{CN: "frontend", Role: auth.RoleEditor}}}}
+
+	handler := Middleware(sink, "update_contact", authz.Require(auth.RoleEditor, func(w http.ResponseWriter, r *http.Request) {
+		entry, ok := FromContext(r.Context())
+		assert.True(t, ok)
+		entry.RecordChange(7, map[string]string{"phone": "+1234567890"}, map[string]string{"phone": "+1987654321"})
+		http.Error(w, "Duplicate contact", http.StatusBadRequest)
+	}))
+
+	req := httptest.NewRequest("POST", "/updateContact/7", bytes.NewBufferString(`{}`))
+	req.Header.Set("X-Request-ID", "req-123")
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "frontend"}}}}
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+
+	assert.Equal(t, "req-123", rr.Header().Get("X-Request-ID"))
+	assert.Len(t, sink.records, 1)
+	rec := sink.records[0]
+	assert.Equal(t, "CN=frontend", rec.Actor)
+	assert.Equal(t, "frontend", rec.Tenant)
+	assert.Equal(t, "update_contact", rec.Action)
+	assert.Equal(t, []uint{7}, rec.Targets())
+	assert.Equal(t, "req-123", rec.RequestID)
+	assert.Equal(t, http.StatusBadRequest, rec.Status)
+	assert.Equal(t, "failure", rec.Outcome)
+	assert.Equal(t, `[{"phone":"+1234567890"}]`, rec.Before)
+	assert.Equal(t, `[{"phone":"+1987654321"}]`, rec.After)
+	assert.Equal(t, genesisHash, rec.PrevHash)
+}
+
+func TestMiddlewareDenied(t *testing.T) {
+	sink := &memorySink{}
+	authz := &auth.Authorizer{Config: auth.RoleConfig{Rules: []auth.RoleRule{{CN: "dashboard", Role: auth.RoleReader}}}}
+	handler := Middleware(sink, "delete_contact", authz.Require(auth.RoleEditor, func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("handler ran for a denied client")
+	}))
+
+	req := httptest.NewRequest("DELETE", "/deleteContact/7", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "dashboard"}}}}
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	require.Len(t, sink.records, 1)
+	rec := sink.records[0]
+	assert.Equal(t, "CN=dashboard", rec.Actor)
+	assert.Equal(t, "dashboard", rec.Tenant)
+	assert.Equal(t, "delete_contact", rec.Action)
+	assert.Equal(t, http.StatusForbidden, rec.Status)
+	assert.Equal(t, "denied", rec.Outcome)
+	assert.Empty(t, rec.Targets())
+
+	// Without a certificate there is nobody to name
+	req = httptest.NewRequest("DELETE", "/deleteContact/7", nil)
+	handler(httptest.NewRecorder(), req)
+	require.Len(t, sink.records, 2)
+	assert.Equal(t, "anonymous", sink.records[1].Actor)
+	assert.Equal(t, "denied", sink.records[1].Outcome)
+}
+
+type failingSink struct{}
+
+func (failingSink) Append(rec *Record) error {
+	return errors.New("database error")
+}
+
+func TestMiddlewareSinkFailure(t *testing.T) {
+	// The response is unaffected when the audit record can't be written
+	handler := Middleware(failingSink{}, "delete_contact", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Contact deleted successfully"))
+	})
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("DELETE", "/deleteContact/1", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("X-Request-ID"))
+}
+
+func TestEncryptedPayload(t *testing.T) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	encoded := base64.StdEncoding.EncodeToString(key)
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf("current: k1\nindex_key: %s\nkeys:\n  k1: %s\n", encoded, encoded)), 0600))
+	keys, err := fieldcrypt.LoadKeyFile(path)
+	require.NoError(t, err)
+
+	contacts.EnableFieldEncryption(fieldcrypt.NewCipher(keys), false)
+	defer contacts.DisableFieldEncryption()
+
+	sink := &memorySink{}
+	handler := Middleware(sink, "update_contact", func(w http.ResponseWriter, r *http.Request) {
+		entry, _ := FromContext(r.Context())
+		entry.RecordChange(7, map[string]string{"phone": "+1234567890"}, map[string]string{"phone": "+1987654321"})
+	})
+	handler(httptest.NewRecorder(), httptest.NewRequest("POST", "/updateContact/7", nil))
+
+	require.Len(t, sink.records, 1)
+	rec := sink.records[0]
+	assert.True(t, fieldcrypt.IsEncrypted(rec.Before))
+	assert.NotContains(t, rec.Before+rec.After, "234567890")
+	assert.NotContains(t, rec.After, "987654321")
+
+	result := &Verification{Valid: true}
+	assert.True(t, verifyChain(nil, sink.records, result), "the chain covers the encrypted payload")
+
+	opened := slices.Clone(sink.records)
+	require.NoError(t, openRecords(opened))
+	assert.Equal(t, `[{"phone":"+1234567890"}]`, opened[0].Before)
+	assert.Equal(t, `[{"phone":"+1987654321"}]`, opened[0].After)
+}
@@ -0,0 +1,29 @@
+// In-memory audit sink for tests of the APIs that write to the audit log
+package audittest
+
+import (
+	"golangphonebook/pkg/audit"
+	"sync"
+)
+
+// Keeps appended records in order, without sealing them into a chain
+type Sink struct {
+	mu      sync.Mutex
+	records []*audit.Record
+}
+
+var _ audit.Sink = (*Sink)(nil)
+
+func (s *Sink) Append(rec *audit.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, rec)
+	return nil
+}
+
+// Records appended so far, oldest first
+func (s *Sink) Records() []*audit.Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*audit.Record(nil), s.records...)
+}
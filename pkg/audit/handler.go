@@ -0,0 +1,144 @@
+// handle HTTP requests to query, export and verify the audit log
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"golangphonebook/internal"
+	"golangphonebook/pkg/auth"
+	"golangphonebook/pkg/problem"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Build a query from the request's parameters, limited to the caller's tenant
+func parseQuery(r *http.Request) (Query, error) {
+	params := r.URL.Query()
+	q := Query{
+		Actor:   params.Get("actor"),
+		Action:  params.Get("action"),
+		Outcome: params.Get("outcome"),
+	}
+	if id, ok := auth.FromContext(r.Context()); ok {
+		q.Tenant = id.Tenant
+	}
+
+	if targetStr := params.Get("target_id"); targetStr != "" {
+		target, err := strconv.ParseUint(targetStr, 10, 64)
+		if err != nil {
+			return q, fmt.Errorf("invalid target_id %q, IDs can only be integers", targetStr)
+		}
+		q.TargetID = uint(target)
+	}
+
+	var err error
+	if fromStr := params.Get("from"); fromStr != "" {
+		if q.From, err = time.Parse(time.RFC3339, fromStr); err != nil {
+			return q, fmt.Errorf("invalid from %q, must be an RFC 3339 timestamp", fromStr)
+		}
+	}
+	if toStr := params.Get("to"); toStr != "" {
+		if q.To, err = time.Parse(time.RFC3339, toStr); err != nil {
+			return q, fmt.Errorf("invalid to %q, must be an RFC 3339 timestamp", toStr)
+		}
+	}
+	return q, nil
+}
+
+type PaginatedRecords struct {
+	Records     []Record `json:"records"`
+	TotalPages  int      `json:"total_pages"`
+	CurrentPage int      `json:"current_page"`
+	TotalCount  int64    `json:"total_count"`
+}
+
+func GetAuditRecords(w http.ResponseWriter, r *http.Request, store *Store) {
+	defer internal.Timer("GetAuditRecords")()
+
+	q, err := parseQuery(r)
+	if err != nil {
+		problem.Error(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	records, count, err := store.Search(q, page)
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to search audit records: %v", err))
+		problem.Error(w, r, "Failed to search audit records", http.StatusInternalServerError)
+		return
+	}
+
+	response, err := json.Marshal(PaginatedRecords{
+		Records:     records,
+		TotalPages:  int((count + pageSize - 1) / pageSize),
+		CurrentPage: page,
+		TotalCount:  count,
+	})
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to serialize audit records: %v", err))
+		problem.Error(w, r, "Failed to serialize audit records", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// Stream every matching record as newline delimited JSON, oldest first
+func ExportAudit(w http.ResponseWriter, r *http.Request, store *Store) {
+	defer internal.Timer("ExportAudit")()
+
+	q, err := parseQuery(r)
+	if err != nil {
+		problem.Error(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-%s.ndjson"`, time.Now().UTC().Format("20060102T150405Z")))
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	exported := 0
+	err = store.Each(q, func(rec Record) error {
+		exported++
+		return encoder.Encode(rec)
+	})
+	if err != nil {
+		// Headers are already sent, the client will notice the truncated export
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Audit export failed after %d records: %v", exported, err))
+		return
+	}
+	internal.Logger.InfoContext(r.Context(), fmt.Sprintf("Exported %d audit records", exported))
+}
+
+func VerifyAudit(w http.ResponseWriter, r *http.Request, store *Store) {
+	defer internal.Timer("VerifyAudit")()
+
+	result, err := store.Verify()
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to verify audit log: %v", err))
+		problem.Error(w, r, "Failed to verify audit log", http.StatusInternalServerError)
+		return
+	}
+	if !result.Valid {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Audit log hash chain is broken at sequence %d: %s", result.BrokenSequence, result.Reason))
+	}
+
+	response, err := json.Marshal(result)
+	if err != nil {
+		problem.Error(w, r, "Failed to serialize verification result", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
@@ -0,0 +1,138 @@
+// Record every mutating request
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"golangphonebook/internal"
+	"golangphonebook/pkg/auth"
+	"golangphonebook/pkg/requestlog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Collects what a single request changed, the repository reports each changed value to it
+type Entry struct {
+	Action    string
+	RequestID string
+
+	mu        sync.Mutex
+	identity  *auth.Identity // Set by the role check, which runs inside the audit middleware
+	targetIDs []uint
+	before    []any
+	after     []any
+}
+
+// Called by the repository for every value the request changed. Before is nil for creations, after is nil for deletions.
+func (e *Entry) RecordChange(id uint, before, after any) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.targetIDs = append(e.targetIDs, id)
+	e.before = append(e.before, before)
+	e.after = append(e.after, after)
+}
+
+// Called by the role check with the client it let through or denied
+func (e *Entry) SetIdentity(id auth.Identity) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.identity = &id
+}
+
+type entryKey struct{}
+
+// Entry of the request being audited, if any
+func FromContext(ctx context.Context) (*Entry, bool) {
+	entry, ok := ctx.Value(entryKey{}).(*Entry)
+	return entry, ok
+}
+
+// Start auditing a request, the repository finds the entry on the returned context.
+// Transports other than HTTP use this with Finish, HTTP handlers use Middleware.
+func Begin(ctx context.Context, action string, requestID string) (context.Context, *Entry) {
+	entry := &Entry{Action: action, RequestID: requestID}
+	return context.WithValue(ctx, entryKey{}, entry), entry
+}
+
+// Write the audit record once the request is done, status is the HTTP status the outcome maps to
+func (e *Entry) Finish(ctx context.Context, sink Sink, status int) {
+	rec, err := e.toRecord(ctx, status)
+	if err == nil {
+		err = sink.Append(rec)
+	}
+	if err != nil {
+		// The response is already out, all we can do is make some noise
+		internal.Logger.ErrorContext(ctx, fmt.Sprintf("Failed to write audit record for %s request %s: %v", e.Action, e.RequestID, err))
+	}
+}
+
+// Wrap a mutating handler so every request to it ends up in the audit log, whatever the outcome.
+// Goes outside the role check, so requests it denies are recorded too.
+func Middleware(sink Sink, action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The request log middleware has usually checked the header already, the ID is only made up here without it
+		ctx, entry := Begin(r.Context(), action, requestlog.FromHeader(r.Header.Get(requestlog.Header)))
+		ctx = auth.WithIdentityListener(ctx, entry)
+		w.Header().Set(requestlog.Header, entry.RequestID)
+
+		recorder := internal.NewStatusRecorder(w)
+		next(recorder, r.WithContext(ctx))
+
+		entry.Finish(r.Context(), sink, recorder.Status())
+	}
+}
+
+func (e *Entry) toRecord(ctx context.Context, status int) (*Record, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if status == 0 {
+		status = http.StatusOK
+	}
+	outcome := "success"
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		outcome = "denied"
+	case status >= http.StatusBadRequest:
+		outcome = "failure"
+	}
+
+	rec := &Record{
+		Timestamp: time.Now(),
+		Actor:     "anonymous",
+		Action:    e.Action,
+		TargetIDs: formatTargetIDs(e.targetIDs),
+		RequestID: e.RequestID,
+		Status:    status,
+		Outcome:   outcome,
+	}
+	id, ok := auth.FromContext(ctx)
+	if e.identity != nil {
+		id, ok = *e.identity, true
+	}
+	if ok {
+		rec.Actor = id.String()
+		rec.Tenant = id.Tenant
+	}
+
+	if len(e.targetIDs) > 0 {
+		before, err := json.Marshal(e.before)
+		if err != nil {
+			return nil, err
+		}
+		after, err := json.Marshal(e.after)
+		if err != nil {
+			return nil, err
+		}
+		// Payloads hold the phone numbers and addresses that are encrypted on contacts
+		if rec.Before, err = sealPayload(string(before)); err != nil {
+			return nil, err
+		}
+		if rec.After, err = sealPayload(string(after)); err != nil {
+			return nil, err
+		}
+	}
+	return rec, nil
+}
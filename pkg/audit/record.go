@@ -0,0 +1,240 @@
+// Append-only, hash-chained audit log of every change to the phonebook
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"golangphonebook/pkg/contacts"
+	"golangphonebook/pkg/fieldcrypt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Name payloads are encrypted under, like the name of a contact field
+const payloadField = "audit_payload"
+
+// Hash the first record in the chain links to
+const genesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+type Record struct {
+	ID        uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	Sequence  int64     `json:"sequence" gorm:"not null;uniqueIndex"` // Position in the hash chain, starting at 1
+	Timestamp time.Time `json:"timestamp" gorm:"not null;index"`
+	Actor     string    `json:"actor" gorm:"size:255;index"`  // Client certificate that made the request
+	Tenant    string    `json:"tenant" gorm:"size:100;index"` // Tenant the client acted in
+	Action    string    `json:"action" gorm:"size:50;index"`  // add_contact, update_contact, delete_contacts, ...
+	TargetIDs string    `json:"target_ids" gorm:"type:text"`  // Comma separated with leading and trailing commas, ",3,5,"
+	RequestID string    `json:"request_id" gorm:"size:100;index"`
+	Status    int       `json:"status"`                       // HTTP status code of the response
+	Outcome   string    `json:"outcome" gorm:"size:20;index"` // success, failure, or denied by the role check
+	Before    string    `json:"before" gorm:"type:text"`      // JSON array of values before the change, aligned with TargetIDs, encrypted when field encryption is enabled
+	After     string    `json:"after" gorm:"type:text"`       // JSON array of values after the change, aligned with TargetIDs
+	// Hash of Before and After. The chain covers this instead of the payload itself, so a payload can be erased
+	// without breaking the chain. Empty for records written before payloads could be erased.
+	PayloadHash string     `json:"payload_hash" gorm:"size:64"`
+	ErasedAt    *time.Time `json:"erased_at,omitempty"` // When the payload was erased for a data subject, not covered by the hash
+	PrevHash    string     `json:"prev_hash" gorm:"size:64;not null"`
+	Hash        string     `json:"hash" gorm:"size:64;not null;uniqueIndex"`
+}
+
+func (Record) TableName() string {
+	return "audit_records"
+}
+
+// Encrypt a payload the way contacts are encrypted, when field encryption is enabled. The hash chain covers the
+// stored payload, so sealed payloads are never re-encrypted under a newer key and old keys have to be kept.
+func sealPayload(payload string) (string, error) {
+	if e := contacts.FieldEncryptionSettings(); e != nil && payload != "" {
+		return e.Cipher.Encrypt(payloadField, payload)
+	}
+	return payload, nil
+}
+
+func openPayload(payload string) (string, error) {
+	if !fieldcrypt.IsEncrypted(payload) {
+		return payload, nil
+	}
+	e := contacts.FieldEncryptionSettings()
+	if e == nil {
+		return "", contacts.ErrEncryptionNotConfigured
+	}
+	return e.Cipher.Decrypt(payloadField, payload)
+}
+
+// Decrypt the payloads of records read from the database, for reading rather than verifying
+func openRecords(records []Record) error {
+	for i := range records {
+		var err error
+		if records[i].Before, err = openPayload(records[i].Before); err != nil {
+			return err
+		}
+		if records[i].After, err = openPayload(records[i].After); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatTargetIDs(ids []uint) string {
+	if len(ids) == 0 {
+		return ""
+	}
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	return "," + strings.Join(parts, ",") + ","
+}
+
+// Target IDs of the record as a list
+func (rec Record) Targets() []uint {
+	var ids []uint
+	for _, part := range strings.Split(strings.Trim(rec.TargetIDs, ","), ",") {
+		if id, err := strconv.ParseUint(part, 10, 64); err == nil {
+			ids = append(ids, uint(id))
+		}
+	}
+	return ids
+}
+
+// Everything that is covered by the hash, in a fixed order
+type hashedFields struct {
+	Sequence    int64  `json:"sequence"`
+	Timestamp   string `json:"timestamp"`
+	Actor       string `json:"actor"`
+	Tenant      string `json:"tenant"`
+	Action      string `json:"action"`
+	TargetIDs   string `json:"target_ids"`
+	RequestID   string `json:"request_id"`
+	Status      int    `json:"status"`
+	Outcome     string `json:"outcome"`
+	PayloadHash string `json:"payload_hash"`
+	PrevHash    string `json:"prev_hash"`
+}
+
+// Fields covered by the hash of records without a payload hash, which hash the payload directly
+type legacyHashedFields struct {
+	Sequence  int64  `json:"sequence"`
+	Timestamp string `json:"timestamp"`
+	Actor     string `json:"actor"`
+	Tenant    string `json:"tenant"`
+	Action    string `json:"action"`
+	TargetIDs string `json:"target_ids"`
+	RequestID string `json:"request_id"`
+	Status    int    `json:"status"`
+	Outcome   string `json:"outcome"`
+	Before    string `json:"before"`
+	After     string `json:"after"`
+	PrevHash  string `json:"prev_hash"`
+}
+
+func payloadHash(before, after string) string {
+	data, _ := json.Marshal([]string{before, after})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (rec Record) computeHash() string {
+	// Postgres keeps microseconds, so that's all the hash can cover
+	timestamp := rec.Timestamp.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano)
+	if rec.PayloadHash == "" {
+		return rec.computeLegacyHash(timestamp)
+	}
+
+	data, _ := json.Marshal(hashedFields{
+		Sequence:    rec.Sequence,
+		Timestamp:   timestamp,
+		Actor:       rec.Actor,
+		Tenant:      rec.Tenant,
+		Action:      rec.Action,
+		TargetIDs:   rec.TargetIDs,
+		RequestID:   rec.RequestID,
+		Status:      rec.Status,
+		Outcome:     rec.Outcome,
+		PayloadHash: rec.PayloadHash,
+		PrevHash:    rec.PrevHash,
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (rec Record) computeLegacyHash(timestamp string) string {
+	data, _ := json.Marshal(legacyHashedFields{
+		Sequence:  rec.Sequence,
+		Timestamp: timestamp,
+		Actor:     rec.Actor,
+		Tenant:    rec.Tenant,
+		Action:    rec.Action,
+		TargetIDs: rec.TargetIDs,
+		RequestID: rec.RequestID,
+		Status:    rec.Status,
+		Outcome:   rec.Outcome,
+		Before:    rec.Before,
+		After:     rec.After,
+		PrevHash:  rec.PrevHash,
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Link a new record to the previous one in the chain, prev is nil for the very first record
+func (rec *Record) seal(prev *Record) {
+	rec.Timestamp = rec.Timestamp.UTC().Truncate(time.Microsecond)
+	if prev == nil {
+		rec.Sequence = 1
+		rec.PrevHash = genesisHash
+	} else {
+		rec.Sequence = prev.Sequence + 1
+		rec.PrevHash = prev.Hash
+	}
+	rec.PayloadHash = payloadHash(rec.Before, rec.After)
+	rec.Hash = rec.computeHash()
+}
+
+// Result of checking the hash chain
+type Verification struct {
+	Valid          bool   `json:"valid"`
+	RecordsChecked int64  `json:"records_checked"`
+	LastHash       string `json:"last_hash,omitempty"`       // Hash of the last valid record, keep a copy elsewhere to detect truncation
+	BrokenSequence int64  `json:"broken_sequence,omitempty"` // First record that doesn't match the chain
+	Reason         string `json:"reason,omitempty"`
+}
+
+// Check records that follow prev, which is nil when starting from the beginning of the chain
+func verifyChain(prev *Record, records []Record, result *Verification) bool {
+	for i := range records {
+		rec := records[i]
+		expectedSequence, expectedPrev := int64(1), genesisHash
+		if prev != nil {
+			expectedSequence, expectedPrev = prev.Sequence+1, prev.Hash
+		}
+
+		switch {
+		case rec.Sequence != expectedSequence:
+			result.Reason = fmt.Sprintf("expected sequence %d, found %d", expectedSequence, rec.Sequence)
+		case rec.PrevHash != expectedPrev:
+			result.Reason = "previous hash doesn't match the record before it"
+		case rec.ErasedAt != nil && (rec.Before != "" || rec.After != ""):
+			result.Reason = "erased record still has a payload"
+		case rec.ErasedAt != nil && rec.PayloadHash == "":
+			// Older records hash their payload directly, once erased only their place in the chain can be checked
+		case rec.computeHash() != rec.Hash:
+			result.Reason = "record contents don't match its hash"
+		case rec.ErasedAt == nil && rec.PayloadHash != "" && payloadHash(rec.Before, rec.After) != rec.PayloadHash:
+			result.Reason = "record payload doesn't match its hash"
+		}
+		if result.Reason != "" {
+			result.Valid = false
+			result.BrokenSequence = rec.Sequence
+			return false
+		}
+
+		result.RecordsChecked++
+		result.LastHash = rec.Hash
+		prev = &records[i]
+	}
+	return true
+}
@@ -0,0 +1,190 @@
+// Store audit records in the database
+package audit
+
+import (
+	"errors"
+	"golangphonebook/pkg/fieldcrypt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Key for the Postgres advisory lock that serializes appends across instances
+const appendLockKey = 7_290_029
+
+// Number of records read at a time when verifying or exporting
+const batchSize = 500
+
+// Page size when querying records
+const pageSize = 50
+
+// Anything audit records can be appended to
+type Sink interface {
+	Append(rec *Record) error
+}
+
+type Store struct {
+	DB *gorm.DB
+	mu sync.Mutex // Serializes appends within this instance, the advisory lock covers the others
+}
+
+// NewStore creates a new instance of Store
+func NewStore(db *gorm.DB) *Store {
+	return &Store{DB: db}
+}
+
+// Append a record to the end of the hash chain. Records are never deleted, and only updated to erase their payload.
+func (s *Store) Append(rec *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", appendLockKey).Error; err != nil {
+			return err
+		}
+
+		var last Record
+		err := tx.Order("sequence DESC").First(&last).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			rec.seal(nil)
+		} else if err != nil {
+			return err
+		} else {
+			rec.seal(&last)
+		}
+
+		return tx.Create(rec).Error
+	})
+}
+
+// Filters for querying records, zero values are ignored
+type Query struct {
+	Actor    string
+	Tenant   string
+	Action   string
+	Outcome  string
+	TargetID uint
+	From     time.Time
+	To       time.Time
+}
+
+func (s *Store) filtered(q Query) *gorm.DB {
+	query := s.DB.Model(&Record{})
+	if q.Actor != "" {
+		query = query.Where("actor = ?", q.Actor)
+	}
+	if q.Tenant != "" {
+		query = query.Where("tenant = ?", q.Tenant)
+	}
+	if q.Action != "" {
+		query = query.Where("action = ?", q.Action)
+	}
+	if q.Outcome != "" {
+		query = query.Where("outcome = ?", q.Outcome)
+	}
+	if q.TargetID != 0 {
+		query = query.Where("target_ids LIKE ?", "%"+formatTargetIDs([]uint{q.TargetID})+"%")
+	}
+	if !q.From.IsZero() {
+		query = query.Where("timestamp >= ?", q.From)
+	}
+	if !q.To.IsZero() {
+		query = query.Where("timestamp < ?", q.To)
+	}
+	return query
+}
+
+// One page of matching records, newest first, along with the total number of matches
+func (s *Store) Search(q Query, page int) ([]Record, int64, error) {
+	query := s.filtered(q)
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var records []Record
+	err := query.Order("sequence DESC").Limit(pageSize).Offset((page - 1) * pageSize).Find(&records).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return records, count, openRecords(records)
+}
+
+// Hand every matching record to fn, oldest first, without loading them all at once
+func (s *Store) Each(q Query, fn func(rec Record) error) error {
+	var afterSequence int64
+	for {
+		var records []Record
+		err := s.filtered(q).Where("sequence > ?", afterSequence).Order("sequence").Limit(batchSize).Find(&records).Error
+		if err != nil {
+			return err
+		}
+		if err := openRecords(records); err != nil {
+			return err
+		}
+		for _, rec := range records {
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+		if len(records) < batchSize {
+			return nil
+		}
+		afterSequence = records[len(records)-1].Sequence
+	}
+}
+
+// Walk the whole hash chain and report the first record that was tampered with
+func (s *Store) Verify() (*Verification, error) {
+	result := &Verification{Valid: true}
+	var prev *Record
+	for {
+		var records []Record
+		query := s.DB.Order("sequence").Limit(batchSize)
+		if prev != nil {
+			query = query.Where("sequence > ?", prev.Sequence)
+		}
+		if err := query.Find(&records).Error; err != nil {
+			return nil, err
+		}
+		if !verifyChain(prev, records, result) || len(records) < batchSize {
+			return result, nil
+		}
+		prev = &records[len(records)-1]
+	}
+}
+
+// Records of a tenant that still have a payload and either target one of the IDs or mention one of the terms
+// in their payload. Matching on text is loose, callers are expected to check the payloads themselves.
+// Encrypted payloads can't be searched, so with terms every encrypted record of the tenant is returned.
+func (s *Store) Mentioning(tenant string, targetIDs []uint, terms []string) ([]Record, error) {
+	conditions := s.DB.Where("1 = 0")
+	for _, id := range targetIDs {
+		conditions = conditions.Or("target_ids LIKE ?", "%"+formatTargetIDs([]uint{id})+"%")
+	}
+	for _, term := range terms {
+		conditions = conditions.Or("before ILIKE ?", "%"+term+"%").Or("after ILIKE ?", "%"+term+"%")
+	}
+	if len(terms) > 0 {
+		conditions = conditions.Or("before LIKE ?", fieldcrypt.Prefix+"%").Or("after LIKE ?", fieldcrypt.Prefix+"%")
+	}
+
+	var records []Record
+	err := s.DB.Where("tenant = ? AND erased_at IS NULL", tenant).Where(conditions).Order("sequence").Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+	return records, openRecords(records)
+}
+
+// Remove the payload of records while keeping them in the chain, the erasure time is left as a tombstone
+func (s *Store) ErasePayloads(ids []uint, at time.Time) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	result := s.DB.Model(&Record{}).Where("id IN ? AND erased_at IS NULL", ids).
+		UpdateColumns(map[string]interface{}{"before": "", "after": "", "erased_at": at.UTC()})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,139 @@
+package auth_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"golangphonebook/pkg/auth"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testConfig = auth.RoleConfig{
+	Rules: []auth.RoleRule{
+		{CN: "frontend", Role: auth.RoleReader},
+		{OU: "Support", Role: auth.RoleEditor},
+		{CN: "ops", OU: "Platform", Role: auth.RoleAdmin},
+		{SAN: "sync.example.com", Role: auth.RoleEditor},
+	},
+}
+
+func requestWithCert(cert *x509.Certificate) *http.Request {
+	req := httptest.NewRequest("GET", "/getContacts", nil)
+	if cert != nil {
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	}
+	return req
+}
+
+func TestRoleFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		cert     *x509.Certificate
+		expected auth.Role
+	}{
+		{"Common Name", &x509.Certificate{Subject: pkix.Name{CommonName: "frontend"}}, auth.RoleReader},
+		{"Organizational Unit", &x509.Certificate{Subject: pkix.Name{CommonName: "alice", OrganizationalUnit: []string{"Support"}}}, auth.RoleEditor},
+		{"All Attributes Must Match", &x509.Certificate{Subject: pkix.Name{CommonName: "ops"}}, ""},
+		{"Highest Role Wins", &x509.Certificate{Subject: pkix.Name{CommonName: "ops", OrganizationalUnit: []string{"Platform", "Support"}}}, auth.RoleAdmin},
+		{"Subject Alternative Name", &x509.Certificate{DNSNames: []string{"sync.example.com"}}, auth.RoleEditor},
+		{"No Match", &x509.Certificate{Subject: pkix.Name{CommonName: "stranger"}}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, testConfig.RoleFor(auth.IdentityFromCertificate(tt.cert)))
+		})
+	}
+
+	// Unmatched clients fall back to the default role
+	config := testConfig
+	config.DefaultRole = auth.RoleReader
+	assert.Equal(t, auth.RoleReader, config.RoleFor(auth.IdentityFromCertificate(&x509.Certificate{})))
+}
+
+func TestTenantFor(t *testing.T) {
+	config := auth.RoleConfig{
+		Rules: []auth.RoleRule{
+			{CN: "ops", Role: auth.RoleAdmin, Tenant: "platform"},
+		},
+	}
+
+	tenantOf := func(cert *x509.Certificate) string {
+		return config.TenantFor(auth.IdentityFromCertificate(cert))
+	}
+
+	assert.Equal(t, "platform", tenantOf(&x509.Certificate{Subject: pkix.Name{CommonName: "ops", OrganizationalUnit: []string{"Support"}}}))
+	assert.Equal(t, "Acme/Support", tenantOf(&x509.Certificate{Subject: pkix.Name{CommonName: "alice", OrganizationalUnit: []string{"Support"}, Organization: []string{"Acme"}}}))
+	assert.Equal(t, "Globex/Support", tenantOf(&x509.Certificate{Subject: pkix.Name{CommonName: "bob", OrganizationalUnit: []string{"Support"}, Organization: []string{"Globex"}}}))
+	assert.Equal(t, "Support", tenantOf(&x509.Certificate{Subject: pkix.Name{CommonName: "alice", OrganizationalUnit: []string{"Support"}}}))
+	assert.Equal(t, "Acme", tenantOf(&x509.Certificate{Subject: pkix.Name{CommonName: "alice", Organization: []string{"Acme"}}}))
+	assert.Equal(t, "alice", tenantOf(&x509.Certificate{Subject: pkix.Name{CommonName: "alice"}}))
+	assert.Equal(t, "", tenantOf(&x509.Certificate{}))
+}
+
+func TestRequire(t *testing.T) {
+	authz := &auth.Authorizer{Config: testConfig}
+
+	tests := []struct {
+		name               string
+		cert               *x509.Certificate
+		required           auth.Role
+		expectedStatusCode int
+		expectedResponse   string
+	}{
+		{"Allowed", &x509.Certificate{Subject: pkix.Name{CommonName: "frontend"}}, auth.RoleReader, http.StatusOK, "CN=frontend frontend"},
+		{"Higher Role Allowed", &x509.Certificate{Subject: pkix.Name{OrganizationalUnit: []string{"Support"}}}, auth.RoleReader, http.StatusOK, "OU=Support"},
+		{"Role Too Low", &x509.Certificate{Subject: pkix.Name{CommonName: "frontend"}}, auth.RoleEditor, http.StatusForbidden, "Forbidden: client CN=frontend has the reader role, GET /getContacts requires the editor role"},
+		{"Unmapped Client", &x509.Certificate{Subject: pkix.Name{CommonName: "stranger"}}, auth.RoleReader, http.StatusForbidden, "Forbidden: client CN=stranger is not mapped to any role"},
+		{"No Certificate", nil, auth.RoleReader, http.StatusUnauthorized, "a valid client certificate is required"},
+		{"No Tenant", &x509.Certificate{DNSNames: []string{"sync.example.com"}}, auth.RoleReader, http.StatusForbidden, "no tenant could be derived"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := authz.Require(tt.required, func(w http.ResponseWriter, r *http.Request) {
+				// The identity is passed on to the handler
+				id, ok := auth.FromContext(r.Context())
+				assert.True(t, ok)
+				w.Write([]byte(id.String() + " " + id.Tenant))
+			})
+
+			rr := httptest.NewRecorder()
+			handler(rr, requestWithCert(tt.cert))
+
+			assert.Equal(t, tt.expectedStatusCode, rr.Code)
+			assert.Contains(t, rr.Body.String(), tt.expectedResponse)
+		})
+	}
+}
+
+func TestLoadRoleConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "valid.yaml")
+	os.WriteFile(valid, []byte("default_role: reader\nrules:\n  - cn: ops\n    role: admin\n"), 0600)
+	config, err := auth.LoadRoleConfig(valid)
+	assert.NoError(t, err)
+	assert.Equal(t, auth.RoleReader, config.DefaultRole)
+	assert.Len(t, config.Rules, 1)
+
+	invalidRole := filepath.Join(dir, "invalid_role.yaml")
+	os.WriteFile(invalidRole, []byte("rules:\n  - cn: ops\n    role: superuser\n"), 0600)
+	_, err = auth.LoadRoleConfig(invalidRole)
+	assert.ErrorContains(t, err, `invalid role "superuser"`)
+
+	emptyRule := filepath.Join(dir, "empty_rule.yaml")
+	os.WriteFile(emptyRule, []byte("rules:\n  - role: admin\n"), 0600)
+	_, err = auth.LoadRoleConfig(emptyRule)
+	assert.ErrorContains(t, err, "must match on at least one of cn, ou, o or san")
+
+	// The config shipped with the repo has to stay valid
+	_, err = auth.LoadRoleConfig("../../config/roles.yaml")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,88 @@
+// Work out who is calling from their verified client certificate
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type Identity struct {
+	CommonName          string   `json:"common_name"`
+	Organizations       []string `json:"organizations"`
+	OrganizationalUnits []string `json:"organizational_units"`
+	SANs                []string `json:"sans"`   // DNS names, email addresses and URIs from the certificate
+	Role                Role     `json:"role"`   // Highest role granted by the role mapping, empty if none
+	Tenant              string   `json:"tenant"` // Tenant that owns the address books the client works with
+}
+
+func (id Identity) String() string {
+	if id.CommonName != "" {
+		return fmt.Sprintf("CN=%s", id.CommonName)
+	}
+	if len(id.OrganizationalUnits) > 0 {
+		return fmt.Sprintf("OU=%s", strings.Join(id.OrganizationalUnits, ","))
+	}
+	if len(id.SANs) > 0 {
+		return fmt.Sprintf("SAN=%s", id.SANs[0])
+	}
+	if len(id.Organizations) > 0 {
+		return fmt.Sprintf("O=%s", strings.Join(id.Organizations, ","))
+	}
+	return "anonymous"
+}
+
+func IdentityFromCertificate(cert *x509.Certificate) Identity {
+	id := Identity{
+		CommonName:          cert.Subject.CommonName,
+		Organizations:       cert.Subject.Organization,
+		OrganizationalUnits: cert.Subject.OrganizationalUnit,
+	}
+	id.SANs = append(id.SANs, cert.DNSNames...)
+	id.SANs = append(id.SANs, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		id.SANs = append(id.SANs, uri.String())
+	}
+	return id
+}
+
+// Identity of the client certificate on the request, false if there is none (plain HTTP or no client cert)
+func IdentityFromRequest(r *http.Request) (Identity, bool) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return Identity{}, false
+	}
+	// The first peer certificate is the leaf, the TLS config has already verified it chains to our CA
+	return IdentityFromCertificate(r.TLS.PeerCertificates[0]), true
+}
+
+type identityKey struct{}
+
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// Told the identity of a request once its role is checked, whether the request was let through or not
+type IdentityListener interface {
+	SetIdentity(id Identity)
+}
+
+type listenerKey struct{}
+
+// Have Require tell the listener who requests made with ctx come from
+func WithIdentityListener(ctx context.Context, listener IdentityListener) context.Context {
+	return context.WithValue(ctx, listenerKey{}, listener)
+}
+
+func notifyIdentity(ctx context.Context, id Identity) {
+	if listener, ok := ctx.Value(listenerKey{}).(IdentityListener); ok {
+		listener.SetIdentity(id)
+	}
+}
+
+// Identity stored on the context by the authorization middleware
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
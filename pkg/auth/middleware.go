@@ -0,0 +1,63 @@
+// Enforce roles on routes
+package auth
+
+import (
+	"fmt"
+	"golangphonebook/internal"
+	"golangphonebook/pkg/problem"
+	"net/http"
+)
+
+type Authorizer struct {
+	Config RoleConfig
+}
+
+// NewAuthorizer creates a new Authorizer from the role config file at path
+func NewAuthorizer(path string) (*Authorizer, error) {
+	config, err := LoadRoleConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Authorizer{Config: *config}, nil
+}
+
+// Fill in the role and tenant of a verified client, or explain why it can't run the operation.
+// The operation is only used in messages, like "GET /getContacts".
+func (a *Authorizer) Authorize(id Identity, required Role, operation string) (Identity, error) {
+	id.Role = a.Config.RoleFor(id)
+	// Also known for denied clients, so their attempts are audited under their tenant
+	id.Tenant = a.Config.TenantFor(id)
+	if !id.Role.Includes(required) {
+		internal.Logger.Warn(fmt.Sprintf("Denied %s for %s with role %q, requires %q", operation, id, id.Role, required))
+		if id.Role == "" {
+			return id, fmt.Errorf("Forbidden: client %s is not mapped to any role, %s requires the %s role", id, operation, required)
+		}
+		return id, fmt.Errorf("Forbidden: client %s has the %s role, %s requires the %s role", id, id.Role, operation, required)
+	}
+	if id.Tenant == "" {
+		internal.Logger.Warn(fmt.Sprintf("Denied %s for %s without a tenant", operation, id))
+		return id, fmt.Errorf("Forbidden: no tenant could be derived from the certificate of client %s", id)
+	}
+	return id, nil
+}
+
+// Wrap a handler so it only runs for clients whose certificate grants at least the required role
+func (a *Authorizer) Require(required Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := IdentityFromRequest(r)
+		if !ok {
+			internal.Logger.WarnContext(r.Context(), fmt.Sprintf("Rejected %s %s without a client certificate", r.Method, r.URL.Path))
+			problem.Error(w, r, "Unauthorized: a valid client certificate is required", http.StatusUnauthorized)
+			return
+		}
+
+		id, err := a.Authorize(id, required, r.Method+" "+r.URL.Path)
+		notifyIdentity(r.Context(), id)
+		if err != nil {
+			problem.Error(w, r, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		next(w, r.WithContext(WithIdentity(r.Context(), id)))
+	}
+}
@@ -0,0 +1,130 @@
+// Map certificate identities to roles from a config file
+package auth
+
+import (
+	"fmt"
+	"os"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Role string
+
+const (
+	RoleReader Role = "reader" // Can read contacts
+	RoleEditor Role = "editor" // Can also add, update, merge and delete contacts
+	RoleAdmin  Role = "admin"  // Can do everything
+)
+
+// Roles are hierarchical, a higher rank includes everything the lower ranks can do
+var roleRank = map[Role]int{
+	RoleReader: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+func (r Role) Valid() bool {
+	_, ok := roleRank[r]
+	return ok
+}
+
+// Does this role include everything the required role can do
+func (r Role) Includes(required Role) bool {
+	return r.Valid() && roleRank[r] >= roleRank[required]
+}
+
+// One mapping from certificate attributes to a role. Every attribute that is set has to match.
+type RoleRule struct {
+	CN     string `yaml:"cn"`
+	OU     string `yaml:"ou"`
+	O      string `yaml:"o"`
+	SAN    string `yaml:"san"`
+	Role   Role   `yaml:"role"`
+	Tenant string `yaml:"tenant"` // Optional tenant for matching clients, overrides the one derived from the certificate
+}
+
+func (rule RoleRule) matches(id Identity) bool {
+	if rule.CN != "" && rule.CN != id.CommonName {
+		return false
+	}
+	if rule.OU != "" && !slices.Contains(id.OrganizationalUnits, rule.OU) {
+		return false
+	}
+	if rule.O != "" && !slices.Contains(id.Organizations, rule.O) {
+		return false
+	}
+	if rule.SAN != "" && !slices.Contains(id.SANs, rule.SAN) {
+		return false
+	}
+	return true
+}
+
+type RoleConfig struct {
+	DefaultRole Role       `yaml:"default_role"` // Role for verified clients no rule matches, empty denies them
+	Rules       []RoleRule `yaml:"rules"`
+}
+
+func (c RoleConfig) Validate() error {
+	if c.DefaultRole != "" && !c.DefaultRole.Valid() {
+		return fmt.Errorf("invalid default_role %q", c.DefaultRole)
+	}
+	for i, rule := range c.Rules {
+		if !rule.Role.Valid() {
+			return fmt.Errorf("rule %d has invalid role %q", i, rule.Role)
+		}
+		if rule.CN == "" && rule.OU == "" && rule.O == "" && rule.SAN == "" {
+			return fmt.Errorf("rule %d must match on at least one of cn, ou, o or san", i)
+		}
+	}
+	return nil
+}
+
+func LoadRoleConfig(path string) (*RoleConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config RoleConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("unable to parse role config %s: %v", path, err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid role config %s: %v", path, err)
+	}
+	return &config, nil
+}
+
+// Highest role any rule grants to this identity, falling back to the default role
+func (c RoleConfig) RoleFor(id Identity) Role {
+	var role Role
+	for _, rule := range c.Rules {
+		if rule.matches(id) && roleRank[rule.Role] > roleRank[role] {
+			role = rule.Role
+		}
+	}
+	if role == "" {
+		role = c.DefaultRole
+	}
+	return role
+}
+
+// Tenant of the first matching rule that sets one, otherwise the certificate's first O and first OU as "O/OU",
+// or whichever of the two it has, then its CN. The O is part of it, so the same OU in two organizations is two tenants.
+func (c RoleConfig) TenantFor(id Identity) string {
+	for _, rule := range c.Rules {
+		if rule.Tenant != "" && rule.matches(id) {
+			return rule.Tenant
+		}
+	}
+	switch {
+	case len(id.Organizations) > 0 && len(id.OrganizationalUnits) > 0:
+		return id.Organizations[0] + "/" + id.OrganizationalUnits[0]
+	case len(id.Organizations) > 0:
+		return id.Organizations[0]
+	case len(id.OrganizationalUnits) > 0:
+		return id.OrganizationalUnits[0]
+	}
+	return id.CommonName
+}
@@ -0,0 +1,504 @@
+// Settings of the server, from defaults, a YAML or TOML file, environment variables and flags
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"golangphonebook/internal"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Shown instead of secrets when the config is printed
+const mask = "********"
+
+// Returned by Load after -print-config printed the config, like flag.ErrHelp after -h
+var ErrPrinted = errors.New("config printed")
+
+// Every setting, each field names its key in the file and, for settings that had one before, its environment variable.
+// Flags are named after the keys, like -server.addr.
+type Config struct {
+	Server      Server      `yaml:"server"`
+	TLS         TLS         `yaml:"tls"`
+	DB          Database    `yaml:"db"`
+	GRPC        GRPC        `yaml:"grpc"`
+	Auth        Auth        `yaml:"auth"`
+	Contacts    Contacts    `yaml:"contacts"`
+	GraphQL     GraphQL     `yaml:"graphql"`
+	Encryption  Encryption  `yaml:"encryption"`
+	Events      Events      `yaml:"events"`
+	Webhooks    Webhooks    `yaml:"webhooks"`
+	Idempotency Idempotency `yaml:"idempotency"`
+	Log         Log         `yaml:"log"`
+	Tracing     Tracing     `yaml:"tracing"`
+}
+
+type Server struct {
+	Addr            string        `yaml:"addr" env:"HTTPS_ADDR" usage:"Address HTTPS is served on"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" usage:"Longest requests and background work get to finish on SIGTERM or SIGINT"`
+	AdminAddr       string        `yaml:"admin_addr" env:"ADMIN_ADDR" usage:"Address the health probes and metrics are also served on over plain HTTP, off when empty"`
+	HealthTimeout   time.Duration `yaml:"health_timeout" env:"HEALTH_TIMEOUT" usage:"Longest the readiness checks get"`
+
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" env:"READ_HEADER_TIMEOUT" usage:"Longest a client gets to send the request headers"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" env:"READ_TIMEOUT" usage:"Longest a client gets to send the whole request"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" env:"WRITE_TIMEOUT" usage:"Longest writing a response may take, event streams excepted, 0 for none"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" env:"IDLE_TIMEOUT" usage:"How long idle keep-alive connections stay open"`
+	RequestTimeout    time.Duration `yaml:"request_timeout" env:"REQUEST_TIMEOUT" usage:"Deadline of a request, after which its database work is canceled"`
+	RouteTimeouts     string        `yaml:"route_timeouts" env:"ROUTE_TIMEOUTS" usage:"Deadlines of single routes, like GET /getContacts=10s,POST /mergeContacts=1m"`
+}
+
+type TLS struct {
+	CertFile     string `yaml:"cert_file" env:"TLS_CERT_FILE" usage:"Server certificate, used for HTTPS and gRPC"`
+	KeyFile      string `yaml:"key_file" env:"TLS_KEY_FILE" usage:"Private key of the server certificate"`
+	ClientCAFile string `yaml:"client_ca_file" env:"TLS_CLIENT_CA_FILE" usage:"CA that signs client certificates"`
+}
+
+type Database struct {
+	Host         string `yaml:"host" env:"DB_HOST"`
+	Port         int    `yaml:"port" env:"DB_PORT"`
+	User         string `yaml:"user" env:"DB_USER"`
+	Password     string `yaml:"password" env:"DB_PASSWORD" secret:"true"`
+	Name         string `yaml:"name" env:"DB_NAME"`
+	SSLMode      string `yaml:"sslmode" env:"DB_SSLMODE" usage:"disable, allow, prefer, require, verify-ca or verify-full"`
+	SSLRootCert  string `yaml:"sslrootcert" env:"DB_SSLROOTCERT" usage:"CA certificates the database's certificate is checked against, for verify-ca and verify-full"`
+	SSLCert      string `yaml:"sslcert" env:"DB_SSLCERT" usage:"Client certificate, for databases that authenticate with one"`
+	SSLKey       string `yaml:"sslkey" env:"DB_SSLKEY" usage:"Private key of the client certificate"`
+	LegacyTenant string `yaml:"legacy_tenant" env:"LEGACY_TENANT" usage:"Tenant that contacts from before address books are given to"`
+
+	ConnectTimeout      time.Duration `yaml:"connect_timeout" env:"DB_CONNECT_TIMEOUT" usage:"Longest one connection attempt may take, in whole seconds"`
+	StartupTimeout      time.Duration `yaml:"startup_timeout" env:"DB_STARTUP_TIMEOUT" usage:"How long connecting is retried at startup, 0 for a single attempt"`
+	MaxOpenConns        int           `yaml:"max_open_conns" env:"DB_MAX_OPEN_CONNS" usage:"Most connections open at once"`
+	MaxIdleConns        int           `yaml:"max_idle_conns" env:"DB_MAX_IDLE_CONNS" usage:"Most idle connections kept open"`
+	ConnMaxLifetime     time.Duration `yaml:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME" usage:"Connections are replaced once they are this old"`
+	ConnMaxIdleTime     time.Duration `yaml:"conn_max_idle_time" env:"DB_CONN_MAX_IDLE_TIME" usage:"Connections idle for this long are closed"`
+	HealthCheckInterval time.Duration `yaml:"health_check_interval" env:"DB_HEALTH_CHECK_INTERVAL" usage:"How often the database is pinged, replacing connections it dropped"`
+	AutoMigrate         bool          `yaml:"auto_migrate" env:"DB_AUTO_MIGRATE" usage:"Apply pending schema migrations at startup, otherwise run the migrate command first"`
+}
+
+type GRPC struct {
+	Addr string `yaml:"addr" env:"GRPC_ADDR" usage:"Address gRPC is served on"`
+}
+
+type Auth struct {
+	RolesFile string `yaml:"roles_file" env:"ROLES_FILE" usage:"Maps client certificates to roles"`
+}
+
+type Contacts struct {
+	PageSize     int `yaml:"page_size" env:"CONTACTS_PAGE_SIZE" usage:"Contacts per page of a listing"`
+	MaxBatchSize int `yaml:"max_batch_size" env:"CONTACTS_MAX_BATCH_SIZE" usage:"Most contacts one request can add, delete or merge"`
+}
+
+type GraphQL struct {
+	MaxDepth        int `yaml:"max_depth" env:"GRAPHQL_MAX_DEPTH"`
+	MaxCost         int `yaml:"max_cost" env:"GRAPHQL_MAX_COST"`
+	MaxPageSize     int `yaml:"max_page_size" env:"GRAPHQL_MAX_PAGE_SIZE"`
+	DefaultPageSize int `yaml:"default_page_size" env:"GRAPHQL_DEFAULT_PAGE_SIZE"`
+	ListSize        int `yaml:"list_size" env:"GRAPHQL_LIST_SIZE"`
+}
+
+type Encryption struct {
+	KeyFile      string `yaml:"key_file" env:"FIELD_ENCRYPTION_KEYFILE" usage:"Key file for encryption at rest, personal data is stored unencrypted without one"`
+	EncryptNames bool   `yaml:"encrypt_names" env:"FIELD_ENCRYPTION_NAMES" usage:"Encrypt first and last names too"`
+}
+
+type Events struct {
+	History   int           `yaml:"history" env:"EVENTS_HISTORY" usage:"Events kept for clients that resume"`
+	Heartbeat time.Duration `yaml:"heartbeat" env:"EVENTS_HEARTBEAT" usage:"How often idle streams are kept alive"`
+}
+
+type Webhooks struct {
+	Timeout     time.Duration `yaml:"timeout" env:"WEBHOOKS_TIMEOUT" usage:"Longest a receiver has to answer"`
+	Interval    time.Duration `yaml:"interval" env:"WEBHOOKS_INTERVAL" usage:"How often the outbox is checked"`
+	BatchSize   int           `yaml:"batch_size" env:"WEBHOOKS_BATCH_SIZE"`
+	MaxAttempts int           `yaml:"max_attempts" env:"WEBHOOKS_MAX_ATTEMPTS"`
+	MinBackoff  time.Duration `yaml:"min_backoff" env:"WEBHOOKS_MIN_BACKOFF"`
+	MaxBackoff  time.Duration `yaml:"max_backoff" env:"WEBHOOKS_MAX_BACKOFF"`
+}
+
+type Idempotency struct {
+	TTL           time.Duration `yaml:"ttl" env:"IDEMPOTENCY_TTL" usage:"How long responses are kept for retries"`
+	PurgeInterval time.Duration `yaml:"purge_interval" env:"IDEMPOTENCY_PURGE_INTERVAL" usage:"How often expired keys are removed"`
+}
+
+type Log struct {
+	Level     string `yaml:"level" env:"LOG_LEVEL" usage:"Least severe messages logged, debug, info, warn or error"`
+	Format    string `yaml:"format" env:"LOG_FORMAT" usage:"text for key=value lines or json for a JSON object per line"`
+	Redaction string `yaml:"redaction" env:"LOG_REDACTION" usage:"How personal data is masked in the logs, like mask or phone=off"`
+}
+
+type Tracing struct {
+	Exporter    string  `yaml:"exporter" env:"TRACING_EXPORTER" usage:"Where spans go, none, otlp, stdout or file"`
+	Endpoint    string  `yaml:"endpoint" env:"TRACING_ENDPOINT" usage:"OTLP over HTTP collector URL, for the otlp exporter"`
+	File        string  `yaml:"file" env:"TRACING_FILE" usage:"File spans are appended to as JSON, for the file exporter"`
+	SampleRatio float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO" usage:"Share of new traces recorded, between 0 and 1"`
+	ServiceName string  `yaml:"service_name" env:"TRACING_SERVICE_NAME" usage:"Name the spans are reported under"`
+}
+
+// Settings used when nothing else sets them, what used to be hard-coded
+func Default() Config {
+	return Config{
+		Server: Server{
+			Addr:              ":8443",
+			ShutdownTimeout:   30 * time.Second,
+			HealthTimeout:     2 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      time.Minute,
+			IdleTimeout:       2 * time.Minute,
+			RequestTimeout:    30 * time.Second,
+		},
+		TLS: TLS{CertFile: "certs/server.crt", KeyFile: "certs/server.key", ClientCAFile: "certs/ca.crt"},
+		DB: Database{
+			Port:                5432,
+			SSLMode:             "disable",
+			ConnectTimeout:      5 * time.Second,
+			StartupTimeout:      time.Minute,
+			MaxOpenConns:        20,
+			MaxIdleConns:        10,
+			ConnMaxLifetime:     30 * time.Minute,
+			ConnMaxIdleTime:     5 * time.Minute,
+			HealthCheckInterval: 15 * time.Second,
+			AutoMigrate:         true,
+		},
+		GRPC: GRPC{Addr: ":9443"},
+		Auth: Auth{RolesFile: "config/roles.yaml"},
+		Contacts: Contacts{
+			PageSize:     10,
+			MaxBatchSize: 20,
+		},
+		GraphQL: GraphQL{MaxDepth: 10, MaxCost: 1000, MaxPageSize: 100, DefaultPageSize: 10, ListSize: 10},
+		Events:  Events{History: 1000, Heartbeat: 15 * time.Second},
+		Webhooks: Webhooks{
+			Timeout:     10 * time.Second,
+			Interval:    time.Second,
+			BatchSize:   20,
+			MaxAttempts: 10,
+			MinBackoff:  30 * time.Second,
+			MaxBackoff:  6 * time.Hour,
+		},
+		Idempotency: Idempotency{TTL: 24 * time.Hour, PurgeInterval: time.Hour},
+		Log:         Log{Level: "info", Format: internal.FormatText, Redaction: "strict"},
+		Tracing:     Tracing{Exporter: "none", Endpoint: "http://localhost:4318", SampleRatio: 1, ServiceName: "phonebook"},
+	}
+}
+
+// Load the config. Later sources override earlier ones: defaults, then the file from -config or CONFIG_FILE,
+// then environment variables, then flags. Args are the command-line arguments without the program name.
+// With -print-config the config is printed and ErrPrinted returned, with -h the flags are and flag.ErrHelp is.
+func Load(args []string) (*Config, error) {
+	cfg, _, err := LoadWithArgs(args)
+	return cfg, err
+}
+
+// Like Load, also returning the arguments after the flags, for subcommands like migrate
+func LoadWithArgs(args []string) (*Config, []string, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("phonebook", flag.ContinueOnError)
+	file := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML config file")
+	printConfig := fs.Bool("print-config", false, "Print the effective config with secrets masked and exit")
+	// Flags are only applied once the file and environment are read, so they are collected first
+	var set []func() error
+	for _, s := range settings(&cfg) {
+		register(fs, s, &set)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if *file != "" {
+		if err := cfg.loadFile(*file); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, s := range settings(&cfg) {
+		if s.env == "" {
+			continue
+		}
+		if value := os.Getenv(s.env); value != "" {
+			if err := s.set(value); err != nil {
+				return nil, nil, fmt.Errorf("invalid %s: %w", s.env, err)
+			}
+		}
+	}
+	for _, apply := range set {
+		if err := apply(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	if *printConfig {
+		fmt.Fprint(os.Stdout, cfg.String())
+		return &cfg, nil, ErrPrinted
+	}
+	return &cfg, fs.Args(), nil
+}
+
+// Read a YAML or TOML file, by its extension. Keys the config doesn't have are rejected, they are most likely typos.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+	case ".toml":
+		tables, err := parseTOML(data)
+		if err != nil {
+			return fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+		// The TOML is decoded like YAML, so both formats take the same keys and values
+		if data, err = yaml.Marshal(tables); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("config file %s must end in .yaml, .yml or .toml", path)
+	}
+
+	decoder := yaml.NewDecoder(strings.NewReader(string(data)))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Check every setting, reporting all problems at once
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	validAddr := func(addr string) bool {
+		_, port, err := net.SplitHostPort(addr)
+		return err == nil && port != ""
+	}
+
+	check(validAddr(c.Server.Addr), "server.addr %q must be a host and port like :8443", c.Server.Addr)
+	check(validAddr(c.GRPC.Addr), "grpc.addr %q must be a host and port like :9443", c.GRPC.Addr)
+	check(c.Server.Addr != c.GRPC.Addr, "server.addr and grpc.addr must differ")
+	check(c.Server.ShutdownTimeout > 0 && c.Server.HealthTimeout > 0, "server.shutdown_timeout and server.health_timeout must be positive")
+	check(c.Server.AdminAddr == "" || validAddr(c.Server.AdminAddr), "server.admin_addr %q must be empty or a host and port like :8080", c.Server.AdminAddr)
+	check(c.Server.AdminAddr == "" || (c.Server.AdminAddr != c.Server.Addr && c.Server.AdminAddr != c.GRPC.Addr), "server.admin_addr must differ from server.addr and grpc.addr")
+	check(c.Server.ReadHeaderTimeout > 0 && c.Server.ReadTimeout > 0 && c.Server.IdleTimeout > 0 && c.Server.RequestTimeout > 0,
+		"server.read_header_timeout, server.read_timeout, server.idle_timeout and server.request_timeout must be positive")
+	check(c.Server.WriteTimeout >= 0, "server.write_timeout must not be negative")
+	if routes, err := ParseRouteTimeouts(c.Server.RouteTimeouts); err != nil {
+		errs = append(errs, fmt.Errorf("server.route_timeouts: %w", err))
+	} else {
+		// A response has to be written before the connection is cut, or the client never learns the deadline passed
+		longest := c.Server.RequestTimeout
+		for _, timeout := range routes {
+			longest = max(longest, timeout)
+		}
+		check(c.Server.WriteTimeout == 0 || c.Server.WriteTimeout > longest,
+			"server.write_timeout %v must be longer than every request deadline, the longest is %v", c.Server.WriteTimeout, longest)
+	}
+	check(c.TLS.CertFile != "" && c.TLS.KeyFile != "" && c.TLS.ClientCAFile != "", "tls.cert_file, tls.key_file and tls.client_ca_file are required")
+	check(c.DB.Port > 0 && c.DB.Port < 65536, "db.port %d must be between 1 and 65535", c.DB.Port)
+	check(slices.Contains([]string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}, c.DB.SSLMode),
+		"db.sslmode %q must be disable, allow, prefer, require, verify-ca or verify-full", c.DB.SSLMode)
+	check(!strings.HasPrefix(c.DB.SSLMode, "verify-") || c.DB.SSLRootCert != "", "db.sslrootcert is required for sslmode %s", c.DB.SSLMode)
+	check((c.DB.SSLCert == "") == (c.DB.SSLKey == ""), "db.sslcert and db.sslkey must be set together")
+	check(c.DB.ConnectTimeout >= time.Second, "db.connect_timeout must be at least 1s")
+	check(c.DB.StartupTimeout >= 0, "db.startup_timeout must not be negative")
+	check(c.DB.MaxOpenConns > 0 && c.DB.MaxIdleConns >= 0 && c.DB.MaxIdleConns <= c.DB.MaxOpenConns,
+		"db.max_open_conns %d must be positive and db.max_idle_conns %d between 0 and it", c.DB.MaxOpenConns, c.DB.MaxIdleConns)
+	check(c.DB.ConnMaxLifetime > 0 && c.DB.ConnMaxIdleTime > 0 && c.DB.HealthCheckInterval > 0,
+		"db.conn_max_lifetime, db.conn_max_idle_time and db.health_check_interval must be positive")
+	check(c.Auth.RolesFile != "", "auth.roles_file is required")
+	check(c.Contacts.PageSize >= 1 && c.Contacts.PageSize <= 1000, "contacts.page_size %d must be between 1 and 1000", c.Contacts.PageSize)
+	check(c.Contacts.MaxBatchSize >= 2 && c.Contacts.MaxBatchSize <= 1000, "contacts.max_batch_size %d must be between 2 and 1000", c.Contacts.MaxBatchSize)
+	check(c.GraphQL.MaxDepth > 0 && c.GraphQL.MaxCost > 0 && c.GraphQL.MaxPageSize > 0 && c.GraphQL.DefaultPageSize > 0 && c.GraphQL.ListSize > 0,
+		"graphql limits must be positive")
+	check(c.GraphQL.DefaultPageSize <= c.GraphQL.MaxPageSize, "graphql.default_page_size %d must be at most graphql.max_page_size %d",
+		c.GraphQL.DefaultPageSize, c.GraphQL.MaxPageSize)
+	check(c.Events.History > 0, "events.history %d must be positive", c.Events.History)
+	check(c.Events.Heartbeat > 0, "events.heartbeat must be positive")
+	check(c.Webhooks.Timeout > 0 && c.Webhooks.Interval > 0 && c.Webhooks.MinBackoff > 0, "webhooks.timeout, webhooks.interval and webhooks.min_backoff must be positive")
+	check(c.Webhooks.BatchSize > 0 && c.Webhooks.MaxAttempts > 0, "webhooks.batch_size and webhooks.max_attempts must be positive")
+	check(c.Webhooks.MinBackoff <= c.Webhooks.MaxBackoff, "webhooks.min_backoff must be at most webhooks.max_backoff")
+	check(c.Idempotency.TTL > 0 && c.Idempotency.PurgeInterval > 0, "idempotency.ttl and idempotency.purge_interval must be positive")
+	if _, err := internal.ParseLevel(c.Log.Level); err != nil {
+		errs = append(errs, fmt.Errorf("log.level: %w", err))
+	}
+	check(c.Log.Format == internal.FormatText || c.Log.Format == internal.FormatJSON, "log.format %q must be text or json", c.Log.Format)
+	if _, err := internal.ParseRedactionPolicy(c.Log.Redaction); err != nil {
+		errs = append(errs, fmt.Errorf("log.redaction: %w", err))
+	}
+	check(slices.Contains([]string{"none", "otlp", "stdout", "file"}, c.Tracing.Exporter), "tracing.exporter %q must be none, otlp, stdout or file", c.Tracing.Exporter)
+	check(c.Tracing.Exporter != "otlp" || c.Tracing.Endpoint != "", "tracing.endpoint is required for the otlp exporter")
+	check(c.Tracing.Exporter != "file" || c.Tracing.File != "", "tracing.file is required for the file exporter")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio %v must be between 0 and 1", c.Tracing.SampleRatio)
+	check(c.Tracing.ServiceName != "", "tracing.service_name is required")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Copy of the config with every secret replaced, safe to log
+func (c Config) Masked() Config {
+	masked := c
+	for _, s := range settings(&masked) {
+		if s.secret && !s.value.IsZero() {
+			s.value.SetString(mask)
+		}
+	}
+	return masked
+}
+
+// The config as YAML, with secrets masked
+func (c Config) String() string {
+	var out strings.Builder
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c.Masked()); err != nil {
+		return err.Error()
+	}
+	return out.String()
+}
+
+// Parse deadlines of single routes, like "GET /getContacts=10s,POST /mergeContacts=1m", keyed by method and path template
+func ParseRouteTimeouts(spec string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		route, raw, ok := strings.Cut(part, "=")
+		method, path, hasPath := strings.Cut(strings.TrimSpace(route), " ")
+		if !ok || !hasPath || method == "" || !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("%q must be a method, path and duration like GET /getContacts=10s", part)
+		}
+		timeout, err := time.ParseDuration(strings.TrimSpace(raw))
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("%q is not a positive duration like 10s", raw)
+		}
+		timeouts[strings.ToUpper(method)+" "+path] = timeout
+	}
+	return timeouts, nil
+}
+
+// Connection string for Postgres
+func (d Database) DSN() string {
+	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s connect_timeout=%d",
+		dsnValue(d.Host), d.Port, dsnValue(d.User), dsnValue(d.Password), dsnValue(d.Name), d.SSLMode, int(d.ConnectTimeout.Seconds()))
+	for _, file := range [][2]string{{"sslrootcert", d.SSLRootCert}, {"sslcert", d.SSLCert}, {"sslkey", d.SSLKey}} {
+		if file[1] != "" {
+			dsn += " " + file[0] + "=" + dsnValue(file[1])
+		}
+	}
+	return dsn
+}
+
+// Quote a value of a connection string when it is empty or has spaces, quotes or backslashes
+func dsnValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// A single value of the config
+type setting struct {
+	key    string // Like server.addr
+	env    string
+	usage  string
+	secret bool
+	value  reflect.Value
+}
+
+// Every setting of the config, in the order of the struct
+func settings(c *Config) []setting {
+	var all []setting
+	sections := reflect.ValueOf(c).Elem()
+	for i := range sections.NumField() {
+		section, sectionField := sections.Field(i), sections.Type().Field(i)
+		for j := range section.NumField() {
+			field := section.Type().Field(j)
+			all = append(all, setting{
+				key:    sectionField.Tag.Get("yaml") + "." + field.Tag.Get("yaml"),
+				env:    field.Tag.Get("env"),
+				usage:  field.Tag.Get("usage"),
+				secret: field.Tag.Get("secret") == "true",
+				value:  section.Field(j),
+			})
+		}
+	}
+	return all
+}
+
+// Parse a string into the setting's type
+func (s setting) set(raw string) error {
+	switch s.value.Interface().(type) {
+	case string:
+		s.value.SetString(raw)
+	case bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", raw)
+		}
+		s.value.SetBool(b)
+	case int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", raw)
+		}
+		s.value.SetInt(int64(n))
+	case float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		s.value.SetFloat(f)
+	case time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration like 30s or 24h", raw)
+		}
+		s.value.SetInt(int64(d))
+	default:
+		return fmt.Errorf("unsupported type %s", s.value.Type())
+	}
+	return nil
+}
+
+// Add a flag for the setting, recording it in set when it is given
+func register(fs *flag.FlagSet, s setting, set *[]func() error) {
+	usage := s.usage
+	if s.env != "" {
+		usage = strings.TrimSpace(usage + " (env " + s.env + ")")
+	}
+	record := func(raw string) error {
+		*set = append(*set, func() error {
+			if err := s.set(raw); err != nil {
+				return fmt.Errorf("invalid -%s: %w", s.key, err)
+			}
+			return nil
+		})
+		return nil
+	}
+	if s.value.Kind() == reflect.Bool {
+		fs.BoolFunc(s.key, usage, record)
+		return
+	}
+	fs.Func(s.key, usage, record)
+}
@@ -0,0 +1,239 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The docker-compose test service sets DB_HOST and the like, the tests expect the defaults
+func TestMain(m *testing.M) {
+	var cfg Config
+	for _, s := range settings(&cfg) {
+		if s.env != "" {
+			os.Unsetenv(s.env)
+		}
+	}
+	os.Unsetenv("CONFIG_FILE")
+	os.Exit(m.Run())
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestDefaults(t *testing.T) {
+	cfg, err := Load(nil)
+	require.NoError(t, err)
+	assert.Equal(t, Default(), *cfg)
+	assert.Equal(t, ":8443", cfg.Server.Addr)
+	assert.Equal(t, "disable", cfg.DB.SSLMode)
+	assert.Equal(t, 10, cfg.Contacts.PageSize)
+	assert.Equal(t, 20, cfg.Contacts.MaxBatchSize)
+}
+
+func TestPrecedence(t *testing.T) {
+	file := writeFile(t, "phonebook.yaml", `
+server:
+  addr: ":7443"
+db:
+  host: file-host
+  port: 6543
+contacts:
+  page_size: 25
+idempotency:
+  ttl: 2h
+`)
+	t.Setenv("DB_HOST", "env-host")
+	t.Setenv("CONTACTS_PAGE_SIZE", "30")
+
+	cfg, err := Load([]string{"-config", file, "-contacts.page_size", "40", "-encryption.encrypt_names"})
+	require.NoError(t, err)
+	assert.Equal(t, ":7443", cfg.Server.Addr, "file over defaults")
+	assert.Equal(t, 6543, cfg.DB.Port)
+	assert.Equal(t, "env-host", cfg.DB.Host, "env over file")
+	assert.Equal(t, 40, cfg.Contacts.PageSize, "flags over env")
+	assert.Equal(t, 2*time.Hour, cfg.Idempotency.TTL)
+	assert.True(t, cfg.Encryption.EncryptNames)
+	assert.Equal(t, ":9443", cfg.GRPC.Addr, "defaults for the rest")
+}
+
+func TestConfigFileFromEnv(t *testing.T) {
+	t.Setenv("CONFIG_FILE", writeFile(t, "phonebook.yml", "grpc:\n  addr: \":9999\"\n"))
+	cfg, err := Load(nil)
+	require.NoError(t, err)
+	assert.Equal(t, ":9999", cfg.GRPC.Addr)
+}
+
+func TestLoadWithArgs(t *testing.T) {
+	cfg, args, err := LoadWithArgs([]string{"-db.host", "flag-host", "to", "3"})
+	require.NoError(t, err)
+	assert.Equal(t, "flag-host", cfg.DB.Host)
+	assert.Equal(t, []string{"to", "3"}, args, "flags stop at the first argument")
+}
+
+func TestTOML(t *testing.T) {
+	file := writeFile(t, "phonebook.toml", `
+# Same keys as the YAML file
+[server]
+addr = ":7443" # inline comment
+
+[db]
+host = 'db.internal'
+port = 6_543
+password = "p#ss\"word"
+
+[encryption]
+encrypt_names = true
+
+[webhooks]
+max_backoff = "1h"
+graphql.max_cost = 500
+`)
+	_, err := Load([]string{"-config", file})
+	require.Error(t, err, "webhooks has no graphql table")
+
+	file = writeFile(t, "phonebook.toml", `
+[server]
+addr = ":7443" # inline comment
+
+[db]
+host = 'db.internal'
+port = 6_543
+password = "p#ss\"word"
+
+[encryption]
+encrypt_names = true
+
+[webhooks]
+max_backoff = "1h"
+
+[graphql]
+max_cost = 500
+`)
+	cfg, err := Load([]string{"-config", file})
+	require.NoError(t, err)
+	assert.Equal(t, ":7443", cfg.Server.Addr)
+	assert.Equal(t, "db.internal", cfg.DB.Host)
+	assert.Equal(t, 6543, cfg.DB.Port)
+	assert.Equal(t, `p#ss"word`, cfg.DB.Password)
+	assert.True(t, cfg.Encryption.EncryptNames)
+	assert.Equal(t, time.Hour, cfg.Webhooks.MaxBackoff)
+	assert.Equal(t, 500, cfg.GraphQL.MaxCost)
+}
+
+func TestParseTOML(t *testing.T) {
+	for name, content := range map[string]string{
+		"Missing Value":   "[db]\nhost =",
+		"Unterminated":    "[db]\nhost = \"db",
+		"Array":           "[db]\nhosts = [\"a\", \"b\"]",
+		"Array Of Tables": "[[db]]",
+		"Set Twice":       "[db]\nport = 1\nport = 2",
+		"No Equals":       "[db]\nhost",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseTOML([]byte(content))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	t.Run("Unknown Key", func(t *testing.T) {
+		_, err := Load([]string{"-config", writeFile(t, "phonebook.yaml", "db:\n  hots: localhost\n")})
+		assert.ErrorContains(t, err, "hots")
+	})
+
+	t.Run("Unknown Extension", func(t *testing.T) {
+		_, err := Load([]string{"-config", writeFile(t, "phonebook.json", "{}")})
+		assert.Error(t, err)
+	})
+
+	t.Run("Bad Env", func(t *testing.T) {
+		t.Setenv("DB_PORT", "postgres")
+		_, err := Load(nil)
+		assert.ErrorContains(t, err, "DB_PORT")
+	})
+
+	t.Run("Bad Flag", func(t *testing.T) {
+		_, err := Load([]string{"-idempotency.ttl", "a day"})
+		assert.ErrorContains(t, err, "-idempotency.ttl")
+	})
+
+	t.Run("Every Problem Is Reported", func(t *testing.T) {
+		_, err := Load([]string{"-db.sslmode", "sometimes", "-contacts.page_size", "0", "-server.addr", "8443", "-log.redaction", "hide", "-log.level", "loud", "-log.format", "xml", "-tracing.exporter", "jaeger", "-tracing.sample_ratio", "2"})
+		require.Error(t, err)
+		for _, key := range []string{"db.sslmode", "contacts.page_size", "server.addr", "log.redaction", "log.level", "log.format", "tracing.exporter", "tracing.sample_ratio"} {
+			assert.ErrorContains(t, err, key)
+		}
+	})
+
+	t.Run("Route Deadline Past The Write Timeout", func(t *testing.T) {
+		_, err := Load([]string{"-server.route_timeouts", "GET /getContacts=10s,POST /mergeContacts=2m"})
+		assert.ErrorContains(t, err, "server.write_timeout 1m0s must be longer than every request deadline, the longest is 2m0s")
+	})
+
+	t.Run("Verify Without A CA", func(t *testing.T) {
+		_, err := Load([]string{"-db.sslmode", "verify-full"})
+		assert.ErrorContains(t, err, "db.sslrootcert is required for sslmode verify-full")
+	})
+
+	t.Run("Same Address Twice", func(t *testing.T) {
+		_, err := Load([]string{"-grpc.addr", ":8443"})
+		assert.ErrorContains(t, err, "must differ")
+	})
+}
+
+func TestMasked(t *testing.T) {
+	cfg := Default()
+	cfg.DB.Password = "hunter2"
+	printed := cfg.String()
+	assert.NotContains(t, printed, "hunter2")
+	assert.Contains(t, printed, "password: '"+mask+"'")
+	assert.Contains(t, printed, "ttl: 24h0m0s")
+	assert.Equal(t, "hunter2", cfg.DB.Password, "the config itself keeps the secret")
+
+	// Nothing to hide when there is no secret
+	assert.Contains(t, Default().String(), `password: ""`)
+}
+
+func TestParseRouteTimeouts(t *testing.T) {
+	timeouts, err := ParseRouteTimeouts("get /getContacts=10s, POST /mergeContacts = 1m")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]time.Duration{"GET /getContacts": 10 * time.Second, "POST /mergeContacts": time.Minute}, timeouts)
+
+	timeouts, err = ParseRouteTimeouts("")
+	assert.NoError(t, err)
+	assert.Empty(t, timeouts)
+
+	for _, spec := range []string{"/getContacts=10s", "GET getContacts=10s", "GET /getContacts", "GET /getContacts=soon", "GET /getContacts=0s"} {
+		_, err := ParseRouteTimeouts(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestDSN(t *testing.T) {
+	d := Database{Host: "db", Port: 5432, User: "phonebook", Password: "secret", Name: "contacts", SSLMode: "require", ConnectTimeout: 5 * time.Second}
+	assert.Equal(t, "host=db port=5432 user=phonebook password=secret dbname=contacts sslmode=require connect_timeout=5", d.DSN())
+
+	d.SSLMode, d.SSLRootCert, d.Password = "verify-full", "/etc/phonebook/db ca.crt", `it's \ secret`
+	assert.Equal(t, `host=db port=5432 user=phonebook password='it\'s \\ secret' dbname=contacts sslmode=verify-full connect_timeout=5 sslrootcert='/etc/phonebook/db ca.crt'`, d.DSN())
+
+	d.Password = ""
+	assert.Contains(t, d.DSN(), "password='' ", "empty values don't swallow the next key")
+}
+
+// The example has to stay loadable
+func TestExample(t *testing.T) {
+	cfg, err := Load([]string{"-config", "../../config/phonebook.example.yaml"})
+	require.NoError(t, err)
+	assert.Equal(t, "contacts", cfg.DB.Name)
+	assert.Equal(t, Default().Webhooks, cfg.Webhooks)
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Read the part of TOML a config file needs: [tables], dotted table names, and keys set to strings, integers,
+// floats or booleans, with # comments. Durations are strings like "24h", as in YAML.
+func parseTOML(data []byte) (map[string]any, error) {
+	root := map[string]any{}
+	table := root
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %d: invalid table header %q", n, line)
+			}
+			var err error
+			if table, err = subtable(root, strings.Trim(line, "[]")); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			continue
+		}
+
+		key, raw, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		value, err := parseValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		parent, name := table, key
+		if dot := strings.LastIndex(key, "."); dot >= 0 {
+			if parent, err = subtable(table, key[:dot]); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			name = strings.TrimSpace(key[dot+1:])
+		}
+		if _, exists := parent[name]; exists {
+			return nil, fmt.Errorf("line %d: %s is set twice", n, key)
+		}
+		parent[name] = value
+	}
+	return root, scanner.Err()
+}
+
+// Table at a dotted path, created when it doesn't exist yet
+func subtable(root map[string]any, path string) (map[string]any, error) {
+	table := root
+	for _, name := range strings.Split(path, ".") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, fmt.Errorf("invalid table name %q", path)
+		}
+		switch existing := table[name].(type) {
+		case nil:
+			next := map[string]any{}
+			table[name] = next
+			table = next
+		case map[string]any:
+			table = existing
+		default:
+			return nil, fmt.Errorf("%s is a value, not a table", name)
+		}
+	}
+	return table, nil
+}
+
+// Everything before a # that isn't inside a string
+func stripComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, c := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && c == '\\':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func parseValue(raw string) (any, error) {
+	switch {
+	case raw == "":
+		return nil, fmt.Errorf("missing value")
+	case raw == "true" || raw == "false":
+		return raw == "true", nil
+	case strings.HasPrefix(raw, `"`):
+		if len(raw) < 2 || !strings.HasSuffix(raw, `"`) {
+			return nil, fmt.Errorf("unterminated string %s", raw)
+		}
+		return strconv.Unquote(raw)
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") {
+			return nil, fmt.Errorf("unterminated string %s", raw)
+		}
+		// Literal strings have no escapes
+		return raw[1 : len(raw)-1], nil
+	}
+	number := strings.ReplaceAll(raw, "_", "")
+	if n, err := strconv.ParseInt(number, 0, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(number, 64); err == nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("unsupported value %s, only strings, numbers and booleans are", raw)
+}
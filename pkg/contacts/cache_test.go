@@ -0,0 +1,69 @@
+package contacts
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// Lists one tenant's contacts and caches pages the way SQLContactRepository does
+type tenantRepo struct {
+	ContactRepository
+	tenant string
+}
+
+func (r tenantRepo) FilterContacts(context.Context, map[string]string) (*gorm.DB, int64, error) {
+	return nil, int64(3 * PageSize), nil
+}
+
+func (r tenantRepo) SearchContacts(_ context.Context, _ *gorm.DB, page int, _ SortBy, _ bool, initialFetch bool) ([]Contact, error) {
+	limit := PageSize
+	if initialFetch {
+		limit = 2 * PageSize
+	}
+	var found []Contact
+	for i := (page - 1) * PageSize; i < min(page*PageSize+limit-PageSize, 3*PageSize); i++ {
+		found = append(found, Contact{ID: uint(i + 1), FirstName: fmt.Sprint(i + 1), LastName: r.tenant})
+	}
+	return pageCaches.store(r.tenant, found, page, initialFetch), nil
+}
+
+// Run with -race, tenants listing at once must neither race on nor see each other's cached pages
+func TestListContactsConcurrentTenants(t *testing.T) {
+	var wg sync.WaitGroup
+	for _, tenant := range []string{"acme", "globex", "initech"} {
+		repo := tenantRepo{tenant: tenant}
+		for range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for page := 1; page <= 3; page++ {
+					listed, err := ListContacts(context.Background(), repo, ListParams{Page: page, Tenant: tenant})
+					if !assert.NoError(t, err) {
+						return
+					}
+					for _, c := range listed.Contacts {
+						assert.Equal(t, tenant, c.LastName)
+					}
+				}
+				pageCaches.invalidate(tenant)
+			}()
+		}
+	}
+	wg.Wait()
+	require.NoError(t, WaitForPrefetches(context.Background()))
+
+	// Each tenant has a state of its own
+	for _, tenant := range []string{"acme", "globex", "initech"} {
+		pageCaches.with(tenant, func(state *FilterState) {
+			for _, c := range state.Cache {
+				assert.Equal(t, tenant, c.LastName)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package contacts
+
+import "gorm.io/gorm"
+
+// Gorm plugin that reports queries cut short by their context as a CanceledError, whatever the driver returned.
+// Register with db.Use(contacts.CancellationPlugin{}).
+type CancellationPlugin struct{}
+
+func (CancellationPlugin) Name() string {
+	return "contacts:cancellation"
+}
+
+func (CancellationPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	for _, register := range []func() error{
+		func() error {
+			return cb.Create().After("gorm:create").Register("contacts:canceled_create", reportCancellation)
+		},
+		func() error {
+			return cb.Query().After("gorm:query").Register("contacts:canceled_query", reportCancellation)
+		},
+		func() error {
+			return cb.Update().After("gorm:update").Register("contacts:canceled_update", reportCancellation)
+		},
+		func() error {
+			return cb.Delete().After("gorm:delete").Register("contacts:canceled_delete", reportCancellation)
+		},
+		func() error { return cb.Row().After("gorm:row").Register("contacts:canceled_row", reportCancellation) },
+		func() error { return cb.Raw().After("gorm:raw").Register("contacts:canceled_raw", reportCancellation) },
+	} {
+		if err := register(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func reportCancellation(db *gorm.DB) {
+	if db.Error != nil && db.Statement.Context != nil {
+		db.Error = contextError(db.Statement.Context, db.Error)
+	}
+}
@@ -0,0 +1,53 @@
+package contacts_test
+
+import (
+	"context"
+	"errors"
+	"golangphonebook/pkg/contacts"
+	"golangphonebook/pkg/problem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestCancellationPlugin(t *testing.T) {
+	// Dry runs never reach a database, so every query fails the way a driver does once its context has ended
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	require.NoError(t, err)
+	require.NoError(t, db.Use(contacts.CancellationPlugin{}))
+	driverErr := errors.New("conn closed")
+	require.NoError(t, db.Callback().Query().Before("gorm:query").Register("test:fail", func(db *gorm.DB) {
+		db.AddError(driverErr)
+	}))
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	for name, tc := range map[string]struct {
+		ctx    context.Context
+		kind   error
+		cause  error
+		status int
+	}{
+		"Client Gone":      {canceled, contacts.ErrCanceled, context.Canceled, 499},
+		"Deadline Passed":  {expired, contacts.ErrTimeout, context.DeadlineExceeded, 504},
+		"Context Still Up": {context.Background(), nil, nil, 500},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := db.WithContext(tc.ctx).Find(&[]contacts.Contact{}).Error
+			assert.ErrorIs(t, err, driverErr, "the driver's error is kept")
+			assert.Equal(t, tc.kind != nil, contacts.IsCanceled(err))
+			if tc.kind != nil {
+				assert.ErrorIs(t, err, tc.kind)
+				assert.ErrorIs(t, err, tc.cause)
+			}
+			assert.Equal(t, tc.status, problem.FromError(err).Status)
+		})
+	}
+}
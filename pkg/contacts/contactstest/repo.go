@@ -0,0 +1,127 @@
+// In-memory contact repository for tests of the APIs built on contacts, without a database
+package contactstest
+
+import (
+	"context"
+	"golangphonebook/pkg/contacts"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Keeps contacts and address books in maps. Listing, searching, duplicates and sync find nothing,
+// there is no database to page through.
+type Repo struct {
+	mu       sync.Mutex
+	contacts map[int]contacts.Contact
+	books    []contacts.AddressBook
+	nextID   int
+	// Returned by GetMergeHistory for their survivor
+	Merges []contacts.MergeRecord
+}
+
+var _ contacts.ContactRepository = (*Repo)(nil)
+
+// Empty repository with the address books, new contacts go into the first one
+func NewRepo(books ...contacts.AddressBook) *Repo {
+	return &Repo{contacts: make(map[int]contacts.Contact), books: books}
+}
+
+func (m *Repo) AddContact(_ context.Context, c contacts.Contact) (*contacts.Contact, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.contacts {
+		if existing.FirstName == c.FirstName && existing.LastName == c.LastName && existing.Phone == c.Phone {
+			return nil, contacts.ErrDuplicate
+		}
+	}
+	if c.AddressBookID == 0 && len(m.books) > 0 {
+		c.AddressBookID = m.books[0].ID
+	}
+	m.nextID++
+	c.ID = uint(m.nextID)
+	c.LastModified = time.Now()
+	m.contacts[m.nextID] = c
+	return &c, nil
+}
+
+func (m *Repo) GetContact(_ context.Context, id int) (*contacts.Contact, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.contacts[id]
+	if !ok {
+		return nil, contacts.ErrNotFound
+	}
+	return &c, nil
+}
+
+func (m *Repo) ReplaceContact(_ context.Context, id int, c contacts.Contact) (*contacts.Contact, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	existing, ok := m.contacts[id]
+	if !ok {
+		return nil, contacts.ErrNotFound
+	}
+	c.ID = uint(id)
+	if c.AddressBookID == 0 {
+		c.AddressBookID = existing.AddressBookID
+	}
+	m.contacts[id] = c
+	return &c, nil
+}
+
+func (m *Repo) DeleteContact(_ context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.contacts[id]; !ok {
+		return contacts.ErrNotFound
+	}
+	delete(m.contacts, id)
+	return nil
+}
+
+func (m *Repo) GetMergeHistory(_ context.Context, id int) ([]contacts.MergeRecord, error) {
+	var history []contacts.MergeRecord
+	for _, rec := range m.Merges {
+		if rec.SurvivorID == uint(id) {
+			history = append(history, rec)
+		}
+	}
+	return history, nil
+}
+
+func (m *Repo) ListAddressBooks(_ context.Context) ([]contacts.AddressBook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]contacts.AddressBook(nil), m.books...), nil
+}
+
+func (m *Repo) CreateAddressBook(_ context.Context, name string) (*contacts.AddressBook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	book := contacts.AddressBook{ID: uint(len(m.books) + 1), Name: name}
+	if len(m.books) > 0 {
+		book.TenantID = m.books[0].TenantID
+	}
+	m.books = append(m.books, book)
+	return &book, nil
+}
+
+func (m *Repo) FilterContacts(context.Context, map[string]string) (*gorm.DB, int64, error) {
+	return nil, 0, nil
+}
+func (m *Repo) SearchContacts(context.Context, *gorm.DB, int, contacts.SortBy, bool, bool) ([]contacts.Contact, error) {
+	return nil, nil
+}
+func (m *Repo) UpdateContact(context.Context, int, contacts.Contact) error { return nil }
+func (m *Repo) GetContactCount(_ context.Context) (int64, error)           { return 0, nil }
+func (m *Repo) FindDuplicates(context.Context, float64) ([][]contacts.Contact, error) {
+	return nil, nil
+}
+func (m *Repo) MergeContacts(context.Context, contacts.MergeRequest) (*contacts.Contact, error) {
+	return nil, nil
+}
+func (m *Repo) ChangesSince(context.Context, contacts.SyncPosition, int) (*contacts.SyncResult, error) {
+	return nil, nil
+}
@@ -2,17 +2,81 @@
 package contacts
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"golangphonebook/internal"
+	"sync"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 )
 
-var filterState FilterState
+var tracer = otel.Tracer("golangphonebook/pkg/contacts")
+
+// Pages cached for ListContacts, one FilterState per tenant so tenants never see or overwrite each other's pages
+type pageCache struct {
+	mu     sync.Mutex
+	states map[string]*FilterState
+}
+
+var pageCaches = pageCache{states: make(map[string]*FilterState)}
+
+// Run fn on the tenant's state, holding the lock
+func (c *pageCache) with(tenant string, fn func(state *FilterState)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, ok := c.states[tenant]
+	if !ok {
+		state = &FilterState{}
+		c.states[tenant] = state
+	}
+	fn(state)
+}
+
+// Keep what SearchContacts found for the tenant's next page, returns the page to respond with
+func (c *pageCache) store(tenant string, contacts []Contact, page int, initialFetch bool) []Contact {
+	c.with(tenant, func(filterState *FilterState) {
+		if initialFetch {
+			if len(contacts) > PageSize {
+				// Don't cache any of these if they don't exist
+				filterState.Cache = contacts[PageSize:] // Cache the next page
+				filterState.CachedPage = page + 1       // We cached the next page
+				filterState.UpdateCache = false         // cache has next page as of here
+				contacts = contacts[:PageSize]          // Return the first page
+			} else {
+				filterState.UpdateCache = true // Next time, you'll need to hit the server again
+			}
+
+		} else {
+			filterState.Cache = contacts
+			filterState.CachedPage = page // Passed this in to only retrieve only the next page
+		}
+	})
+	return contacts
+}
+
+// Make the tenant's next listing hit the database
+func (c *pageCache) invalidate(tenant string) {
+	c.with(tenant, func(state *FilterState) { state.UpdateCache = true })
+}
+
+// Goroutines prefetching the next page of contacts into the cache
+var prefetches sync.WaitGroup
+
+// Wait for running prefetches before the database is closed, or until the context is done
+func WaitForPrefetches(ctx context.Context) error {
+	return internal.Wait(ctx, &prefetches)
+}
 
 type SQLContactRepository struct {
-	DB *gorm.DB
+	DB        *gorm.DB
+	Tenant    string          // Every query is limited to the address books of this tenant
+	Recorder  ChangeRecorder  // Optional, told about every change made through the repository
+	Publisher ChangePublisher // Optional, told about every contact the repository created, changed or deleted
+	Outbox    ChangeOutbox    // Optional, given every change to a contact in the transaction that makes it
 }
 
 // NewSQLContactRepository creates a new instance of SQLContactRepository
@@ -20,46 +84,169 @@ func NewSQLContactRepository(db *gorm.DB) *SQLContactRepository {
 	return &SQLContactRepository{DB: db}
 }
 
-func (repo *SQLContactRepository) AddContact(contact Contact) error {
+// Copy of the repository that only sees the address books of the given tenant
+func (repo *SQLContactRepository) ForTenant(tenant string) *SQLContactRepository {
+	scoped := *repo
+	scoped.Tenant = tenant
+	return &scoped
+}
+
+// Copy of the repository that reports every change it makes to the recorder
+func (repo *SQLContactRepository) WithRecorder(recorder ChangeRecorder) *SQLContactRepository {
+	recorded := *repo
+	recorded.Recorder = recorder
+	return &recorded
+}
+
+// Start a repository method: a copy of the repository whose queries run with ctx, inside a span for the method.
+// Use as repo, done := repo.begin(ctx, "GetContact"); defer done()
+func (repo *SQLContactRepository) begin(ctx context.Context, method string) (*SQLContactRepository, func()) {
+	ctx, span := tracer.Start(ctx, "ContactRepository."+method, trace.WithAttributes(attribute.String("tenant", repo.Tenant)))
+	observed := observeQuery(method)
+	bound := *repo
+	bound.DB = repo.DB.WithContext(ctx)
+	return &bound, func() {
+		observed()
+		span.End()
+	}
+}
+
+// Hand a change to the outbox, within the transaction that makes it
+func (repo *SQLContactRepository) enqueue(tx *gorm.DB, change ChangeType, id uint, contact *Contact) error {
+	if repo.Outbox == nil {
+		return nil
+	}
+	return repo.Outbox.Enqueue(tx, repo.Tenant, change, id, contact)
+}
+
+func (repo *SQLContactRepository) recordChange(id uint, before, after any) {
+	if repo.Recorder != nil {
+		repo.Recorder.RecordChange(id, before, after)
+	}
+	if repo.Publisher == nil {
+		return
+	}
+	// Address books are recorded too, only contacts have change events
+	switch after := after.(type) {
+	case Contact:
+		if before == nil {
+			repo.Publisher.Publish(repo.Tenant, ContactCreated, id, &after)
+		} else {
+			repo.Publisher.Publish(repo.Tenant, ContactUpdated, id, &after)
+		}
+	case nil:
+		if before, ok := before.(Contact); ok {
+			repo.Publisher.Publish(repo.Tenant, ContactDeleted, id, &before)
+		}
+	}
+}
+
+// Restrict a query on contacts to the address books of the repository's tenant
+func (repo *SQLContactRepository) scoped(query *gorm.DB) *gorm.DB {
+	return query.Where("address_book_id IN (?)", repo.DB.Model(&AddressBook{}).Select("id").Where("tenant_id = ?", repo.Tenant))
+}
+
+// Address book a new contact goes into, the tenant's default book when none is given
+func (repo *SQLContactRepository) resolveAddressBook(tx *gorm.DB, id uint) (uint, error) {
+	var book AddressBook
+	if id != 0 {
+		err := tx.Where("id = ? AND tenant_id = ?", id, repo.Tenant).First(&book).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, ErrAddressBookNotFound
+		}
+		return book.ID, err
+	}
+
+	err := tx.Where(AddressBook{TenantID: repo.Tenant, Name: DefaultAddressBookName}).FirstOrCreate(&book).Error
+	if err != nil {
+		// Another request may have created the default book at the same time
+		err = tx.Where(AddressBook{TenantID: repo.Tenant, Name: DefaultAddressBookName}).First(&book).Error
+	}
+	return book.ID, err
+}
+
+func (repo *SQLContactRepository) AddContact(ctx context.Context, contact Contact) (*Contact, error) {
+	repo, done := repo.begin(ctx, "AddContact")
+	defer done()
+
 	var existingContact Contact
 
-	// Check if a contact with the same FirstName, LastName and Phone already exists
-	err := repo.DB.Where("first_name = ? AND last_name = ? AND phone = ?", contact.FirstName, contact.LastName, contact.Phone).First(&existingContact).Error
+	bookID, err := repo.resolveAddressBook(repo.DB, contact.AddressBookID)
+	if err != nil {
+		return nil, err
+	}
+	contact.AddressBookID = bookID
+
+	// Check if a contact with the same FirstName, LastName and Phone already exists in the address book
+	err = sameNameAndPhone(repo.DB, contact).Where("address_book_id = ?", contact.AddressBookID).First(&existingContact).Error
 	if err == nil {
 		// Contact already exists
 		internal.Logger.Warn("contact with the same full name and phone number already exists")
-		return errors.New("contact with the same full name and phone number already exists")
+		return nil, ErrDuplicate
 	}
 
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		// Contact does not exist
-		err = repo.DB.Create(&contact).Error
-		return err
+		err = repo.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&contact).Error; err != nil {
+				return err
+			}
+			return repo.enqueue(tx, ContactCreated, contact.ID, &contact)
+		})
+		if err != nil {
+			return nil, err
+		}
+		repo.recordChange(contact.ID, nil, contact)
+		return &contact, nil
 	} else {
 		// We got some other error
-		return err
+		return nil, err
 
 	}
 }
 
-func (repo *SQLContactRepository) FilterContacts(filters map[string]string) (*gorm.DB, int64, error) {
-	// Build the query based on filters
-	query := repo.DB.Model(&Contact{})
+func (repo *SQLContactRepository) GetContact(ctx context.Context, id int) (*Contact, error) {
+	repo, done := repo.begin(ctx, "GetContact")
+	defer done()
 
-	if firstName, exists := filters["first_name"]; exists {
-		query = query.Where("first_name ILIKE ?", "%"+firstName+"%")
+	var contact Contact
+	err := repo.scoped(repo.DB).First(&contact, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, newError(ErrNotFound, "no contact found with ID %d", id)
 	}
-
-	if lastName, exists := filters["last_name"]; exists {
-		query = query.Where("last_name ILIKE ?", "%"+lastName+"%")
+	if err != nil {
+		return nil, err
 	}
+	return &contact, nil
+}
+
+func (repo *SQLContactRepository) FilterContacts(ctx context.Context, filters map[string]string) (*gorm.DB, int64, error) {
+	repo, done := repo.begin(ctx, "FilterContacts")
+	defer done()
+
+	// Build the query based on filters
+	query := repo.scoped(repo.DB.Model(&Contact{}))
 
-	if address, exists := filters["address"]; exists {
-		query = query.Where("address ILIKE ?", "%"+address+"%")
+	if addressBook, exists := filters["address_book_id"]; exists && addressBook != "" {
+		query = query.Where("address_book_id = ?", addressBook)
 	}
 
-	if phone, exists := filters["phone"]; exists {
-		query = query.Where("phone LIKE ?", "%"+phone+"%")
+	// Encrypted fields can only be matched exactly, on their blind index
+	for _, field := range []string{"first_name", "last_name", "address", "phone"} {
+		value, exists := filters[field]
+		if !exists {
+			continue
+		}
+		if encrypted(field) {
+			if value != "" {
+				column, match := exactMatch(field, value)
+				query = query.Where(column+" = ?", match)
+			}
+		} else if field == "phone" {
+			query = query.Where("phone LIKE ?", "%"+value+"%")
+		} else {
+			query = query.Where(field+" ILIKE ?", "%"+value+"%")
+		}
 	}
 
 	var count int64
@@ -70,13 +257,30 @@ func (repo *SQLContactRepository) FilterContacts(filters map[string]string) (*go
 	return query, count, nil
 }
 
-func (repo *SQLContactRepository) SearchContacts(query *gorm.DB, page int, sortBy SortBy, ascending bool, initialFetch bool) ([]Contact, error) {
+// Column contacts are sorted on, first_name when sortBy is empty.
+// Ciphertext has no useful order, so encrypted names fall back to the order contacts were added in.
+func SortColumn(sortBy SortBy) string {
+	switch {
+	case sortBy == SortByLastModified:
+		return "last_modified"
+	case encrypted(string(SortByFirstName)):
+		return "id"
+	case sortBy == SortByLastName:
+		return "last_name"
+	}
+	return "first_name"
+}
+
+func (repo *SQLContactRepository) SearchContacts(ctx context.Context, query *gorm.DB, page int, sortBy SortBy, ascending bool, initialFetch bool) ([]Contact, error) {
+	repo, done := repo.begin(ctx, "SearchContacts")
+	defer done()
+
 	var contacts []Contact
-	limit := 10
+	limit := PageSize
 	if initialFetch {
-		limit = 20 // Fetch 20 records initially
+		limit = 2 * PageSize // Fetch two pages initially
 	}
-	offset := (page - 1) * 10
+	offset := (page - 1) * PageSize
 
 	// Determine the sort order
 	var ascStr string
@@ -86,16 +290,8 @@ func (repo *SQLContactRepository) SearchContacts(query *gorm.DB, page int, sortB
 		ascStr = "DESC"
 	}
 
-	switch sortBy {
-	case SortByFirstName:
-		query = query.Order("first_name " + ascStr)
-	case SortByLastName:
-		query = query.Order("last_name " + ascStr)
-	case SortByLastModified:
-		query = query.Order("last_modified " + ascStr)
-	default:
-		query = query.Order("first_name " + ascStr) // Default sorting
-	}
+	// The query was built by FilterContacts, possibly for an earlier request, and runs in this method's span
+	query = query.WithContext(repo.DB.Statement.Context).Order(SortColumn(sortBy) + " " + ascStr)
 
 	// Retrieve the contacts with pagination
 	err := query.Limit(limit).Offset(offset).Find(&contacts).Error
@@ -103,44 +299,38 @@ func (repo *SQLContactRepository) SearchContacts(query *gorm.DB, page int, sortB
 		return nil, err
 	}
 
-	// Update filter state
-	if initialFetch {
-		if len(contacts) > 10 {
-			// Don't cache any of these if they don't exist
-			filterState.Cache = contacts[10:] // Cache the next 10 records
-			filterState.CachedPage = page + 1 // We cached the next page
-			filterState.UpdateCache = false   // cache has next page as of here
-			contacts = contacts[:10]          // Return the first 10 contacts
-		} else {
-			filterState.UpdateCache = true // Next time, you'll need to hit the server again
-		}
-
-	} else {
-		filterState.Cache = contacts
-		filterState.CachedPage = page // Passed this in to only retrieve only the next 10 records
-	}
-
-	return contacts, nil
+	return pageCaches.store(repo.Tenant, contacts, page, initialFetch), nil
 }
 
-func (repo *SQLContactRepository) UpdateContact(id int, updatedContact Contact) error {
+func (repo *SQLContactRepository) UpdateContact(ctx context.Context, id int, updatedContact Contact) error {
+	repo, done := repo.begin(ctx, "UpdateContact")
+	defer done()
+
 	// Check if contact exists
 	var existingContact Contact
-	err := repo.DB.First(&existingContact, id).Error
+	err := repo.scoped(repo.DB).First(&existingContact, id).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return errors.New("contact not found")
+			return newError(ErrNotFound, "no contact found with ID %d", id)
 		}
 		return err
 	}
+	before := existingContact
+
+	// Contacts can move to another address book of the same tenant
+	if updatedContact.AddressBookID != 0 {
+		existingContact.AddressBookID, err = repo.resolveAddressBook(repo.DB, updatedContact.AddressBookID)
+		if err != nil {
+			return err
+		}
+	}
 
 	// Check for duplicate contact
 	var duplicateContact Contact
-	err = repo.DB.Where("first_name = ? AND last_name = ? AND id != ? AND phone = ?",
-		updatedContact.FirstName, updatedContact.LastName, id, updatedContact.Phone).First(&duplicateContact).Error
+	err = sameNameAndPhone(repo.DB, updatedContact).Where("id != ? AND address_book_id = ?", id, existingContact.AddressBookID).First(&duplicateContact).Error
 	if err == nil {
 		// Duplicate exists
-		return errors.New("another contact with the same first name, last name, and phone number already exists")
+		return newError(ErrDuplicate, "another contact with the same first name, last name, and phone number already exists")
 	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
 		// Some other error
 		return err
@@ -160,37 +350,289 @@ func (repo *SQLContactRepository) UpdateContact(id int, updatedContact Contact)
 	}
 
 	// Save contact back to db
-	err = repo.DB.Save(&existingContact).Error
+	err = repo.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&existingContact).Error; err != nil {
+			return err
+		}
+		return repo.enqueue(tx, ContactUpdated, existingContact.ID, &existingContact)
+	})
 	if err != nil {
 		internal.Logger.Error(fmt.Sprintf("Encountered err while saving updated contact back to DB: %v", err))
 		return err
 	}
 
+	repo.recordChange(existingContact.ID, before, existingContact)
 	internal.Logger.Info(fmt.Sprintf("Contact with ID %d updated successfully", id))
 	return nil
 }
 
-func (repo *SQLContactRepository) DeleteContact(id int) error {
-	result := repo.DB.Delete(&Contact{}, id)
-	if result.Error != nil {
-		return result.Error
+// Replace every field of a contact, where UpdateContact leaves the fields that are empty in the update alone
+func (repo *SQLContactRepository) ReplaceContact(ctx context.Context, id int, replacement Contact) (*Contact, error) {
+	repo, done := repo.begin(ctx, "ReplaceContact")
+	defer done()
+
+	existingContact, err := repo.GetContact(ctx, id)
+	if err != nil {
+		return nil, err
 	}
-	if result.RowsAffected == 0 {
+	before := *existingContact
+
+	// Contacts can move to another address book of the same tenant, and otherwise stay where they are
+	if replacement.AddressBookID != 0 {
+		existingContact.AddressBookID, err = repo.resolveAddressBook(repo.DB, replacement.AddressBookID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var duplicateContact Contact
+	err = sameNameAndPhone(repo.DB, replacement).Where("id != ? AND address_book_id = ?", id, existingContact.AddressBookID).First(&duplicateContact).Error
+	if err == nil {
+		return nil, newError(ErrDuplicate, "another contact with the same first name, last name, and phone number already exists")
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	existingContact.FirstName = replacement.FirstName
+	existingContact.LastName = replacement.LastName
+	existingContact.Phone = replacement.Phone
+	existingContact.Address = replacement.Address
+
+	// Save takes care of the blank fields, Updates would skip them
+	err = repo.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(existingContact).Error; err != nil {
+			return err
+		}
+		return repo.enqueue(tx, ContactUpdated, existingContact.ID, existingContact)
+	})
+	if err != nil {
+		internal.Logger.Error(fmt.Sprintf("Encountered err while saving replaced contact back to DB: %v", err))
+		return nil, err
+	}
+
+	repo.recordChange(existingContact.ID, before, *existingContact)
+	internal.Logger.Info(fmt.Sprintf("Contact with ID %d replaced successfully", id))
+	return existingContact, nil
+}
+
+func (repo *SQLContactRepository) DeleteContact(ctx context.Context, id int) error {
+	repo, done := repo.begin(ctx, "DeleteContact")
+	defer done()
+
+	// Keep what is about to be deleted when it needs to be recorded, published or sent
+	var before Contact
+	if repo.Recorder != nil || repo.Publisher != nil || repo.Outbox != nil {
+		err := repo.scoped(repo.DB).First(&before, id).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+	}
+
+	var deleted int64
+	err := repo.DB.Transaction(func(tx *gorm.DB) error {
+		result := repo.scoped(tx).Delete(&Contact{}, id)
+		deleted = result.RowsAffected
+		if result.Error != nil || deleted == 0 {
+			return result.Error
+		}
+		if err := bury(tx, repo.Tenant, []uint{uint(id)}); err != nil {
+			return err
+		}
+		return repo.enqueue(tx, ContactDeleted, uint(id), &before)
+	})
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
 		internal.Logger.Error(fmt.Sprintf("no contact found with ID: %d", id))
-		return errors.New("no contact found with the given ID")
+		return newError(ErrNotFound, "no contact found with the given ID")
 	}
+	repo.recordChange(uint(id), before, nil)
 
-	internal.Logger.Info(fmt.Sprintf("Contact deleted successfully, %d row(s) affected", result.RowsAffected))
+	internal.Logger.Info(fmt.Sprintf("Contact deleted successfully, %d row(s) affected", deleted))
 
 	return nil
 }
 
 // Helper methods
-func (repo *SQLContactRepository) GetContactCount() (int64, error) {
+func (repo *SQLContactRepository) GetContactCount(ctx context.Context) (int64, error) {
+	repo, done := repo.begin(ctx, "GetContactCount")
+	defer done()
+
 	var count int64
-	err := repo.DB.Model(&Contact{}).Count(&count).Error
+	err := repo.scoped(repo.DB.Model(&Contact{})).Count(&count).Error
 	if err != nil {
 		return 0, err
 	}
 	return count, nil
 }
+
+func (repo *SQLContactRepository) FindDuplicates(ctx context.Context, threshold float64) ([][]Contact, error) {
+	repo, done := repo.begin(ctx, "FindDuplicates")
+	defer done()
+
+	var contacts []Contact
+	err := repo.scoped(repo.DB).Order("id").Find(&contacts).Error
+	if err != nil {
+		return nil, err
+	}
+	return ClusterDuplicates(contacts, threshold), nil
+}
+
+func (repo *SQLContactRepository) MergeContacts(ctx context.Context, request MergeRequest) (*Contact, error) {
+	repo, done := repo.begin(ctx, "MergeContacts")
+	defer done()
+
+	var merged Contact
+	var records []Contact
+
+	err := repo.DB.Transaction(func(tx *gorm.DB) error {
+		if err := repo.scoped(tx).Where("id IN ?", request.IDs).Find(&records).Error; err != nil {
+			return err
+		}
+		if len(records) != len(request.IDs) {
+			return newError(ErrNotFound, "one or more contacts to merge were not found")
+		}
+
+		// Keep the order of the request, the first ID is the one that survives
+		byID := make(map[uint]Contact, len(records))
+		for _, c := range records {
+			byID[c.ID] = c
+		}
+		for i, id := range request.IDs {
+			records[i] = byID[uint(id)]
+		}
+
+		merged = ResolveMerge(records, request.Rules, request.Overrides)
+		if err := validateStruct(merged); err != nil {
+			return fmt.Errorf("merged contact is invalid: %w", err)
+		}
+
+		// The merged contact can't collide with a contact outside of the merge in the same address book
+		var duplicateContact Contact
+		err := sameNameAndPhone(tx, merged).Where("address_book_id = ? AND id NOT IN ?", merged.AddressBookID, request.IDs).First(&duplicateContact).Error
+		if err == nil {
+			return newError(ErrDuplicate, "another contact with the same first name, last name, and phone number already exists")
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		// Earlier merges into the contacts that are going away now belong to the survivor
+		err = tx.Model(&MergeRecord{}).Where("survivor_id IN ?", request.IDs[1:]).Update("survivor_id", merged.ID).Error
+		if err != nil {
+			return err
+		}
+
+		// Keep a snapshot of every contact, including the survivor as it was before the merge
+		history := make([]MergeRecord, 0, len(records))
+		for _, c := range records {
+			history = append(history, newMergeRecord(merged.ID, c))
+		}
+		if err := tx.Create(&history).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Save(&merged).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&Contact{}, request.IDs[1:]).Error; err != nil {
+			return err
+		}
+		removed := make([]uint, 0, len(records)-1)
+		for _, c := range records[1:] {
+			removed = append(removed, c.ID)
+		}
+		if err := bury(tx, repo.Tenant, removed); err != nil {
+			return err
+		}
+
+		if err := repo.enqueue(tx, ContactUpdated, merged.ID, &merged); err != nil {
+			return err
+		}
+		for i := range records[1:] {
+			if err := repo.enqueue(tx, ContactDeleted, records[i+1].ID, &records[i+1]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		internal.Logger.Error(fmt.Sprintf("Failed to merge contacts %v: %v", request.IDs, err))
+		return nil, err
+	}
+
+	repo.recordChange(merged.ID, records[0], merged)
+	for _, c := range records[1:] {
+		repo.recordChange(c.ID, c, nil)
+	}
+	internal.Logger.Info(fmt.Sprintf("Merged contacts %v into contact with ID %d", request.IDs, merged.ID))
+	return &merged, nil
+}
+
+func (repo *SQLContactRepository) GetMergeHistory(ctx context.Context, id int) ([]MergeRecord, error) {
+	repo, done := repo.begin(ctx, "GetMergeHistory")
+	defer done()
+
+	var history []MergeRecord
+	// Only the history of contacts the tenant can see
+	err := repo.DB.Where("survivor_id = ? AND survivor_id IN (?)", id, repo.scoped(repo.DB.Model(&Contact{})).Select("id")).
+		Order("merged_at, id").Find(&history).Error
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+func (repo *SQLContactRepository) ListAddressBooks(ctx context.Context) ([]AddressBook, error) {
+	repo, done := repo.begin(ctx, "ListAddressBooks")
+	defer done()
+
+	var books []AddressBook
+	err := repo.DB.Where("tenant_id = ?", repo.Tenant).Order("id").Find(&books).Error
+	if err != nil {
+		return nil, err
+	}
+	return books, nil
+}
+
+func (repo *SQLContactRepository) CreateAddressBook(ctx context.Context, name string) (*AddressBook, error) {
+	repo, done := repo.begin(ctx, "CreateAddressBook")
+	defer done()
+
+	var existingBook AddressBook
+
+	// Book names are unique per tenant
+	err := repo.DB.Where("tenant_id = ? AND name = ?", repo.Tenant, name).First(&existingBook).Error
+	if err == nil {
+		return nil, ErrAddressBookExists
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	book := AddressBook{TenantID: repo.Tenant, Name: name}
+	if err := repo.DB.Create(&book).Error; err != nil {
+		return nil, err
+	}
+
+	repo.recordChange(book.ID, nil, book)
+	internal.Logger.Info(fmt.Sprintf("Address book %d created for tenant %q", book.ID, repo.Tenant))
+	return &book, nil
+}
+
+// Move contacts from before address books existed into the default book of the given tenant
+func AssignUnownedContacts(db *gorm.DB, tenant string) (int64, error) {
+	repo := NewSQLContactRepository(db).ForTenant(tenant)
+	bookID, err := repo.resolveAddressBook(db, 0)
+	if err != nil {
+		return 0, err
+	}
+	result := db.Model(&Contact{}).Where("address_book_id = 0").Update("address_book_id", bookID)
+	return result.RowsAffected, result.Error
+}
+
+// Number of contacts that don't belong to any address book yet
+func CountUnownedContacts(db *gorm.DB) (int64, error) {
+	var count int64
+	err := db.Model(&Contact{}).Where("address_book_id = 0").Count(&count).Error
+	return count, err
+}
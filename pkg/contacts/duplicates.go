@@ -0,0 +1,290 @@
+// Find likely duplicate contacts and merge them together
+package contacts
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Default similarity needed between two full names for them to be considered the same person
+const DefaultDuplicateThreshold = 0.8
+
+// Phones shorter than this are too ambiguous to match on a suffix (country code or trunk prefix differences)
+const minPhoneSuffixMatch = 7
+
+// Per-field merge resolution strategy
+type MergeStrategy string
+
+const (
+	MergeKeepPrimary MergeStrategy = "primary" // Value from the first ID in the merge request, the default
+	MergeKeepNewest  MergeStrategy = "newest"  // Value from the most recently modified contact
+	MergeKeepOldest  MergeStrategy = "oldest"  // Value from the least recently modified contact
+	MergeKeepLongest MergeStrategy = "longest" // Longest value, handy for addresses
+)
+
+// Fields that can be resolved during a merge
+var mergeableFields = []string{"first_name", "last_name", "phone", "address"}
+
+type MergeRequest struct {
+	IDs       []int                    `json:"ids"`       // Contacts to merge, the first ID survives
+	Rules     map[string]MergeStrategy `json:"rules"`     // Strategy per field, keyed by the json field name
+	Overrides map[string]string        `json:"overrides"` // Explicit values that win over any strategy
+}
+
+// Check the IDs, rules and overrides of a merge, errors match ErrInvalidRequest
+func (request MergeRequest) Validate() error {
+	// Same batch limit as the other bulk endpoints
+	if len(request.IDs) < 2 || len(request.IDs) > MaxBatchSize {
+		return newError(ErrInvalidRequest, "Between 2 and %d contact IDs are needed to merge", MaxBatchSize)
+	}
+	seen := make(map[int]bool)
+	for _, id := range request.IDs {
+		if seen[id] {
+			return newError(ErrInvalidRequest, "Contact ID %d appears more than once", id)
+		}
+		seen[id] = true
+	}
+	for field, strategy := range request.Rules {
+		if !slices.Contains(mergeableFields, field) || !validMergeStrategy(strategy) {
+			return newError(ErrInvalidRequest, "Invalid merge rule %s=%s", field, strategy)
+		}
+	}
+	for field := range request.Overrides {
+		if !slices.Contains(mergeableFields, field) {
+			return newError(ErrInvalidRequest, "Invalid merge override for field %s", field)
+		}
+	}
+	return nil
+}
+
+// Snapshot of a contact as it was right before it was merged into another one
+type MergeRecord struct {
+	ID                   uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	SurvivorID           uint      `json:"survivor_id" gorm:"not null;index"` // Contact the record was merged into
+	MergedID             uint      `json:"merged_id" gorm:"not null;index"`   // Original ID of the merged contact
+	FirstName            string    `json:"first_name" gorm:"type:text"`       // Personal data is encrypted at rest like it is on contacts
+	LastName             string    `json:"last_name" gorm:"type:text"`
+	Phone                string    `json:"phone" gorm:"type:text"`
+	Address              string    `json:"address" gorm:"type:text"`
+	OriginalLastModified time.Time `json:"original_last_modified"`
+	MergedAt             time.Time `json:"merged_at" gorm:"autoCreateTime"`
+	FirstNameIndex       string    `json:"-" gorm:"size:64;index"`
+	LastNameIndex        string    `json:"-" gorm:"size:64;index"`
+	PhoneIndex           string    `json:"-" gorm:"size:64;index"`
+	AddressIndex         string    `json:"-" gorm:"size:64;index"`
+}
+
+func newMergeRecord(survivorID uint, c Contact) MergeRecord {
+	return MergeRecord{
+		SurvivorID:           survivorID,
+		MergedID:             c.ID,
+		FirstName:            c.FirstName,
+		LastName:             c.LastName,
+		Phone:                c.Phone,
+		Address:              c.Address,
+		OriginalLastModified: c.LastModified,
+	}
+}
+
+// Strip everything except digits, so "+1 (555) 123-4567" and "15551234567" compare equal
+func NormalizePhone(phone string) string {
+	var b strings.Builder
+	for _, r := range phone {
+		if unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Two phones match if their digits are equal, or one is a long enough suffix of the other (missing country code)
+func phonesMatch(a, b string) bool {
+	a, b = NormalizePhone(a), NormalizePhone(b)
+	if a == "" || b == "" {
+		return false
+	}
+	if a == b {
+		return true
+	}
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	return len(a) >= minPhoneSuffixMatch && strings.HasSuffix(b, a)
+}
+
+// Bucket key used to avoid comparing every contact against every other contact
+func phoneBucket(phone string) string {
+	digits := NormalizePhone(phone)
+	if len(digits) > minPhoneSuffixMatch {
+		return digits[len(digits)-minPhoneSuffixMatch:]
+	}
+	return digits
+}
+
+func fullName(c Contact) string {
+	return strings.ToLower(strings.Join(strings.Fields(c.FirstName+" "+c.LastName), " "))
+}
+
+// Similarity between two contacts' full names in the range [0, 1], based on Levenshtein distance
+func NameSimilarity(a, b Contact) float64 {
+	x, y := []rune(fullName(a)), []rune(fullName(b))
+	longest := max(len(x), len(y))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(x, y))/float64(longest)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// Group contacts into clusters of likely duplicates. Only clusters of 2 or more contacts are returned,
+// each sorted by ID, and the clusters themselves are sorted by their lowest ID.
+func ClusterDuplicates(contacts []Contact, threshold float64) [][]Contact {
+	// Union-find over contact indexes
+	parent := make([]int, len(contacts))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	buckets := make(map[string][]int)
+	for i, c := range contacts {
+		key := phoneBucket(c.Phone)
+		buckets[key] = append(buckets[key], i)
+	}
+
+	for _, bucket := range buckets {
+		for x := 0; x < len(bucket); x++ {
+			for y := x + 1; y < len(bucket); y++ {
+				a, b := contacts[bucket[x]], contacts[bucket[y]]
+				if phonesMatch(a.Phone, b.Phone) && NameSimilarity(a, b) >= threshold {
+					parent[find(bucket[x])] = find(bucket[y])
+				}
+			}
+		}
+	}
+
+	groups := make(map[int][]Contact)
+	for i, c := range contacts {
+		root := find(i)
+		groups[root] = append(groups[root], c)
+	}
+
+	var clusters [][]Contact
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Slice(group, func(i, j int) bool { return group[i].ID < group[j].ID })
+		clusters = append(clusters, group)
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i][0].ID < clusters[j][0].ID })
+	return clusters
+}
+
+// Combine contacts into one according to the merge rules. records[0] is the primary contact and keeps its ID.
+func ResolveMerge(records []Contact, rules map[string]MergeStrategy, overrides map[string]string) Contact {
+	merged := records[0]
+	for _, field := range mergeableFields {
+		value, ok := overrides[field]
+		if !ok {
+			value = resolveField(records, field, rules[field])
+		}
+		setContactField(&merged, field, value)
+	}
+	return merged
+}
+
+func resolveField(records []Contact, field string, strategy MergeStrategy) string {
+	// Empty values never win, unless every record is empty
+	var candidates []Contact
+	for _, c := range records {
+		if contactField(c, field) != "" {
+			candidates = append(candidates, c)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	chosen := candidates[0]
+	for _, c := range candidates[1:] {
+		switch strategy {
+		case MergeKeepNewest:
+			if c.LastModified.After(chosen.LastModified) {
+				chosen = c
+			}
+		case MergeKeepOldest:
+			if c.LastModified.Before(chosen.LastModified) {
+				chosen = c
+			}
+		case MergeKeepLongest:
+			if len(contactField(c, field)) > len(contactField(chosen, field)) {
+				chosen = c
+			}
+		}
+	}
+	return contactField(chosen, field)
+}
+
+func validMergeStrategy(strategy MergeStrategy) bool {
+	switch strategy {
+	case MergeKeepPrimary, MergeKeepNewest, MergeKeepOldest, MergeKeepLongest:
+		return true
+	}
+	return false
+}
+
+func contactField(c Contact, field string) string {
+	switch field {
+	case "first_name":
+		return c.FirstName
+	case "last_name":
+		return c.LastName
+	case "phone":
+		return c.Phone
+	case "address":
+		return c.Address
+	}
+	return ""
+}
+
+func setContactField(c *Contact, field string, value string) {
+	switch field {
+	case "first_name":
+		c.FirstName = value
+	case "last_name":
+		c.LastName = value
+	case "phone":
+		c.Phone = value
+	case "address":
+		c.Address = value
+	}
+}
@@ -0,0 +1,66 @@
+package contacts_test
+
+import (
+	"golangphonebook/pkg/contacts"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterDuplicates(t *testing.T) {
+	input := []contacts.Contact{
+		{ID: 1, FirstName: "John", LastName: "Doe", Phone: "+15551234567"},
+		{ID: 2, FirstName: "Jon", LastName: "Doe", Phone: "5551234567"},
+		{ID: 3, FirstName: "Jane", LastName: "Smith", Phone: "+15551234567"}, // Same phone, different person
+		{ID: 4, FirstName: "John", LastName: "Doe", Phone: "+19998887777"},   // Same name, different phone
+		{ID: 5, FirstName: "john", LastName: "doe", Phone: "+1 555 123 4567"},
+		{ID: 6, FirstName: "Alice", LastName: "Wonderland", Phone: "+3422220456"},
+	}
+
+	clusters := contacts.ClusterDuplicates(input, contacts.DefaultDuplicateThreshold)
+
+	assert.Len(t, clusters, 1)
+	var ids []uint
+	for _, c := range clusters[0] {
+		ids = append(ids, c.ID)
+	}
+	assert.Equal(t, []uint{1, 2, 5}, ids)
+}
+
+func TestNameSimilarity(t *testing.T) {
+	john := contacts.Contact{FirstName: "John", LastName: "Doe"}
+
+	assert.Equal(t, 1.0, contacts.NameSimilarity(john, contacts.Contact{FirstName: " JOHN ", LastName: "doe"}))
+	assert.InDelta(t, 0.875, contacts.NameSimilarity(john, contacts.Contact{FirstName: "Jon", LastName: "Doe"}), 0.001)
+	assert.Less(t, contacts.NameSimilarity(john, contacts.Contact{FirstName: "Jane", LastName: "Smith"}), 0.5)
+}
+
+func TestResolveMerge(t *testing.T) {
+	now := time.Now()
+	records := []contacts.Contact{
+		{ID: 1, FirstName: "Jon", LastName: "Doe", Phone: "5551234567", LastModified: now.Add(-time.Hour)},
+		{ID: 2, FirstName: "John", LastName: "", Phone: "+15551234567", Address: "123 Main St", LastModified: now},
+		{ID: 3, FirstName: "Johnny", LastName: "Doe", Phone: "+15551234567", Address: "123 Main Street, Springfield", LastModified: now.Add(-2 * time.Hour)},
+	}
+
+	merged := contacts.ResolveMerge(records,
+		map[string]contacts.MergeStrategy{
+			"first_name": contacts.MergeKeepNewest,
+			"phone":      contacts.MergeKeepOldest,
+			"address":    contacts.MergeKeepLongest,
+		},
+		map[string]string{"last_name": "Doe-Smith"},
+	)
+
+	assert.Equal(t, uint(1), merged.ID) // The primary contact survives
+	assert.Equal(t, "John", merged.FirstName)
+	assert.Equal(t, "Doe-Smith", merged.LastName)
+	assert.Equal(t, "+15551234567", merged.Phone)
+	assert.Equal(t, "123 Main Street, Springfield", merged.Address)
+
+	// Without rules the primary wins, but empty values are filled in from the other contacts
+	merged = contacts.ResolveMerge(records, nil, nil)
+	assert.Equal(t, "Jon", merged.FirstName)
+	assert.Equal(t, "123 Main St", merged.Address)
+}
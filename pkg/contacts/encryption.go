@@ -0,0 +1,271 @@
+// Transparent field level encryption of personal data at rest
+package contacts
+
+import (
+	"errors"
+	"fmt"
+	"golangphonebook/internal"
+	"golangphonebook/pkg/fieldcrypt"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+)
+
+// Field level encryption settings, nil when encryption is disabled. Swapped atomically when keys are reloaded.
+var fieldEncryption atomic.Pointer[FieldEncryption]
+
+type FieldEncryption struct {
+	Cipher       *fieldcrypt.Cipher
+	EncryptNames bool // Names are optional, encrypting them turns name filters into exact matches and sorts by ID instead
+}
+
+// Encrypt phone numbers and addresses, and optionally names, of everything saved from now on
+func EnableFieldEncryption(cipher *fieldcrypt.Cipher, encryptNames bool) {
+	fieldEncryption.Store(&FieldEncryption{Cipher: cipher, EncryptNames: encryptNames})
+}
+
+func DisableFieldEncryption() {
+	fieldEncryption.Store(nil)
+}
+
+// Current encryption settings, nil when encryption is disabled
+func FieldEncryptionSettings() *FieldEncryption {
+	return fieldEncryption.Load()
+}
+
+// Is the column for this field encrypted
+func (e *FieldEncryption) encrypted(field string) bool {
+	if e == nil {
+		return false
+	}
+	switch field {
+	case "phone", "address":
+		return true
+	case "first_name", "last_name":
+		return e.EncryptNames
+	}
+	return false
+}
+
+func encrypted(field string) bool {
+	return fieldEncryption.Load().encrypted(field)
+}
+
+// Column and value to compare for an exact match, the blind index when the field is encrypted
+func exactMatch(field string, value string) (string, string) {
+	if e := fieldEncryption.Load(); e.encrypted(field) {
+		return field + "_index", e.Cipher.BlindIndex(field, value)
+	}
+	return field, value
+}
+
+// Match contacts with the same full name and phone number
+func sameNameAndPhone(query *gorm.DB, c Contact) *gorm.DB {
+	for _, f := range []struct{ field, value string }{{"first_name", c.FirstName}, {"last_name", c.LastName}, {"phone", c.Phone}} {
+		column, match := exactMatch(f.field, f.value)
+		query = query.Where(column+" = ?", match)
+	}
+	return query
+}
+
+// Pointers to the personal data of a contact or merge record, and the blind indexes that go with it
+type personalField struct {
+	name  string
+	value *string
+	index *string
+}
+
+type personalData interface {
+	personalFields() []personalField
+	rowID() uint
+}
+
+func (c *Contact) personalFields() []personalField {
+	return []personalField{
+		{"first_name", &c.FirstName, &c.FirstNameIndex},
+		{"last_name", &c.LastName, &c.LastNameIndex},
+		{"phone", &c.Phone, &c.PhoneIndex},
+		{"address", &c.Address, &c.AddressIndex},
+	}
+}
+
+func (c *Contact) rowID() uint {
+	return c.ID
+}
+
+func (m *MergeRecord) personalFields() []personalField {
+	return []personalField{
+		{"first_name", &m.FirstName, &m.FirstNameIndex},
+		{"last_name", &m.LastName, &m.LastNameIndex},
+		{"phone", &m.Phone, &m.PhoneIndex},
+		{"address", &m.Address, &m.AddressIndex},
+	}
+}
+
+func encryptPersonalData(data personalData) error {
+	e := fieldEncryption.Load()
+	if e == nil {
+		return nil
+	}
+	for _, f := range data.personalFields() {
+		// Empty fields stay empty, without an index every empty value would share
+		if *f.value == "" {
+			*f.index = ""
+			continue
+		}
+		// Already encrypted, nothing to do
+		if fieldcrypt.IsEncrypted(*f.value) {
+			continue
+		}
+		*f.index = e.Cipher.BlindIndex(f.name, *f.value)
+		if !e.encrypted(f.name) {
+			continue
+		}
+
+		ciphertext, err := e.Cipher.Encrypt(f.name, *f.value)
+		if err != nil {
+			return fmt.Errorf("unable to encrypt %s: %v", f.name, err)
+		}
+		*f.value = ciphertext
+	}
+	return nil
+}
+
+func decryptPersonalData(data personalData) error {
+	e := fieldEncryption.Load()
+	for _, f := range data.personalFields() {
+		if !fieldcrypt.IsEncrypted(*f.value) {
+			continue
+		}
+		if e == nil {
+			return errors.New("found encrypted data but field encryption is not configured")
+		}
+
+		plaintext, err := e.Cipher.Decrypt(f.name, *f.value)
+		if err != nil {
+			return err
+		}
+		*f.value = plaintext
+	}
+	return nil
+}
+
+func (m *MergeRecord) rowID() uint {
+	return m.ID
+}
+
+// Is a row stored as plaintext where it should be encrypted, under an old key, or missing its blind indexes
+func needsRotation(e *FieldEncryption, data personalData) bool {
+	for _, f := range data.personalFields() {
+		if *f.value == "" {
+			continue
+		}
+		if fieldcrypt.IsEncrypted(*f.value) != e.encrypted(f.name) || *f.index == "" {
+			return true
+		}
+		if e.encrypted(f.name) && e.Cipher.NeedsRotation(*f.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// gorm hooks, the rest of the code only ever sees plaintext
+func (c *Contact) BeforeSave(tx *gorm.DB) error {
+	// Every save is a change sync clients have to pick up
+	seq, err := nextChange(tx)
+	if err != nil {
+		return err
+	}
+	tx.Statement.SetColumn("change_seq", seq)
+	return encryptPersonalData(c)
+}
+
+func (c *Contact) AfterSave(tx *gorm.DB) error {
+	return decryptPersonalData(c)
+}
+
+func (c *Contact) AfterFind(tx *gorm.DB) error {
+	return decryptPersonalData(c)
+}
+
+func (m *MergeRecord) BeforeSave(tx *gorm.DB) error {
+	return encryptPersonalData(m)
+}
+
+func (m *MergeRecord) AfterSave(tx *gorm.DB) error {
+	return decryptPersonalData(m)
+}
+
+func (m *MergeRecord) AfterFind(tx *gorm.DB) error {
+	return decryptPersonalData(m)
+}
+
+// Number of rows handled at a time when rotating
+const rotationBatchSize = 200
+
+// Encrypt every contact and merge record that is still plaintext or uses an old key, and fill in missing blind indexes.
+// Run it after enabling encryption and after every key rotation. Returns the number of rows that changed.
+func RotateEncryption(db *gorm.DB) (int64, error) {
+	if fieldEncryption.Load() == nil {
+		return 0, ErrEncryptionNotConfigured
+	}
+
+	contacts, err := rotateTable[Contact](db)
+	if err != nil {
+		return contacts, err
+	}
+	records, err := rotateTable[MergeRecord](db)
+	internal.Logger.Info(fmt.Sprintf("Encryption rotated for %d contacts and %d merge records", contacts, records))
+	return contacts + records, err
+}
+
+func rotateTable[T any, PT interface {
+	*T
+	personalData
+}](db *gorm.DB) (int64, error) {
+	// Work on the stored values, without the hooks decrypting them
+	raw := db.Session(&gorm.Session{SkipHooks: true})
+
+	var rotated int64
+	var lastID uint
+	for {
+		var batch []T
+		if err := raw.Where("id > ?", lastID).Order("id").Limit(rotationBatchSize).Find(&batch).Error; err != nil {
+			return rotated, err
+		}
+
+		for i := range batch {
+			row := PT(&batch[i])
+			if !needsRotation(fieldEncryption.Load(), row) {
+				continue
+			}
+			if err := decryptPersonalData(row); err != nil {
+				return rotated, err
+			}
+			// Encrypt from scratch with the current key
+			for _, f := range row.personalFields() {
+				*f.index = ""
+			}
+			if err := encryptPersonalData(row); err != nil {
+				return rotated, err
+			}
+
+			// UpdateColumns leaves last_modified alone, rotating isn't a change to the contact
+			columns := make(map[string]interface{})
+			for _, f := range row.personalFields() {
+				columns[f.name] = *f.value
+				columns[f.name+"_index"] = *f.index
+			}
+			if err := raw.Model(row).UpdateColumns(columns).Error; err != nil {
+				return rotated, err
+			}
+			rotated++
+		}
+
+		if len(batch) < rotationBatchSize {
+			return rotated, nil
+		}
+		lastID = PT(&batch[len(batch)-1]).rowID()
+	}
+}
@@ -0,0 +1,40 @@
+package contacts
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"golangphonebook/pkg/fieldcrypt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptEmptyFields(t *testing.T) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	encoded := base64.StdEncoding.EncodeToString(key)
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf("current: k1\nindex_key: %s\nkeys:\n  k1: %s\n", encoded, encoded)), 0600))
+	keys, err := fieldcrypt.LoadKeyFile(path)
+	require.NoError(t, err)
+
+	EnableFieldEncryption(fieldcrypt.NewCipher(keys), true)
+	defer DisableFieldEncryption()
+
+	c := Contact{FirstName: "Ada", LastName: "Lovelace", Phone: "+441234567", AddressIndex: "stale"}
+	require.NoError(t, encryptPersonalData(&c))
+	assert.True(t, fieldcrypt.IsEncrypted(c.Phone))
+	assert.NotEmpty(t, c.PhoneIndex)
+	assert.Empty(t, c.Address, "empty fields stay empty")
+	assert.Empty(t, c.AddressIndex, "and have no blind index")
+	assert.False(t, needsRotation(FieldEncryptionSettings(), &c))
+
+	require.NoError(t, decryptPersonalData(&c))
+	assert.Equal(t, "+441234567", c.Phone)
+	assert.Empty(t, c.Address)
+}
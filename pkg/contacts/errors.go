@@ -0,0 +1,181 @@
+// Typed errors of the contacts package and how they are reported to clients
+package contacts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golangphonebook/pkg/problem"
+	"net/http"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Sentinel errors, compare with errors.Is. The repository often returns them wrapped with a more specific message.
+var (
+	ErrNotFound                = errors.New("contact not found")
+	ErrDuplicate               = errors.New("contact with the same full name and phone number already exists")
+	ErrValidation              = errors.New("validation failed")
+	ErrInvalidRequest          = errors.New("invalid request")
+	ErrTooMany                 = errors.New("too many contacts in one request")
+	ErrAddressBookNotFound     = errors.New("address book not found")
+	ErrAddressBookExists       = errors.New("address book with the same name already exists")
+	ErrEncryptionNotConfigured = errors.New("field encryption is not configured")
+	ErrCanceled                = errors.New("request canceled")
+	ErrTimeout                 = errors.New("request timed out")
+)
+
+// Not in net/http, the status nginx made common for clients that went away before the response
+const StatusClientClosedRequest = 499
+
+// Reported for IDs in the URL path that aren't integers
+var errInvalidID = newError(ErrInvalidRequest, "Invalid ID, IDs can only be integers")
+
+func init() {
+	problem.Register(ErrNotFound, http.StatusNotFound, "contact-not-found", "Contact not found")
+	problem.Register(ErrDuplicate, http.StatusConflict, "duplicate-contact", "Duplicate contact")
+	problem.Register(ErrValidation, http.StatusBadRequest, "validation-failed", "Validation failed")
+	problem.Register(ErrInvalidRequest, http.StatusBadRequest, "invalid-request", "Invalid request")
+	problem.Register(ErrTooMany, http.StatusBadRequest, "too-many-contacts", "Too many contacts")
+	problem.Register(ErrAddressBookNotFound, http.StatusBadRequest, "address-book-not-found", "Address book not found")
+	problem.Register(ErrAddressBookExists, http.StatusConflict, "duplicate-address-book", "Duplicate address book")
+	problem.Register(ErrEncryptionNotConfigured, http.StatusConflict, "encryption-not-configured", "Field encryption is not configured")
+	problem.Register(ErrCanceled, StatusClientClosedRequest, "request-canceled", "Request canceled")
+	problem.Register(ErrTimeout, http.StatusGatewayTimeout, "request-timeout", "Request timed out")
+}
+
+// Statuses the v1 routes answered with before errors had problem types, kept so v1 clients don't break
+var v1Statuses = map[error]int{
+	ErrDuplicate:         http.StatusBadRequest,
+	ErrAddressBookExists: http.StatusBadRequest,
+}
+
+// Respond with the problem for an error on a v1 route
+func writeV1Problem(w http.ResponseWriter, r *http.Request, err error) {
+	for kind, status := range v1Statuses {
+		if errors.Is(err, kind) {
+			problem.WriteStatus(w, r, err, status)
+			return
+		}
+	}
+	problem.Write(w, r, err)
+}
+
+// An error with its own message that still matches a sentinel error
+type contactError struct {
+	kind    error
+	message string
+}
+
+func (e *contactError) Error() string {
+	return e.message
+}
+
+func (e *contactError) Unwrap() error {
+	return e.kind
+}
+
+func newError(kind error, format string, args ...interface{}) error {
+	return &contactError{kind: kind, message: fmt.Sprintf(format, args...)}
+}
+
+// Database work cut short by the context it ran with. Matches ErrCanceled when the client went away and ErrTimeout
+// when the request's deadline passed, and the context's own error either way.
+type CanceledError struct {
+	kind  error
+	cause error // The context's error
+	err   error // What the database call returned
+}
+
+func (e *CanceledError) Error() string {
+	if e.kind == ErrTimeout {
+		return "the request took longer than its deadline and was stopped"
+	}
+	return "the request was canceled by the client and was stopped"
+}
+
+func (e *CanceledError) Unwrap() []error {
+	return []error{e.kind, e.cause, e.err}
+}
+
+// Err as a CanceledError when ctx has ended, database drivers report that in their own ways
+func contextError(ctx context.Context, err error) error {
+	var canceled *CanceledError
+	if err == nil || ctx.Err() == nil || errors.As(err, &canceled) {
+		return err
+	}
+	kind := ErrCanceled
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		kind = ErrTimeout
+	}
+	return &CanceledError{kind: kind, cause: ctx.Err(), err: err}
+}
+
+// Whether the request was canceled or ran out of time, errors that are reported as they are
+func IsCanceled(err error) bool {
+	return errors.Is(err, ErrCanceled) || errors.Is(err, ErrTimeout)
+}
+
+// Respond to a repository error without a kind of its own: 499 or 504 when the request was canceled or ran out of time,
+// an internal error with the message otherwise
+func writeFailure(w http.ResponseWriter, r *http.Request, message string, err error) {
+	if IsCanceled(err) {
+		problem.Write(w, r, err)
+		return
+	}
+	problem.Error(w, r, message, http.StatusInternalServerError)
+}
+
+// Invalid fields of a contact or address book, matches ErrValidation
+type ValidationError struct {
+	Fields []problem.FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var parts []string
+	for _, f := range e.Fields {
+		parts = append(parts, f.Message)
+	}
+	return "validation failed: " + strings.Join(parts, ", ")
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrValidation
+}
+
+func (e *ValidationError) FieldErrors() []problem.FieldError {
+	return e.Fields
+}
+
+// Run the validator, reporting every invalid field by its json name
+func validateStruct(value interface{}) error {
+	err := validate.Struct(value)
+	var invalid validator.ValidationErrors
+	if !errors.As(err, &invalid) {
+		return err
+	}
+
+	result := &ValidationError{}
+	for _, fe := range invalid {
+		result.Fields = append(result.Fields, problem.FieldError{
+			Field:   fe.Field(),
+			Rule:    fe.Tag(),
+			Param:   fe.Param(),
+			Message: fieldErrorMessage(fe),
+		})
+	}
+	return result
+}
+
+func fieldErrorMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return fmt.Sprintf("%s is required", fe.Field())
+	case "max":
+		return fmt.Sprintf("%s must be at most %s characters", fe.Field(), fe.Param())
+	case "customPhone":
+		return fmt.Sprintf("%s must be an optional + followed by 4 to 20 digits", fe.Field())
+	}
+	return fmt.Sprintf("%s failed the %s rule", fe.Field(), fe.Tag())
+}
@@ -3,9 +3,14 @@ package contacts
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"golangphonebook/internal"
+	"golangphonebook/pkg/auth"
+	"golangphonebook/pkg/fieldcrypt"
+	"golangphonebook/pkg/problem"
 	"io"
 	"net/http"
 	"sort"
@@ -13,6 +18,7 @@ import (
 	"strings"
 
 	"github.com/gorilla/mux"
+	"gorm.io/gorm"
 )
 
 func PutContact(w http.ResponseWriter, r *http.Request, repo ContactRepository) {
@@ -20,26 +26,22 @@ func PutContact(w http.ResponseWriter, r *http.Request, repo ContactRepository)
 
 	contact, err := decodeBodyToContact(r)
 	if err != nil {
-		internal.Logger.Error(fmt.Sprintf("Received invalid body in addContact method %s", err))
-		http.Error(w, "Invalid request body, first name and phone must be correctly defined", http.StatusBadRequest)
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Received invalid body in addContact method %s", err))
+		writeV1Problem(w, r, fmt.Errorf("Invalid request body, first name and phone must be correctly defined: %w", err))
 		return
 	} else {
-		internal.Logger.Info(fmt.Sprintf("Received valid body in addContact method %s", contact))
+		internal.Logger.InfoContext(r.Context(), fmt.Sprintf("Received valid body in addContact method %s", contact.Redacted()))
 	}
 
-	err = repo.AddContact(*contact)
+	_, err = repo.AddContact(r.Context(), *contact)
 	if err != nil {
-		if err.Error() == "contact with the same full name and phone number already exists" {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-		} else {
-			http.Error(w, fmt.Sprintf("Failed to insert contact to db with error %v", err), http.StatusInternalServerError)
-		}
+		writeV1Problem(w, r, err)
 		return
 	}
 
-	internal.Logger.Info("Contact added to DB successfully")
+	internal.Logger.InfoContext(r.Context(), "Contact added to DB successfully")
 	// State tracking for caching, since changes to DB we need to pull fresh data
-	filterState.UpdateCache = true
+	InvalidateCache(r.Context())
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Contact added to DB successfully"))
@@ -52,14 +54,14 @@ func PutContacts(w http.ResponseWriter, r *http.Request, repo ContactRepository)
 	// Decode JSON array from request body
 	var contacts []json.RawMessage
 	if err := json.NewDecoder(r.Body).Decode(&contacts); err != nil {
-		internal.Logger.Error(fmt.Sprintf("Received invalid body in AddContacts method: %v", err))
-		http.Error(w, "Invalid request body. Please provide a valid JSON array of contacts.", http.StatusBadRequest)
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Received invalid body in AddContacts method: %v", err))
+		writeV1Problem(w, r, newError(ErrInvalidRequest, "Invalid request body. Please provide a valid JSON array of contacts."))
 		return
 	}
 
 	// Check if the number of contacts exceeds the allowed limit
-	if len(contacts) > 20 {
-		http.Error(w, "Cannot add more than 20 contacts at a time", http.StatusBadRequest)
+	if len(contacts) > MaxBatchSize {
+		writeV1Problem(w, r, newError(ErrTooMany, "Cannot add more than %d contacts at a time", MaxBatchSize))
 		return
 	}
 
@@ -72,7 +74,7 @@ func PutContacts(w http.ResponseWriter, r *http.Request, repo ContactRepository)
 		// Create a new request with the contact JSON
 		req, err := http.NewRequest("POST", "", bytes.NewReader(contactJSON))
 		if err != nil {
-			internal.Logger.Error(fmt.Sprintf("Failed to create request for contact: %v", err))
+			internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to create request for contact: %v", err))
 			failedContacts = append(failedContacts, string(contactJSON))
 			failedErrors = append(failedErrors, "Failed to create request for contact")
 			continue
@@ -80,14 +82,14 @@ func PutContacts(w http.ResponseWriter, r *http.Request, repo ContactRepository)
 
 		contact, err := decodeBodyToContact(req)
 		if err != nil {
-			internal.Logger.Warn(fmt.Sprintf("Failed to decode and validate contact: %v", err))
+			internal.Logger.WarnContext(r.Context(), fmt.Sprintf("Failed to decode and validate contact: %v", err))
 			failedContacts = append(failedContacts, string(contactJSON))
 			failedErrors = append(failedErrors, fmt.Sprintf("Validation error: %v", err))
 			continue
 		}
 
-		if err := repo.AddContact(*contact); err != nil {
-			internal.Logger.Error(fmt.Sprintf("Failed to add contact: %v, error: %v", contact, err))
+		if _, err := repo.AddContact(r.Context(), *contact); err != nil {
+			internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to add contact: %s, error: %v", contact.Redacted(), err))
 			failedContacts = append(failedContacts, string(contactJSON))
 			failedErrors = append(failedErrors, fmt.Sprintf("Database error: %v", err))
 			continue
@@ -98,19 +100,20 @@ func PutContacts(w http.ResponseWriter, r *http.Request, repo ContactRepository)
 
 	// Update cache if any contacts were added successfully
 	if successfulContacts > 0 {
-		filterState.UpdateCache = true
-		internal.Logger.Info(fmt.Sprintf("%d contacts added to DB successfully", successfulContacts))
+		InvalidateCache(r.Context())
+		internal.Logger.InfoContext(r.Context(), fmt.Sprintf("%d contacts added to DB successfully", successfulContacts))
 	}
 
 	// Prepare response
-	response := map[string]interface{}{
-		"successful_contacts": successfulContacts,
-		"failed_contacts":     failedContacts,
-		"errors":              failedErrors,
+	response := BatchResult{
+		SuccessfulContacts: successfulContacts,
+		FailedContacts:     failedContacts,
+		Errors:             failedErrors,
 	}
 
 	// Set appropriate status code based on success/failure
-	internal.Logger.Info(fmt.Sprintf("Successful: %d, Failed: %d", successfulContacts, len(failedContacts)))
+	internal.Logger.InfoContext(r.Context(), fmt.Sprintf("Successful: %d, Failed: %d", successfulContacts, len(failedContacts)))
+	RecordBatch(successfulContacts, len(failedContacts))
 
 	if len(failedContacts) > 0 && successfulContacts == 0 {
 		w.WriteHeader(http.StatusBadRequest)
@@ -122,151 +125,204 @@ func PutContacts(w http.ResponseWriter, r *http.Request, repo ContactRepository)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		internal.Logger.Error(fmt.Sprintf("Failed to encode response: %v", err))
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to encode response: %v", err))
+		problem.Error(w, r, "Failed to encode response", http.StatusInternalServerError)
 	}
 }
 
 func GetContacts(w http.ResponseWriter, r *http.Request, repo ContactRepository) {
 	defer internal.Timer("GetContacts")()
 
-	pageStr := r.URL.Query().Get("page")
-	ascDec := r.URL.Query().Get("asc_dec")
-	sortByStr := r.URL.Query().Get("sort_by")
+	paginatedContacts, err := listContacts(r, repo)
+	if err != nil {
+		writeFailure(w, r, err.Error(), err)
+		return
+	}
 
-	var ascending bool
-	if ascDec == "asc" {
-		ascending = true
-	} else if ascDec == "dec" {
-		ascending = false
-	} else {
-		ascending = true
+	// Serialize the PaginatedContacts object to JSON
+	response, err := marshalJSON(r.Context(), paginatedContacts)
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to serialize contacts: %v", err))
+		problem.Error(w, r, "Failed to serialize contacts", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+
+}
+
+// One page of contacts for the filter, sort and page parameters, served from the cache when possible.
+// Errors are safe to show to the client.
+func listContacts(r *http.Request, repo ContactRepository) (*PaginatedContacts, error) {
+	params := ListParams{
+		FirstName: r.URL.Query().Get("first_name"),
+		LastName:  r.URL.Query().Get("last_name"),
+		Address:   r.URL.Query().Get("address"),
+		Phone:     r.URL.Query().Get("phone"),
+		SortBy:    SortBy(r.URL.Query().Get("sort_by")),
+		// Anything but dec sorts ascending
+		Descending:  r.URL.Query().Get("asc_dec") == "dec",
+		AddressBook: r.URL.Query().Get("address_book_id"),
+	}
+	internal.Logger.InfoContext(r.Context(), fmt.Sprintf("page input: %s", r.URL.Query().Get("page")))
+	params.Page, _ = strconv.Atoi(r.URL.Query().Get("page"))
+	// The repository scopes queries to the tenant itself, this only keeps cached pages from leaking between tenants
+	if id, ok := auth.FromContext(r.Context()); ok {
+		params.Tenant = id.Tenant
+	}
+	return ListContacts(r.Context(), repo, params)
+}
+
+// Filters, sort order and page of a contact listing, shared by every API
+type ListParams struct {
+	FirstName   string
+	LastName    string
+	Address     string
+	Phone       string
+	AddressBook string // ID of the address book, any book of the tenant when empty
+	SortBy      SortBy // first_name when empty
+	Descending  bool
+	Page        int    // Out of range pages return the first page
+	Tenant      string // Keeps cached pages from leaking between tenants
+}
+
+// Filters in the form FilterContacts takes them
+func (p ListParams) Filters() map[string]string {
+	return map[string]string{
+		"first_name":      p.FirstName,
+		"last_name":       p.LastName,
+		"address":         p.Address,
+		"phone":           p.Phone,
+		"address_book_id": p.AddressBook,
 	}
+}
+
+// Drop the cached pages of the tenant in ctx, for changes made outside the HTTP handlers
+func InvalidateCache(ctx context.Context) {
+	id, _ := auth.FromContext(ctx)
+	pageCaches.invalidate(id.Tenant)
+}
+
+// One page of contacts, served from the cache when possible. Errors are safe to show to the client.
+func ListContacts(ctx context.Context, repo ContactRepository, params ListParams) (*PaginatedContacts, error) {
+	ascending := !params.Descending
+	sortByStr := string(params.SortBy)
 
 	var sortBy SortBy
-	switch sortByStr {
-	case "first_name":
+	switch params.SortBy {
+	case SortByFirstName:
 		sortBy = SortByFirstName
-	case "last_name":
+	case SortByLastName:
 		sortBy = SortByLastName
-	case "last_modified": // I don't really know anyone who wants to see their very oldest contacts, you'd use this functionality for more recent ones
+	case SortByLastModified: // I don't really know anyone who wants to see their very oldest contacts, you'd use this functionality for more recent ones
 		sortBy = SortByLastModified
 		ascending = false
 	default:
 		sortBy = SortByFirstName
 	}
 
-	filters := map[string]string{
-		"first_name": r.URL.Query().Get("first_name"),
-		"last_name":  r.URL.Query().Get("last_name"),
-		"address":    r.URL.Query().Get("address"),
-		"phone":      r.URL.Query().Get("phone"),
-		"asc_dec":    strconv.FormatBool(ascending),
-		"sort_str":   sortByStr,
+	filters := params.Filters()
+	filters["asc_dec"] = strconv.FormatBool(ascending)
+	filters["sort_str"] = sortByStr
+	if params.Tenant != "" {
+		filters["tenant"] = params.Tenant
 	}
 
-	internal.Logger.Info(fmt.Sprintf("Filters applied: %v", filters))
-	internal.Logger.Info(fmt.Sprintf("page input: %s", pageStr))
-	internal.Logger.Info(fmt.Sprintf("sort_by input: %s", pageStr))
+	internal.Logger.InfoContext(ctx, fmt.Sprintf("Filters applied: %s", internal.Logger.RedactFields(filters)))
+	internal.Logger.InfoContext(ctx, fmt.Sprintf("sort_by input: %s", sortByStr))
 	// For comparisons, check if changes to filter
 	queryString := buildFilterQueryString(filters)
 
 	// Get the filtered gorm query, total count of contacts that match that query
-	query, totalCount, err := repo.FilterContacts(filters)
+	query, totalCount, err := repo.FilterContacts(ctx, filters)
 	if err != nil {
-		internal.Logger.Error(fmt.Sprintf("Failed to filter contacts: %v", err))
-		http.Error(w, "Failed to filter contacts", http.StatusInternalServerError)
-		return
+		internal.Logger.ErrorContext(ctx, fmt.Sprintf("Failed to filter contacts: %v", err))
+		if IsCanceled(err) {
+			return nil, err
+		}
+		return nil, errors.New("failed to filter contacts")
 	}
 
-	totalPages := int((totalCount + 9) / 10)
+	totalPages := int((totalCount + int64(PageSize) - 1) / int64(PageSize))
 	// Failsafe for out of bounds page numbers, some tolerance for invalid page number input (just default to 1)
-	page, err := strconv.Atoi(pageStr)
-	if err != nil || page < 1 || page > totalPages {
+	page := params.Page
+	if page < 1 || page > totalPages {
 		page = 1
 	}
 
-	internal.Logger.Info(fmt.Sprintf("filterState queryState: %s", filterState.QueryString))
-	internal.Logger.Info(fmt.Sprintf("new queryState: %s", queryString))
-	internal.Logger.Info(fmt.Sprintf("Cached page is %d and queried page is %d", filterState.CachedPage, page))
-	internal.Logger.Info(fmt.Sprintf("UpdateCache requirement is %s", strconv.FormatBool(filterState.UpdateCache)))
-
-	// Check if the filter or page has changed, queries are case insensitive so let's consider that here too
-	if strings.EqualFold(filterState.QueryString, queryString) && page == filterState.CachedPage && !filterState.UpdateCache {
-		// If the filter is the same and page is the same, serve from cache
-		internal.Logger.Info("Fetching data stored in the cache, user just went up a page")
-		if len(filterState.Cache) > 0 {
-			paginatedContacts := PaginatedContacts{
-				Contacts:    filterState.Cache[:len(filterState.Cache)],
-				TotalPages:  filterState.TotalPages,
-				CurrentPage: page,
-				TotalCount:  filterState.TotalCount,
-			}
-
-			response, err := json.Marshal(paginatedContacts)
-			if err != nil {
-				internal.Logger.Error(fmt.Sprintf("Failed to serialize contacts: %v", err))
-				http.Error(w, "Failed to serialize contacts", http.StatusInternalServerError)
-				return
-			}
-
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusOK)
-			w.Write(response)
-
-			// Start goroutine to prefetch the next set of contacts
-			go func() {
-				contacts, err := repo.SearchContacts(filterState.Query, page+1, sortBy, ascending, false)
-				if err == nil && len(contacts) > 0 {
-					internal.Logger.Info("Cache updated successfully")
-				} else {
-					internal.Logger.Error("Failed to update cache, setting cache to try updating again with next call")
-					filterState.UpdateCache = true
+	var cached *PaginatedContacts
+	var cachedQuery *gorm.DB
+	pageCaches.with(params.Tenant, func(filterState *FilterState) {
+		internal.Logger.InfoContext(ctx, fmt.Sprintf("Filter unchanged since the cached query: %v", strings.EqualFold(filterState.QueryString, queryString)))
+		internal.Logger.InfoContext(ctx, fmt.Sprintf("Cached page is %d and queried page is %d", filterState.CachedPage, page))
+		internal.Logger.InfoContext(ctx, fmt.Sprintf("UpdateCache requirement is %s", strconv.FormatBool(filterState.UpdateCache)))
+
+		// Check if the filter or page has changed, queries are case insensitive so let's consider that here too
+		if strings.EqualFold(filterState.QueryString, queryString) && page == filterState.CachedPage && !filterState.UpdateCache {
+			// If the filter is the same and page is the same, serve from cache
+			internal.Logger.InfoContext(ctx, "Fetching data stored in the cache, user just went up a page")
+			if len(filterState.Cache) > 0 {
+				cached = &PaginatedContacts{
+					Contacts:    filterState.Cache[:len(filterState.Cache)],
+					TotalPages:  filterState.TotalPages,
+					CurrentPage: page,
+					TotalCount:  filterState.TotalCount,
 				}
-			}()
+				cachedQuery = filterState.Query
+			}
 
-			return
+		} else { // If it's a new fetch continue below
+			internal.Logger.InfoContext(ctx, "Something has changed, so fetching data from the db rather than from the cache")
+			filterState.Query = query
+			filterState.QueryString = queryString
+			// filteredState.Cache is populated in search method
+			filterState.CachedPage = page + 1
+			filterState.TotalPages = totalPages
+			filterState.TotalCount = totalCount
+			// filterState.UpdateCache is updated in search method
 		}
+	})
+
+	if cached != nil {
+		cacheRequests.Inc("hit")
+		// Start goroutine to prefetch the next set of contacts
+		// The prefetch outlives the request, but stays part of its trace
+		ctx := context.WithoutCancel(ctx)
+		prefetches.Add(1)
+		go func() {
+			defer prefetches.Done()
+			contacts, err := repo.SearchContacts(ctx, cachedQuery, page+1, sortBy, ascending, false)
+			if err == nil && len(contacts) > 0 {
+				internal.Logger.InfoContext(ctx, "Cache updated successfully")
+			} else {
+				internal.Logger.ErrorContext(ctx, "Failed to update cache, setting cache to try updating again with next call")
+				pageCaches.invalidate(params.Tenant)
+			}
+		}()
 
-	} else { // If it's a new fetch continue below
-		internal.Logger.Info("Something has changed, so fetching data from the db rather than from the cache")
-		filterState.Query = query
-		filterState.QueryString = queryString
-		// filteredState.Cache is populated in search method
-		filterState.CachedPage = page + 1
-		filterState.TotalPages = totalPages
-		filterState.TotalCount = totalCount
-		// filterState.UpdateCache is updated in search method
+		return cached, nil
 	}
 
 	// Get the contacts for the specified page using SearchContacts
-	contacts, err := repo.SearchContacts(query, page, sortBy, ascending, true)
+	cacheRequests.Inc("miss")
+	contacts, err := repo.SearchContacts(ctx, query, page, sortBy, ascending, true)
 	if err != nil {
-		internal.Logger.Error(fmt.Sprintf("Failed to search contacts: %v", err))
-		http.Error(w, "Failed to search contacts", http.StatusInternalServerError)
-		return
+		internal.Logger.ErrorContext(ctx, fmt.Sprintf("Failed to search contacts: %v", err))
+		if IsCanceled(err) {
+			return nil, err
+		}
+		return nil, errors.New("failed to search contacts")
 	}
 
 	// Construct the PaginatedContacts object
-	paginatedContacts := PaginatedContacts{
+	return &PaginatedContacts{
 		Contacts:    contacts,
 		TotalPages:  totalPages,
 		CurrentPage: page,
 		TotalCount:  totalCount,
-	}
-
-	// Serialize the PaginatedContacts object to JSON
-	response, err := json.Marshal(paginatedContacts)
-	if err != nil {
-		internal.Logger.Error(fmt.Sprintf("Failed to serialize contacts: %v", err))
-		http.Error(w, "Failed to serialize contacts", http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(response)
-
+	}, nil
 }
 
 func UpdateContact(w http.ResponseWriter, r *http.Request, repo ContactRepository) {
@@ -277,36 +333,29 @@ func UpdateContact(w http.ResponseWriter, r *http.Request, repo ContactRepositor
 	id, err := strconv.Atoi(vars["id"])
 
 	if err != nil {
-		http.Error(w, "Invalid ID, IDs can only be integers", http.StatusBadRequest)
+		writeV1Problem(w, r, errInvalidID)
 		return
 	}
-	internal.Logger.Info(fmt.Sprintf("ID to update detected as %d", id))
+	internal.Logger.InfoContext(r.Context(), fmt.Sprintf("ID to update detected as %d", id))
 
 	contact, err := decodeBodyToContact(r)
 	if err != nil {
-		internal.Logger.Error(fmt.Sprintf("Received invalid body in updateContact method %s", err))
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Received invalid body in updateContact method %s", err))
+		writeV1Problem(w, r, fmt.Errorf("Invalid request body: %w", err))
 		return
 	}
 
-	internal.Logger.Info(fmt.Sprintf("Received valid body in updateContact method %s", contact))
+	internal.Logger.InfoContext(r.Context(), fmt.Sprintf("Received valid body in updateContact method %s", contact.Redacted()))
 
 	// Update contact in db
-	err = repo.UpdateContact(id, *contact)
+	err = repo.UpdateContact(r.Context(), id, *contact)
 	if err != nil {
-		// Handle duplicate data error
-		if err.Error() == "another contact with the same first name, last name, and phone number already exists" {
-			internal.Logger.Error(fmt.Sprintf("Duplicate data error: %s", err))
-			http.Error(w, "Duplicate contact with the same first name, last name, and phone number already exists", http.StatusBadRequest)
-			return
-		}
-		// Handle other errors as internal server errors
-		internal.Logger.Error(fmt.Sprintf("Failed to update contact to db: %s", err))
-		http.Error(w, "Failed to update contact due to an internal server error", http.StatusInternalServerError)
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to update contact to db: %s", err))
+		writeV1Problem(w, r, err)
 		return
 	}
 
-	filterState.UpdateCache = true
+	InvalidateCache(r.Context())
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Contact updated successfully"))
@@ -320,21 +369,17 @@ func DeleteContact(w http.ResponseWriter, r *http.Request, repo ContactRepositor
 	id, err := strconv.Atoi(vars["id"])
 
 	if err != nil {
-		http.Error(w, "Invalid ID, IDs can only be integers", http.StatusBadRequest)
+		writeV1Problem(w, r, errInvalidID)
 		return
 	}
-	internal.Logger.Info(fmt.Sprintf("ID to delete detected as %d", id))
+	internal.Logger.InfoContext(r.Context(), fmt.Sprintf("ID to delete detected as %d", id))
 
-	err = repo.DeleteContact(id)
+	err = repo.DeleteContact(r.Context(), id)
 	if err != nil {
-		if err.Error() == "no contact found with the given ID" {
-			http.Error(w, err.Error(), http.StatusNotFound)
-		} else {
-			http.Error(w, "failed to delete contact", http.StatusInternalServerError)
-		}
+		writeV1Problem(w, r, err)
 		return
 	}
-	filterState.UpdateCache = true
+	InvalidateCache(r.Context())
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Contact deleted successfully"))
@@ -346,14 +391,14 @@ func DeleteContacts(w http.ResponseWriter, r *http.Request, repo ContactReposito
 	// Extract comma-separated IDs from the query parameters
 	idsParam := r.URL.Query().Get("ids")
 	if idsParam == "" {
-		http.Error(w, "No IDs provided", http.StatusBadRequest)
+		writeV1Problem(w, r, newError(ErrInvalidRequest, "No IDs provided"))
 		return
 	}
 
 	// Split the IDs by comma
 	ids := strings.Split(idsParam, ",")
-	if len(ids) > 20 {
-		http.Error(w, "Cannot delete more than 20 contacts at a time", http.StatusBadRequest)
+	if len(ids) > MaxBatchSize {
+		writeV1Problem(w, r, newError(ErrTooMany, "Cannot delete more than %d contacts at a time", MaxBatchSize))
 		return
 	}
 
@@ -372,54 +417,243 @@ func DeleteContacts(w http.ResponseWriter, r *http.Request, repo ContactReposito
 
 	// If there are any invalid IDs, return an error
 	if len(invalidIds) > 0 {
-		http.Error(w, fmt.Sprintf("Invalid IDs: %v. IDs can only be integers.", invalidIds), http.StatusBadRequest)
+		writeV1Problem(w, r, newError(ErrInvalidRequest, "Invalid IDs: %v. IDs can only be integers.", invalidIds))
 		return
 	}
 
 	// Iterate over the valid IDs and delete each contact
 	for _, id := range validIds {
-		internal.Logger.Info(fmt.Sprintf("Attempting to delete contact with ID %d", id))
+		internal.Logger.InfoContext(r.Context(), fmt.Sprintf("Attempting to delete contact with ID %d", id))
 
-		err := repo.DeleteContact(id)
+		err := repo.DeleteContact(r.Context(), id)
 		if err != nil {
-			if err.Error() == "no contact found with the given ID" {
-				http.Error(w, fmt.Sprintf("No contact found with ID %d", id), http.StatusNotFound)
-			} else {
-				http.Error(w, fmt.Sprintf("Failed to delete contact with ID %d", id), http.StatusInternalServerError)
+			if errors.Is(err, ErrNotFound) {
+				err = newError(ErrNotFound, "No contact found with ID %d", id)
 			}
+			writeV1Problem(w, r, err)
 			return
 		}
 	}
 
-	filterState.UpdateCache = true
+	InvalidateCache(r.Context())
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Contacts deleted successfully"))
 }
 
+func FindDuplicates(w http.ResponseWriter, r *http.Request, repo ContactRepository) {
+	defer internal.Timer("FindDuplicates")()
+
+	// Optional similarity threshold for the names, between 0 and 1
+	threshold := DefaultDuplicateThreshold
+	if thresholdStr := r.URL.Query().Get("threshold"); thresholdStr != "" {
+		parsed, err := strconv.ParseFloat(thresholdStr, 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			writeV1Problem(w, r, newError(ErrInvalidRequest, "Invalid threshold, must be a number between 0 and 1"))
+			return
+		}
+		threshold = parsed
+	}
+
+	clusters, err := repo.FindDuplicates(r.Context(), threshold)
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to find duplicate contacts: %v", err))
+		writeFailure(w, r, "Failed to find duplicate contacts", err)
+		return
+	}
+	internal.Logger.InfoContext(r.Context(), fmt.Sprintf("Found %d clusters of likely duplicates", len(clusters)))
+
+	response, err := json.Marshal(DuplicateClusters{Threshold: threshold, Clusters: clusters})
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to serialize duplicates: %v", err))
+		problem.Error(w, r, "Failed to serialize duplicates", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+func MergeContacts(w http.ResponseWriter, r *http.Request, repo ContactRepository) {
+	defer internal.Timer("MergeContacts")()
+
+	var request MergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Received invalid body in mergeContacts method: %v", err))
+		writeV1Problem(w, r, newError(ErrInvalidRequest, "Invalid request body"))
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		writeV1Problem(w, r, err)
+		return
+	}
+
+	merged, err := repo.MergeContacts(r.Context(), request)
+	if err != nil {
+		writeV1Problem(w, r, err)
+		return
+	}
+
+	InvalidateCache(r.Context())
+
+	response, err := json.Marshal(merged)
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to serialize merged contact: %v", err))
+		problem.Error(w, r, "Failed to serialize merged contact", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+func GetMergeHistory(w http.ResponseWriter, r *http.Request, repo ContactRepository) {
+	defer internal.Timer("GetMergeHistory")()
+
+	// Extract ID from  URL path /mergeHistory/{id}
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		writeV1Problem(w, r, errInvalidID)
+		return
+	}
+
+	history, err := repo.GetMergeHistory(r.Context(), id)
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to get merge history for contact %d: %v", id, err))
+		writeFailure(w, r, "Failed to get merge history", err)
+		return
+	}
+
+	response, err := json.Marshal(history)
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to serialize merge history: %v", err))
+		problem.Error(w, r, "Failed to serialize merge history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+func GetAddressBooks(w http.ResponseWriter, r *http.Request, repo ContactRepository) {
+	defer internal.Timer("GetAddressBooks")()
+
+	books, err := repo.ListAddressBooks(r.Context())
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to list address books: %v", err))
+		writeFailure(w, r, "Failed to list address books", err)
+		return
+	}
+
+	response, err := json.Marshal(books)
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to serialize address books: %v", err))
+		problem.Error(w, r, "Failed to serialize address books", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+func PutAddressBook(w http.ResponseWriter, r *http.Request, repo ContactRepository) {
+	defer internal.Timer("PutAddressBook")()
+
+	var book AddressBook
+	err := json.NewDecoder(r.Body).Decode(&book)
+	if err != nil {
+		err = newError(ErrInvalidRequest, "unable to decode JSON: %v", err)
+	} else {
+		err = book.Validate()
+	}
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Received invalid body in addAddressBook method %v", err))
+		writeV1Problem(w, r, fmt.Errorf("Invalid request body, name must be defined and at most 100 characters: %w", err))
+		return
+	}
+
+	created, err := repo.CreateAddressBook(r.Context(), book.Name)
+	if err != nil {
+		writeV1Problem(w, r, err)
+		return
+	}
+
+	response, err := json.Marshal(created)
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to serialize address book: %v", err))
+		problem.Error(w, r, "Failed to serialize address book", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// Reload the key file and encrypt everything again with its current key, so an old key can be retired
+func RotateEncryptionKeys(w http.ResponseWriter, r *http.Request, db *gorm.DB, keyFile string) {
+	defer internal.Timer("RotateEncryptionKeys")()
+
+	settings := FieldEncryptionSettings()
+	if settings == nil || keyFile == "" {
+		writeV1Problem(w, r, ErrEncryptionNotConfigured)
+		return
+	}
+
+	keys, err := fieldcrypt.LoadKeyFile(keyFile)
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to reload encryption keys: %v", err))
+		problem.Error(w, r, "Failed to reload encryption keys", http.StatusInternalServerError)
+		return
+	}
+	EnableFieldEncryption(fieldcrypt.NewCipher(keys), settings.EncryptNames)
+
+	rotated, err := RotateEncryption(db)
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to rotate encryption after %d rows: %v", rotated, err))
+		problem.Error(w, r, fmt.Sprintf("Failed to rotate encryption after %d rows", rotated), http.StatusInternalServerError)
+		return
+	}
+
+	response, _ := json.Marshal(RotationResult{CurrentKey: keys.CurrentKeyID(), Rotated: rotated})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
 // Helper method(s)
 // Decode JSON body into a Contact
 func decodeBodyToContact(r *http.Request) (*Contact, error) {
 	// Read body from request
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		internal.Logger.Error(fmt.Sprintf("Unable to read request body: %v", err))
-		return nil, fmt.Errorf("unable to read request body: %v", err)
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Unable to read request body: %v", err))
+		return nil, newError(ErrInvalidRequest, "unable to read request body: %v", err)
 	}
 	defer r.Body.Close()
-	internal.Logger.Info(fmt.Sprintf("Received JSON: %s", string(body)))
+	// The raw body is full of personal data, only log it when redaction is switched off
+	if internal.Logger.Redaction.Disabled() {
+		internal.Logger.InfoContext(r.Context(), fmt.Sprintf("Received JSON: %s", string(body)))
+	} else {
+		internal.Logger.InfoContext(r.Context(), fmt.Sprintf("Received JSON body of %d bytes", len(body)))
+	}
 
 	// Decode JSON body into a Contact
 	var contact Contact
 	if err := json.Unmarshal(body, &contact); err != nil {
-		internal.Logger.Error(fmt.Sprintf("Unable to unmarshal JSON into Contact: %v", err))
-		return nil, fmt.Errorf("unable to unmarshal JSON into Contact: %v", err)
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Unable to unmarshal JSON into Contact: %v", err))
+		return nil, newError(ErrInvalidRequest, "unable to unmarshal JSON into Contact: %v", err)
 	}
 
 	// Validate the Contact struct
-	err = validate.Struct(contact)
-	if err != nil {
-		return nil, fmt.Errorf("Err(s):\n%+v", err)
+	if err := validateStruct(contact); err != nil {
+		return nil, err
 	}
 	// Return Contact object
 	return &contact, nil
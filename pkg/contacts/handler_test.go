@@ -2,10 +2,15 @@ package contacts_test
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"golangphonebook/pkg/contacts"
+	"golangphonebook/pkg/metrics"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/gorilla/mux"
@@ -16,43 +21,99 @@ import (
 // MockContactRepository is a mock implementation of the ContactRepository interface
 type MockContactRepository struct {
 	addContactFn    func(contact contacts.Contact) error
+	getContactFn    func(id int) (*contacts.Contact, error)
+	replaceFn       func(id int, contact contacts.Contact) (*contacts.Contact, error)
 	updateContactFn func(id int, contact contacts.Contact) error
 	deleteContactFn func(id int) error
+	mergeContactsFn func(request contacts.MergeRequest) (*contacts.Contact, error)
+	createBookFn    func(name string) (*contacts.AddressBook, error)
+	changesSinceFn  func(position contacts.SyncPosition, limit int) (*contacts.SyncResult, error)
 }
 
-func (m *MockContactRepository) AddContact(contact contacts.Contact) error {
+func (m *MockContactRepository) AddContact(_ context.Context, contact contacts.Contact) (*contacts.Contact, error) {
 	if m.addContactFn != nil {
-		return m.addContactFn(contact)
+		if err := m.addContactFn(contact); err != nil {
+			return nil, err
+		}
 	}
-	return nil
+	contact.ID = 1
+	return &contact, nil
+}
+
+func (m *MockContactRepository) GetContact(_ context.Context, id int) (*contacts.Contact, error) {
+	if m.getContactFn != nil {
+		return m.getContactFn(id)
+	}
+	return &contacts.Contact{ID: uint(id), FirstName: "John", LastName: "Doe", Phone: "+1234567890"}, nil
 }
 
-func (m *MockContactRepository) FilterContacts(filters map[string]string) (*gorm.DB, int64, error) {
+func (m *MockContactRepository) ReplaceContact(_ context.Context, id int, contact contacts.Contact) (*contacts.Contact, error) {
+	if m.replaceFn != nil {
+		return m.replaceFn(id, contact)
+	}
+	contact.ID = uint(id)
+	return &contact, nil
+}
+
+func (m *MockContactRepository) FilterContacts(_ context.Context, filters map[string]string) (*gorm.DB, int64, error) {
 	return nil, 0, nil
 }
 
-func (m *MockContactRepository) SearchContacts(query *gorm.DB, page int, sortBy contacts.SortBy, ascending bool, initialFetch bool) ([]contacts.Contact, error) {
+func (m *MockContactRepository) SearchContacts(_ context.Context, query *gorm.DB, page int, sortBy contacts.SortBy, ascending bool, initialFetch bool) ([]contacts.Contact, error) {
 	return nil, nil
 }
 
-func (m *MockContactRepository) UpdateContact(id int, contact contacts.Contact) error {
+func (m *MockContactRepository) UpdateContact(_ context.Context, id int, contact contacts.Contact) error {
 	if m.updateContactFn != nil {
 		return m.updateContactFn(id, contact)
 	}
 	return nil
 }
 
-func (m *MockContactRepository) DeleteContact(id int) error {
+func (m *MockContactRepository) DeleteContact(_ context.Context, id int) error {
 	if m.deleteContactFn != nil {
 		return m.deleteContactFn(id)
 	}
 	return nil
 }
 
-func (m *MockContactRepository) GetContactCount() (int64, error) {
+func (m *MockContactRepository) GetContactCount(_ context.Context) (int64, error) {
 	return 0, nil
 }
 
+func (m *MockContactRepository) FindDuplicates(_ context.Context, threshold float64) ([][]contacts.Contact, error) {
+	return nil, nil
+}
+
+func (m *MockContactRepository) MergeContacts(_ context.Context, request contacts.MergeRequest) (*contacts.Contact, error) {
+	if m.mergeContactsFn != nil {
+		return m.mergeContactsFn(request)
+	}
+	return &contacts.Contact{}, nil
+}
+
+func (m *MockContactRepository) GetMergeHistory(_ context.Context, id int) ([]contacts.MergeRecord, error) {
+	return nil, nil
+}
+
+func (m *MockContactRepository) ListAddressBooks(_ context.Context) ([]contacts.AddressBook, error) {
+	return nil, nil
+}
+
+func (m *MockContactRepository) CreateAddressBook(_ context.Context, name string) (*contacts.AddressBook, error) {
+	if m.createBookFn != nil {
+		return m.createBookFn(name)
+	}
+	return &contacts.AddressBook{Name: name}, nil
+}
+
+func (m *MockContactRepository) ChangesSince(_ context.Context, position contacts.SyncPosition, limit int) (*contacts.SyncResult, error) {
+	if m.changesSinceFn != nil {
+		return m.changesSinceFn(position, limit)
+	}
+	return &contacts.SyncResult{SyncToken: position.Token()}, nil
+}
+
 func TestPutContact(t *testing.T) {
 	tests := []struct {
 		name               string
@@ -149,7 +210,7 @@ func TestPutContact(t *testing.T) {
 				"address": "123 Main St"
 			}`,
 			mockAddContactFn: func(contact contacts.Contact) error {
-				return errors.New("contact with the same full name and phone number already exists")
+				return contacts.ErrDuplicate
 			},
 			expectedStatusCode: http.StatusBadRequest,
 			expectedResponse:   "contact with the same full name and phone number already exists",
@@ -167,7 +228,7 @@ func TestPutContact(t *testing.T) {
 				return errors.New("database error")
 			},
 			expectedStatusCode: http.StatusInternalServerError,
-			expectedResponse:   "Failed to insert contact to db with error database error",
+			expectedResponse:   "internal server error",
 		},
 	}
 
@@ -314,10 +375,10 @@ func TestUpdateContact(t *testing.T) {
 				"address": "123 Main St"
 			}`,
 			mockUpdateContactFn: func(id int, contact contacts.Contact) error {
-				return errors.New("another contact with the same first name, last name, and phone number already exists")
+				return fmt.Errorf("another contact with the same first name, last name, and phone number already exists: %w", contacts.ErrDuplicate)
 			},
 			expectedStatusCode: http.StatusBadRequest,
-			expectedResponse:   "Duplicate contact with the same first name, last name, and phone number already exists",
+			expectedResponse:   "another contact with the same first name, last name, and phone number already exists",
 		},
 		{
 			name: "Internal Server Error",
@@ -332,7 +393,7 @@ func TestUpdateContact(t *testing.T) {
 				return errors.New("database error")
 			},
 			expectedStatusCode: http.StatusInternalServerError,
-			expectedResponse:   "Failed to update contact due to an internal server error",
+			expectedResponse:   "internal server error",
 		},
 	}
 
@@ -399,10 +460,10 @@ func TestDeleteContact(t *testing.T) {
 			name: "Contact Not Found",
 			url:  "/deleteContact/999",
 			mockDeleteContactFn: func(id int) error {
-				return errors.New("no contact found with the given ID")
+				return contacts.ErrNotFound
 			},
 			expectedStatusCode: http.StatusNotFound,
-			expectedResponse:   "no contact found with the given ID",
+			expectedResponse:   "contact not found",
 		},
 		{
 			name: "Internal Server Error",
@@ -411,7 +472,7 @@ func TestDeleteContact(t *testing.T) {
 				return errors.New("database error")
 			},
 			expectedStatusCode: http.StatusInternalServerError,
-			expectedResponse:   "failed to delete contact",
+			expectedResponse:   "internal server error",
 		},
 	}
 
@@ -449,9 +510,176 @@ func TestDeleteContact(t *testing.T) {
 	}
 }
 
+func TestMergeContacts(t *testing.T) {
+	tests := []struct {
+		name                string
+		requestBody         string
+		mockMergeContactsFn func(request contacts.MergeRequest) (*contacts.Contact, error)
+		expectedStatusCode  int
+		expectedResponse    string
+	}{
+		{
+			name:        "Valid Merge",
+			requestBody: `{"ids": [1, 2], "rules": {"address": "longest"}}`,
+			mockMergeContactsFn: func(request contacts.MergeRequest) (*contacts.Contact, error) {
+				return &contacts.Contact{ID: 1, FirstName: "John", Phone: "+1234567890"}, nil
+			},
+			expectedStatusCode: http.StatusOK,
+			expectedResponse:   `"id":1`,
+		},
+		{
+			name:               "Invalid JSON Request",
+			requestBody:        `{"ids": [1, 2]`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   "Invalid request body",
+		},
+		{
+			name:               "Single ID",
+			requestBody:        `{"ids": [1]}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   "Between 2 and 20 contact IDs are needed to merge",
+		},
+		{
+			name:               "Repeated ID",
+			requestBody:        `{"ids": [1, 1]}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   "Contact ID 1 appears more than once",
+		},
+		{
+			name:               "Invalid Rule",
+			requestBody:        `{"ids": [1, 2], "rules": {"phone": "random"}}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   "Invalid merge rule phone=random",
+		},
+		{
+			name:        "Contact Not Found",
+			requestBody: `{"ids": [1, 999]}`,
+			mockMergeContactsFn: func(request contacts.MergeRequest) (*contacts.Contact, error) {
+				return nil, fmt.Errorf("one or more contacts to merge were not found: %w", contacts.ErrNotFound)
+			},
+			expectedStatusCode: http.StatusNotFound,
+			expectedResponse:   "one or more contacts to merge were not found",
+		},
+		{
+			name:        "Internal Server Error",
+			requestBody: `{"ids": [1, 2]}`,
+			mockMergeContactsFn: func(request contacts.MergeRequest) (*contacts.Contact, error) {
+				return nil, errors.New("database error")
+			},
+			expectedStatusCode: http.StatusInternalServerError,
+			expectedResponse:   "internal server error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &MockContactRepository{
+				mergeContactsFn: tt.mockMergeContactsFn,
+			}
+
+			req := httptest.NewRequest("POST", "/mergeContacts", bytes.NewBuffer([]byte(tt.requestBody)))
+			rr := httptest.NewRecorder()
+
+			contacts.MergeContacts(rr, req, mockRepo)
+
+			assert.Equal(t, tt.expectedStatusCode, rr.Code)
+			if tt.expectedResponse != "" {
+				assert.Contains(t, rr.Body.String(), tt.expectedResponse)
+			}
+		})
+	}
+}
+
+func TestPutAddressBook(t *testing.T) {
+	tests := []struct {
+		name               string
+		requestBody        string
+		mockCreateBookFn   func(name string) (*contacts.AddressBook, error)
+		expectedStatusCode int
+		expectedResponse   string
+	}{
+		{
+			name:        "Valid Request",
+			requestBody: `{"name": "Work"}`,
+			mockCreateBookFn: func(name string) (*contacts.AddressBook, error) {
+				return &contacts.AddressBook{ID: 2, TenantID: "Support", Name: name}, nil
+			},
+			expectedStatusCode: http.StatusOK,
+			expectedResponse:   `"name":"Work"`,
+		},
+		{
+			name:               "Missing Name",
+			requestBody:        `{}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   "Invalid request body, name must be defined and at most 100 characters",
+		},
+		{
+			name:               "Invalid JSON Request",
+			requestBody:        `{"name": "Work"`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   "Invalid request body, name must be defined and at most 100 characters",
+		},
+		{
+			name:        "Duplicate Address Book",
+			requestBody: `{"name": "Work"}`,
+			mockCreateBookFn: func(name string) (*contacts.AddressBook, error) {
+				return nil, contacts.ErrAddressBookExists
+			},
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   "address book with the same name already exists",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &MockContactRepository{
+				createBookFn: tt.mockCreateBookFn,
+			}
+
+			req := httptest.NewRequest("PUT", "/addAddressBook", bytes.NewBuffer([]byte(tt.requestBody)))
+			rr := httptest.NewRecorder()
+
+			contacts.PutAddressBook(rr, req, mockRepo)
+
+			assert.Equal(t, tt.expectedStatusCode, rr.Code)
+			if tt.expectedResponse != "" {
+				assert.Contains(t, rr.Body.String(), tt.expectedResponse)
+			}
+		})
+	}
+}
+
 // faultyReader simulates a read error
 type faultyReader struct{}
 
 func (fr *faultyReader) Read(p []byte) (int, error) {
 	return 0, errors.New("simulated read error")
 }
+
+// Value of a sample in the scraped metrics, 0 when it isn't there yet
+func sampleValue(t *testing.T, sample string) float64 {
+	t.Helper()
+	var b strings.Builder
+	metrics.Default.Write(context.Background(), &b)
+	for _, line := range strings.Split(b.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, sample+" "); ok {
+			v, err := strconv.ParseFloat(value, 64)
+			assert.NoError(t, err)
+			return v
+		}
+	}
+	return 0
+}
+
+func TestPutContactsMetrics(t *testing.T) {
+	succeeded := sampleValue(t, `phonebook_contacts_batch_total{outcome="success"}`)
+	failed := sampleValue(t, `phonebook_contacts_batch_total{outcome="failure"}`)
+
+	body := `[{"first_name": "John", "phone": "+1234567890"}, {"first_name": "Jane"}, {"first_name": "Jim", "phone": "+1234567891"}]`
+	rr := httptest.NewRecorder()
+	contacts.PutContacts(rr, httptest.NewRequest("PUT", "/addContacts", strings.NewReader(body)), &MockContactRepository{})
+	assert.Equal(t, http.StatusPartialContent, rr.Code)
+
+	assert.Equal(t, succeeded+2, sampleValue(t, `phonebook_contacts_batch_total{outcome="success"}`))
+	assert.Equal(t, failed+1, sampleValue(t, `phonebook_contacts_batch_total{outcome="failure"}`))
+}
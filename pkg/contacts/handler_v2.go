@@ -0,0 +1,253 @@
+// handle HTTP requests for the resource oriented /v2/contacts API, which only ever responds with JSON or problem+json
+package contacts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"golangphonebook/internal"
+	"golangphonebook/pkg/problem"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, value interface{}) {
+	response, err := marshalJSON(r.Context(), value)
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to serialize response: %v", err))
+		problem.Error(w, r, "Failed to serialize response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(response)
+}
+
+// Encode a response in a span of its own, large pages of contacts take a while
+func marshalJSON(ctx context.Context, value any) ([]byte, error) {
+	_, span := tracer.Start(ctx, "json.Marshal")
+	defer span.End()
+	return json.Marshal(value)
+}
+
+// ID from the URL path /v2/contacts/{id}
+func pathID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id < 1 {
+		return 0, newError(ErrInvalidRequest, "invalid ID %q, IDs can only be positive integers", mux.Vars(r)["id"])
+	}
+	return id, nil
+}
+
+// Same parameters, caching and response as the v1 getContacts
+func ListContactsV2(w http.ResponseWriter, r *http.Request, repo ContactRepository) {
+	defer internal.Timer("ListContactsV2")()
+
+	paginatedContacts, err := listContacts(r, repo)
+	if err != nil {
+		writeFailure(w, r, err.Error(), err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, paginatedContacts)
+}
+
+func GetContactV2(w http.ResponseWriter, r *http.Request, repo ContactRepository) {
+	defer internal.Timer("GetContactV2")()
+
+	id, err := pathID(r)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	contact, err := repo.GetContact(r.Context(), id)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, contact)
+}
+
+func CreateContactV2(w http.ResponseWriter, r *http.Request, repo ContactRepository) {
+	defer internal.Timer("CreateContactV2")()
+
+	contact, err := decodeBodyToContact(r)
+	if err != nil {
+		problem.Write(w, r, fmt.Errorf("invalid request body, first name and phone must be correctly defined: %w", err))
+		return
+	}
+	// IDs are assigned by the database
+	contact.ID = 0
+
+	created, err := repo.AddContact(r.Context(), *contact)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	InvalidateCache(r.Context())
+
+	w.Header().Set("Location", fmt.Sprintf("/v2/contacts/%d", created.ID))
+	writeJSON(w, r, http.StatusCreated, created)
+}
+
+// Replace the whole contact, fields missing from the body are cleared
+func ReplaceContactV2(w http.ResponseWriter, r *http.Request, repo ContactRepository) {
+	defer internal.Timer("ReplaceContactV2")()
+
+	id, err := pathID(r)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	contact, err := decodeBodyToContact(r)
+	if err != nil {
+		problem.Write(w, r, fmt.Errorf("invalid request body, first name and phone must be correctly defined: %w", err))
+		return
+	}
+
+	replaced, err := repo.ReplaceContact(r.Context(), id, *contact)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	InvalidateCache(r.Context())
+
+	writeJSON(w, r, http.StatusOK, replaced)
+}
+
+// Fields a patch can change, read-only fields like id and last_modified are ignored
+var patchableFields = map[string]bool{"first_name": true, "last_name": true, "phone": true, "address": true, "address_book_id": true}
+
+// Apply a JSON merge patch (RFC 7396) to a contact, null clears a field
+func applyPatch(contact *Contact, body []byte) error {
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal(body, &patch); err != nil {
+		return newError(ErrInvalidRequest, "body must be a JSON object: %v", err)
+	}
+
+	for field, value := range patch {
+		if field == "id" || field == "last_modified" {
+			continue
+		}
+		if !patchableFields[field] {
+			return newError(ErrInvalidRequest, "unknown field %s", field)
+		}
+
+		if field == "address_book_id" {
+			var bookID uint
+			if string(value) != "null" {
+				if err := json.Unmarshal(value, &bookID); err != nil {
+					return newError(ErrInvalidRequest, "address_book_id must be a positive integer")
+				}
+			}
+			// Null leaves the contact in its current address book
+			if bookID != 0 {
+				contact.AddressBookID = bookID
+			}
+			continue
+		}
+
+		var text *string
+		if err := json.Unmarshal(value, &text); err != nil {
+			return newError(ErrInvalidRequest, "%s must be a string or null", field)
+		}
+		if text == nil {
+			text = new(string)
+		}
+		setContactField(contact, field, *text)
+	}
+	return nil
+}
+
+// Change only the fields in the body
+func PatchContactV2(w http.ResponseWriter, r *http.Request, repo ContactRepository) {
+	defer internal.Timer("PatchContactV2")()
+
+	id, err := pathID(r)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		problem.Write(w, r, newError(ErrInvalidRequest, "invalid request body: %v", err))
+		return
+	}
+
+	contact, err := repo.GetContact(r.Context(), id)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	// The patched contact has to be as valid as a new one
+	if err := applyPatch(contact, body); err != nil {
+		problem.Write(w, r, fmt.Errorf("invalid patch: %w", err))
+		return
+	}
+	if err := validateStruct(contact); err != nil {
+		problem.Write(w, r, fmt.Errorf("invalid patch: %w", err))
+		return
+	}
+
+	patched, err := repo.ReplaceContact(r.Context(), id, *contact)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	InvalidateCache(r.Context())
+
+	writeJSON(w, r, http.StatusOK, patched)
+}
+
+func DeleteContactV2(w http.ResponseWriter, r *http.Request, repo ContactRepository) {
+	defer internal.Timer("DeleteContactV2")()
+
+	id, err := pathID(r)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	if err := repo.DeleteContact(r.Context(), id); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	InvalidateCache(r.Context())
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Changes since the token parameter, limit of them at a time
+func SyncContacts(w http.ResponseWriter, r *http.Request, repo ContactRepository) {
+	defer internal.Timer("SyncContacts")()
+
+	params := r.URL.Query()
+	position, err := ParseSyncToken(params.Get("token"))
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	limit := DefaultSyncLimit
+	if limitStr := params.Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > MaxSyncLimit {
+			problem.Write(w, r, newError(ErrInvalidRequest, "invalid limit %q, must be between 1 and %d", limitStr, MaxSyncLimit))
+			return
+		}
+	}
+
+	result, err := repo.ChangesSince(r.Context(), position, limit)
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to sync contacts: %v", err))
+		writeFailure(w, r, "Failed to sync contacts", err)
+		return
+	}
+	internal.Logger.InfoContext(r.Context(), fmt.Sprintf("Synced %d changed and %d deleted contacts", len(result.Contacts), len(result.Deleted)))
+	writeJSON(w, r, http.StatusOK, result)
+}
@@ -0,0 +1,190 @@
+package contacts_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"golangphonebook/pkg/contacts"
+	"golangphonebook/pkg/problem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func v2Router(repo contacts.ContactRepository) *mux.Router {
+	router := mux.NewRouter()
+	handle := func(handler func(http.ResponseWriter, *http.Request, contacts.ContactRepository)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) { handler(w, r, repo) }
+	}
+	router.HandleFunc("/v2/contacts", handle(contacts.CreateContactV2)).Methods("POST")
+	router.HandleFunc("/v2/contacts/{id}", handle(contacts.GetContactV2)).Methods("GET")
+	router.HandleFunc("/v2/contacts/{id}", handle(contacts.ReplaceContactV2)).Methods("PUT")
+	router.HandleFunc("/v2/contacts/{id}", handle(contacts.PatchContactV2)).Methods("PATCH")
+	router.HandleFunc("/v2/contacts/{id}", handle(contacts.DeleteContactV2)).Methods("DELETE")
+	return router
+}
+
+func serveV2(repo contacts.ContactRepository, method, path, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	v2Router(repo).ServeHTTP(rr, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+	return rr
+}
+
+func TestCreateContactV2(t *testing.T) {
+	t.Run("Created", func(t *testing.T) {
+		rr := serveV2(&MockContactRepository{}, "POST", "/v2/contacts", `{"first_name": "John", "phone": "+1234567890"}`)
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		assert.Equal(t, "/v2/contacts/1", rr.Header().Get("Location"))
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+		var created contacts.Contact
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+		assert.Equal(t, uint(1), created.ID)
+		assert.Equal(t, "John", created.FirstName)
+	})
+
+	t.Run("Invalid Body", func(t *testing.T) {
+		rr := serveV2(&MockContactRepository{}, "POST", "/v2/contacts", `{"first_name": "John"}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+		assert.Contains(t, rr.Body.String(), `"field":"phone"`)
+	})
+
+	t.Run("Duplicate", func(t *testing.T) {
+		repo := &MockContactRepository{addContactFn: func(contact contacts.Contact) error {
+			return contacts.ErrDuplicate
+		}}
+		rr := serveV2(repo, "POST", "/v2/contacts", `{"first_name": "John", "phone": "+1234567890"}`)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+	})
+}
+
+func TestGetContactV2(t *testing.T) {
+	rr := serveV2(&MockContactRepository{}, "GET", "/v2/contacts/7", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"id":7`)
+
+	repo := &MockContactRepository{getContactFn: func(id int) (*contacts.Contact, error) {
+		return nil, contacts.ErrNotFound
+	}}
+	rr = serveV2(repo, "GET", "/v2/contacts/7", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.JSONEq(t, `{"type": "/problems/contact-not-found", "title": "Contact not found", "status": 404, "detail": "contact not found", "instance": "/v2/contacts/7"}`, rr.Body.String())
+
+	rr = serveV2(&MockContactRepository{}, "GET", "/v2/contacts/abc", "")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestReplaceContactV2(t *testing.T) {
+	var replacement contacts.Contact
+	repo := &MockContactRepository{replaceFn: func(id int, contact contacts.Contact) (*contacts.Contact, error) {
+		replacement = contact
+		contact.ID = uint(id)
+		return &contact, nil
+	}}
+
+	rr := serveV2(repo, "PUT", "/v2/contacts/7", `{"first_name": "Jane", "phone": "+1234567890"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	// Fields missing from the body are cleared
+	assert.Equal(t, "", replacement.LastName)
+	assert.Equal(t, "Jane", replacement.FirstName)
+}
+
+func TestPatchContactV2(t *testing.T) {
+	tests := []struct {
+		name               string
+		body               string
+		expectedStatusCode int
+		expected           contacts.Contact
+	}{
+		{"Change Phone", `{"phone": "+1999999999"}`, http.StatusOK, contacts.Contact{FirstName: "John", LastName: "Doe", Phone: "+1999999999"}},
+		{"Clear Last Name", `{"last_name": null, "address": "123 Main St"}`, http.StatusOK, contacts.Contact{FirstName: "John", Phone: "+1234567890", Address: "123 Main St"}},
+		{"Read Only Fields Ignored", `{"id": 99, "first_name": "Jon"}`, http.StatusOK, contacts.Contact{FirstName: "Jon", LastName: "Doe", Phone: "+1234567890"}},
+		{"Clear Required Field", `{"phone": null}`, http.StatusBadRequest, contacts.Contact{}},
+		{"Unknown Field", `{"nickname": "Johnny"}`, http.StatusBadRequest, contacts.Contact{}},
+		{"Not An Object", `["phone"]`, http.StatusBadRequest, contacts.Contact{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var replacement contacts.Contact
+			repo := &MockContactRepository{replaceFn: func(id int, contact contacts.Contact) (*contacts.Contact, error) {
+				replacement = contact
+				return &contact, nil
+			}}
+
+			rr := serveV2(repo, "PATCH", "/v2/contacts/7", tt.body)
+			assert.Equal(t, tt.expectedStatusCode, rr.Code)
+			if tt.expectedStatusCode == http.StatusOK {
+				tt.expected.ID = 7
+				assert.Equal(t, tt.expected, replacement)
+			}
+		})
+	}
+}
+
+func TestDeleteContactV2(t *testing.T) {
+	rr := serveV2(&MockContactRepository{}, "DELETE", "/v2/contacts/7", "")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Empty(t, rr.Body.String())
+
+	repo := &MockContactRepository{deleteContactFn: func(id int) error {
+		return contacts.ErrNotFound
+	}}
+	rr = serveV2(repo, "DELETE", "/v2/contacts/7", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestValidationProblem(t *testing.T) {
+	rr := serveV2(&MockContactRepository{}, "POST", "/v2/contacts", `{"phone": "12"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	var details problem.Details
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &details))
+	assert.Equal(t, "/problems/validation-failed", details.Type)
+	assert.Equal(t, []problem.FieldError{
+		{Field: "first_name", Rule: "required", Message: "first_name is required"},
+		{Field: "phone", Rule: "customPhone", Message: "phone must be an optional + followed by 4 to 20 digits"},
+	}, details.Errors)
+}
+
+func TestSyncContacts(t *testing.T) {
+	var got contacts.SyncPosition
+	var gotLimit int
+	repo := &MockContactRepository{changesSinceFn: func(position contacts.SyncPosition, limit int) (*contacts.SyncResult, error) {
+		got, gotLimit = position, limit
+		return &contacts.SyncResult{Contacts: []contacts.Contact{{ID: 3}}, Deleted: []contacts.Tombstone{{ContactID: 4}}, SyncToken: "next"}, nil
+	}}
+	sync := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		contacts.SyncContacts(rr, httptest.NewRequest("GET", "/v2/sync"+query, nil), repo)
+		return rr
+	}
+
+	t.Run("From The Start", func(t *testing.T) {
+		rr := sync("")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, contacts.SyncPosition{}, got)
+		assert.Equal(t, contacts.DefaultSyncLimit, gotLimit)
+		assert.JSONEq(t, `{"contacts":[{"id":3,"first_name":"","last_name":"","phone":"","address":"","last_modified":"0001-01-01T00:00:00Z","address_book_id":0}],
+			"deleted":[{"id":4,"deleted_at":"0001-01-01T00:00:00Z"}],"sync_token":"next","has_more":false,"last_modified":"0001-01-01T00:00:00Z"}`, rr.Body.String())
+	})
+
+	t.Run("From A Token", func(t *testing.T) {
+		position := contacts.SyncPosition{Seq: 12, ID: 5}
+		rr := sync("?limit=50&token=" + position.Token())
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, position, got)
+		assert.Equal(t, 50, gotLimit)
+	})
+
+	for name, query := range map[string]string{"Invalid Token": "?token=abc", "Limit Too High": "?limit=1001", "Limit Not A Number": "?limit=all"} {
+		t.Run(name, func(t *testing.T) {
+			rr := sync(query)
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+			assert.Equal(t, problem.ContentType, rr.Header().Get("Content-Type"))
+		})
+	}
+}
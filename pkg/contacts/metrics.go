@@ -0,0 +1,63 @@
+// Metrics of the contact cache, batches and database queries
+package contacts
+
+import (
+	"context"
+	"fmt"
+	"golangphonebook/internal"
+	"golangphonebook/pkg/metrics"
+	"time"
+)
+
+var (
+	cacheRequests = metrics.NewCounter("phonebook_contacts_cache_requests_total",
+		"Contact listings served from the page cache (hit) or the database (miss)", "result")
+	batchContacts = metrics.NewCounter("phonebook_contacts_batch_total",
+		"Contacts in batch adds, by whether they were added", "outcome")
+	queryDuration = metrics.NewHistogram("phonebook_db_query_duration_seconds",
+		"Time taken by contact repository methods, every query they make included", metrics.DefaultBuckets, "method")
+	contactCount = metrics.NewGauge("phonebook_contacts",
+		"Contacts stored, by tenant", "tenant")
+)
+
+// Count the outcome of a batch add, for every API that adds contacts in batches
+func RecordBatch(succeeded, failed int) {
+	batchContacts.Add(float64(succeeded), "success")
+	batchContacts.Add(float64(failed), "failure")
+}
+
+// Time a repository method, use as defer observeQuery("GetContact")()
+func observeQuery(method string) func() {
+	start := time.Now()
+	return func() {
+		queryDuration.Observe(time.Since(start).Seconds(), method)
+	}
+}
+
+// Refresh the contact count of every tenant before each scrape, register with metrics.Default.BeforeScrape
+func CountContacts(repo *SQLContactRepository) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		var tenants []string
+		err := repo.DB.WithContext(ctx).Model(&AddressBook{}).Distinct("tenant_id").Pluck("tenant_id", &tenants).Error
+		if err != nil {
+			internal.Logger.WarnContext(ctx, fmt.Sprintf("Failed to list tenants for the contact count, keeping the last count: %v", err))
+			return
+		}
+
+		counts := make(map[string]int64, len(tenants))
+		for _, tenant := range tenants {
+			count, err := repo.ForTenant(tenant).GetContactCount(ctx)
+			if err != nil {
+				internal.Logger.WarnContext(ctx, fmt.Sprintf("Failed to count contacts, keeping the last count: %v", err))
+				return
+			}
+			counts[tenant] = count
+		}
+
+		// Tenants without address books anymore drop out
+		contactCount.Reset()
+		for tenant, count := range counts {
+			contactCount.Set(float64(count), tenant)
+		}
+	}
+}
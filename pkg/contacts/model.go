@@ -2,9 +2,12 @@
 package contacts
 
 import (
+	"context"
 	"fmt"
 	"golangphonebook/internal"
+	"reflect"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/go-playground/validator/v10"
@@ -12,15 +15,37 @@ import (
 )
 
 type Contact struct {
-	ID           uint      `json:"id" gorm:"primaryKey;autoIncrement;index:idx_first_last,priority:3;index:idx_last_first,priority:3"` // Auto-incrementing primary key
-	FirstName    string    `json:"first_name" validate:"required" gorm:"size:50;not null;index:idx_first_last,priority:1"`             // Index on FirstName with LastName and ID
-	LastName     string    `json:"last_name" gorm:"size:50;index:idx_first_last,priority:2;index:idx_last_first,priority:1"`           // Index on LastName with FirstName and ID
-	Phone        string    `json:"phone" validate:"required,customPhone" gorm:"size:20"`                                               // Phone field with validation and size constraint
-	Address      string    `json:"address" gorm:"size:100;type:text"`                                                                  // Address field, stored as text in the database
-	LastModified time.Time `json:"last_modified" gorm:"autoUpdateTime;index"`                                                          // Automatically updated on save
+	ID             uint      `json:"id" gorm:"primaryKey;autoIncrement;index:idx_first_last,priority:3;index:idx_last_first,priority:3"`           // Auto-incrementing primary key
+	FirstName      string    `json:"first_name" validate:"required,max=50" gorm:"type:text;not null;index:idx_first_last,priority:1"`              // Index on FirstName with LastName and ID
+	LastName       string    `json:"last_name" validate:"max=50" gorm:"type:text;index:idx_first_last,priority:2;index:idx_last_first,priority:1"` // Index on LastName with FirstName and ID
+	Phone          string    `json:"phone" validate:"required,customPhone" gorm:"type:text"`                                                       // Phone field with validation, encrypted at rest when field encryption is enabled
+	Address        string    `json:"address" gorm:"type:text"`                                                                                     // Address field, stored as text in the database and encrypted at rest when field encryption is enabled
+	LastModified   time.Time `json:"last_modified" gorm:"autoUpdateTime;index"`                                                                    // Automatically updated on save
+	AddressBookID  uint      `json:"address_book_id" gorm:"not null;default:0;index"`                                                              // Address book the contact belongs to, defaults to the tenant's default book
+	ChangeSeq      int64     `json:"-" gorm:"not null;default:0;index"`                                                                            // Change sequence number of the latest change, for sync
+	FirstNameIndex string    `json:"-" gorm:"size:64;index"`                                                                                       // Blind indexes for exact matches on encrypted fields
+	LastNameIndex  string    `json:"-" gorm:"size:64;index"`
+	PhoneIndex     string    `json:"-" gorm:"size:64;index"`
+	AddressIndex   string    `json:"-" gorm:"size:64;index"`
+}
 
+// Address books belong to a tenant, which is derived from the client certificate
+type AddressBook struct {
+	ID        uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	TenantID  string    `json:"tenant_id" gorm:"size:100;not null;uniqueIndex:idx_tenant_book_name,priority:1"`
+	Name      string    `json:"name" validate:"required,max=100" gorm:"size:100;not null;uniqueIndex:idx_tenant_book_name,priority:2"`
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
 }
 
+// Contacts per page of a listing, and most contacts one request can add, delete or merge. Set from the config at startup.
+var (
+	PageSize     = 10
+	MaxBatchSize = 20
+)
+
+// Name of the address book every tenant gets on first use
+const DefaultAddressBookName = "default"
+
 // Sort enum
 type SortBy string
 
@@ -48,6 +73,23 @@ type PaginatedContacts struct {
 	TotalCount  int64     `json:"total_count"`  // Total contacts that match the filter criteria
 }
 
+// Outcome of adding several contacts at once
+type BatchResult struct {
+	SuccessfulContacts int      `json:"successful_contacts"`
+	FailedContacts     []string `json:"failed_contacts"` // Request body of every contact that wasn't added
+	Errors             []string `json:"errors"`          // Why, aligned with FailedContacts
+}
+
+type DuplicateClusters struct {
+	Threshold float64     `json:"threshold"`
+	Clusters  [][]Contact `json:"clusters"`
+}
+
+type RotationResult struct {
+	CurrentKey string `json:"current_key"`
+	Rotated    int64  `json:"rotated"` // Rows encrypted again
+}
+
 func (c Contact) String() string {
 	if c.LastModified.IsZero() && c.ID == 0 {
 		return fmt.Sprintf("Contact(FirstName=%s, LastName=%s, Phone=%s, Address=%s)",
@@ -58,23 +100,92 @@ func (c Contact) String() string {
 	}
 }
 
+// Check the contact has the fields every stored contact needs, errors match ErrValidation
+func (c Contact) Validate() error {
+	return validateStruct(c)
+}
+
+// Check the address book has a valid name, errors match ErrValidation
+func (b AddressBook) Validate() error {
+	return validateStruct(b)
+}
+
+// Contact with its personal data redacted according to the logging policy, use this rather than String() in logs
+func (c Contact) Redacted() string {
+	fields := internal.Logger.RedactFields(map[string]string{
+		"first_name": c.FirstName,
+		"last_name":  c.LastName,
+		"phone":      c.Phone,
+		"address":    c.Address,
+	})
+	if c.ID == 0 {
+		return fmt.Sprintf("Contact%s", fields)
+	}
+	return fmt.Sprintf("Contact(ID=%d)%s", c.ID, fields)
+}
+
 // DB interaction interface
 type ContactRepository interface {
-	AddContact(contact Contact) error
-	FilterContacts(filters map[string]string) (*gorm.DB, int64, error)
-	SearchContacts(query *gorm.DB, page int, sortBy SortBy, ascending bool, initialFetch bool) ([]Contact, error)
-	UpdateContact(id int, contact Contact) error
-	DeleteContact(id int) error
-	GetContactCount() (int64, error)
+	AddContact(ctx context.Context, contact Contact) (*Contact, error)
+	GetContact(ctx context.Context, id int) (*Contact, error)
+	FilterContacts(ctx context.Context, filters map[string]string) (*gorm.DB, int64, error)
+	SearchContacts(ctx context.Context, query *gorm.DB, page int, sortBy SortBy, ascending bool, initialFetch bool) ([]Contact, error)
+	UpdateContact(ctx context.Context, id int, contact Contact) error
+	ReplaceContact(ctx context.Context, id int, contact Contact) (*Contact, error)
+	DeleteContact(ctx context.Context, id int) error
+	GetContactCount(ctx context.Context) (int64, error)
+	FindDuplicates(ctx context.Context, threshold float64) ([][]Contact, error)
+	MergeContacts(ctx context.Context, request MergeRequest) (*Contact, error)
+	GetMergeHistory(ctx context.Context, id int) ([]MergeRecord, error)
+	ListAddressBooks(ctx context.Context) ([]AddressBook, error)
+	CreateAddressBook(ctx context.Context, name string) (*AddressBook, error)
+	ChangesSince(ctx context.Context, position SyncPosition, limit int) (*SyncResult, error)
+}
+
+// Receives every value a repository call changed, so it can be audited. Before is nil for creations, after is nil for deletions.
+type ChangeRecorder interface {
+	RecordChange(id uint, before, after any)
+}
+
+// Kinds of change to a contact
+type ChangeType string
+
+const (
+	ContactCreated ChangeType = "contact.created"
+	ContactUpdated ChangeType = "contact.updated"
+	ContactDeleted ChangeType = "contact.deleted"
+)
+
+// Told about every committed change to a contact of a tenant, for change feeds.
+// Contact is the contact after the change, or before it for deletions, and nil when it was erased.
+type ChangePublisher interface {
+	Publish(tenant string, change ChangeType, id uint, contact *Contact)
 }
 
+// Stores what has to be sent about a change to a contact, in the transaction tx that makes the change.
+// Contact is nil for erased contacts, like for ChangePublisher.
+type ChangeOutbox interface {
+	Enqueue(tx *gorm.DB, tenant string, change ChangeType, id uint, contact *Contact) error
+}
+
+// Phone numbers checked by the customPhone rule, an optional + followed by 4 to 20 digits
+const PhonePattern = "^\\+?[0-9]{4,20}$"
+
 // Structure validator
 var validate *validator.Validate
 
 func init() {
 	validate = validator.New(validator.WithRequiredStructEnabled())
+	// Refer to fields by their json names, which is also how the log redaction policy knows them
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			return field.Name
+		}
+		return name
+	})
 
-	validate.RegisterValidation("customPhone", regexValidator("^\\+?[0-9]{4,20}$"))
+	validate.RegisterValidation("customPhone", regexValidator(PhonePattern))
 
 }
 
@@ -91,7 +202,7 @@ func regexValidator(pattern string) validator.Func {
 
 		// Validate the field value against the regex pattern
 		matches := re.MatchString(fl.Field().String())
-		internal.Logger.Info(fmt.Sprintf("Validating field '%s' with value '%s': %v", fl.FieldName(), fl.Field().String(), matches))
+		internal.Logger.Info(fmt.Sprintf("Validating field '%s' with value '%s': %v", fl.FieldName(), internal.Logger.Redact(fl.FieldName(), fl.Field().String()), matches))
 		return matches
 	}
 }
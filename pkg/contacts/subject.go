@@ -0,0 +1,122 @@
+// Find and erase everything held on one person, for subject access and erasure requests
+package contacts
+
+import (
+	"context"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// The person a request is about. Every field that is set has to match.
+type Subject struct {
+	Phone     string `json:"phone"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+func (s Subject) Valid() bool {
+	return NormalizePhone(s.Phone) != "" || strings.TrimSpace(s.FirstName) != "" || strings.TrimSpace(s.LastName) != ""
+}
+
+// Phones are compared on their digits like duplicates are, names case-insensitively
+func (s Subject) Matches(c Contact) bool {
+	if s.Phone != "" && !phonesMatch(s.Phone, c.Phone) {
+		return false
+	}
+	if s.FirstName != "" && !strings.EqualFold(strings.TrimSpace(s.FirstName), strings.TrimSpace(c.FirstName)) {
+		return false
+	}
+	if s.LastName != "" && !strings.EqualFold(strings.TrimSpace(s.LastName), strings.TrimSpace(c.LastName)) {
+		return false
+	}
+	return s.Valid()
+}
+
+// Loose search terms for data stored as text, like the audit log. The last digits of the phone catch
+// different formats of the same number, so results still have to be checked with Matches.
+func (s Subject) SearchTerms() []string {
+	var terms []string
+	if phone := phoneBucket(s.Phone); phone != "" {
+		terms = append(terms, phone)
+	}
+	for _, name := range []string{s.FirstName, s.LastName} {
+		if name = strings.TrimSpace(name); name != "" {
+			terms = append(terms, name)
+		}
+	}
+	return terms
+}
+
+// Contacts matching the subject, and the merge history snapshots that either match the subject
+// or belong to one of those contacts
+func (repo *SQLContactRepository) FindSubject(ctx context.Context, subject Subject) ([]Contact, []MergeRecord, error) {
+	repo, done := repo.begin(ctx, "FindSubject")
+	defer done()
+
+	// Encrypted fields can't be searched in the database, and phones are stored in different formats anyway
+	var matches []Contact
+	var batch []Contact
+	err := repo.scoped(repo.DB).Order("id").FindInBatches(&batch, rotationBatchSize, func(tx *gorm.DB, _ int) error {
+		for _, c := range batch {
+			if subject.Matches(c) {
+				matches = append(matches, c)
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	matchedIDs := make(map[uint]bool)
+	for _, c := range matches {
+		matchedIDs[c.ID] = true
+	}
+
+	var history []MergeRecord
+	err = repo.DB.Where("survivor_id IN (?)", repo.scoped(repo.DB.Model(&Contact{})).Select("id")).
+		Order("merged_at, id").Find(&history).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var revisions []MergeRecord
+	for _, record := range history {
+		snapshot := Contact{FirstName: record.FirstName, LastName: record.LastName, Phone: record.Phone, Address: record.Address}
+		if matchedIDs[record.SurvivorID] || subject.Matches(snapshot) {
+			revisions = append(revisions, record)
+		}
+	}
+	return matches, revisions, nil
+}
+
+// Permanently delete contacts and merge history snapshots, along with any other snapshots of those contacts
+func (repo *SQLContactRepository) EraseSubjectData(ctx context.Context, contactIDs []uint, revisionIDs []uint) (int64, int64, error) {
+	repo, done := repo.begin(ctx, "EraseSubjectData")
+	defer done()
+
+	var contactsErased, revisionsErased int64
+	err := repo.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id IN ? OR survivor_id IN ?", revisionIDs, contactIDs).Delete(&MergeRecord{})
+		if result.Error != nil {
+			return result.Error
+		}
+		revisionsErased = result.RowsAffected
+
+		if len(contactIDs) > 0 {
+			result := repo.scoped(tx).Where("id IN ?", contactIDs).Delete(&Contact{})
+			if result.Error != nil {
+				return result.Error
+			}
+			contactsErased = result.RowsAffected
+		}
+		return bury(tx, repo.Tenant, contactIDs)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	pageCaches.invalidate(repo.Tenant)
+	return contactsErased, revisionsErased, nil
+}
@@ -0,0 +1,38 @@
+package contacts_test
+
+import (
+	"golangphonebook/pkg/contacts"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubjectMatches(t *testing.T) {
+	john := contacts.Contact{FirstName: "John", LastName: "Doe", Phone: "+15551234567"}
+	tests := []struct {
+		name     string
+		subject  contacts.Subject
+		expected bool
+	}{
+		{"Phone", contacts.Subject{Phone: "+15551234567"}, true},
+		{"Phone Without Country Code", contacts.Subject{Phone: "555-123-4567"}, true},
+		{"Other Phone", contacts.Subject{Phone: "+15559999999"}, false},
+		{"Name Ignores Case", contacts.Subject{FirstName: "john", LastName: "DOE"}, true},
+		{"First Name Only", contacts.Subject{FirstName: "John"}, true},
+		{"Name And Other Phone", contacts.Subject{FirstName: "John", Phone: "+15559999999"}, false},
+		{"Partial Name", contacts.Subject{FirstName: "Jo"}, false},
+		{"Empty Subject", contacts.Subject{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.subject.Matches(john))
+		})
+	}
+}
+
+func TestSubjectSearchTerms(t *testing.T) {
+	subject := contacts.Subject{Phone: "+1 (555) 123-4567", FirstName: " John "}
+	assert.Equal(t, []string{"1234567", "John"}, subject.SearchTerms())
+	assert.Empty(t, contacts.Subject{}.SearchTerms())
+}
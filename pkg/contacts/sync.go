@@ -0,0 +1,145 @@
+// Incremental sync, clients get what changed since their last sync instead of every contact
+package contacts
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Key for the Postgres advisory lock that makes change sequence numbers commit in order
+const changeLockKey = 7_290_030
+
+// Contacts and tombstones a sync returns by default and at most
+const (
+	DefaultSyncLimit = 100
+	MaxSyncLimit     = 1000
+)
+
+// Record of a deleted contact, so clients that synced it learn it is gone. Holds nothing about the contact but its ID.
+type Tombstone struct {
+	ContactID uint      `json:"id" gorm:"primaryKey;autoIncrement:false"`
+	TenantID  string    `json:"-" gorm:"size:100;not null;index"`
+	ChangeSeq int64     `json:"-" gorm:"not null;index"`
+	RemovedAt time.Time `json:"deleted_at" gorm:"not null"`
+}
+
+// Where a client is in the tenant's changes. Changes are ordered by change sequence and then ID, since
+// several contacts can change in one transaction and timestamps of different transactions can collide.
+type SyncPosition struct {
+	Seq      int64     `json:"s"`
+	ID       uint      `json:"i"`
+	Modified time.Time `json:"m"` // Newest LastModified the client has seen
+}
+
+type SyncResult struct {
+	Contacts     []Contact   `json:"contacts"` // Created or changed since the token, in the order they changed
+	Deleted      []Tombstone `json:"deleted"`
+	SyncToken    string      `json:"sync_token"`    // Pass as token next time to get what changed after this
+	HasMore      bool        `json:"has_more"`      // More changes are waiting, sync again with the new token straight away
+	LastModified time.Time   `json:"last_modified"` // Newest LastModified of the contacts synced so far
+}
+
+// Next number of the change sequence. Holds a lock until the transaction ends, so the numbers commit in order
+// and a client can never skip a change that commits after it synced.
+func nextChange(tx *gorm.DB) (int64, error) {
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", changeLockKey).Error; err != nil {
+		return 0, err
+	}
+	var seq int64
+	err := tx.Raw("SELECT nextval('contact_change_seq')").Scan(&seq).Error
+	return seq, err
+}
+
+// Leave tombstones for deleted contacts of the tenant, in the transaction that deletes them
+func bury(tx *gorm.DB, tenant string, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	seq, err := nextChange(tx)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	tombstones := make([]Tombstone, len(ids))
+	for i, id := range ids {
+		tombstones[i] = Tombstone{ContactID: id, TenantID: tenant, ChangeSeq: seq, RemovedAt: now}
+	}
+	return tx.Create(&tombstones).Error
+}
+
+func (p SyncPosition) Token() string {
+	data, _ := json.Marshal(p)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Position of a token from an earlier sync, the start for an empty token
+func ParseSyncToken(token string) (SyncPosition, error) {
+	var p SyncPosition
+	if token == "" {
+		return p, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		err = json.Unmarshal(data, &p)
+	}
+	if err != nil || p.Seq < 0 {
+		return p, newError(ErrInvalidRequest, "invalid sync token, use the sync_token of an earlier sync or none to start over")
+	}
+	return p, nil
+}
+
+func (p SyncPosition) before(seq int64, id uint) bool {
+	return p.Seq < seq || (p.Seq == seq && p.ID < id)
+}
+
+// Contacts and tombstones of the tenant that changed after the position, at most limit of them together
+func (repo *SQLContactRepository) ChangesSince(ctx context.Context, position SyncPosition, limit int) (*SyncResult, error) {
+	repo, done := repo.begin(ctx, "ChangesSince")
+	defer done()
+
+	var changed []Contact
+	err := repo.scoped(repo.DB).Where("(change_seq > ? OR (change_seq = ? AND id > ?))", position.Seq, position.Seq, position.ID).
+		Order("change_seq, id").Limit(limit + 1).Find(&changed).Error
+	if err != nil {
+		return nil, err
+	}
+	var deleted []Tombstone
+	err = repo.DB.Where("tenant_id = ? AND (change_seq > ? OR (change_seq = ? AND contact_id > ?))", repo.Tenant, position.Seq, position.Seq, position.ID).
+		Order("change_seq, contact_id").Limit(limit + 1).Find(&deleted).Error
+	if err != nil {
+		return nil, err
+	}
+	return mergeChanges(position, changed, deleted, limit), nil
+}
+
+// Take the first changes of both lists in sequence order, up to the limit
+func mergeChanges(position SyncPosition, changed []Contact, deleted []Tombstone, limit int) *SyncResult {
+	result := &SyncResult{Contacts: []Contact{}, Deleted: []Tombstone{}, LastModified: position.Modified}
+	next := position
+	for len(result.Contacts)+len(result.Deleted) < limit && (len(changed) > 0 || len(deleted) > 0) {
+		takeContact := len(deleted) == 0 ||
+			(len(changed) > 0 && SyncPosition{Seq: changed[0].ChangeSeq, ID: changed[0].ID}.before(deleted[0].ChangeSeq, deleted[0].ContactID))
+		if takeContact {
+			c := changed[0]
+			changed = changed[1:]
+			result.Contacts = append(result.Contacts, c)
+			next.Seq, next.ID = c.ChangeSeq, c.ID
+			if c.LastModified.After(next.Modified) {
+				next.Modified = c.LastModified
+			}
+		} else {
+			t := deleted[0]
+			deleted = deleted[1:]
+			result.Deleted = append(result.Deleted, t)
+			next.Seq, next.ID = t.ChangeSeq, t.ContactID
+		}
+	}
+	result.HasMore = len(changed) > 0 || len(deleted) > 0
+	result.SyncToken = next.Token()
+	result.LastModified = next.Modified
+	return result
+}
@@ -0,0 +1,69 @@
+package contacts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncToken(t *testing.T) {
+	position := SyncPosition{Seq: 42, ID: 7, Modified: time.Date(2025, 10, 19, 10, 0, 0, 0, time.UTC)}
+	parsed, err := ParseSyncToken(position.Token())
+	require.NoError(t, err)
+	assert.Equal(t, position, parsed)
+
+	start, err := ParseSyncToken("")
+	require.NoError(t, err)
+	assert.Equal(t, SyncPosition{}, start)
+
+	for _, token := range []string{"not a token!", "bm90IGpzb24", SyncPosition{Seq: -1}.Token()} {
+		_, err := ParseSyncToken(token)
+		assert.ErrorIs(t, err, ErrInvalidRequest, token)
+	}
+}
+
+func TestMergeChanges(t *testing.T) {
+	older := time.Date(2025, 10, 19, 10, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Minute)
+	changed := []Contact{
+		{ID: 3, ChangeSeq: 5, LastModified: newer},
+		// Changed in the same transaction, with the same timestamp
+		{ID: 1, ChangeSeq: 8, LastModified: older},
+		{ID: 2, ChangeSeq: 8, LastModified: older},
+	}
+	deleted := []Tombstone{{ContactID: 4, ChangeSeq: 6}, {ContactID: 9, ChangeSeq: 9}}
+
+	t.Run("In Sequence Order", func(t *testing.T) {
+		result := mergeChanges(SyncPosition{}, changed, deleted, 10)
+		assert.Equal(t, []uint{3, 1, 2}, []uint{result.Contacts[0].ID, result.Contacts[1].ID, result.Contacts[2].ID})
+		assert.Len(t, result.Deleted, 2)
+		assert.False(t, result.HasMore)
+		assert.Equal(t, newer, result.LastModified)
+
+		next, err := ParseSyncToken(result.SyncToken)
+		require.NoError(t, err)
+		assert.Equal(t, SyncPosition{Seq: 9, ID: 9, Modified: newer}, next)
+	})
+
+	t.Run("Pages Between Contacts Of One Change", func(t *testing.T) {
+		result := mergeChanges(SyncPosition{}, changed, deleted, 3)
+		assert.Len(t, result.Contacts, 2)
+		assert.Equal(t, uint(4), result.Deleted[0].ContactID)
+		assert.True(t, result.HasMore)
+
+		next, err := ParseSyncToken(result.SyncToken)
+		require.NoError(t, err)
+		assert.Equal(t, SyncPosition{Seq: 8, ID: 1, Modified: newer}, next)
+	})
+
+	t.Run("Nothing New", func(t *testing.T) {
+		position := SyncPosition{Seq: 9, ID: 9, Modified: newer}
+		result := mergeChanges(position, nil, nil, 10)
+		assert.Empty(t, result.Contacts)
+		assert.Empty(t, result.Deleted)
+		assert.False(t, result.HasMore)
+		assert.Equal(t, position.Token(), result.SyncToken)
+	})
+}
@@ -0,0 +1,30 @@
+// Per-route request deadlines
+package deadline
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Cancel the request's context once the timeout is up, so the database work it started is stopped with it.
+// Without a timeout the request only ends when the client goes away.
+func Middleware(timeout time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	if timeout <= 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// Lift the server's write timeout for a route that streams until the client goes away
+func Unlimited(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Fails when the writer can't set deadlines, then there is no timeout to lift either
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		next(w, r)
+	}
+}
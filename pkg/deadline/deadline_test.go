@@ -0,0 +1,45 @@
+package deadline
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	var ctx context.Context
+	handler := func(w http.ResponseWriter, r *http.Request) { ctx = r.Context() }
+
+	Middleware(time.Minute, handler)(httptest.NewRecorder(), httptest.NewRequest("GET", "/getContacts", nil))
+	_, hasDeadline := ctx.Deadline()
+	assert.True(t, hasDeadline)
+	assert.ErrorIs(t, ctx.Err(), context.Canceled, "the context ends with the request")
+
+	Middleware(0, handler)(httptest.NewRecorder(), httptest.NewRequest("GET", "/getContacts", nil))
+	_, hasDeadline = ctx.Deadline()
+	assert.False(t, hasDeadline)
+}
+
+func TestUnlimited(t *testing.T) {
+	written := make(chan error, 1)
+	server := httptest.NewUnstartedServer(Unlimited(func(w http.ResponseWriter, r *http.Request) {
+		// Past the server's write timeout
+		time.Sleep(150 * time.Millisecond)
+		_, err := w.Write([]byte("event"))
+		written <- err
+	}))
+	server.Config.WriteTimeout = 50 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	assert.NoError(t, <-written)
+}
@@ -0,0 +1,189 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golangphonebook/internal"
+	"golangphonebook/pkg/contacts"
+	"golangphonebook/pkg/problem"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Events kept for subscribers that resume after reconnecting
+const DefaultHistory = 1000
+
+// Events a subscriber can fall behind by before it is dropped
+const subscriberBuffer = 64
+
+var (
+	// Resuming after an event that is no longer kept, the client has to reload what it needs
+	ErrExpired = errors.New("event expired")
+	// Subscribing while the server shuts down, the client reconnects to another instance
+	ErrClosed = errors.New("event bus closed")
+)
+
+func init() {
+	problem.Register(ErrExpired, http.StatusGone, "events-expired", "Events expired")
+	problem.Register(ErrClosed, http.StatusServiceUnavailable, "events-unavailable", "Events unavailable")
+}
+
+// Fans out changes to the subscribers of each tenant, and keeps the latest ones so clients can resume
+type Bus struct {
+	mu          sync.Mutex
+	lastID      uint64
+	history     []Event // Oldest first
+	size        int
+	subscribers map[*Subscription]struct{}
+	closed      bool
+	open        sync.WaitGroup // Subscriptions not closed by their subscriber yet
+}
+
+// IDs start at the current time in microseconds, so IDs from before a restart are never mistaken for new ones
+func NewBus(history int) *Bus {
+	return &Bus{
+		lastID:      uint64(time.Now().UnixMicro()),
+		size:        history,
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+type Subscription struct {
+	Events  <-chan Event // Closed when the subscription is closed or the subscriber fell behind
+	events  chan Event
+	tenant  string
+	filter  Filter
+	bus     *Bus
+	dropped bool
+	done    bool // Closed by the subscriber
+}
+
+// Publish a change once it is committed, implements contacts.ChangePublisher
+func (b *Bus) Publish(tenant string, change contacts.ChangeType, id uint, contact *contacts.Contact) {
+	e := Event{Type: change, Tenant: tenant, ContactID: id, Time: time.Now().UTC()}
+	if contact != nil {
+		// Subscribers share the event, so it must not change under them
+		c := *contact
+		e.Contact = &c
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	e.ID = b.lastID
+	if b.size > 0 {
+		if len(b.history) == b.size {
+			copy(b.history, b.history[1:])
+			b.history = b.history[:len(b.history)-1]
+		}
+		b.history = append(b.history, e)
+	}
+
+	for sub := range b.subscribers {
+		if sub.tenant != tenant || !sub.filter.Matches(e) {
+			continue
+		}
+		select {
+		case sub.events <- e:
+		default:
+			// Publishing never waits on a slow client, it can resume from its last event
+			internal.Logger.Warn(fmt.Sprintf("Dropped event subscriber of tenant %s that fell %d events behind", tenant, subscriberBuffer))
+			sub.dropped = true
+			b.remove(sub)
+		}
+	}
+}
+
+// Drop the contact data of the tenant's erased contacts from the kept events, so resuming clients aren't sent
+// it again. The events themselves stay, IDs clients resume after have to remain valid.
+func (b *Bus) Forget(tenant string, ids []uint) {
+	erased := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		erased[id] = true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, e := range b.history {
+		if e.Tenant == tenant && erased[e.ContactID] {
+			// Subscribers may still hold the event, so it is replaced rather than changed
+			b.history[i].Contact = nil
+		}
+	}
+}
+
+// Subscribe to the tenant's events that match the filter. With after set, the kept events since then are
+// returned to be sent first, ErrExpired when some of them are no longer kept.
+func (b *Bus) Subscribe(tenant string, filter Filter, after uint64) (*Subscription, []Event, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, nil, fmt.Errorf("the server is shutting down, reconnect: %w", ErrClosed)
+	}
+	var replay []Event
+	if after != 0 {
+		oldest := b.lastID + 1
+		if len(b.history) > 0 {
+			oldest = b.history[0].ID
+		}
+		if after > b.lastID || after+1 < oldest {
+			return nil, nil, fmt.Errorf("cannot resume after event %d, events are kept from %d on: %w", after, oldest, ErrExpired)
+		}
+		for _, e := range b.history {
+			if e.ID > after && e.Tenant == tenant && filter.Matches(e) {
+				replay = append(replay, e)
+			}
+		}
+	}
+
+	events := make(chan Event, subscriberBuffer)
+	sub := &Subscription{Events: events, events: events, tenant: tenant, filter: filter, bus: b}
+	b.subscribers[sub] = struct{}{}
+	b.open.Add(1)
+	return sub, replay, nil
+}
+
+func (b *Bus) remove(sub *Subscription) {
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// Stop receiving events, safe to call more than once
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.remove(s)
+	if !s.done {
+		s.done = true
+		s.bus.open.Done()
+	}
+}
+
+// End every subscription and refuse new ones, for shutting down. Streams end as if their
+// subscriber fell behind, but Dropped stays false.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subscribers {
+		b.remove(sub)
+	}
+}
+
+// Wait until every subscriber closed its subscription, or until the context is done
+func (b *Bus) Wait(ctx context.Context) error {
+	return internal.Wait(ctx, &b.open)
+}
+
+// Whether the bus closed the subscription because the subscriber fell behind
+func (s *Subscription) Dropped() bool {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	return s.dropped
+}
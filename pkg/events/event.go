@@ -0,0 +1,78 @@
+// Publish changes to contacts in process and stream them to clients
+package events
+
+import (
+	"fmt"
+	"golangphonebook/pkg/contacts"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Event struct {
+	ID        uint64              `json:"id"` // Increases with every event, resume after it with Last-Event-ID
+	Type      contacts.ChangeType `json:"type"`
+	Tenant    string              `json:"-"`
+	ContactID uint                `json:"contact_id"`
+	Contact   *contacts.Contact   `json:"contact,omitempty"` // After the change, before it for deletions, missing for erased contacts
+	Time      time.Time           `json:"time"`
+}
+
+// Events a subscriber wants, every event when empty
+type Filter struct {
+	Types         []contacts.ChangeType
+	ContactID     uint
+	AddressBookID uint // Erased contacts have no payload, so their events never match an address book
+}
+
+var changeTypes = []contacts.ChangeType{contacts.ContactCreated, contacts.ContactUpdated, contacts.ContactDeleted}
+
+func (f Filter) Matches(e Event) bool {
+	if len(f.Types) > 0 && !slices.Contains(f.Types, e.Type) {
+		return false
+	}
+	if f.ContactID != 0 && f.ContactID != e.ContactID {
+		return false
+	}
+	if f.AddressBookID != 0 && (e.Contact == nil || e.Contact.AddressBookID != f.AddressBookID) {
+		return false
+	}
+	return true
+}
+
+// Build a filter from the types, contact_id and address_book_id parameters
+func ParseFilter(params url.Values) (Filter, error) {
+	var f Filter
+	if typesStr := params.Get("types"); typesStr != "" {
+		for _, name := range strings.Split(typesStr, ",") {
+			change := contacts.ChangeType(strings.TrimSpace(name))
+			if !slices.Contains(changeTypes, change) {
+				return f, fmt.Errorf("invalid event type %q, must be one of %s, %s or %s: %w", name, contacts.ContactCreated, contacts.ContactUpdated, contacts.ContactDeleted, contacts.ErrInvalidRequest)
+			}
+			f.Types = append(f.Types, change)
+		}
+	}
+
+	var err error
+	if f.ContactID, err = parseID(params, "contact_id"); err != nil {
+		return f, err
+	}
+	if f.AddressBookID, err = parseID(params, "address_book_id"); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+func parseID(params url.Values, name string) (uint, error) {
+	idStr := params.Get(name)
+	if idStr == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q, IDs can only be integers: %w", name, idStr, contacts.ErrInvalidRequest)
+	}
+	return uint(id), nil
+}
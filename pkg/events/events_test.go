@@ -0,0 +1,290 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"golangphonebook/pkg/auth"
+	"golangphonebook/pkg/contacts"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func contact(id uint, book uint) *contacts.Contact {
+	return &contacts.Contact{ID: id, FirstName: "Ada", Phone: "+441234567890", AddressBookID: book}
+}
+
+func receive(t *testing.T, sub *Subscription) Event {
+	select {
+	case e := <-sub.Events:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+		return Event{}
+	}
+}
+
+func TestBus(t *testing.T) {
+	t.Run("Delivers To The Tenant", func(t *testing.T) {
+		bus := NewBus(DefaultHistory)
+		acme, _, err := bus.Subscribe("acme", Filter{}, 0)
+		require.NoError(t, err)
+		other, _, err := bus.Subscribe("other", Filter{}, 0)
+		require.NoError(t, err)
+
+		bus.Publish("acme", contacts.ContactCreated, 1, contact(1, 1))
+		e := receive(t, acme)
+		assert.Equal(t, contacts.ContactCreated, e.Type)
+		assert.Equal(t, uint(1), e.ContactID)
+		assert.Equal(t, "Ada", e.Contact.FirstName)
+		assert.Empty(t, other.Events)
+	})
+
+	t.Run("Copies The Payload", func(t *testing.T) {
+		bus := NewBus(DefaultHistory)
+		sub, _, err := bus.Subscribe("acme", Filter{}, 0)
+		require.NoError(t, err)
+		c := contact(1, 1)
+		bus.Publish("acme", contacts.ContactUpdated, 1, c)
+		c.FirstName = "Changed"
+		assert.Equal(t, "Ada", receive(t, sub).Contact.FirstName)
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		bus := NewBus(DefaultHistory)
+		sub, _, err := bus.Subscribe("acme", Filter{Types: []contacts.ChangeType{contacts.ContactDeleted}, AddressBookID: 2}, 0)
+		require.NoError(t, err)
+		bus.Publish("acme", contacts.ContactCreated, 1, contact(1, 2))
+		bus.Publish("acme", contacts.ContactDeleted, 2, contact(2, 1))
+		bus.Publish("acme", contacts.ContactDeleted, 3, nil)
+		bus.Publish("acme", contacts.ContactDeleted, 4, contact(4, 2))
+		assert.Equal(t, uint(4), receive(t, sub).ContactID)
+		assert.Empty(t, sub.Events)
+	})
+
+	t.Run("Resumes After An Event", func(t *testing.T) {
+		bus := NewBus(DefaultHistory)
+		for id := uint(1); id <= 3; id++ {
+			bus.Publish("acme", contacts.ContactCreated, id, contact(id, 1))
+			bus.Publish("other", contacts.ContactCreated, id, contact(id, 1))
+		}
+		first := bus.history[0].ID
+
+		_, replay, err := bus.Subscribe("acme", Filter{}, first)
+		require.NoError(t, err)
+		require.Len(t, replay, 2)
+		assert.Equal(t, uint(2), replay[0].ContactID)
+		assert.Equal(t, uint(3), replay[1].ContactID)
+
+		_, replay, err = bus.Subscribe("acme", Filter{}, bus.lastID)
+		require.NoError(t, err)
+		assert.Empty(t, replay)
+	})
+
+	t.Run("Forgets Erased Contacts", func(t *testing.T) {
+		bus := NewBus(DefaultHistory)
+		bus.Publish("acme", contacts.ContactCreated, 1, contact(1, 1))
+		bus.Publish("acme", contacts.ContactUpdated, 1, contact(1, 1))
+		bus.Publish("acme", contacts.ContactCreated, 2, contact(2, 1))
+		bus.Publish("other", contacts.ContactCreated, 1, contact(1, 1))
+		first := bus.history[0].ID
+
+		bus.Forget("acme", []uint{1})
+		_, replay, err := bus.Subscribe("acme", Filter{}, first-1)
+		require.NoError(t, err)
+		require.Len(t, replay, 3)
+		assert.Nil(t, replay[0].Contact)
+		assert.Nil(t, replay[1].Contact)
+		assert.NotNil(t, replay[2].Contact, "other contacts are kept")
+		_, replay, err = bus.Subscribe("other", Filter{}, first-1)
+		require.NoError(t, err)
+		assert.NotNil(t, replay[0].Contact, "other tenants are kept")
+	})
+
+	t.Run("Expired Events", func(t *testing.T) {
+		bus := NewBus(2)
+		for id := uint(1); id <= 3; id++ {
+			bus.Publish("acme", contacts.ContactCreated, id, contact(id, 1))
+		}
+		first := bus.history[0].ID
+
+		// The event before the oldest kept one is the last one a client can resume after
+		_, replay, err := bus.Subscribe("acme", Filter{}, first-1)
+		require.NoError(t, err)
+		assert.Len(t, replay, 2)
+
+		_, _, err = bus.Subscribe("acme", Filter{}, first-2)
+		assert.ErrorIs(t, err, ErrExpired)
+		// From before a restart
+		_, _, err = bus.Subscribe("acme", Filter{}, bus.lastID+1)
+		assert.ErrorIs(t, err, ErrExpired)
+	})
+
+	t.Run("Drops Slow Subscribers", func(t *testing.T) {
+		bus := NewBus(DefaultHistory)
+		slow, _, err := bus.Subscribe("acme", Filter{}, 0)
+		require.NoError(t, err)
+		for id := uint(1); id <= subscriberBuffer+1; id++ {
+			bus.Publish("acme", contacts.ContactCreated, id, contact(id, 1))
+		}
+		assert.True(t, slow.Dropped())
+		received := 0
+		for range slow.Events {
+			received++
+		}
+		assert.Equal(t, subscriberBuffer, received)
+		slow.Close()
+	})
+}
+
+func TestParseFilter(t *testing.T) {
+	f, err := ParseFilter(url.Values{"types": {"contact.created, contact.deleted"}, "contact_id": {"7"}, "address_book_id": {"2"}})
+	require.NoError(t, err)
+	assert.Equal(t, Filter{Types: []contacts.ChangeType{contacts.ContactCreated, contacts.ContactDeleted}, ContactID: 7, AddressBookID: 2}, f)
+
+	_, err = ParseFilter(url.Values{"types": {"contact.moved"}})
+	assert.ErrorIs(t, err, contacts.ErrInvalidRequest)
+	_, err = ParseFilter(url.Values{"contact_id": {"abc"}})
+	assert.ErrorIs(t, err, contacts.ErrInvalidRequest)
+}
+
+// Serve the handler the way the route's role check leaves the request
+func newServer(t *testing.T, bus *Bus, handler func(http.ResponseWriter, *http.Request, *Bus)) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(auth.WithIdentity(r.Context(), auth.Identity{CommonName: "reader", Role: auth.RoleReader, Tenant: "acme"}))
+		handler(w, r, bus)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Read one event from a stream, skipping heartbeats
+func readSSE(t *testing.T, reader *bufio.Reader) (string, Event) {
+	var id string
+	var e Event
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && id != "":
+			return id, e
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e))
+		}
+	}
+}
+
+func TestServeSSE(t *testing.T) {
+	bus := NewBus(DefaultHistory)
+	server := newServer(t, bus, ServeSSE)
+
+	t.Run("Streams Events", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "?types=contact.updated")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		bus.Publish("acme", contacts.ContactCreated, 1, contact(1, 1))
+		bus.Publish("acme", contacts.ContactUpdated, 1, contact(1, 1))
+		id, e := readSSE(t, bufio.NewReader(resp.Body))
+		assert.Equal(t, strconv.FormatUint(e.ID, 10), id)
+		assert.Equal(t, contacts.ContactUpdated, e.Type)
+	})
+
+	t.Run("Resumes From Last-Event-ID", func(t *testing.T) {
+		first := bus.history[0].ID
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		req.Header.Set("Last-Event-ID", strconv.FormatUint(first, 10))
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		_, e := readSSE(t, bufio.NewReader(resp.Body))
+		assert.Equal(t, first+1, e.ID)
+	})
+
+	t.Run("Rejects Expired And Invalid IDs", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "?last_event_id=1")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusGone, resp.StatusCode)
+
+		resp, err = http.Get(server.URL + "?last_event_id=abc")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestServeWebSocket(t *testing.T) {
+	bus := NewBus(DefaultHistory)
+	server := newServer(t, bus, ServeWebSocket)
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	bus.Publish("acme", contacts.ContactCreated, 1, contact(1, 1))
+	first := bus.lastID
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?contact_id=2&last_event_id="+strconv.FormatUint(first-1, 10), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Only the live event for contact 2, the replayed one is for contact 1
+	bus.Publish("acme", contacts.ContactCreated, 1, contact(1, 1))
+	bus.Publish("acme", contacts.ContactDeleted, 2, contact(2, 1))
+	var e Event
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	require.NoError(t, conn.ReadJSON(&e))
+	assert.Equal(t, contacts.ContactDeleted, e.Type)
+	assert.Equal(t, uint(2), e.ContactID)
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL+"?last_event_id=1", nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusGone, resp.StatusCode)
+}
+
+func TestClose(t *testing.T) {
+	bus := NewBus(DefaultHistory)
+	server := newServer(t, bus, ServeWebSocket)
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	sub, _, err := bus.Subscribe("acme", Filter{}, 0)
+	require.NoError(t, err)
+
+	bus.Close()
+	_, open := <-sub.Events
+	assert.False(t, open)
+	assert.False(t, sub.Dropped())
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "got %v", err)
+
+	_, _, err = bus.Subscribe("acme", Filter{}, 0)
+	assert.ErrorIs(t, err, ErrClosed)
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	// Waits for the subscriber that hasn't closed yet
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, bus.Wait(ctx), context.DeadlineExceeded)
+	sub.Close()
+	assert.NoError(t, bus.Wait(context.Background()))
+}
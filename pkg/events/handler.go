@@ -0,0 +1,188 @@
+// handle HTTP requests that stream events as server-sent events or over a WebSocket
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"golangphonebook/internal"
+	"golangphonebook/pkg/auth"
+	"golangphonebook/pkg/contacts"
+	"golangphonebook/pkg/problem"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// How often idle streams are kept alive, set from the config at startup
+var Heartbeat = 15 * time.Second
+
+// Longest a write to a WebSocket can take
+const writeWait = 10 * time.Second
+
+// Event to resume after, from the Last-Event-ID header browsers send on reconnect or the last_event_id parameter
+func lastEventID(r *http.Request) (uint64, error) {
+	idStr := r.Header.Get("Last-Event-ID")
+	if idStr == "" {
+		idStr = r.URL.Query().Get("last_event_id")
+	}
+	if idStr == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid last event ID %q, event IDs can only be integers: %w", idStr, contacts.ErrInvalidRequest)
+	}
+	return id, nil
+}
+
+// Subscribe for the caller's tenant with the request's filter and last event ID
+func subscribe(r *http.Request, bus *Bus) (*Subscription, []Event, error) {
+	filter, err := ParseFilter(r.URL.Query())
+	if err != nil {
+		return nil, nil, err
+	}
+	after, err := lastEventID(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	id, _ := auth.FromContext(r.Context())
+	return bus.Subscribe(id.Tenant, filter, after)
+}
+
+func writeSSE(w http.ResponseWriter, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
+
+// Stream the tenant's events until the client goes away
+func ServeSSE(w http.ResponseWriter, r *http.Request, bus *Bus) {
+	defer internal.Timer("ServeSSE")()
+
+	sub, replay, err := subscribe(r, bus)
+	if err != nil {
+		internal.Logger.WarnContext(r.Context(), fmt.Sprintf("Rejected event stream: %v", err))
+		problem.Write(w, r, err)
+		return
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher := http.NewResponseController(w)
+
+	for _, e := range replay {
+		if err := writeSSE(w, e); err != nil {
+			return
+		}
+	}
+	if err := flusher.Flush(); err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Event stream cannot be flushed: %v", err))
+		return
+	}
+
+	ticker := time.NewTicker(Heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-sub.Events:
+			if !ok {
+				// Fell behind or the server is shutting down, the client reconnects with the last event it got
+				return
+			}
+			if err := writeSSE(w, e); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		if err := flusher.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// Browsers send client certificates on cross-site WebSocket requests too, so only the API's own origin is allowed
+var upgrader = websocket.Upgrader{}
+
+// Stream the tenant's events as JSON text messages until either side closes the WebSocket
+func ServeWebSocket(w http.ResponseWriter, r *http.Request, bus *Bus) {
+	defer internal.Timer("ServeWebSocket")()
+
+	sub, replay, err := subscribe(r, bus)
+	if err != nil {
+		internal.Logger.WarnContext(r.Context(), fmt.Sprintf("Rejected event WebSocket: %v", err))
+		problem.Write(w, r, err)
+		return
+	}
+	defer sub.Close()
+
+	// The upgrader reports its own errors
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		internal.Logger.WarnContext(r.Context(), fmt.Sprintf("Failed to upgrade to a WebSocket: %v", err))
+		return
+	}
+	defer conn.Close()
+
+	// Clients only send control messages, reading notices when they close or stop answering pings
+	closed := make(chan struct{})
+	conn.SetReadLimit(512)
+	conn.SetReadDeadline(time.Now().Add(2 * Heartbeat))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * Heartbeat))
+	})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(e Event) error {
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		return conn.WriteJSON(e)
+	}
+	for _, e := range replay {
+		if err := send(e); err != nil {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(Heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case e, ok := <-sub.Events:
+			if !ok {
+				message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down, reconnect with last_event_id")
+				if sub.Dropped() {
+					message = websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "fell behind, reconnect with last_event_id")
+				}
+				conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(writeWait))
+				return
+			}
+			if err := send(e); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				return
+			}
+		}
+	}
+}
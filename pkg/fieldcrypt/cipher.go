@@ -0,0 +1,112 @@
+// Envelope encryption of single values with AES-GCM, plus blind indexes for exact-match lookups
+package fieldcrypt
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Every encrypted value starts with this, anything else is treated as plaintext from before encryption was enabled
+const Prefix = "enc:v1:"
+
+type Cipher struct {
+	Keys KeyProvider
+}
+
+// NewCipher creates a new instance of Cipher
+func NewCipher(keys KeyProvider) *Cipher {
+	return &Cipher{Keys: keys}
+}
+
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+// ID of the key an encrypted value's data key is wrapped with, empty for plaintext
+func KeyID(value string) string {
+	if !IsEncrypted(value) {
+		return ""
+	}
+	keyID, _, _ := strings.Cut(strings.TrimPrefix(value, Prefix), ":")
+	return keyID
+}
+
+// Encrypt a value with a fresh data key, which is wrapped with the current key encryption key.
+// The field name is bound to the ciphertext so values can't be swapped between columns.
+// The result looks like enc:v1:<key id>:<wrapped data key>:<ciphertext>.
+func (c *Cipher) Encrypt(field string, plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataKey, []byte(plaintext), []byte(field))
+	if err != nil {
+		return "", err
+	}
+
+	keyID := c.Keys.CurrentKeyID()
+	wrapped, err := c.Keys.WrapKey(keyID, dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	return Prefix + keyID + ":" + base64.RawStdEncoding.EncodeToString(wrapped) + ":" + base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt a value from Encrypt, plaintext values are returned as they are
+func (c *Cipher) Decrypt(field string, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, Prefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted value")
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed wrapped data key: %v", err)
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("malformed ciphertext: %v", err)
+	}
+
+	dataKey, err := c.Keys.UnwrapKey(parts[0], wrapped)
+	if err != nil {
+		return "", fmt.Errorf("unable to unwrap data key: %v", err)
+	}
+	plaintext, err := open(dataKey, ciphertext, []byte(field))
+	if err != nil {
+		return "", fmt.Errorf("unable to decrypt %s: %v", field, err)
+	}
+	return string(plaintext), nil
+}
+
+// Does the value need to be encrypted again, because it's plaintext or uses an old key
+func (c *Cipher) NeedsRotation(value string) bool {
+	return value != "" && KeyID(value) != c.Keys.CurrentKeyID()
+}
+
+// Deterministic keyed hash of a value, so encrypted columns can still be matched exactly.
+// Empty values have an empty index.
+func (c *Cipher) BlindIndex(field string, plaintext string) string {
+	if plaintext == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, c.Keys.BlindIndexKey())
+	mac.Write([]byte(field))
+	mac.Write([]byte{0})
+	mac.Write([]byte(plaintext))
+	return hex.EncodeToString(mac.Sum(nil))
+}
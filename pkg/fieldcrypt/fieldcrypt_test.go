@@ -0,0 +1,133 @@
+package fieldcrypt_test
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"golangphonebook/pkg/fieldcrypt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func randomKey(t *testing.T) string {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	assert.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func writeKeyFile(t *testing.T, current string, keys map[string]string, indexKey string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "current: %s\nindex_key: %s\nkeys:\n", current, indexKey)
+	for id, key := range keys {
+		fmt.Fprintf(&b, "  %s: %s\n", id, key)
+	}
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(b.String()), 0600))
+	return path
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	keys, err := fieldcrypt.LoadKeyFile(writeKeyFile(t, "k1", map[string]string{"k1": randomKey(t)}, randomKey(t)))
+	assert.NoError(t, err)
+	cipher := fieldcrypt.NewCipher(keys)
+
+	encrypted, err := cipher.Encrypt("phone", "+1234567890")
+	assert.NoError(t, err)
+	assert.True(t, fieldcrypt.IsEncrypted(encrypted))
+	assert.Equal(t, "k1", fieldcrypt.KeyID(encrypted))
+	assert.NotContains(t, encrypted, "1234567890")
+
+	// Same value encrypts differently every time
+	again, _ := cipher.Encrypt("phone", "+1234567890")
+	assert.NotEqual(t, encrypted, again)
+
+	decrypted, err := cipher.Decrypt("phone", encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, "+1234567890", decrypted)
+
+	// Ciphertext is bound to its field
+	_, err = cipher.Decrypt("address", encrypted)
+	assert.Error(t, err)
+
+	// Plaintext from before encryption was enabled passes through
+	plaintext, err := cipher.Decrypt("phone", "+1234567890")
+	assert.NoError(t, err)
+	assert.Equal(t, "+1234567890", plaintext)
+
+	empty, err := cipher.Encrypt("address", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "", empty)
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey, newKey, indexKey := randomKey(t), randomKey(t), randomKey(t)
+
+	before, err := fieldcrypt.LoadKeyFile(writeKeyFile(t, "k1", map[string]string{"k1": oldKey}, indexKey))
+	assert.NoError(t, err)
+	encrypted, err := fieldcrypt.NewCipher(before).Encrypt("address", "123 Main St")
+	assert.NoError(t, err)
+
+	after, err := fieldcrypt.LoadKeyFile(writeKeyFile(t, "k2", map[string]string{"k1": oldKey, "k2": newKey}, indexKey))
+	assert.NoError(t, err)
+	cipher := fieldcrypt.NewCipher(after)
+
+	// Values under the old key still decrypt, but need to be rotated
+	assert.True(t, cipher.NeedsRotation(encrypted))
+	decrypted, err := cipher.Decrypt("address", encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, "123 Main St", decrypted)
+
+	rotated, err := cipher.Encrypt("address", decrypted)
+	assert.NoError(t, err)
+	assert.False(t, cipher.NeedsRotation(rotated))
+	assert.True(t, cipher.NeedsRotation("123 Main St"))
+
+	// Once the old key is retired its values can no longer be read
+	retired, err := fieldcrypt.LoadKeyFile(writeKeyFile(t, "k2", map[string]string{"k2": newKey}, indexKey))
+	assert.NoError(t, err)
+	_, err = fieldcrypt.NewCipher(retired).Decrypt("address", encrypted)
+	assert.Error(t, err)
+}
+
+func TestBlindIndex(t *testing.T) {
+	indexKey := randomKey(t)
+	first, err := fieldcrypt.LoadKeyFile(writeKeyFile(t, "k1", map[string]string{"k1": randomKey(t)}, indexKey))
+	assert.NoError(t, err)
+	second, err := fieldcrypt.LoadKeyFile(writeKeyFile(t, "k2", map[string]string{"k2": randomKey(t)}, indexKey))
+	assert.NoError(t, err)
+
+	index := fieldcrypt.NewCipher(first).BlindIndex("phone", "+1234567890")
+	assert.Len(t, index, 64)
+	// Stable across key rotations, as long as the index key stays the same
+	assert.Equal(t, index, fieldcrypt.NewCipher(second).BlindIndex("phone", "+1234567890"))
+	assert.NotEqual(t, index, fieldcrypt.NewCipher(first).BlindIndex("phone", "+1234567891"))
+	assert.NotEqual(t, index, fieldcrypt.NewCipher(first).BlindIndex("address", "+1234567890"))
+	assert.Equal(t, "", fieldcrypt.NewCipher(first).BlindIndex("phone", ""))
+}
+
+func TestLoadKeyFileErrors(t *testing.T) {
+	key := randomKey(t)
+	tests := []struct {
+		name     string
+		current  string
+		keys     map[string]string
+		indexKey string
+	}{
+		{"Missing current key", "k2", map[string]string{"k1": key}, key},
+		{"Short key", "k1", map[string]string{"k1": base64.StdEncoding.EncodeToString([]byte("short"))}, key},
+		{"Invalid base64", "k1", map[string]string{"k1": "not base64!"}, key},
+		{"Missing index key", "k1", map[string]string{"k1": key}, `""`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := fieldcrypt.LoadKeyFile(writeKeyFile(t, tt.current, tt.keys, tt.indexKey))
+			assert.Error(t, err)
+		})
+	}
+}
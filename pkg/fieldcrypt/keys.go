@@ -0,0 +1,133 @@
+// Keys for field level encryption
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// KMS-style access to key encryption keys. The keys themselves never have to leave the provider,
+// it only wraps and unwraps the data keys that encrypt each value.
+type KeyProvider interface {
+	CurrentKeyID() string                                   // Key new values are wrapped with
+	WrapKey(keyID string, dataKey []byte) ([]byte, error)   // Encrypt a data key with a key encryption key
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error) // Decrypt a data key wrapped by WrapKey
+	BlindIndexKey() []byte                                  // HMAC key for blind indexes, never rotated
+}
+
+// Key file format, every key is 32 bytes encoded as standard base64
+type keyFile struct {
+	Current  string            `yaml:"current"`   // ID of the key new values are encrypted with
+	Keys     map[string]string `yaml:"keys"`      // Every key that may still be needed to decrypt, by ID
+	IndexKey string            `yaml:"index_key"` // Key for the blind indexes
+}
+
+// Key provider backed by a local key file
+type FileKeyProvider struct {
+	current  string
+	keys     map[string][]byte
+	indexKey []byte
+}
+
+func decodeKey(name string, encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not valid base64: %v", name, err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("%s must be 32 bytes, found %d", name, len(key))
+	}
+	return key, nil
+}
+
+func LoadKeyFile(path string) (*FileKeyProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file keyFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("unable to parse key file %s: %v", path, err)
+	}
+
+	provider := &FileKeyProvider{current: file.Current, keys: make(map[string][]byte)}
+	for id, encoded := range file.Keys {
+		if provider.keys[id], err = decodeKey("key "+id, encoded); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := provider.keys[file.Current]; !ok {
+		return nil, fmt.Errorf("current key %q is not in the key file", file.Current)
+	}
+	if provider.indexKey, err = decodeKey("index_key", file.IndexKey); err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+func (p *FileKeyProvider) CurrentKeyID() string {
+	return p.current
+}
+
+func (p *FileKeyProvider) WrapKey(keyID string, dataKey []byte) ([]byte, error) {
+	key, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+	return seal(key, dataKey, []byte(keyID))
+}
+
+func (p *FileKeyProvider) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	key, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+	return open(key, wrapped, []byte(keyID))
+}
+
+func (p *FileKeyProvider) BlindIndexKey() []byte {
+	return p.indexKey
+}
+
+// AES-GCM encrypt with a random nonce, which is prepended to the ciphertext
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
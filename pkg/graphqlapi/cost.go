@@ -0,0 +1,234 @@
+// Limit how much a query can ask for before any of it runs
+package graphqlapi
+
+import (
+	"errors"
+	"fmt"
+	"golangphonebook/pkg/contacts"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+type Limits struct {
+	MaxDepth        int // Deepest nesting of fields
+	MaxCost         int // Most fields a query can resolve, counting every item of every list
+	MaxPageSize     int // Most contacts first or last can ask for
+	DefaultPageSize int // Contacts a connection returns without first or last
+	ListSize        int // Items assumed for lists without first or last, like addressBooks
+}
+
+var DefaultLimits = Limits{MaxDepth: 10, MaxCost: 1000, MaxPageSize: 100, DefaultPageSize: 10, ListSize: 10}
+
+// Matches every query that is rejected for asking too much
+var ErrQueryTooExpensive = errors.New("query too expensive")
+
+// Walks the operation that will run, the way the executor will, and adds up the cost of every field
+type costAnalysis struct {
+	schema    graphql.Schema
+	limits    Limits
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]any
+	defaults  map[string]ast.Value
+	cost      int
+	mutation  bool
+	changes   int // Contacts and address books the mutations of the operation add or change
+	err       error
+}
+
+// Cost of the operation of the document that will run. Fields are counted once for every item
+// of the lists they are in, lists are as long as first or last allow.
+func queryCost(schema graphql.Schema, doc *ast.Document, operationName string, variables map[string]any, limits Limits) (int, error) {
+	a := &costAnalysis{schema: schema, limits: limits, fragments: make(map[string]*ast.FragmentDefinition), variables: variables, defaults: make(map[string]ast.Value)}
+
+	var operation *ast.OperationDefinition
+	for _, definition := range doc.Definitions {
+		switch definition := definition.(type) {
+		case *ast.FragmentDefinition:
+			a.fragments[definition.Name.Value] = definition
+		case *ast.OperationDefinition:
+			if operationName == "" || (definition.Name != nil && definition.Name.Value == operationName) {
+				operation = definition
+			}
+		}
+	}
+	// Validation has already made sure there is one
+	if operation == nil {
+		return 0, nil
+	}
+	for _, variable := range operation.VariableDefinitions {
+		if variable.DefaultValue != nil {
+			a.defaults[variable.Variable.Name.Value] = variable.DefaultValue
+		}
+	}
+
+	root := schema.QueryType()
+	if operation.Operation == ast.OperationTypeMutation {
+		root = schema.MutationType()
+		a.mutation = true
+	}
+	a.selections(operation.SelectionSet, root, 1, 0, 1)
+	if a.err != nil {
+		return a.cost, a.err
+	}
+	// Aliases could otherwise repeat a mutation past the batch size REST and gRPC enforce
+	if a.changes > contacts.MaxBatchSize {
+		return a.cost, fmt.Errorf("mutations would make %d changes, more than the limit of %d for one request: %w", a.changes, contacts.MaxBatchSize, ErrQueryTooExpensive)
+	}
+	if a.cost > limits.MaxCost {
+		return a.cost, fmt.Errorf("query would resolve up to %d fields, more than the limit of %d, ask for fewer contacts with first or last: %w", a.cost, limits.MaxCost, ErrQueryTooExpensive)
+	}
+	return a.cost, nil
+}
+
+// Add up the fields of a selection set. Lists in it are pageSize items long.
+func (a *costAnalysis) selections(set *ast.SelectionSet, parent *graphql.Object, multiplier int, pageSize int, depth int) {
+	if set == nil || a.err != nil {
+		return
+	}
+	if depth > a.limits.MaxDepth {
+		a.err = fmt.Errorf("query is nested deeper than the limit of %d: %w", a.limits.MaxDepth, ErrQueryTooExpensive)
+		return
+	}
+
+	for _, selection := range set.Selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			a.field(selection, parent, multiplier, pageSize, depth)
+		case *ast.InlineFragment:
+			a.selections(selection.SelectionSet, a.typeCondition(selection.TypeCondition, parent), multiplier, pageSize, depth)
+		case *ast.FragmentSpread:
+			if fragment, ok := a.fragments[selection.Name.Value]; ok {
+				a.selections(fragment.SelectionSet, a.typeCondition(fragment.TypeCondition, parent), multiplier, pageSize, depth)
+			}
+		}
+	}
+}
+
+func (a *costAnalysis) field(field *ast.Field, parent *graphql.Object, multiplier int, pageSize int, depth int) {
+	// Introspection is bounded by the size of the schema
+	if strings.HasPrefix(field.Name.Value, "__") || parent == nil {
+		return
+	}
+	definition, ok := parent.Fields()[field.Name.Value]
+	if !ok {
+		return
+	}
+	a.cost += multiplier
+	if a.mutation && depth == 1 {
+		a.changes += a.changeCount(field)
+	}
+
+	// Connections pass their page size on to the list of edges inside them
+	childPageSize := 0
+	if hasArgument(definition, "first") {
+		childPageSize = a.pageSize(field)
+	}
+
+	fieldType := definition.Type
+unwrap:
+	for {
+		switch t := fieldType.(type) {
+		case *graphql.NonNull:
+			fieldType = t.OfType
+		case *graphql.List:
+			size := pageSize
+			if size == 0 {
+				size = a.limits.ListSize
+			}
+			multiplier *= size
+			pageSize = 0
+			fieldType = t.OfType
+		default:
+			break unwrap
+		}
+	}
+	if childPageSize == 0 {
+		childPageSize = pageSize
+	}
+
+	if object, ok := fieldType.(*graphql.Object); ok {
+		a.selections(field.SelectionSet, object, multiplier, childPageSize, depth+1)
+	}
+}
+
+func hasArgument(definition *graphql.FieldDefinition, name string) bool {
+	for _, arg := range definition.Args {
+		if arg.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Changes a mutation makes, one for each item of a list input like the contacts of createContacts
+func (a *costAnalysis) changeCount(field *ast.Field) int {
+	for _, arg := range field.Arguments {
+		if arg.Name.Value != "input" {
+			continue
+		}
+		switch value := arg.Value.(type) {
+		case *ast.ListValue:
+			return max(len(value.Values), 1)
+		case *ast.Variable:
+			if items, ok := a.variables[value.Name.Value].([]any); ok {
+				return max(len(items), 1)
+			}
+		}
+	}
+	return 1
+}
+
+// Contacts a connection field asks for, the resolvers reject the same sizes this does
+func (a *costAnalysis) pageSize(field *ast.Field) int {
+	size := 0
+	for _, arg := range field.Arguments {
+		if arg.Name.Value != "first" && arg.Name.Value != "last" {
+			continue
+		}
+		value, ok := a.intValue(arg.Value)
+		if !ok {
+			continue
+		}
+		if value < 0 || value > a.limits.MaxPageSize {
+			a.err = fmt.Errorf("%s must be between 0 and %d: %w", arg.Name.Value, a.limits.MaxPageSize, ErrQueryTooExpensive)
+			return 0
+		}
+		size = max(size, value)
+	}
+	if size == 0 {
+		return a.limits.DefaultPageSize
+	}
+	return size
+}
+
+// Value of an Int argument, written out or passed as a variable
+func (a *costAnalysis) intValue(value ast.Value) (int, bool) {
+	switch value := value.(type) {
+	case *ast.IntValue:
+		n, err := strconv.Atoi(value.Value)
+		return n, err == nil
+	case *ast.Variable:
+		name := value.Name.Value
+		switch n := a.variables[name].(type) {
+		case float64:
+			return int(n), true
+		case int:
+			return n, true
+		}
+		if fallback, ok := a.defaults[name]; ok {
+			return a.intValue(fallback)
+		}
+	}
+	return 0, false
+}
+
+func (a *costAnalysis) typeCondition(condition *ast.Named, parent *graphql.Object) *graphql.Object {
+	if condition == nil {
+		return parent
+	}
+	object, _ := a.schema.Type(condition.Name.Value).(*graphql.Object)
+	return object
+}
@@ -0,0 +1,211 @@
+// Serve GraphQL over HTTP, behind the same role checks and audit log as the REST API
+package graphqlapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golangphonebook/internal"
+	"golangphonebook/pkg/audit"
+	"golangphonebook/pkg/auth"
+	"golangphonebook/pkg/contacts"
+	"golangphonebook/pkg/problem"
+	"golangphonebook/pkg/requestlog"
+	"net/http"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// Largest request body, queries are short and variables hold at most a batch of contacts
+const maxBodySize = 64 << 10
+
+// Mutations that need a higher role than the route
+var errForbidden = errors.New("forbidden")
+
+type Handler struct {
+	// Repository for a request or mutation, scoped to the caller's tenant and reporting to the audit entry on the context
+	Repo   func(ctx context.Context) contacts.ContactRepository
+	Audit  audit.Sink
+	Limits Limits
+	schema graphql.Schema
+}
+
+func NewHandler(repo func(ctx context.Context) contacts.ContactRepository, sink audit.Sink, limits Limits) (*Handler, error) {
+	h := &Handler{Repo: repo, Audit: sink, Limits: limits}
+	schema, err := h.buildSchema()
+	if err != nil {
+		return nil, err
+	}
+	h.schema = schema
+	return h, nil
+}
+
+type Request struct {
+	Query         string         `json:"query" validate:"required"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+type Response struct {
+	Data       any                        `json:"data"`
+	Errors     []gqlerrors.FormattedError `json:"errors,omitempty"`
+	Extensions map[string]any             `json:"extensions,omitempty"` // cost holds the cost of the query and the limit
+}
+
+// What the resolvers of one request share
+type request struct {
+	repo      contacts.ContactRepository
+	requestID string
+	books     []contacts.AddressBook // Loaded on first use, so a page of contacts costs one query for their address books
+}
+
+type requestKey struct{}
+
+func requestFrom(ctx context.Context) *request {
+	return ctx.Value(requestKey{}).(*request)
+}
+
+func (r *request) addressBooks(ctx context.Context) ([]contacts.AddressBook, error) {
+	if r.books == nil {
+		books, err := r.repo.ListAddressBooks(ctx)
+		if err != nil {
+			return nil, err
+		}
+		r.books = append([]contacts.AddressBook{}, books...)
+	}
+	return r.books, nil
+}
+
+// Address book of the tenant with the ID, nil when there is none
+func (r *request) addressBook(ctx context.Context, id uint) (any, error) {
+	books, err := r.addressBooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range books {
+		if books[i].ID == id {
+			return &books[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// Run a mutation once the caller's role allows it, audited like the REST route it matches
+func (h *Handler) mutation(name string, resolve mutationFunc) graphql.FieldResolveFn {
+	rule := mutationRules[name]
+	return func(p graphql.ResolveParams) (any, error) {
+		req := requestFrom(p.Context)
+		ctx, entry := audit.Begin(p.Context, rule.action, req.requestID)
+
+		// Denied mutations are audited too, like requests the role check of the REST routes denies
+		id, _ := auth.FromContext(ctx)
+		if !id.Role.Includes(rule.role) {
+			internal.Logger.WarnContext(ctx, fmt.Sprintf("Denied mutation %s for %s with role %q, requires %q", name, id, id.Role, rule.role))
+			entry.Finish(ctx, h.Audit, http.StatusForbidden)
+			return nil, fmt.Errorf("Forbidden: client %s has the %s role, mutation %s requires the %s role: %w", id, id.Role, name, rule.role, errForbidden)
+		}
+
+		result, err := resolve(ctx, h.Repo(ctx), p.Args)
+		status := http.StatusOK
+		if err != nil {
+			status = details(err).Status
+		}
+		entry.Finish(ctx, h.Audit, status)
+
+		// Later fields of the request see the change
+		req.books = nil
+		return result, err
+	}
+}
+
+// Problem an error is reported as. The errors only GraphQL has aren't registered, so the REST routes don't document them.
+func details(err error) problem.Details {
+	switch {
+	case errors.Is(err, errForbidden):
+		return problem.Details{Type: problem.TypeFor("forbidden"), Title: "Forbidden", Status: http.StatusForbidden, Detail: err.Error()}
+	case errors.Is(err, ErrQueryTooExpensive):
+		return problem.Details{Type: problem.TypeFor("query-too-expensive"), Title: "Query too expensive", Status: http.StatusBadRequest, Detail: err.Error()}
+	}
+	return problem.FromError(err)
+}
+
+// Extensions of an error, with the same problem type and field errors as the problem+json response of the REST API
+func extensions(details problem.Details) map[string]any {
+	ext := map[string]any{"type": details.Type, "status": details.Status}
+	if len(details.Errors) > 0 {
+		ext["errors"] = details.Errors
+	}
+	return ext
+}
+
+func formatError(err error) gqlerrors.FormattedError {
+	reported := details(err)
+	formatted := gqlerrors.NewFormattedError(reported.Detail)
+	formatted.Extensions = extensions(reported)
+	return formatted
+}
+
+// Errors of resolvers are reported like problems, so internal errors don't leak their message
+func formatResolverErrors(errs []gqlerrors.FormattedError) {
+	for i, formatted := range errs {
+		located, ok := formatted.OriginalError().(*gqlerrors.Error)
+		if !ok || located.OriginalError == nil {
+			continue
+		}
+		reported := details(located.OriginalError)
+		errs[i].Message = reported.Detail
+		errs[i].Extensions = extensions(reported)
+	}
+}
+
+// Parse, validate, check the cost of and run a request
+func (h *Handler) Execute(ctx context.Context, req Request, requestID string) Response {
+	doc, err := parser.Parse(parser.ParseParams{Source: req.Query})
+	if err != nil {
+		return Response{Errors: gqlerrors.FormatErrors(err)}
+	}
+	if validation := graphql.ValidateDocument(&h.schema, doc, nil); !validation.IsValid {
+		return Response{Errors: validation.Errors}
+	}
+
+	cost, err := queryCost(h.schema, doc, req.OperationName, req.Variables, h.Limits)
+	costs := map[string]any{"cost": map[string]int{"requested": cost, "limit": h.Limits.MaxCost}}
+	if err != nil {
+		internal.Logger.WarnContext(ctx, fmt.Sprintf("Rejected GraphQL request %s: %v", requestID, err))
+		return Response{Errors: []gqlerrors.FormattedError{formatError(err)}, Extensions: costs}
+	}
+
+	ctx = context.WithValue(ctx, requestKey{}, &request{repo: h.Repo(ctx), requestID: requestID})
+	result := graphql.Execute(graphql.ExecuteParams{Schema: h.schema, AST: doc, OperationName: req.OperationName, Args: req.Variables, Context: ctx})
+	formatResolverErrors(result.Errors)
+	return Response{Data: result.Data, Errors: result.Errors, Extensions: costs}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer internal.Timer("GraphQL")()
+
+	var req Request
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&req)
+	if err != nil || req.Query == "" {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Received invalid body in GraphQL request: %v", err))
+		problem.Write(w, r, fmt.Errorf("Invalid request body, a query is required: %w", contacts.ErrInvalidRequest))
+		return
+	}
+
+	requestID := requestlog.FromHeader(r.Header.Get(requestlog.Header))
+	w.Header().Set(requestlog.Header, requestID)
+
+	// Errors are part of the result, the status is for the transport
+	response, err := json.Marshal(h.Execute(r.Context(), req, requestID))
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to serialize GraphQL response: %v", err))
+		problem.Error(w, r, "Failed to serialize response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
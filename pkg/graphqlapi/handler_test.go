@@ -0,0 +1,279 @@
+package graphqlapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"golangphonebook/pkg/audit/audittest"
+	"golangphonebook/pkg/auth"
+	"golangphonebook/pkg/contacts"
+	"golangphonebook/pkg/contacts/contactstest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testEnv struct {
+	repo    *contactstest.Repo
+	sink    *audittest.Sink
+	handler *Handler
+}
+
+func newEnv(t *testing.T) *testEnv {
+	env := &testEnv{
+		repo: contactstest.NewRepo(contacts.AddressBook{ID: 1, TenantID: "acme", Name: "default"}),
+		sink: &audittest.Sink{},
+	}
+	env.repo.Merges = []contacts.MergeRecord{{ID: 1, SurvivorID: 1, MergedID: 99, FirstName: "Old"}}
+	handler, err := NewHandler(func(context.Context) contacts.ContactRepository { return env.repo }, env.sink, DefaultLimits)
+	require.NoError(t, err)
+	env.handler = handler
+	return env
+}
+
+// Run a request as a client with the role, the way the route's role check leaves it
+func (env *testEnv) do(t *testing.T, role auth.Role, query string, variables map[string]any) Response {
+	body, err := json.Marshal(Request{Query: query, Variables: variables})
+	require.NoError(t, err)
+	r := httptest.NewRequest("POST", "/graphql", bytes.NewReader(body))
+	r = r.WithContext(auth.WithIdentity(r.Context(), auth.Identity{CommonName: string(role), Role: role, Tenant: "acme"}))
+	r.Header.Set("X-Request-ID", "req-1")
+
+	rr := httptest.NewRecorder()
+	env.handler.ServeHTTP(rr, r)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "req-1", rr.Header().Get("X-Request-ID"))
+
+	var response Response
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	return response
+}
+
+// Value at a path of field names in the data
+func dig(t *testing.T, response Response, path ...string) any {
+	var value any = response.Data
+	for _, name := range path {
+		object, ok := value.(map[string]any)
+		require.True(t, ok, "no object at %s", name)
+		value = object[name]
+	}
+	return value
+}
+
+func TestContactLifecycle(t *testing.T) {
+	env := newEnv(t)
+
+	created := env.do(t, auth.RoleEditor, `mutation($input: ContactInput!) { createContact(input: $input) { id firstName } }`,
+		map[string]any{"input": map[string]any{"firstName": "Ada", "lastName": "Lovelace", "phone": "+441234567"}})
+	require.Empty(t, created.Errors)
+	assert.Equal(t, "1", dig(t, created, "createContact", "id"))
+
+	got := env.do(t, auth.RoleReader, `{ contact(id: "1") { lastName addressBook { name } mergeHistory { mergedId firstName } } }`, nil)
+	require.Empty(t, got.Errors)
+	assert.Equal(t, "Lovelace", dig(t, got, "contact", "lastName"))
+	assert.Equal(t, "default", dig(t, got, "contact", "addressBook", "name"))
+	assert.Equal(t, []any{map[string]any{"mergedId": "99", "firstName": "Old"}}, dig(t, got, "contact", "mergeHistory"))
+
+	// Fields left out of the patch keep their value
+	updated := env.do(t, auth.RoleEditor, `mutation { updateContact(id: "1", input: {lastName: "King"}) { lastName phone } }`, nil)
+	require.Empty(t, updated.Errors)
+	assert.Equal(t, "King", dig(t, updated, "updateContact", "lastName"))
+	assert.Equal(t, "+441234567", dig(t, updated, "updateContact", "phone"))
+
+	deleted := env.do(t, auth.RoleEditor, `mutation { deleteContact(id: "1") }`, nil)
+	require.Empty(t, deleted.Errors)
+	assert.Equal(t, "1", dig(t, deleted, "deleteContact"))
+
+	require.Len(t, env.sink.Records(), 3)
+	assert.Equal(t, "add_contact", env.sink.Records()[0].Action)
+	assert.Equal(t, "req-1", env.sink.Records()[0].RequestID)
+	assert.Equal(t, "CN=editor", env.sink.Records()[0].Actor)
+	assert.Equal(t, "update_contact", env.sink.Records()[1].Action)
+	assert.Equal(t, "delete_contact", env.sink.Records()[2].Action)
+	assert.Equal(t, "success", env.sink.Records()[2].Outcome)
+}
+
+func TestBatchAndAddressBooks(t *testing.T) {
+	env := newEnv(t)
+
+	batch := env.do(t, auth.RoleEditor, `mutation($input: [ContactInput!]!) { createContacts(input: $input) { created { id } failures { index message } } }`,
+		map[string]any{"input": []any{
+			map[string]any{"firstName": "Ada", "phone": "+441234567"},
+			map[string]any{"firstName": "Bad", "phone": "nope"},
+		}})
+	require.Empty(t, batch.Errors)
+	assert.Len(t, dig(t, batch, "createContacts", "created"), 1)
+	failures := dig(t, batch, "createContacts", "failures").([]any)
+	require.Len(t, failures, 1)
+	assert.Equal(t, 1.0, failures[0].(map[string]any)["index"])
+
+	created := env.do(t, auth.RoleAdmin, `mutation { createAddressBook(name: "work") { id contacts { totalCount edges { cursor } } } }`, nil)
+	require.Empty(t, created.Errors)
+	assert.Equal(t, "2", dig(t, created, "createAddressBook", "id"))
+	assert.Equal(t, 0.0, dig(t, created, "createAddressBook", "contacts", "totalCount"))
+
+	list := env.do(t, auth.RoleReader, `{ addressBooks { name } }`, nil)
+	assert.Equal(t, []any{map[string]any{"name": "default"}, map[string]any{"name": "work"}}, dig(t, list, "addressBooks"))
+}
+
+func TestMutationRoles(t *testing.T) {
+	env := newEnv(t)
+
+	denied := env.do(t, auth.RoleReader, `mutation { deleteContact(id: "1") }`, nil)
+	require.Len(t, denied.Errors, 1)
+	assert.Contains(t, denied.Errors[0].Message, "requires the editor role")
+	assert.Equal(t, "/problems/forbidden", denied.Errors[0].Extensions["type"])
+	assert.Equal(t, 403.0, denied.Errors[0].Extensions["status"])
+
+	denied = env.do(t, auth.RoleEditor, `mutation { createAddressBook(name: "work") { id } }`, nil)
+	require.Len(t, denied.Errors, 1)
+	assert.Contains(t, denied.Errors[0].Message, "requires the admin role")
+
+	// Denied mutations are audited like on the REST routes
+	records := env.sink.Records()
+	require.Len(t, records, 2)
+	assert.Equal(t, "delete_contact", records[0].Action)
+	assert.Equal(t, "CN=reader", records[0].Actor)
+	assert.Equal(t, http.StatusForbidden, records[0].Status)
+	assert.Equal(t, "denied", records[0].Outcome)
+	assert.Equal(t, "add_address_book", records[1].Action)
+	assert.Equal(t, "denied", records[1].Outcome)
+}
+
+func TestErrors(t *testing.T) {
+	env := newEnv(t)
+
+	invalid := env.do(t, auth.RoleEditor, `mutation { createContact(input: {firstName: "Ada", phone: "12"}) { id } }`, nil)
+	require.Len(t, invalid.Errors, 1)
+	assert.Equal(t, "/problems/validation-failed", invalid.Errors[0].Extensions["type"])
+	assert.Equal(t, []any{"createContact"}, invalid.Errors[0].Path)
+	fields := invalid.Errors[0].Extensions["errors"].([]any)
+	assert.Equal(t, "phone", fields[0].(map[string]any)["field"])
+	require.Len(t, env.sink.Records(), 1)
+	assert.Equal(t, http.StatusBadRequest, env.sink.Records()[0].Status)
+	assert.Equal(t, "failure", env.sink.Records()[0].Outcome)
+
+	missing := env.do(t, auth.RoleReader, `{ contact(id: "42") { id } }`, nil)
+	require.Len(t, missing.Errors, 1)
+	assert.Equal(t, "/problems/contact-not-found", missing.Errors[0].Extensions["type"])
+	assert.Equal(t, map[string]any{"contact": nil}, missing.Data)
+
+	badID := env.do(t, auth.RoleReader, `{ contact(id: "abc") { id } }`, nil)
+	require.Len(t, badID.Errors, 1)
+	assert.Equal(t, "/problems/invalid-request", badID.Errors[0].Extensions["type"])
+
+	unknown := env.do(t, auth.RoleReader, `{ contact(id: "1") { nickname } }`, nil)
+	require.Len(t, unknown.Errors, 1)
+	assert.Nil(t, unknown.Data)
+
+	rr := httptest.NewRecorder()
+	env.handler.ServeHTTP(rr, httptest.NewRequest("POST", "/graphql", bytes.NewBufferString(`{"variables": {}}`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+}
+
+func TestCostLimits(t *testing.T) {
+	env := newEnv(t)
+	aliased := "mutation {"
+	for i := range contacts.MaxBatchSize + 1 {
+		aliased += fmt.Sprintf(` d%d: deleteContact(id: "%d")`, i, i+1)
+	}
+	aliased += " }"
+
+	cases := []struct {
+		name      string
+		query     string
+		variables map[string]any
+		cost      int
+		rejected  string
+	}{
+		// contacts and edges once, node and id for each of the 10 contacts of the default page
+		{name: "default page", query: `{ contacts { edges { node { id } } } }`, cost: 2 + 10*2},
+		{name: "page size from a variable", query: `query($n: Int) { contacts(first: $n) { totalCount edges { node { id } } } }`, variables: map[string]any{"n": 50}, cost: 3 + 50*2},
+		{name: "variable default", query: `query($n: Int = 20) { contacts(last: $n) { edges { cursor } } }`, cost: 2 + 20},
+		{name: "fragments", query: `{ ...all } fragment all on Query { contacts(first: 5) { edges { node { ...names } } } } fragment names on Contact { firstName lastName }`, cost: 2 + 5*3},
+		{name: "introspection is free", query: `{ __schema { types { name fields { name type { name ofType { name } } } } } }`, cost: 0},
+		{name: "page too large", query: `{ contacts(first: 101) { totalCount } }`, rejected: "between 0 and 100"},
+		{
+			name:     "nested connections",
+			query:    `{ addressBooks { contacts(first: 100) { edges { node { id mergeHistory { id } } } } } }`,
+			rejected: "more than the limit of 1000",
+		},
+		{
+			name:     "aliased mutations past the batch size",
+			query:    aliased,
+			rejected: "more than the limit of 20 for one request",
+		},
+		{
+			name:      "batch inputs count every contact",
+			query:     `mutation($input: [ContactInput!]!) { createContacts(input: $input) { created { id } } deleteContact(id: "1") }`,
+			variables: map[string]any{"input": make([]any, contacts.MaxBatchSize)},
+			rejected:  "make 21 changes",
+		},
+		{
+			name:     "too deep",
+			query:    `{ contact(id: "1") { addressBook { contacts { edges { node { addressBook { contacts { edges { node { addressBook { contacts { totalCount } } } } } } } } } } } }`,
+			rejected: "nested deeper than the limit of 10",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			response := env.do(t, auth.RoleEditor, tc.query, tc.variables)
+			if tc.rejected != "" {
+				require.Len(t, response.Errors, 1)
+				assert.Contains(t, response.Errors[0].Message, tc.rejected)
+				assert.Equal(t, "/problems/query-too-expensive", response.Errors[0].Extensions["type"])
+				assert.Nil(t, response.Data)
+				return
+			}
+			require.Empty(t, response.Errors)
+			assert.Equal(t, map[string]any{"requested": float64(tc.cost), "limit": 1000.0}, response.Extensions["cost"])
+		})
+	}
+}
+
+func TestWindow(t *testing.T) {
+	limits := DefaultLimits
+	cases := []struct {
+		name       string
+		args       map[string]any
+		start, end int
+	}{
+		{name: "default page", args: map[string]any{}, start: 0, end: 10},
+		{name: "first", args: map[string]any{"first": 5}, start: 0, end: 5},
+		{name: "first after", args: map[string]any{"first": 5, "after": encodeCursor(4)}, start: 5, end: 10},
+		{name: "last", args: map[string]any{"last": 5}, start: 20, end: 25},
+		{name: "last before", args: map[string]any{"last": 5, "before": encodeCursor(10)}, start: 5, end: 10},
+		{name: "after the end", args: map[string]any{"after": encodeCursor(30)}, start: 25, end: 25},
+		{name: "before the start", args: map[string]any{"last": 3, "before": encodeCursor(0)}, start: 0, end: 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			start, end, err := window(tc.args, 25, limits)
+			require.NoError(t, err)
+			assert.Equal(t, tc.start, start)
+			assert.Equal(t, tc.end, end)
+		})
+	}
+
+	_, _, err := window(map[string]any{"first": 101}, 25, limits)
+	assert.ErrorIs(t, err, contacts.ErrInvalidRequest)
+	_, _, err = window(map[string]any{"after": "not a cursor"}, 25, limits)
+	assert.ErrorIs(t, err, contacts.ErrInvalidRequest)
+
+	page := connection{contacts: make([]contacts.Contact, 5), start: 5, end: 10, total: 25}
+	info := page.pageInfo()
+	assert.True(t, info.hasNext)
+	assert.True(t, info.hasPrevious)
+	assert.Equal(t, encodeCursor(5), *info.start)
+	assert.Equal(t, encodeCursor(9), *info.end)
+	assert.Equal(t, encodeCursor(7), page.edges()[2].cursor)
+
+	offset, err := decodeCursor(encodeCursor(9))
+	require.NoError(t, err)
+	assert.Equal(t, 9, offset)
+}
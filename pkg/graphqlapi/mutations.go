@@ -0,0 +1,191 @@
+// Mutations, each one maps onto a repository method
+package graphqlapi
+
+import (
+	"context"
+	"fmt"
+	"golangphonebook/internal"
+	"golangphonebook/pkg/contacts"
+	"strconv"
+)
+
+type mutationFunc func(ctx context.Context, repo contacts.ContactRepository, args map[string]any) (any, error)
+
+// Optional ID of an address book, zero when it is left out
+func addressBookID(input map[string]any) (uint, error) {
+	value, ok := input["addressBookId"].(string)
+	if !ok {
+		return 0, nil
+	}
+	id, err := parseID(value)
+	return uint(id), err
+}
+
+func contactFromInput(input map[string]any) (contacts.Contact, error) {
+	var contact contacts.Contact
+	contact.FirstName, _ = input["firstName"].(string)
+	contact.LastName, _ = input["lastName"].(string)
+	contact.Phone, _ = input["phone"].(string)
+	contact.Address, _ = input["address"].(string)
+
+	bookID, err := addressBookID(input)
+	if err != nil {
+		return contact, err
+	}
+	contact.AddressBookID = bookID
+	if err := contact.Validate(); err != nil {
+		return contact, fmt.Errorf("invalid contact, first name and phone must be correctly defined: %w", err)
+	}
+	return contact, nil
+}
+
+func createContact(ctx context.Context, repo contacts.ContactRepository, args map[string]any) (any, error) {
+	defer internal.Timer("GraphQL createContact")()
+
+	input, _ := args["input"].(map[string]any)
+	contact, err := contactFromInput(input)
+	if err != nil {
+		return nil, err
+	}
+	created, err := repo.AddContact(ctx, contact)
+	if err != nil {
+		return nil, err
+	}
+	contacts.InvalidateCache(ctx)
+	return created, nil
+}
+
+func createContacts(ctx context.Context, repo contacts.ContactRepository, args map[string]any) (any, error) {
+	defer internal.Timer("GraphQL createContacts")()
+
+	inputs, _ := args["input"].([]any)
+	if len(inputs) > contacts.MaxBatchSize {
+		return nil, fmt.Errorf("cannot add more than %d contacts at a time: %w", contacts.MaxBatchSize, contacts.ErrTooMany)
+	}
+
+	var payload batchPayload
+	for i, value := range inputs {
+		input, _ := value.(map[string]any)
+		contact, err := contactFromInput(input)
+		if err == nil {
+			var created *contacts.Contact
+			if created, err = repo.AddContact(ctx, contact); err == nil {
+				payload.created = append(payload.created, *created)
+				continue
+			}
+		}
+		internal.Logger.WarnContext(ctx, fmt.Sprintf("Failed to add contact %d of the batch: %v", i, err))
+		payload.failures = append(payload.failures, batchFailure{index: i, message: err.Error()})
+	}
+
+	if len(payload.created) > 0 {
+		contacts.InvalidateCache(ctx)
+	}
+	internal.Logger.InfoContext(ctx, fmt.Sprintf("Successful: %d, Failed: %d", len(payload.created), len(payload.failures)))
+	contacts.RecordBatch(len(payload.created), len(payload.failures))
+	return payload, nil
+}
+
+// Change the fields in the input and keep the rest, like a merge patch
+func updateContact(ctx context.Context, repo contacts.ContactRepository, args map[string]any) (any, error) {
+	defer internal.Timer("GraphQL updateContact")()
+
+	id, err := parseID(args["id"])
+	if err != nil {
+		return nil, err
+	}
+	existing, err := repo.GetContact(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	replacement := *existing
+	input, _ := args["input"].(map[string]any)
+	fields := map[string]*string{
+		"firstName": &replacement.FirstName,
+		"lastName":  &replacement.LastName,
+		"phone":     &replacement.Phone,
+		"address":   &replacement.Address,
+	}
+	for name, target := range fields {
+		if value, ok := input[name]; ok {
+			// null clears the field
+			*target, _ = value.(string)
+		}
+	}
+	if replacement.AddressBookID, err = addressBookID(input); err != nil {
+		return nil, err
+	}
+	if err := replacement.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid contact, first name and phone must be correctly defined: %w", err)
+	}
+
+	updated, err := repo.ReplaceContact(ctx, id, replacement)
+	if err != nil {
+		return nil, err
+	}
+	contacts.InvalidateCache(ctx)
+	return updated, nil
+}
+
+func deleteContact(ctx context.Context, repo contacts.ContactRepository, args map[string]any) (any, error) {
+	defer internal.Timer("GraphQL deleteContact")()
+
+	id, err := parseID(args["id"])
+	if err != nil {
+		return nil, err
+	}
+	if err := repo.DeleteContact(ctx, id); err != nil {
+		return nil, err
+	}
+	contacts.InvalidateCache(ctx)
+	return strconv.Itoa(id), nil
+}
+
+func mergeContacts(ctx context.Context, repo contacts.ContactRepository, args map[string]any) (any, error) {
+	defer internal.Timer("GraphQL mergeContacts")()
+
+	input, _ := args["input"].(map[string]any)
+	request := contacts.MergeRequest{Rules: make(map[string]contacts.MergeStrategy), Overrides: make(map[string]string)}
+	ids, _ := input["ids"].([]any)
+	for _, value := range ids {
+		id, err := parseID(value)
+		if err != nil {
+			return nil, err
+		}
+		request.IDs = append(request.IDs, id)
+	}
+	rules, _ := input["rules"].([]any)
+	for _, value := range rules {
+		rule, _ := value.(map[string]any)
+		field, _ := rule["field"].(string)
+		request.Rules[field], _ = rule["strategy"].(contacts.MergeStrategy)
+	}
+	overrides, _ := input["overrides"].([]any)
+	for _, value := range overrides {
+		override, _ := value.(map[string]any)
+		field, _ := override["field"].(string)
+		request.Overrides[field], _ = override["value"].(string)
+	}
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+
+	merged, err := repo.MergeContacts(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	contacts.InvalidateCache(ctx)
+	return merged, nil
+}
+
+func createAddressBook(ctx context.Context, repo contacts.ContactRepository, args map[string]any) (any, error) {
+	defer internal.Timer("GraphQL createAddressBook")()
+
+	book := contacts.AddressBook{}
+	book.Name, _ = args["name"].(string)
+	if err := book.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid address book, name must be defined and at most 100 characters: %w", err)
+	}
+	return repo.CreateAddressBook(ctx, book.Name)
+}
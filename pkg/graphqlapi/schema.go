@@ -0,0 +1,483 @@
+// GraphQL schema over contacts, address books and merge history, resolved through the contact repository
+package graphqlapi
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"golangphonebook/internal"
+	"golangphonebook/pkg/auth"
+	"golangphonebook/pkg/contacts"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+)
+
+// Role each mutation needs, and the action it is audited as. Queries only need the reader role of the route.
+var mutationRules = map[string]struct {
+	role   auth.Role
+	action string
+}{
+	"createContact":     {auth.RoleEditor, "add_contact"},
+	"createContacts":    {auth.RoleEditor, "add_contacts"},
+	"updateContact":     {auth.RoleEditor, "update_contact"},
+	"deleteContact":     {auth.RoleEditor, "delete_contact"},
+	"mergeContacts":     {auth.RoleAdmin, "merge_contacts"},
+	"createAddressBook": {auth.RoleAdmin, "add_address_book"},
+}
+
+// Contacts of a page, with the offsets of the window they were cut from
+type connection struct {
+	contacts   []contacts.Contact
+	start, end int
+	total      int64
+}
+
+type edge struct {
+	cursor  string
+	contact contacts.Contact
+}
+
+type pageInfo struct {
+	hasNext, hasPrevious bool
+	start, end           *string
+}
+
+type batchFailure struct {
+	index   int
+	message string
+}
+
+type batchPayload struct {
+	created  []contacts.Contact
+	failures []batchFailure
+}
+
+// Cursors are opaque to clients, they are offsets into the sorted and filtered contacts
+func encodeCursor(offset int) string {
+	return base64.StdEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	raw, err := base64.StdEncoding.DecodeString(cursor)
+	if err == nil {
+		if value, ok := strings.CutPrefix(string(raw), "offset:"); ok {
+			if offset, err := strconv.Atoi(value); err == nil && offset >= 0 {
+				return offset, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("invalid cursor %q: %w", cursor, contacts.ErrInvalidRequest)
+}
+
+// Offsets of the contacts first, after, last and before select out of total, as the cursor connections spec slices them
+func window(args map[string]any, total int, limits Limits) (int, int, error) {
+	start, end := 0, total
+	if after, ok := args["after"].(string); ok {
+		offset, err := decodeCursor(after)
+		if err != nil {
+			return 0, 0, err
+		}
+		start = min(max(start, offset+1), end)
+	}
+	if before, ok := args["before"].(string); ok {
+		offset, err := decodeCursor(before)
+		if err != nil {
+			return 0, 0, err
+		}
+		end = max(min(end, offset), start)
+	}
+
+	first, hasFirst := args["first"].(int)
+	last, hasLast := args["last"].(int)
+	if !hasFirst && !hasLast {
+		first, hasFirst = limits.DefaultPageSize, true
+	}
+	for _, size := range []int{first, last} {
+		if size < 0 || size > limits.MaxPageSize {
+			return 0, 0, fmt.Errorf("first and last must be between 0 and %d: %w", limits.MaxPageSize, contacts.ErrInvalidRequest)
+		}
+	}
+	if hasFirst {
+		end = min(end, start+first)
+	}
+	if hasLast {
+		start = max(start, end-last)
+	}
+	return start, end, nil
+}
+
+func (c connection) edges() []edge {
+	edges := make([]edge, len(c.contacts))
+	for i, contact := range c.contacts {
+		edges[i] = edge{cursor: encodeCursor(c.start + i), contact: contact}
+	}
+	return edges
+}
+
+func (c connection) pageInfo() pageInfo {
+	info := pageInfo{hasNext: int64(c.end) < c.total, hasPrevious: c.start > 0}
+	if len(c.contacts) > 0 {
+		start, end := encodeCursor(c.start), encodeCursor(c.start+len(c.contacts)-1)
+		info.start, info.end = &start, &end
+	}
+	return info
+}
+
+// IDs are strings on the wire and ints in the repository
+func parseID(value any) (int, error) {
+	s, _ := value.(string)
+	id, err := strconv.Atoi(s)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid ID %q, IDs can only be positive integers: %w", s, contacts.ErrInvalidRequest)
+	}
+	return id, nil
+}
+
+var sortFields = graphql.NewEnum(graphql.EnumConfig{
+	Name: "ContactSortField",
+	Values: graphql.EnumValueConfigMap{
+		"FIRST_NAME":    {Value: contacts.SortByFirstName},
+		"LAST_NAME":     {Value: contacts.SortByLastName},
+		"LAST_MODIFIED": {Value: contacts.SortByLastModified},
+	},
+})
+
+var sortDirection = graphql.NewEnum(graphql.EnumConfig{
+	Name: "SortDirection",
+	Values: graphql.EnumValueConfigMap{
+		"ASC":  {Value: "ASC"},
+		"DESC": {Value: "DESC"},
+	},
+})
+
+var contactOrder = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "ContactOrder",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"field":     {Type: graphql.NewNonNull(sortFields)},
+		"direction": {Type: sortDirection, DefaultValue: "ASC"},
+	},
+})
+
+var contactFilter = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name:        "ContactFilter",
+	Description: "Names and addresses match when they contain the value, exactly when field encryption is on",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"firstName":     {Type: graphql.String},
+		"lastName":      {Type: graphql.String},
+		"address":       {Type: graphql.String},
+		"phone":         {Type: graphql.String},
+		"addressBookId": {Type: graphql.ID},
+	},
+})
+
+var contactInput = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "ContactInput",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"firstName":     {Type: graphql.NewNonNull(graphql.String)},
+		"lastName":      {Type: graphql.String},
+		"phone":         {Type: graphql.NewNonNull(graphql.String)},
+		"address":       {Type: graphql.String},
+		"addressBookId": {Type: graphql.ID, Description: "The tenant's default address book when left out"},
+	},
+})
+
+var contactPatch = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name:        "ContactPatch",
+	Description: "Fields left out keep their value",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"firstName":     {Type: graphql.String},
+		"lastName":      {Type: graphql.String},
+		"phone":         {Type: graphql.String},
+		"address":       {Type: graphql.String},
+		"addressBookId": {Type: graphql.ID},
+	},
+})
+
+var mergeStrategy = graphql.NewEnum(graphql.EnumConfig{
+	Name: "MergeStrategy",
+	Values: graphql.EnumValueConfigMap{
+		"PRIMARY": {Value: contacts.MergeKeepPrimary},
+		"NEWEST":  {Value: contacts.MergeKeepNewest},
+		"OLDEST":  {Value: contacts.MergeKeepOldest},
+		"LONGEST": {Value: contacts.MergeKeepLongest},
+	},
+})
+
+var mergeInput = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "MergeInput",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"ids": {Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.ID))), Description: "Contacts to merge, the first one survives"},
+		"rules": {Type: graphql.NewList(graphql.NewNonNull(graphql.NewInputObject(graphql.InputObjectConfig{
+			Name: "MergeRule",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"field":    {Type: graphql.NewNonNull(graphql.String), Description: "Like first_name or address"},
+				"strategy": {Type: graphql.NewNonNull(mergeStrategy)},
+			},
+		})))},
+		"overrides": {Type: graphql.NewList(graphql.NewNonNull(graphql.NewInputObject(graphql.InputObjectConfig{
+			Name: "MergeOverride",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"field": {Type: graphql.NewNonNull(graphql.String)},
+				"value": {Type: graphql.NewNonNull(graphql.String)},
+			},
+		})))},
+	},
+})
+
+// Value a field belongs to, resolvers return values or pointers to them
+func sourceOf[T any](p graphql.ResolveParams) T {
+	if ptr, ok := p.Source.(*T); ok {
+		return *ptr
+	}
+	return p.Source.(T)
+}
+
+// Field read off a value of type T
+func field[T any](t graphql.Output, value func(T) any) *graphql.Field {
+	return &graphql.Field{Type: t, Resolve: func(p graphql.ResolveParams) (any, error) {
+		return value(sourceOf[T](p)), nil
+	}}
+}
+
+func id(value uint) string {
+	return strconv.FormatUint(uint64(value), 10)
+}
+
+// Arguments of every contacts connection
+func connectionArgs() graphql.FieldConfigArgument {
+	return graphql.FieldConfigArgument{
+		"first":   {Type: graphql.Int},
+		"after":   {Type: graphql.String},
+		"last":    {Type: graphql.Int},
+		"before":  {Type: graphql.String},
+		"filter":  {Type: contactFilter},
+		"orderBy": {Type: contactOrder},
+	}
+}
+
+func (h *Handler) buildSchema() (graphql.Schema, error) {
+	pageInfoType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PageInfo",
+		Fields: graphql.Fields{
+			"hasNextPage":     field(graphql.NewNonNull(graphql.Boolean), func(p pageInfo) any { return p.hasNext }),
+			"hasPreviousPage": field(graphql.NewNonNull(graphql.Boolean), func(p pageInfo) any { return p.hasPrevious }),
+			"startCursor":     field(graphql.String, func(p pageInfo) any { return p.start }),
+			"endCursor":       field(graphql.String, func(p pageInfo) any { return p.end }),
+		},
+	})
+
+	mergeRecordType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "MergeRecord",
+		Description: "A contact as it was right before it was merged into another one",
+		Fields: graphql.Fields{
+			"id":                   field(graphql.NewNonNull(graphql.ID), func(m contacts.MergeRecord) any { return id(m.ID) }),
+			"mergedId":             field(graphql.NewNonNull(graphql.ID), func(m contacts.MergeRecord) any { return id(m.MergedID) }),
+			"firstName":            field(graphql.NewNonNull(graphql.String), func(m contacts.MergeRecord) any { return m.FirstName }),
+			"lastName":             field(graphql.NewNonNull(graphql.String), func(m contacts.MergeRecord) any { return m.LastName }),
+			"phone":                field(graphql.NewNonNull(graphql.String), func(m contacts.MergeRecord) any { return m.Phone }),
+			"address":              field(graphql.NewNonNull(graphql.String), func(m contacts.MergeRecord) any { return m.Address }),
+			"originalLastModified": field(graphql.DateTime, func(m contacts.MergeRecord) any { return m.OriginalLastModified }),
+			"mergedAt":             field(graphql.DateTime, func(m contacts.MergeRecord) any { return m.MergedAt }),
+		},
+	})
+
+	// Contacts and address books refer to each other, so their fields are filled in once both exist
+	var connectionType *graphql.Object
+	addressBookType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "AddressBook",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":        field(graphql.NewNonNull(graphql.ID), func(b contacts.AddressBook) any { return id(b.ID) }),
+				"name":      field(graphql.NewNonNull(graphql.String), func(b contacts.AddressBook) any { return b.Name }),
+				"createdAt": field(graphql.DateTime, func(b contacts.AddressBook) any { return b.CreatedAt }),
+				"contacts": {
+					Type:        graphql.NewNonNull(connectionType),
+					Description: "Contacts in the address book",
+					Args:        connectionArgs(),
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						return h.contacts(p.Context, p.Args, id(sourceOf[contacts.AddressBook](p).ID))
+					},
+				},
+			}
+		}),
+	})
+
+	contactType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Contact",
+		Fields: graphql.Fields{
+			"id":           field(graphql.NewNonNull(graphql.ID), func(c contacts.Contact) any { return id(c.ID) }),
+			"firstName":    field(graphql.NewNonNull(graphql.String), func(c contacts.Contact) any { return c.FirstName }),
+			"lastName":     field(graphql.NewNonNull(graphql.String), func(c contacts.Contact) any { return c.LastName }),
+			"phone":        field(graphql.NewNonNull(graphql.String), func(c contacts.Contact) any { return c.Phone }),
+			"address":      field(graphql.NewNonNull(graphql.String), func(c contacts.Contact) any { return c.Address }),
+			"lastModified": field(graphql.DateTime, func(c contacts.Contact) any { return c.LastModified }),
+			"addressBook": {
+				Type: addressBookType,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return requestFrom(p.Context).addressBook(p.Context, sourceOf[contacts.Contact](p).AddressBookID)
+				},
+			},
+			"mergeHistory": {
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(mergeRecordType))),
+				Description: "Contacts that were merged into this one",
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return requestFrom(p.Context).repo.GetMergeHistory(p.Context, int(sourceOf[contacts.Contact](p).ID))
+				},
+			},
+		},
+	})
+
+	edgeType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ContactEdge",
+		Fields: graphql.Fields{
+			"cursor": field(graphql.NewNonNull(graphql.String), func(e edge) any { return e.cursor }),
+			"node":   field(graphql.NewNonNull(contactType), func(e edge) any { return e.contact }),
+		},
+	})
+
+	connectionType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "ContactConnection",
+		Fields: graphql.Fields{
+			"edges":      field(graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(edgeType))), func(c connection) any { return c.edges() }),
+			"pageInfo":   field(graphql.NewNonNull(pageInfoType), func(c connection) any { return c.pageInfo() }),
+			"totalCount": field(graphql.NewNonNull(graphql.Int), func(c connection) any { return c.total }),
+		},
+	})
+
+	batchType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "CreateContactsPayload",
+		Fields: graphql.Fields{
+			"created": field(graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(contactType))), func(b batchPayload) any { return b.created }),
+			"failures": field(graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.NewObject(graphql.ObjectConfig{
+				Name: "BatchFailure",
+				Fields: graphql.Fields{
+					"index":   field(graphql.NewNonNull(graphql.Int), func(f batchFailure) any { return f.index }),
+					"message": field(graphql.NewNonNull(graphql.String), func(f batchFailure) any { return f.message }),
+				},
+			})))), func(b batchPayload) any { return b.failures }),
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"contact": {
+				Type: contactType,
+				Args: graphql.FieldConfigArgument{"id": {Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					id, err := parseID(p.Args["id"])
+					if err != nil {
+						return nil, err
+					}
+					return requestFrom(p.Context).repo.GetContact(p.Context, id)
+				},
+			},
+			"contacts": {
+				Type: graphql.NewNonNull(connectionType),
+				Args: connectionArgs(),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return h.contacts(p.Context, p.Args, "")
+				},
+			},
+			"addressBooks": {
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(addressBookType))),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return requestFrom(p.Context).addressBooks(p.Context)
+				},
+			},
+			"addressBook": {
+				Type: addressBookType,
+				Args: graphql.FieldConfigArgument{"id": {Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					id, err := parseID(p.Args["id"])
+					if err != nil {
+						return nil, err
+					}
+					return requestFrom(p.Context).addressBook(p.Context, uint(id))
+				},
+			},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createContact": {
+				Type:    graphql.NewNonNull(contactType),
+				Args:    graphql.FieldConfigArgument{"input": {Type: graphql.NewNonNull(contactInput)}},
+				Resolve: h.mutation("createContact", createContact),
+			},
+			"createContacts": {
+				Type:        graphql.NewNonNull(batchType),
+				Description: fmt.Sprintf("Add up to %d contacts, each on its own", contacts.MaxBatchSize),
+				Args:        graphql.FieldConfigArgument{"input": {Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(contactInput)))}},
+				Resolve:     h.mutation("createContacts", createContacts),
+			},
+			"updateContact": {
+				Type:    graphql.NewNonNull(contactType),
+				Args:    graphql.FieldConfigArgument{"id": {Type: graphql.NewNonNull(graphql.ID)}, "input": {Type: graphql.NewNonNull(contactPatch)}},
+				Resolve: h.mutation("updateContact", updateContact),
+			},
+			"deleteContact": {
+				Type:        graphql.NewNonNull(graphql.ID),
+				Description: "Returns the ID of the deleted contact",
+				Args:        graphql.FieldConfigArgument{"id": {Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve:     h.mutation("deleteContact", deleteContact),
+			},
+			"mergeContacts": {
+				Type:    graphql.NewNonNull(contactType),
+				Args:    graphql.FieldConfigArgument{"input": {Type: graphql.NewNonNull(mergeInput)}},
+				Resolve: h.mutation("mergeContacts", mergeContacts),
+			},
+			"createAddressBook": {
+				Type:    graphql.NewNonNull(addressBookType),
+				Args:    graphql.FieldConfigArgument{"name": {Type: graphql.NewNonNull(graphql.String)}},
+				Resolve: h.mutation("createAddressBook", createAddressBook),
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+}
+
+// A page of the contacts connection, limited to one address book when book is set
+func (h *Handler) contacts(ctx context.Context, args map[string]any, book string) (connection, error) {
+	params := contacts.ListParams{AddressBook: book}
+	if filter, ok := args["filter"].(map[string]any); ok {
+		params.FirstName, _ = filter["firstName"].(string)
+		params.LastName, _ = filter["lastName"].(string)
+		params.Address, _ = filter["address"].(string)
+		params.Phone, _ = filter["phone"].(string)
+		if filterBook, ok := filter["addressBookId"].(string); ok && book == "" {
+			params.AddressBook = filterBook
+		}
+	}
+	order := "ASC"
+	if orderBy, ok := args["orderBy"].(map[string]any); ok {
+		params.SortBy, _ = orderBy["field"].(contacts.SortBy)
+		order, _ = orderBy["direction"].(string)
+	}
+
+	query, total, err := requestFrom(ctx).repo.FilterContacts(ctx, params.Filters())
+	if err != nil {
+		return connection{}, err
+	}
+	start, end, err := window(args, int(total), h.Limits)
+	if err != nil {
+		return connection{}, err
+	}
+
+	page := connection{start: start, end: end, total: total}
+	if end > start {
+		// IDs break ties, so a contact is on exactly one page
+		err = query.WithContext(ctx).Order(contacts.SortColumn(params.SortBy) + " " + order).Order("id " + order).
+			Offset(start).Limit(end - start).Find(&page.contacts).Error
+		if err != nil {
+			internal.Logger.ErrorContext(ctx, fmt.Sprintf("Failed to load contacts %d to %d: %v", start, end, err))
+			return connection{}, err
+		}
+	}
+	return page, nil
+}
@@ -0,0 +1,929 @@
+// gRPC API for contacts, on the same repository, roles, tenants and audit log as the REST API
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        (unknown)
+// source: phonebook/contacts/v1/contacts.proto
+
+package contactsv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+	fieldmaskpb "google.golang.org/protobuf/types/known/fieldmaskpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type SortBy int32
+
+const (
+	SortBy_SORT_BY_UNSPECIFIED   SortBy = 0 // First name
+	SortBy_SORT_BY_FIRST_NAME    SortBy = 1
+	SortBy_SORT_BY_LAST_NAME     SortBy = 2
+	SortBy_SORT_BY_LAST_MODIFIED SortBy = 3 // Always newest first
+)
+
+// Enum value maps for SortBy.
+var (
+	SortBy_name = map[int32]string{
+		0: "SORT_BY_UNSPECIFIED",
+		1: "SORT_BY_FIRST_NAME",
+		2: "SORT_BY_LAST_NAME",
+		3: "SORT_BY_LAST_MODIFIED",
+	}
+	SortBy_value = map[string]int32{
+		"SORT_BY_UNSPECIFIED":   0,
+		"SORT_BY_FIRST_NAME":    1,
+		"SORT_BY_LAST_NAME":     2,
+		"SORT_BY_LAST_MODIFIED": 3,
+	}
+)
+
+func (x SortBy) Enum() *SortBy {
+	p := new(SortBy)
+	*p = x
+	return p
+}
+
+func (x SortBy) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (SortBy) Descriptor() protoreflect.EnumDescriptor {
+	return file_phonebook_contacts_v1_contacts_proto_enumTypes[0].Descriptor()
+}
+
+func (SortBy) Type() protoreflect.EnumType {
+	return &file_phonebook_contacts_v1_contacts_proto_enumTypes[0]
+}
+
+func (x SortBy) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use SortBy.Descriptor instead.
+func (SortBy) EnumDescriptor() ([]byte, []int) {
+	return file_phonebook_contacts_v1_contacts_proto_rawDescGZIP(), []int{0}
+}
+
+type Contact struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            uint64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`                               // Assigned by the server, ignored on create
+	FirstName     string                 `protobuf:"bytes,2,opt,name=first_name,json=firstName,proto3" json:"first_name,omitempty"` // Required, at most 50 characters
+	LastName      string                 `protobuf:"bytes,3,opt,name=last_name,json=lastName,proto3" json:"last_name,omitempty"`    // At most 50 characters
+	Phone         string                 `protobuf:"bytes,4,opt,name=phone,proto3" json:"phone,omitempty"`                          // Required, an optional + followed by 4 to 20 digits
+	Address       string                 `protobuf:"bytes,5,opt,name=address,proto3" json:"address,omitempty"`
+	LastModified  *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=last_modified,json=lastModified,proto3" json:"last_modified,omitempty"`       // Set by the server
+	AddressBookId uint64                 `protobuf:"varint,7,opt,name=address_book_id,json=addressBookId,proto3" json:"address_book_id,omitempty"` // The tenant's default address book when 0
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Contact) Reset() {
+	*x = Contact{}
+	mi := &file_phonebook_contacts_v1_contacts_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Contact) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Contact) ProtoMessage() {}
+
+func (x *Contact) ProtoReflect() protoreflect.Message {
+	mi := &file_phonebook_contacts_v1_contacts_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Contact.ProtoReflect.Descriptor instead.
+func (*Contact) Descriptor() ([]byte, []int) {
+	return file_phonebook_contacts_v1_contacts_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Contact) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Contact) GetFirstName() string {
+	if x != nil {
+		return x.FirstName
+	}
+	return ""
+}
+
+func (x *Contact) GetLastName() string {
+	if x != nil {
+		return x.LastName
+	}
+	return ""
+}
+
+func (x *Contact) GetPhone() string {
+	if x != nil {
+		return x.Phone
+	}
+	return ""
+}
+
+func (x *Contact) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *Contact) GetLastModified() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastModified
+	}
+	return nil
+}
+
+func (x *Contact) GetAddressBookId() uint64 {
+	if x != nil {
+		return x.AddressBookId
+	}
+	return 0
+}
+
+type PaginatedContacts struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Contacts      []*Contact             `protobuf:"bytes,1,rep,name=contacts,proto3" json:"contacts,omitempty"`
+	TotalPages    int32                  `protobuf:"varint,2,opt,name=total_pages,json=totalPages,proto3" json:"total_pages,omitempty"`
+	CurrentPage   int32                  `protobuf:"varint,3,opt,name=current_page,json=currentPage,proto3" json:"current_page,omitempty"`
+	TotalCount    int64                  `protobuf:"varint,4,opt,name=total_count,json=totalCount,proto3" json:"total_count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PaginatedContacts) Reset() {
+	*x = PaginatedContacts{}
+	mi := &file_phonebook_contacts_v1_contacts_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PaginatedContacts) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PaginatedContacts) ProtoMessage() {}
+
+func (x *PaginatedContacts) ProtoReflect() protoreflect.Message {
+	mi := &file_phonebook_contacts_v1_contacts_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PaginatedContacts.ProtoReflect.Descriptor instead.
+func (*PaginatedContacts) Descriptor() ([]byte, []int) {
+	return file_phonebook_contacts_v1_contacts_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *PaginatedContacts) GetContacts() []*Contact {
+	if x != nil {
+		return x.Contacts
+	}
+	return nil
+}
+
+func (x *PaginatedContacts) GetTotalPages() int32 {
+	if x != nil {
+		return x.TotalPages
+	}
+	return 0
+}
+
+func (x *PaginatedContacts) GetCurrentPage() int32 {
+	if x != nil {
+		return x.CurrentPage
+	}
+	return 0
+}
+
+func (x *PaginatedContacts) GetTotalCount() int64 {
+	if x != nil {
+		return x.TotalCount
+	}
+	return 0
+}
+
+type ContactFilter struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	FirstName     string                 `protobuf:"bytes,1,opt,name=first_name,json=firstName,proto3" json:"first_name,omitempty"`
+	LastName      string                 `protobuf:"bytes,2,opt,name=last_name,json=lastName,proto3" json:"last_name,omitempty"`
+	Address       string                 `protobuf:"bytes,3,opt,name=address,proto3" json:"address,omitempty"`
+	Phone         string                 `protobuf:"bytes,4,opt,name=phone,proto3" json:"phone,omitempty"`
+	AddressBookId uint64                 `protobuf:"varint,5,opt,name=address_book_id,json=addressBookId,proto3" json:"address_book_id,omitempty"` // Any address book of the tenant when 0
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ContactFilter) Reset() {
+	*x = ContactFilter{}
+	mi := &file_phonebook_contacts_v1_contacts_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ContactFilter) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ContactFilter) ProtoMessage() {}
+
+func (x *ContactFilter) ProtoReflect() protoreflect.Message {
+	mi := &file_phonebook_contacts_v1_contacts_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ContactFilter.ProtoReflect.Descriptor instead.
+func (*ContactFilter) Descriptor() ([]byte, []int) {
+	return file_phonebook_contacts_v1_contacts_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ContactFilter) GetFirstName() string {
+	if x != nil {
+		return x.FirstName
+	}
+	return ""
+}
+
+func (x *ContactFilter) GetLastName() string {
+	if x != nil {
+		return x.LastName
+	}
+	return ""
+}
+
+func (x *ContactFilter) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *ContactFilter) GetPhone() string {
+	if x != nil {
+		return x.Phone
+	}
+	return ""
+}
+
+func (x *ContactFilter) GetAddressBookId() uint64 {
+	if x != nil {
+		return x.AddressBookId
+	}
+	return 0
+}
+
+type CreateContactRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Contact       *Contact               `protobuf:"bytes,1,opt,name=contact,proto3" json:"contact,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateContactRequest) Reset() {
+	*x = CreateContactRequest{}
+	mi := &file_phonebook_contacts_v1_contacts_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateContactRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateContactRequest) ProtoMessage() {}
+
+func (x *CreateContactRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_phonebook_contacts_v1_contacts_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateContactRequest.ProtoReflect.Descriptor instead.
+func (*CreateContactRequest) Descriptor() ([]byte, []int) {
+	return file_phonebook_contacts_v1_contacts_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *CreateContactRequest) GetContact() *Contact {
+	if x != nil {
+		return x.Contact
+	}
+	return nil
+}
+
+type BatchCreateContactsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Contacts      []*Contact             `protobuf:"bytes,1,rep,name=contacts,proto3" json:"contacts,omitempty"` // At most 20
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchCreateContactsRequest) Reset() {
+	*x = BatchCreateContactsRequest{}
+	mi := &file_phonebook_contacts_v1_contacts_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchCreateContactsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchCreateContactsRequest) ProtoMessage() {}
+
+func (x *BatchCreateContactsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_phonebook_contacts_v1_contacts_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchCreateContactsRequest.ProtoReflect.Descriptor instead.
+func (*BatchCreateContactsRequest) Descriptor() ([]byte, []int) {
+	return file_phonebook_contacts_v1_contacts_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *BatchCreateContactsRequest) GetContacts() []*Contact {
+	if x != nil {
+		return x.Contacts
+	}
+	return nil
+}
+
+type BatchCreateContactsResponse struct {
+	state         protoimpl.MessageState                 `protogen:"open.v1"`
+	Created       []*Contact                             `protobuf:"bytes,1,rep,name=created,proto3" json:"created,omitempty"`
+	Failures      []*BatchCreateContactsResponse_Failure `protobuf:"bytes,2,rep,name=failures,proto3" json:"failures,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchCreateContactsResponse) Reset() {
+	*x = BatchCreateContactsResponse{}
+	mi := &file_phonebook_contacts_v1_contacts_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchCreateContactsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchCreateContactsResponse) ProtoMessage() {}
+
+func (x *BatchCreateContactsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_phonebook_contacts_v1_contacts_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchCreateContactsResponse.ProtoReflect.Descriptor instead.
+func (*BatchCreateContactsResponse) Descriptor() ([]byte, []int) {
+	return file_phonebook_contacts_v1_contacts_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *BatchCreateContactsResponse) GetCreated() []*Contact {
+	if x != nil {
+		return x.Created
+	}
+	return nil
+}
+
+func (x *BatchCreateContactsResponse) GetFailures() []*BatchCreateContactsResponse_Failure {
+	if x != nil {
+		return x.Failures
+	}
+	return nil
+}
+
+type ListContactsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Filter        *ContactFilter         `protobuf:"bytes,1,opt,name=filter,proto3" json:"filter,omitempty"`
+	SortBy        SortBy                 `protobuf:"varint,2,opt,name=sort_by,json=sortBy,proto3,enum=phonebook.contacts.v1.SortBy" json:"sort_by,omitempty"`
+	Descending    bool                   `protobuf:"varint,3,opt,name=descending,proto3" json:"descending,omitempty"`
+	Page          int32                  `protobuf:"varint,4,opt,name=page,proto3" json:"page,omitempty"` // Starting at 1, out of range pages return the first page
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListContactsRequest) Reset() {
+	*x = ListContactsRequest{}
+	mi := &file_phonebook_contacts_v1_contacts_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListContactsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListContactsRequest) ProtoMessage() {}
+
+func (x *ListContactsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_phonebook_contacts_v1_contacts_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListContactsRequest.ProtoReflect.Descriptor instead.
+func (*ListContactsRequest) Descriptor() ([]byte, []int) {
+	return file_phonebook_contacts_v1_contacts_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ListContactsRequest) GetFilter() *ContactFilter {
+	if x != nil {
+		return x.Filter
+	}
+	return nil
+}
+
+func (x *ListContactsRequest) GetSortBy() SortBy {
+	if x != nil {
+		return x.SortBy
+	}
+	return SortBy_SORT_BY_UNSPECIFIED
+}
+
+func (x *ListContactsRequest) GetDescending() bool {
+	if x != nil {
+		return x.Descending
+	}
+	return false
+}
+
+func (x *ListContactsRequest) GetPage() int32 {
+	if x != nil {
+		return x.Page
+	}
+	return 0
+}
+
+type GetContactRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            uint64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetContactRequest) Reset() {
+	*x = GetContactRequest{}
+	mi := &file_phonebook_contacts_v1_contacts_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetContactRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetContactRequest) ProtoMessage() {}
+
+func (x *GetContactRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_phonebook_contacts_v1_contacts_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetContactRequest.ProtoReflect.Descriptor instead.
+func (*GetContactRequest) Descriptor() ([]byte, []int) {
+	return file_phonebook_contacts_v1_contacts_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *GetContactRequest) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+type UpdateContactRequest struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Id      uint64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Contact *Contact               `protobuf:"bytes,2,opt,name=contact,proto3" json:"contact,omitempty"`
+	// Fields to change, like a PATCH. Every field is replaced when it's empty, like a PUT.
+	UpdateMask    *fieldmaskpb.FieldMask `protobuf:"bytes,3,opt,name=update_mask,json=updateMask,proto3" json:"update_mask,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateContactRequest) Reset() {
+	*x = UpdateContactRequest{}
+	mi := &file_phonebook_contacts_v1_contacts_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateContactRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateContactRequest) ProtoMessage() {}
+
+func (x *UpdateContactRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_phonebook_contacts_v1_contacts_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateContactRequest.ProtoReflect.Descriptor instead.
+func (*UpdateContactRequest) Descriptor() ([]byte, []int) {
+	return file_phonebook_contacts_v1_contacts_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *UpdateContactRequest) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *UpdateContactRequest) GetContact() *Contact {
+	if x != nil {
+		return x.Contact
+	}
+	return nil
+}
+
+func (x *UpdateContactRequest) GetUpdateMask() *fieldmaskpb.FieldMask {
+	if x != nil {
+		return x.UpdateMask
+	}
+	return nil
+}
+
+type DeleteContactRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            uint64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteContactRequest) Reset() {
+	*x = DeleteContactRequest{}
+	mi := &file_phonebook_contacts_v1_contacts_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteContactRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteContactRequest) ProtoMessage() {}
+
+func (x *DeleteContactRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_phonebook_contacts_v1_contacts_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteContactRequest.ProtoReflect.Descriptor instead.
+func (*DeleteContactRequest) Descriptor() ([]byte, []int) {
+	return file_phonebook_contacts_v1_contacts_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *DeleteContactRequest) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+type ExportContactsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Filter        *ContactFilter         `protobuf:"bytes,1,opt,name=filter,proto3" json:"filter,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExportContactsRequest) Reset() {
+	*x = ExportContactsRequest{}
+	mi := &file_phonebook_contacts_v1_contacts_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExportContactsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExportContactsRequest) ProtoMessage() {}
+
+func (x *ExportContactsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_phonebook_contacts_v1_contacts_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExportContactsRequest.ProtoReflect.Descriptor instead.
+func (*ExportContactsRequest) Descriptor() ([]byte, []int) {
+	return file_phonebook_contacts_v1_contacts_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ExportContactsRequest) GetFilter() *ContactFilter {
+	if x != nil {
+		return x.Filter
+	}
+	return nil
+}
+
+type BatchCreateContactsResponse_Failure struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Index         int32                  `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"` // Position of the contact in the request
+	Error         string                 `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchCreateContactsResponse_Failure) Reset() {
+	*x = BatchCreateContactsResponse_Failure{}
+	mi := &file_phonebook_contacts_v1_contacts_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchCreateContactsResponse_Failure) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchCreateContactsResponse_Failure) ProtoMessage() {}
+
+func (x *BatchCreateContactsResponse_Failure) ProtoReflect() protoreflect.Message {
+	mi := &file_phonebook_contacts_v1_contacts_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchCreateContactsResponse_Failure.ProtoReflect.Descriptor instead.
+func (*BatchCreateContactsResponse_Failure) Descriptor() ([]byte, []int) {
+	return file_phonebook_contacts_v1_contacts_proto_rawDescGZIP(), []int{5, 0}
+}
+
+func (x *BatchCreateContactsResponse_Failure) GetIndex() int32 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *BatchCreateContactsResponse_Failure) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+var File_phonebook_contacts_v1_contacts_proto protoreflect.FileDescriptor
+
+const file_phonebook_contacts_v1_contacts_proto_rawDesc = "" +
+	"\n" +
+	"$phonebook/contacts/v1/contacts.proto\x12\x15phonebook.contacts.v1\x1a\x1bgoogle/protobuf/empty.proto\x1a google/protobuf/field_mask.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\xee\x01\n" +
+	"\aContact\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\x12\x1d\n" +
+	"\n" +
+	"first_name\x18\x02 \x01(\tR\tfirstName\x12\x1b\n" +
+	"\tlast_name\x18\x03 \x01(\tR\blastName\x12\x14\n" +
+	"\x05phone\x18\x04 \x01(\tR\x05phone\x12\x18\n" +
+	"\aaddress\x18\x05 \x01(\tR\aaddress\x12?\n" +
+	"\rlast_modified\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\flastModified\x12&\n" +
+	"\x0faddress_book_id\x18\a \x01(\x04R\raddressBookId\"\xb4\x01\n" +
+	"\x11PaginatedContacts\x12:\n" +
+	"\bcontacts\x18\x01 \x03(\v2\x1e.phonebook.contacts.v1.ContactR\bcontacts\x12\x1f\n" +
+	"\vtotal_pages\x18\x02 \x01(\x05R\n" +
+	"totalPages\x12!\n" +
+	"\fcurrent_page\x18\x03 \x01(\x05R\vcurrentPage\x12\x1f\n" +
+	"\vtotal_count\x18\x04 \x01(\x03R\n" +
+	"totalCount\"\xa3\x01\n" +
+	"\rContactFilter\x12\x1d\n" +
+	"\n" +
+	"first_name\x18\x01 \x01(\tR\tfirstName\x12\x1b\n" +
+	"\tlast_name\x18\x02 \x01(\tR\blastName\x12\x18\n" +
+	"\aaddress\x18\x03 \x01(\tR\aaddress\x12\x14\n" +
+	"\x05phone\x18\x04 \x01(\tR\x05phone\x12&\n" +
+	"\x0faddress_book_id\x18\x05 \x01(\x04R\raddressBookId\"P\n" +
+	"\x14CreateContactRequest\x128\n" +
+	"\acontact\x18\x01 \x01(\v2\x1e.phonebook.contacts.v1.ContactR\acontact\"X\n" +
+	"\x1aBatchCreateContactsRequest\x12:\n" +
+	"\bcontacts\x18\x01 \x03(\v2\x1e.phonebook.contacts.v1.ContactR\bcontacts\"\xe6\x01\n" +
+	"\x1bBatchCreateContactsResponse\x128\n" +
+	"\acreated\x18\x01 \x03(\v2\x1e.phonebook.contacts.v1.ContactR\acreated\x12V\n" +
+	"\bfailures\x18\x02 \x03(\v2:.phonebook.contacts.v1.BatchCreateContactsResponse.FailureR\bfailures\x1a5\n" +
+	"\aFailure\x12\x14\n" +
+	"\x05index\x18\x01 \x01(\x05R\x05index\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error\"\xbf\x01\n" +
+	"\x13ListContactsRequest\x12<\n" +
+	"\x06filter\x18\x01 \x01(\v2$.phonebook.contacts.v1.ContactFilterR\x06filter\x126\n" +
+	"\asort_by\x18\x02 \x01(\x0e2\x1d.phonebook.contacts.v1.SortByR\x06sortBy\x12\x1e\n" +
+	"\n" +
+	"descending\x18\x03 \x01(\bR\n" +
+	"descending\x12\x12\n" +
+	"\x04page\x18\x04 \x01(\x05R\x04page\"#\n" +
+	"\x11GetContactRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\"\x9d\x01\n" +
+	"\x14UpdateContactRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\x128\n" +
+	"\acontact\x18\x02 \x01(\v2\x1e.phonebook.contacts.v1.ContactR\acontact\x12;\n" +
+	"\vupdate_mask\x18\x03 \x01(\v2\x1a.google.protobuf.FieldMaskR\n" +
+	"updateMask\"&\n" +
+	"\x14DeleteContactRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\"U\n" +
+	"\x15ExportContactsRequest\x12<\n" +
+	"\x06filter\x18\x01 \x01(\v2$.phonebook.contacts.v1.ContactFilterR\x06filter*k\n" +
+	"\x06SortBy\x12\x17\n" +
+	"\x13SORT_BY_UNSPECIFIED\x10\x00\x12\x16\n" +
+	"\x12SORT_BY_FIRST_NAME\x10\x01\x12\x15\n" +
+	"\x11SORT_BY_LAST_NAME\x10\x02\x12\x19\n" +
+	"\x15SORT_BY_LAST_MODIFIED\x10\x032\xc0\x05\n" +
+	"\x0eContactService\x12\\\n" +
+	"\rCreateContact\x12+.phonebook.contacts.v1.CreateContactRequest\x1a\x1e.phonebook.contacts.v1.Contact\x12|\n" +
+	"\x13BatchCreateContacts\x121.phonebook.contacts.v1.BatchCreateContactsRequest\x1a2.phonebook.contacts.v1.BatchCreateContactsResponse\x12d\n" +
+	"\fListContacts\x12*.phonebook.contacts.v1.ListContactsRequest\x1a(.phonebook.contacts.v1.PaginatedContacts\x12V\n" +
+	"\n" +
+	"GetContact\x12(.phonebook.contacts.v1.GetContactRequest\x1a\x1e.phonebook.contacts.v1.Contact\x12\\\n" +
+	"\rUpdateContact\x12+.phonebook.contacts.v1.UpdateContactRequest\x1a\x1e.phonebook.contacts.v1.Contact\x12T\n" +
+	"\rDeleteContact\x12+.phonebook.contacts.v1.DeleteContactRequest\x1a\x16.google.protobuf.Empty\x12`\n" +
+	"\x0eExportContacts\x12,.phonebook.contacts.v1.ExportContactsRequest\x1a\x1e.phonebook.contacts.v1.Contact0\x01B3Z1golangphonebook/pkg/grpcapi/contactsv1;contactsv1b\x06proto3"
+
+var (
+	file_phonebook_contacts_v1_contacts_proto_rawDescOnce sync.Once
+	file_phonebook_contacts_v1_contacts_proto_rawDescData []byte
+)
+
+func file_phonebook_contacts_v1_contacts_proto_rawDescGZIP() []byte {
+	file_phonebook_contacts_v1_contacts_proto_rawDescOnce.Do(func() {
+		file_phonebook_contacts_v1_contacts_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_phonebook_contacts_v1_contacts_proto_rawDesc), len(file_phonebook_contacts_v1_contacts_proto_rawDesc)))
+	})
+	return file_phonebook_contacts_v1_contacts_proto_rawDescData
+}
+
+var file_phonebook_contacts_v1_contacts_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_phonebook_contacts_v1_contacts_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
+var file_phonebook_contacts_v1_contacts_proto_goTypes = []any{
+	(SortBy)(0),                                 // 0: phonebook.contacts.v1.SortBy
+	(*Contact)(nil),                             // 1: phonebook.contacts.v1.Contact
+	(*PaginatedContacts)(nil),                   // 2: phonebook.contacts.v1.PaginatedContacts
+	(*ContactFilter)(nil),                       // 3: phonebook.contacts.v1.ContactFilter
+	(*CreateContactRequest)(nil),                // 4: phonebook.contacts.v1.CreateContactRequest
+	(*BatchCreateContactsRequest)(nil),          // 5: phonebook.contacts.v1.BatchCreateContactsRequest
+	(*BatchCreateContactsResponse)(nil),         // 6: phonebook.contacts.v1.BatchCreateContactsResponse
+	(*ListContactsRequest)(nil),                 // 7: phonebook.contacts.v1.ListContactsRequest
+	(*GetContactRequest)(nil),                   // 8: phonebook.contacts.v1.GetContactRequest
+	(*UpdateContactRequest)(nil),                // 9: phonebook.contacts.v1.UpdateContactRequest
+	(*DeleteContactRequest)(nil),                // 10: phonebook.contacts.v1.DeleteContactRequest
+	(*ExportContactsRequest)(nil),               // 11: phonebook.contacts.v1.ExportContactsRequest
+	(*BatchCreateContactsResponse_Failure)(nil), // 12: phonebook.contacts.v1.BatchCreateContactsResponse.Failure
+	(*timestamppb.Timestamp)(nil),               // 13: google.protobuf.Timestamp
+	(*fieldmaskpb.FieldMask)(nil),               // 14: google.protobuf.FieldMask
+	(*emptypb.Empty)(nil),                       // 15: google.protobuf.Empty
+}
+var file_phonebook_contacts_v1_contacts_proto_depIdxs = []int32{
+	13, // 0: phonebook.contacts.v1.Contact.last_modified:type_name -> google.protobuf.Timestamp
+	1,  // 1: phonebook.contacts.v1.PaginatedContacts.contacts:type_name -> phonebook.contacts.v1.Contact
+	1,  // 2: phonebook.contacts.v1.CreateContactRequest.contact:type_name -> phonebook.contacts.v1.Contact
+	1,  // 3: phonebook.contacts.v1.BatchCreateContactsRequest.contacts:type_name -> phonebook.contacts.v1.Contact
+	1,  // 4: phonebook.contacts.v1.BatchCreateContactsResponse.created:type_name -> phonebook.contacts.v1.Contact
+	12, // 5: phonebook.contacts.v1.BatchCreateContactsResponse.failures:type_name -> phonebook.contacts.v1.BatchCreateContactsResponse.Failure
+	3,  // 6: phonebook.contacts.v1.ListContactsRequest.filter:type_name -> phonebook.contacts.v1.ContactFilter
+	0,  // 7: phonebook.contacts.v1.ListContactsRequest.sort_by:type_name -> phonebook.contacts.v1.SortBy
+	1,  // 8: phonebook.contacts.v1.UpdateContactRequest.contact:type_name -> phonebook.contacts.v1.Contact
+	14, // 9: phonebook.contacts.v1.UpdateContactRequest.update_mask:type_name -> google.protobuf.FieldMask
+	3,  // 10: phonebook.contacts.v1.ExportContactsRequest.filter:type_name -> phonebook.contacts.v1.ContactFilter
+	4,  // 11: phonebook.contacts.v1.ContactService.CreateContact:input_type -> phonebook.contacts.v1.CreateContactRequest
+	5,  // 12: phonebook.contacts.v1.ContactService.BatchCreateContacts:input_type -> phonebook.contacts.v1.BatchCreateContactsRequest
+	7,  // 13: phonebook.contacts.v1.ContactService.ListContacts:input_type -> phonebook.contacts.v1.ListContactsRequest
+	8,  // 14: phonebook.contacts.v1.ContactService.GetContact:input_type -> phonebook.contacts.v1.GetContactRequest
+	9,  // 15: phonebook.contacts.v1.ContactService.UpdateContact:input_type -> phonebook.contacts.v1.UpdateContactRequest
+	10, // 16: phonebook.contacts.v1.ContactService.DeleteContact:input_type -> phonebook.contacts.v1.DeleteContactRequest
+	11, // 17: phonebook.contacts.v1.ContactService.ExportContacts:input_type -> phonebook.contacts.v1.ExportContactsRequest
+	1,  // 18: phonebook.contacts.v1.ContactService.CreateContact:output_type -> phonebook.contacts.v1.Contact
+	6,  // 19: phonebook.contacts.v1.ContactService.BatchCreateContacts:output_type -> phonebook.contacts.v1.BatchCreateContactsResponse
+	2,  // 20: phonebook.contacts.v1.ContactService.ListContacts:output_type -> phonebook.contacts.v1.PaginatedContacts
+	1,  // 21: phonebook.contacts.v1.ContactService.GetContact:output_type -> phonebook.contacts.v1.Contact
+	1,  // 22: phonebook.contacts.v1.ContactService.UpdateContact:output_type -> phonebook.contacts.v1.Contact
+	15, // 23: phonebook.contacts.v1.ContactService.DeleteContact:output_type -> google.protobuf.Empty
+	1,  // 24: phonebook.contacts.v1.ContactService.ExportContacts:output_type -> phonebook.contacts.v1.Contact
+	18, // [18:25] is the sub-list for method output_type
+	11, // [11:18] is the sub-list for method input_type
+	11, // [11:11] is the sub-list for extension type_name
+	11, // [11:11] is the sub-list for extension extendee
+	0,  // [0:11] is the sub-list for field type_name
+}
+
+func init() { file_phonebook_contacts_v1_contacts_proto_init() }
+func file_phonebook_contacts_v1_contacts_proto_init() {
+	if File_phonebook_contacts_v1_contacts_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_phonebook_contacts_v1_contacts_proto_rawDesc), len(file_phonebook_contacts_v1_contacts_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   12,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_phonebook_contacts_v1_contacts_proto_goTypes,
+		DependencyIndexes: file_phonebook_contacts_v1_contacts_proto_depIdxs,
+		EnumInfos:         file_phonebook_contacts_v1_contacts_proto_enumTypes,
+		MessageInfos:      file_phonebook_contacts_v1_contacts_proto_msgTypes,
+	}.Build()
+	File_phonebook_contacts_v1_contacts_proto = out.File
+	file_phonebook_contacts_v1_contacts_proto_goTypes = nil
+	file_phonebook_contacts_v1_contacts_proto_depIdxs = nil
+}
@@ -0,0 +1,301 @@
+// Authenticate, authorize and audit gRPC calls the way the HTTPS routes are
+package grpcapi
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"golangphonebook/internal"
+	"golangphonebook/pkg/audit"
+	"golangphonebook/pkg/auth"
+	"golangphonebook/pkg/grpcapi/contactsv1"
+	"golangphonebook/pkg/metrics"
+	"golangphonebook/pkg/problem"
+	"golangphonebook/pkg/requestlog"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// Role each method needs, the action changes are audited as and the REST route whose deadline the method shares
+type methodRule struct {
+	role   auth.Role
+	action string
+	route  string
+}
+
+var methodRules = map[string]methodRule{
+	contactsv1.ContactService_CreateContact_FullMethodName:       {auth.RoleEditor, "add_contact", "POST /v2/contacts"},
+	contactsv1.ContactService_BatchCreateContacts_FullMethodName: {auth.RoleEditor, "add_contacts", "PUT /addContacts"},
+	contactsv1.ContactService_ListContacts_FullMethodName:        {auth.RoleReader, "", "GET /v2/contacts"},
+	contactsv1.ContactService_GetContact_FullMethodName:          {auth.RoleReader, "", "GET /v2/contacts/{id}"},
+	contactsv1.ContactService_UpdateContact_FullMethodName:       {auth.RoleEditor, "update_contact", "PATCH /v2/contacts/{id}"},
+	contactsv1.ContactService_DeleteContact_FullMethodName:       {auth.RoleEditor, "delete_contact", "DELETE /v2/contacts/{id}"},
+	// No REST route exports contacts, so exports get the default deadline
+	contactsv1.ContactService_ExportContacts_FullMethodName: {auth.RoleReader, "", ""},
+}
+
+const requestIDHeader = "x-request-id"
+
+// Deadlines of the calls, the same the HTTPS routes get
+type Deadlines struct {
+	Default time.Duration            // Deadline of every call, none when zero
+	Routes  map[string]time.Duration // Deadlines of single routes, keyed like "GET /getContacts"
+}
+
+type Server struct {
+	Authz     *auth.Authorizer
+	Audit     audit.Sink
+	Deadlines Deadlines
+}
+
+// gRPC server for the service, on the same TLS config and client CA as the HTTPS server
+func NewServer(tlsConfig *tls.Config, authz *auth.Authorizer, sink audit.Sink, deadlines Deadlines, service *Service) *grpc.Server {
+	s := &Server{Authz: authz, Audit: sink, Deadlines: deadlines}
+	server := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(tlsConfig)),
+		// Continues the caller's trace, every call gets a server span the repository spans are children of
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		// Counted outside the role check so rejected calls are counted too, and the deadline starts once the call is let in
+		grpc.ChainUnaryInterceptor(countUnary, s.Unary, s.deadlineUnary),
+		grpc.ChainStreamInterceptor(countStream, s.Stream, s.deadlineStream),
+	)
+	contactsv1.RegisterContactServiceServer(server, service)
+	return server
+}
+
+// Verified client certificate of the call
+func peerCertificate(ctx context.Context) (*x509.Certificate, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 {
+		return nil, false
+	}
+	return info.State.PeerCertificates[0], true
+}
+
+// Give the call a request ID, taken from the x-request-id metadata when the client sent one, and add it to the call's log lines
+func withRequestID(ctx context.Context, method string) (context.Context, string) {
+	var requestID string
+	if values := metadata.ValueFromIncomingContext(ctx, requestIDHeader); len(values) > 0 {
+		requestID = values[0]
+	}
+	requestID = requestlog.FromHeader(requestID)
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDHeader, requestID))
+
+	fields := []any{"request_id", requestID, "route", method}
+	if cert, ok := peerCertificate(ctx); ok {
+		fields = append(fields, "client", cert.Subject.String())
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		fields = append(fields, "trace_id", sc.TraceID().String())
+	}
+	return internal.WithLogFields(ctx, fields...), requestID
+}
+
+// Check the caller may make the call, and start its audit entry for changes.
+// Denied changes are audited too, like requests the role check of the HTTPS routes denies.
+func (s *Server) authorize(ctx context.Context, method string) (context.Context, *audit.Entry, error) {
+	ctx, requestID := withRequestID(ctx, method)
+	rule, ok := methodRules[method]
+	if !ok {
+		return ctx, nil, status.Errorf(codes.PermissionDenied, "Forbidden: %s is not open to clients", method)
+	}
+	var entry *audit.Entry
+	if rule.action != "" {
+		ctx, entry = audit.Begin(ctx, rule.action, requestID)
+	}
+	deny := func(err error) (context.Context, *audit.Entry, error) {
+		if entry != nil {
+			entry.Finish(ctx, s.Audit, httpStatus(status.Code(err)))
+		}
+		return ctx, nil, err
+	}
+
+	cert, ok := peerCertificate(ctx)
+	if !ok {
+		internal.Logger.WarnContext(ctx, fmt.Sprintf("Rejected %s without a client certificate", method))
+		return deny(status.Error(codes.Unauthenticated, "Unauthorized: a valid client certificate is required"))
+	}
+	id, err := s.Authz.Authorize(auth.IdentityFromCertificate(cert), rule.role, method)
+	if err != nil {
+		if entry != nil {
+			entry.SetIdentity(id)
+		}
+		return deny(status.Error(codes.PermissionDenied, err.Error()))
+	}
+	return auth.WithIdentity(ctx, id), entry, nil
+}
+
+// Log the call with its code and latency once it is answered, like the HTTPS routes
+func logCall(ctx context.Context, start time.Time, err error) {
+	level := slog.LevelInfo
+	if httpStatus(status.Code(err)) >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	internal.Logger.Slog().Log(ctx, level, "Call answered", "code", status.Code(err).String(), "latency_ms", time.Since(start).Milliseconds())
+}
+
+func (s *Server) Unary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	ctx, entry, err := s.authorize(ctx, info.FullMethod)
+	if err != nil {
+		logCall(ctx, start, err)
+		return nil, err
+	}
+
+	resp, err := handler(ctx, req)
+	err = toStatus(err)
+	if entry != nil {
+		entry.Finish(ctx, s.Audit, httpStatus(status.Code(err)))
+	}
+	logCall(ctx, start, err)
+	return resp, err
+}
+
+// Streams get the identity and audit entry through their context
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *Server) Stream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx, entry, err := s.authorize(stream.Context(), info.FullMethod)
+	if err != nil {
+		logCall(ctx, start, err)
+		return err
+	}
+
+	err = toStatus(handler(srv, &contextStream{ServerStream: stream, ctx: ctx}))
+	if entry != nil {
+		entry.Finish(ctx, s.Audit, httpStatus(status.Code(err)))
+	}
+	logCall(ctx, start, err)
+	return err
+}
+
+// Deadline of the method, from the route it shares one with or the default.
+// A client that sets an earlier deadline keeps it.
+func (s *Server) deadline(method string) time.Duration {
+	if timeout, ok := s.Deadlines.Routes[methodRules[method].route]; ok {
+		return timeout
+	}
+	return s.Deadlines.Default
+}
+
+func (s *Server) deadlineUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if timeout := s.deadline(info.FullMethod); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) deadlineStream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if timeout := s.deadline(info.FullMethod); timeout > 0 {
+		ctx, cancel := context.WithTimeout(stream.Context(), timeout)
+		defer cancel()
+		stream = &contextStream{ServerStream: stream, ctx: ctx}
+	}
+	return handler(srv, stream)
+}
+
+// Count and time every call, with its code mapped to an HTTP status like the HTTPS routes are counted
+func countUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	metrics.ObserveCall(info.FullMethod, httpStatus(status.Code(err)), time.Since(start))
+	return resp, err
+}
+
+func countStream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, stream)
+	metrics.ObserveCall(info.FullMethod, httpStatus(status.Code(err)), time.Since(start))
+	return err
+}
+
+// Status for an error, with the same message and problem type as the problem+json response of the REST API
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+
+	details := problem.FromError(err)
+	st := status.New(grpcCode(details.Status), details.Detail)
+	info := []protoadapt.MessageV1{&errdetails.ErrorInfo{Reason: details.Type, Domain: "phonebook"}}
+	if len(details.Errors) > 0 {
+		badRequest := &errdetails.BadRequest{}
+		for _, field := range details.Errors {
+			badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{Field: field.Field, Description: field.Message})
+		}
+		info = append(info, badRequest)
+	}
+	if withDetails, err := st.WithDetails(info...); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
+
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusUnauthorized:        codes.Unauthenticated,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            codes.AlreadyExists,
+	http.StatusInternalServerError: codes.Internal,
+}
+
+func grpcCode(httpStatus int) codes.Code {
+	if code, ok := grpcCodes[httpStatus]; ok {
+		return code
+	}
+	return codes.Unknown
+}
+
+// HTTP status a code is recorded as in the audit log, so outcomes read the same for both APIs
+func httpStatus(code codes.Code) int {
+	if code == codes.OK {
+		return http.StatusOK
+	}
+	for status, c := range grpcCodes {
+		if c == code {
+			return status
+		}
+	}
+	switch code {
+	case codes.Canceled:
+		return 499
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,294 @@
+package grpcapi
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"golangphonebook/pkg/audit/audittest"
+	"golangphonebook/pkg/auth"
+	"golangphonebook/pkg/contacts"
+	"golangphonebook/pkg/contacts/contactstest"
+	"golangphonebook/pkg/grpcapi/contactsv1"
+	"golangphonebook/pkg/metrics"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return key
+}
+
+// Certificate signed by the parent, self-signed when parent is nil
+func newCert(t *testing.T, template *x509.Certificate, parent *tls.Certificate) tls.Certificate {
+	key := newKey(t)
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := template, any(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+type testEnv struct {
+	ca       tls.Certificate
+	pool     *x509.CertPool
+	listener *bufconn.Listener
+	repo     *contactstest.Repo
+	sink     *audittest.Sink
+}
+
+// Service over an in-memory connection, with the same TLS settings main.go uses
+func startServer(t *testing.T) *testEnv {
+	env := &testEnv{repo: contactstest.NewRepo(), sink: &audittest.Sink{}}
+	env.ca = newCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test CA"}, IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil)
+	env.pool = x509.NewCertPool()
+	env.pool.AddCert(env.ca.Leaf)
+
+	serverCert := newCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "localhost"}, DNSNames: []string{"localhost"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, &env.ca)
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    env.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+
+	authz := &auth.Authorizer{Config: auth.RoleConfig{Rules: []auth.RoleRule{
+		{CN: "frontend", Role: auth.RoleReader, Tenant: "acme"},
+		{CN: "backend", Role: auth.RoleEditor, Tenant: "acme"},
+	}}}
+	service := &Service{Repo: func(context.Context) contacts.ContactRepository { return env.repo }}
+	server := NewServer(tlsConfig, authz, env.sink, Deadlines{}, service)
+
+	env.listener = bufconn.Listen(1 << 20)
+	go server.Serve(env.listener)
+	t.Cleanup(server.Stop)
+	return env
+}
+
+// Client with a certificate for the common name
+func (env *testEnv) client(t *testing.T, commonName string) contactsv1.ContactServiceClient {
+	clientCert := newCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: commonName}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, &env.ca)
+	creds := credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{clientCert}, RootCAs: env.pool, ServerName: "localhost"})
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return env.listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(creds),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return contactsv1.NewContactServiceClient(conn)
+}
+
+func TestContactLifecycle(t *testing.T) {
+	env := startServer(t)
+	client := env.client(t, "backend")
+	ctx := metadata.AppendToOutgoingContext(context.Background(), requestIDHeader, "req-1")
+
+	var header metadata.MD
+	created, err := client.CreateContact(ctx, &contactsv1.CreateContactRequest{Contact: &contactsv1.Contact{FirstName: "Ada", LastName: "Lovelace", Phone: "+441234567"}}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), created.Id)
+	assert.NotNil(t, created.LastModified)
+	assert.Equal(t, []string{"req-1"}, header.Get(requestIDHeader))
+
+	got, err := client.GetContact(ctx, &contactsv1.GetContactRequest{Id: created.Id})
+	require.NoError(t, err)
+	assert.Equal(t, "Lovelace", got.LastName)
+
+	// Only the fields in the mask change
+	updated, err := client.UpdateContact(ctx, &contactsv1.UpdateContactRequest{
+		Id:         created.Id,
+		Contact:    &contactsv1.Contact{LastName: "King", Phone: "ignored"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"last_name"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "King", updated.LastName)
+	assert.Equal(t, "+441234567", updated.Phone)
+
+	_, err = client.DeleteContact(ctx, &contactsv1.DeleteContactRequest{Id: created.Id})
+	require.NoError(t, err)
+	_, err = client.GetContact(ctx, &contactsv1.GetContactRequest{Id: created.Id})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	records := env.sink.Records()
+	require.Len(t, records, 3)
+	assert.Equal(t, "add_contact", records[0].Action)
+	assert.Equal(t, "req-1", records[0].RequestID)
+	assert.Equal(t, "CN=backend", records[0].Actor)
+	assert.Equal(t, "acme", records[0].Tenant)
+	assert.Equal(t, "update_contact", records[1].Action)
+	assert.Equal(t, "delete_contact", records[2].Action)
+	assert.Equal(t, "success", records[2].Outcome)
+}
+
+func TestErrors(t *testing.T) {
+	env := startServer(t)
+	client := env.client(t, "backend")
+	ctx := context.Background()
+
+	_, err := client.CreateContact(ctx, &contactsv1.CreateContactRequest{Contact: &contactsv1.Contact{FirstName: "Ada"}})
+	st := status.Convert(err)
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+	var reasons, fields []string
+	for _, detail := range st.Details() {
+		switch d := detail.(type) {
+		case *errdetails.ErrorInfo:
+			reasons = append(reasons, d.Reason)
+		case *errdetails.BadRequest:
+			for _, violation := range d.FieldViolations {
+				fields = append(fields, violation.Field)
+			}
+		}
+	}
+	assert.Equal(t, []string{"/problems/validation-failed"}, reasons)
+	assert.Equal(t, []string{"phone"}, fields)
+
+	contact := &contactsv1.Contact{FirstName: "Ada", Phone: "+441234567"}
+	_, err = client.CreateContact(ctx, &contactsv1.CreateContactRequest{Contact: contact})
+	require.NoError(t, err)
+	_, err = client.CreateContact(ctx, &contactsv1.CreateContactRequest{Contact: contact})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	_, err = client.GetContact(ctx, &contactsv1.GetContactRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.UpdateContact(ctx, &contactsv1.UpdateContactRequest{Id: 1, Contact: contact, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"nickname"}}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Failed changes are audited too
+	records := env.sink.Records()
+	require.Len(t, records, 4)
+	assert.Equal(t, "failure", records[0].Outcome)
+	assert.Equal(t, 400, records[0].Status)
+	assert.Equal(t, 409, records[2].Status)
+}
+
+func TestRoles(t *testing.T) {
+	env := startServer(t)
+	ctx := context.Background()
+
+	_, err := env.client(t, "frontend").CreateContact(ctx, &contactsv1.CreateContactRequest{Contact: &contactsv1.Contact{FirstName: "Ada", Phone: "+441234567"}})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "has the reader role")
+
+	_, err = env.client(t, "stranger").GetContact(ctx, &contactsv1.GetContactRequest{Id: 1})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "is not mapped to any role")
+
+	_, err = env.client(t, "frontend").GetContact(ctx, &contactsv1.GetContactRequest{Id: 1})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// Denied changes are audited like on the HTTPS routes, reads aren't audited at all
+	records := env.sink.Records()
+	require.Len(t, records, 1)
+	assert.Equal(t, "add_contact", records[0].Action)
+	assert.Equal(t, "CN=frontend", records[0].Actor)
+	assert.Equal(t, "acme", records[0].Tenant)
+	assert.Equal(t, http.StatusForbidden, records[0].Status)
+	assert.Equal(t, "denied", records[0].Outcome)
+	assert.Empty(t, records[0].Targets())
+}
+
+func TestBatchCreate(t *testing.T) {
+	env := startServer(t)
+	client := env.client(t, "backend")
+
+	response, err := client.BatchCreateContacts(context.Background(), &contactsv1.BatchCreateContactsRequest{Contacts: []*contactsv1.Contact{
+		{FirstName: "Ada", Phone: "+441234567"},
+		{FirstName: "Charles"},
+		{FirstName: "Grace", Phone: "+15551234567"},
+	}})
+	require.NoError(t, err)
+	assert.Len(t, response.Created, 2)
+	require.Len(t, response.Failures, 1)
+	assert.Equal(t, int32(1), response.Failures[0].Index)
+	assert.Contains(t, response.Failures[0].Error, "phone is required")
+
+	tooMany := make([]*contactsv1.Contact, contacts.MaxBatchSize+1)
+	_, err = client.BatchCreateContacts(context.Background(), &contactsv1.BatchCreateContactsRequest{Contacts: tooMany})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestUnauthenticated(t *testing.T) {
+	s := &Server{Authz: &auth.Authorizer{}}
+	_, _, err := s.authorize(context.Background(), contactsv1.ContactService_GetContact_FullMethodName)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, _, err = s.authorize(context.Background(), "/grpc.reflection.v1.ServerReflection/ServerReflectionInfo")
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestDeadlines(t *testing.T) {
+	s := &Server{Deadlines: Deadlines{Default: time.Minute, Routes: map[string]time.Duration{"GET /v2/contacts/{id}": time.Second}}}
+	deadlineOf := func(method string) time.Duration {
+		var remaining time.Duration
+		s.deadlineUnary(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, _ any) (any, error) {
+			deadline, ok := ctx.Deadline()
+			require.True(t, ok)
+			remaining = time.Until(deadline)
+			return nil, nil
+		})
+		return remaining
+	}
+	assert.InDelta(t, time.Second, deadlineOf(contactsv1.ContactService_GetContact_FullMethodName), float64(100*time.Millisecond), "the route's deadline")
+	assert.InDelta(t, time.Minute, deadlineOf(contactsv1.ContactService_ListContacts_FullMethodName), float64(100*time.Millisecond), "the default")
+
+	// Streams get theirs through the stream's context
+	var hasDeadline bool
+	s.deadlineStream(nil, &contextStream{ctx: context.Background()}, &grpc.StreamServerInfo{FullMethod: contactsv1.ContactService_ExportContacts_FullMethodName},
+		func(_ any, stream grpc.ServerStream) error {
+			_, hasDeadline = stream.Context().Deadline()
+			return nil
+		})
+	assert.True(t, hasDeadline)
+
+	s.Deadlines = Deadlines{}
+	s.deadlineUnary(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: contactsv1.ContactService_GetContact_FullMethodName}, func(ctx context.Context, _ any) (any, error) {
+		_, hasDeadline = ctx.Deadline()
+		return nil, nil
+	})
+	assert.False(t, hasDeadline, "no deadline without a timeout")
+}
+
+func TestCallMetrics(t *testing.T) {
+	env := startServer(t)
+	client := env.client(t, "frontend")
+
+	_, err := client.GetContact(context.Background(), &contactsv1.GetContactRequest{Id: 99})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.DeleteContact(context.Background(), &contactsv1.DeleteContactRequest{Id: 99})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	var body strings.Builder
+	metrics.Default.Write(context.Background(), &body)
+	assert.Contains(t, body.String(), `phonebook_grpc_calls_total{method="/phonebook.contacts.v1.ContactService/GetContact",status="404"}`)
+	assert.Contains(t, body.String(), `phonebook_grpc_calls_total{method="/phonebook.contacts.v1.ContactService/DeleteContact",status="403"}`, "denied calls are counted too")
+	assert.Contains(t, body.String(), `phonebook_grpc_call_duration_seconds_count{method="/phonebook.contacts.v1.ContactService/GetContact",status="404"}`)
+}
@@ -0,0 +1,271 @@
+// gRPC API for contacts, on the same repository as the REST API
+package grpcapi
+
+import (
+	"context"
+	"fmt"
+	"golangphonebook/internal"
+	"golangphonebook/pkg/auth"
+	"golangphonebook/pkg/contacts"
+	"golangphonebook/pkg/grpcapi/contactsv1"
+	"strconv"
+
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+//go:generate protoc -I ../../proto --go_out=../.. --go_opt=module=golangphonebook --go-grpc_out=../.. --go-grpc_opt=module=golangphonebook phonebook/contacts/v1/contacts.proto
+
+// Contacts served over gRPC, errors are turned into statuses by the interceptors
+type Service struct {
+	contactsv1.UnimplementedContactServiceServer
+	// Repository for a call, scoped to the caller's tenant and reporting to the call's audit entry
+	Repo func(ctx context.Context) contacts.ContactRepository
+}
+
+// Rows the export loads at a time
+const exportBatchSize = 100
+
+func (s *Service) repo(ctx context.Context) contacts.ContactRepository {
+	return s.Repo(ctx)
+}
+
+func toProto(c contacts.Contact) *contactsv1.Contact {
+	result := &contactsv1.Contact{
+		Id:            uint64(c.ID),
+		FirstName:     c.FirstName,
+		LastName:      c.LastName,
+		Phone:         c.Phone,
+		Address:       c.Address,
+		AddressBookId: uint64(c.AddressBookID),
+	}
+	if !c.LastModified.IsZero() {
+		result.LastModified = timestamppb.New(c.LastModified)
+	}
+	return result
+}
+
+// Server assigned fields are left out
+func fromProto(c *contactsv1.Contact) contacts.Contact {
+	return contacts.Contact{
+		FirstName:     c.GetFirstName(),
+		LastName:      c.GetLastName(),
+		Phone:         c.GetPhone(),
+		Address:       c.GetAddress(),
+		AddressBookID: uint(c.GetAddressBookId()),
+	}
+}
+
+// IDs are uint64 on the wire and int in the repository
+func contactID(id uint64) (int, error) {
+	if id == 0 || id > uint64(^uint(0)>>1) {
+		return 0, fmt.Errorf("invalid ID %d, IDs can only be positive integers: %w", id, contacts.ErrInvalidRequest)
+	}
+	return int(id), nil
+}
+
+var sortFields = map[contactsv1.SortBy]contacts.SortBy{
+	contactsv1.SortBy_SORT_BY_FIRST_NAME:    contacts.SortByFirstName,
+	contactsv1.SortBy_SORT_BY_LAST_NAME:     contacts.SortByLastName,
+	contactsv1.SortBy_SORT_BY_LAST_MODIFIED: contacts.SortByLastModified,
+}
+
+func listParams(ctx context.Context, filter *contactsv1.ContactFilter) contacts.ListParams {
+	params := contacts.ListParams{
+		FirstName: filter.GetFirstName(),
+		LastName:  filter.GetLastName(),
+		Address:   filter.GetAddress(),
+		Phone:     filter.GetPhone(),
+	}
+	if book := filter.GetAddressBookId(); book != 0 {
+		params.AddressBook = strconv.FormatUint(book, 10)
+	}
+	if id, ok := auth.FromContext(ctx); ok {
+		params.Tenant = id.Tenant
+	}
+	return params
+}
+
+func (s *Service) CreateContact(ctx context.Context, req *contactsv1.CreateContactRequest) (*contactsv1.Contact, error) {
+	defer internal.Timer("gRPC CreateContact")()
+
+	contact := fromProto(req.GetContact())
+	if err := contact.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid contact, first name and phone must be correctly defined: %w", err)
+	}
+
+	created, err := s.repo(ctx).AddContact(ctx, contact)
+	if err != nil {
+		return nil, err
+	}
+	contacts.InvalidateCache(ctx)
+	return toProto(*created), nil
+}
+
+func (s *Service) BatchCreateContacts(ctx context.Context, req *contactsv1.BatchCreateContactsRequest) (*contactsv1.BatchCreateContactsResponse, error) {
+	defer internal.Timer("gRPC BatchCreateContacts")()
+
+	if len(req.GetContacts()) > contacts.MaxBatchSize {
+		return nil, fmt.Errorf("cannot add more than %d contacts at a time: %w", contacts.MaxBatchSize, contacts.ErrTooMany)
+	}
+
+	repo := s.repo(ctx)
+	response := &contactsv1.BatchCreateContactsResponse{}
+	for i, c := range req.GetContacts() {
+		contact := fromProto(c)
+		err := contact.Validate()
+		if err == nil {
+			var created *contacts.Contact
+			if created, err = repo.AddContact(ctx, contact); err == nil {
+				response.Created = append(response.Created, toProto(*created))
+				continue
+			}
+		}
+		internal.Logger.WarnContext(ctx, fmt.Sprintf("Failed to add contact %d of the batch: %v", i, err))
+		response.Failures = append(response.Failures, &contactsv1.BatchCreateContactsResponse_Failure{Index: int32(i), Error: err.Error()})
+	}
+
+	if len(response.Created) > 0 {
+		contacts.InvalidateCache(ctx)
+	}
+	internal.Logger.InfoContext(ctx, fmt.Sprintf("Successful: %d, Failed: %d", len(response.Created), len(response.Failures)))
+	contacts.RecordBatch(len(response.Created), len(response.Failures))
+	return response, nil
+}
+
+func (s *Service) ListContacts(ctx context.Context, req *contactsv1.ListContactsRequest) (*contactsv1.PaginatedContacts, error) {
+	defer internal.Timer("gRPC ListContacts")()
+
+	params := listParams(ctx, req.GetFilter())
+	params.SortBy = sortFields[req.GetSortBy()]
+	params.Descending = req.GetDescending()
+	params.Page = int(req.GetPage())
+
+	page, err := contacts.ListContacts(ctx, s.repo(ctx), params)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &contactsv1.PaginatedContacts{
+		TotalPages:  int32(page.TotalPages),
+		CurrentPage: int32(page.CurrentPage),
+		TotalCount:  page.TotalCount,
+	}
+	for _, c := range page.Contacts {
+		response.Contacts = append(response.Contacts, toProto(c))
+	}
+	return response, nil
+}
+
+func (s *Service) GetContact(ctx context.Context, req *contactsv1.GetContactRequest) (*contactsv1.Contact, error) {
+	defer internal.Timer("gRPC GetContact")()
+
+	id, err := contactID(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	contact, err := s.repo(ctx).GetContact(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return toProto(*contact), nil
+}
+
+// Copy the fields in the mask from the request, like a PATCH. Server assigned fields are ignored, like they are in a body.
+func applyMask(contact *contacts.Contact, update *contactsv1.Contact, paths []string) error {
+	for _, path := range paths {
+		switch path {
+		case "first_name":
+			contact.FirstName = update.GetFirstName()
+		case "last_name":
+			contact.LastName = update.GetLastName()
+		case "phone":
+			contact.Phone = update.GetPhone()
+		case "address":
+			contact.Address = update.GetAddress()
+		case "address_book_id":
+			contact.AddressBookID = uint(update.GetAddressBookId())
+		case "id", "last_modified":
+		default:
+			return fmt.Errorf("unknown field %s in the update mask: %w", path, contacts.ErrInvalidRequest)
+		}
+	}
+	return nil
+}
+
+func (s *Service) UpdateContact(ctx context.Context, req *contactsv1.UpdateContactRequest) (*contactsv1.Contact, error) {
+	defer internal.Timer("gRPC UpdateContact")()
+
+	id, err := contactID(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	repo := s.repo(ctx)
+
+	replacement := fromProto(req.GetContact())
+	if paths := req.GetUpdateMask().GetPaths(); len(paths) > 0 {
+		existing, err := repo.GetContact(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		replacement = *existing
+		if err := applyMask(&replacement, req.GetContact(), paths); err != nil {
+			return nil, err
+		}
+	}
+	if err := replacement.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid contact, first name and phone must be correctly defined: %w", err)
+	}
+
+	updated, err := repo.ReplaceContact(ctx, id, replacement)
+	if err != nil {
+		return nil, err
+	}
+	contacts.InvalidateCache(ctx)
+	return toProto(*updated), nil
+}
+
+func (s *Service) DeleteContact(ctx context.Context, req *contactsv1.DeleteContactRequest) (*emptypb.Empty, error) {
+	defer internal.Timer("gRPC DeleteContact")()
+
+	id, err := contactID(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo(ctx).DeleteContact(ctx, id); err != nil {
+		return nil, err
+	}
+	contacts.InvalidateCache(ctx)
+	return &emptypb.Empty{}, nil
+}
+
+// Stream the matching contacts in batches, so a large address book is never loaded at once
+func (s *Service) ExportContacts(req *contactsv1.ExportContactsRequest, stream contactsv1.ContactService_ExportContactsServer) error {
+	defer internal.Timer("gRPC ExportContacts")()
+
+	ctx := stream.Context()
+	query, total, err := s.repo(ctx).FilterContacts(ctx, listParams(ctx, req.GetFilter()).Filters())
+	if err != nil {
+		return err
+	}
+
+	exported := 0
+	var batch []contacts.Contact
+	err = query.WithContext(ctx).Order("id").FindInBatches(&batch, exportBatchSize, func(tx *gorm.DB, _ int) error {
+		for _, c := range batch {
+			if err := stream.Send(toProto(c)); err != nil {
+				return err
+			}
+			exported++
+		}
+		// Stop early when the client has gone away
+		return ctx.Err()
+	}).Error
+	if err != nil {
+		internal.Logger.ErrorContext(ctx, fmt.Sprintf("Contact export failed after %d of %d contacts: %v", exported, total, err))
+		return err
+	}
+	internal.Logger.InfoContext(ctx, fmt.Sprintf("Exported %d contacts", exported))
+	return nil
+}
@@ -0,0 +1,187 @@
+// Liveness and readiness probes, reporting the state of each component the server depends on
+package health
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"golangphonebook/internal"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// States of a component and of the whole report
+const (
+	StatusOK   = "ok"
+	StatusWarn = "warn" // Works, but needs attention soon, like a certificate about to expire
+	StatusFail = "fail"
+)
+
+// Certificates expiring sooner than this are reported with a warning
+const ExpiryWarning = 14 * 24 * time.Hour
+
+type Component struct {
+	Status    string     `json:"status"`
+	Detail    string     `json:"detail,omitempty"`
+	LatencyMS int64      `json:"latency_ms"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Certificates only
+}
+
+type Report struct {
+	Status     string               `json:"status"` // fail when any component failed
+	Components map[string]Component `json:"components,omitempty"`
+}
+
+// Check of one component, the context carries the probe's timeout
+type Check func(ctx context.Context) Component
+
+// Runs the checks of every component for the readiness probe
+type Checker struct {
+	Timeout  time.Duration // Longest each check gets
+	checks   map[string]Check
+	draining atomic.Bool
+}
+
+// NewChecker creates a checker without any checks
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{Timeout: timeout, checks: map[string]Check{}}
+}
+
+func (c *Checker) Add(name string, check Check) {
+	c.checks[name] = check
+}
+
+// Report not ready from now on, so the orchestrator stops sending traffic while the server shuts down
+func (c *Checker) Drain() {
+	c.draining.Store(true)
+}
+
+// Run every check at once and collect the results
+func (c *Checker) Check(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	report := Report{Status: StatusOK, Components: make(map[string]Component, len(c.checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			component := check(ctx)
+			component.LatencyMS = time.Since(start).Milliseconds()
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Components[name] = component
+			if component.Status == StatusFail {
+				report.Status = StatusFail
+			}
+		}()
+	}
+	wg.Wait()
+
+	if c.draining.Load() {
+		report.Status = StatusFail
+		report.Components["server"] = Component{Status: StatusFail, Detail: "shutting down"}
+	}
+	return report
+}
+
+// The database answers a ping within the probe's timeout
+func Database(db *gorm.DB) Check {
+	return func(ctx context.Context) Component {
+		sqlDB, err := db.DB()
+		if err == nil {
+			err = sqlDB.PingContext(ctx)
+		}
+		if err != nil {
+			return Component{Status: StatusFail, Detail: err.Error()}
+		}
+		return Component{Status: StatusOK}
+	}
+}
+
+// The schema is up to date, status reports what is missing
+func Migrations(db *gorm.DB, status func(ctx context.Context, db *gorm.DB) error) Check {
+	return func(ctx context.Context) Component {
+		if err := status(ctx, db); err != nil {
+			return Component{Status: StatusFail, Detail: err.Error()}
+		}
+		return Component{Status: StatusOK}
+	}
+}
+
+// The certificate is valid now, with a warning when it expires within ExpiryWarning
+func Certificate(cert *x509.Certificate) Check {
+	return func(ctx context.Context) Component {
+		return certificateStatus(cert, time.Now())
+	}
+}
+
+func certificateStatus(cert *x509.Certificate, now time.Time) Component {
+	if cert == nil {
+		return Component{Status: StatusFail, Detail: "no certificate loaded"}
+	}
+	expires := cert.NotAfter.UTC()
+	component := Component{Status: StatusOK, ExpiresAt: &expires, Detail: cert.Subject.String()}
+	switch {
+	case now.Before(cert.NotBefore):
+		component.Status = StatusFail
+		component.Detail = fmt.Sprintf("%s is not valid before %s", cert.Subject, cert.NotBefore.UTC().Format(time.RFC3339))
+	case now.After(cert.NotAfter):
+		component.Status = StatusFail
+		component.Detail = fmt.Sprintf("%s expired", cert.Subject)
+	case cert.NotAfter.Sub(now) < ExpiryWarning:
+		component.Status = StatusWarn
+		component.Detail = fmt.Sprintf("%s expires in %d days", cert.Subject, int(cert.NotAfter.Sub(now).Hours()/24))
+	}
+	return component
+}
+
+func writeReport(w http.ResponseWriter, report Report) {
+	status := http.StatusOK
+	if report.Status == StatusFail {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+// The process is up and serving, nothing else is checked so a slow database doesn't get it restarted
+func Liveness(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, Report{Status: StatusOK})
+}
+
+// Whether the server can take traffic, 503 when any component failed
+func Readiness(w http.ResponseWriter, r *http.Request, checker *Checker) {
+	report := checker.Check(r.Context())
+	if report.Status == StatusFail {
+		var failed []string
+		for _, name := range slices.Sorted(maps.Keys(report.Components)) {
+			if report.Components[name].Status == StatusFail {
+				failed = append(failed, name)
+			}
+		}
+		internal.Logger.WarnContext(r.Context(), fmt.Sprintf("Not ready, failed checks: %v", failed))
+	}
+	writeReport(w, report)
+}
+
+// Mux with the probes for the plain HTTP admin port, other admin endpoints can be added to it
+func AdminHandler(checker *Checker) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", Liveness)
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) { Readiness(w, r, checker) })
+	return mux
+}
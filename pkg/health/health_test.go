@@ -0,0 +1,117 @@
+package health
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertificateStatus(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	cert := func(notBefore, notAfter time.Time) *x509.Certificate {
+		return &x509.Certificate{Subject: pkix.Name{CommonName: "localhost"}, NotBefore: notBefore, NotAfter: notAfter}
+	}
+
+	for name, test := range map[string]struct {
+		cert   *x509.Certificate
+		status string
+		detail string
+	}{
+		"Valid":          {cert(now.AddDate(-1, 0, 0), now.AddDate(1, 0, 0)), StatusOK, "CN=localhost"},
+		"Expiring Soon":  {cert(now.AddDate(-1, 0, 0), now.AddDate(0, 0, 9)), StatusWarn, "CN=localhost expires in 9 days"},
+		"Expired":        {cert(now.AddDate(-1, 0, 0), now.Add(-time.Minute)), StatusFail, "CN=localhost expired"},
+		"Not Valid Yet":  {cert(now.Add(time.Hour), now.AddDate(1, 0, 0)), StatusFail, "CN=localhost is not valid before 2026-10-19T13:00:00Z"},
+		"Nothing Loaded": {nil, StatusFail, "no certificate loaded"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			component := certificateStatus(test.cert, now)
+			assert.Equal(t, test.status, component.Status)
+			assert.Equal(t, test.detail, component.Detail)
+			if test.cert != nil {
+				require.NotNil(t, component.ExpiresAt)
+				assert.Equal(t, test.cert.NotAfter, *component.ExpiresAt)
+			}
+		})
+	}
+}
+
+func check(status string) Check {
+	return func(ctx context.Context) Component {
+		return Component{Status: status}
+	}
+}
+
+func TestChecker(t *testing.T) {
+	t.Run("Warnings Are Still Ready", func(t *testing.T) {
+		checker := NewChecker(time.Second)
+		checker.Add("database", check(StatusOK))
+		checker.Add("certificate", check(StatusWarn))
+		report := checker.Check(context.Background())
+		assert.Equal(t, StatusOK, report.Status)
+		assert.Len(t, report.Components, 2)
+		assert.Equal(t, StatusWarn, report.Components["certificate"].Status)
+	})
+
+	t.Run("Any Failure Fails", func(t *testing.T) {
+		checker := NewChecker(time.Second)
+		checker.Add("database", check(StatusFail))
+		checker.Add("certificate", check(StatusOK))
+		assert.Equal(t, StatusFail, checker.Check(context.Background()).Status)
+	})
+
+	t.Run("Slow Checks Are Cut Off", func(t *testing.T) {
+		checker := NewChecker(20 * time.Millisecond)
+		checker.Add("database", func(ctx context.Context) Component {
+			<-ctx.Done()
+			return Component{Status: StatusFail, Detail: ctx.Err().Error()}
+		})
+		start := time.Now()
+		report := checker.Check(context.Background())
+		assert.Less(t, time.Since(start), time.Second)
+		assert.Equal(t, StatusFail, report.Status)
+		assert.Equal(t, context.DeadlineExceeded.Error(), report.Components["database"].Detail)
+	})
+
+	t.Run("Draining", func(t *testing.T) {
+		checker := NewChecker(time.Second)
+		checker.Add("database", check(StatusOK))
+		checker.Drain()
+		report := checker.Check(context.Background())
+		assert.Equal(t, StatusFail, report.Status)
+		assert.Equal(t, StatusFail, report.Components["server"].Status)
+	})
+}
+
+func TestAdminHandler(t *testing.T) {
+	checker := NewChecker(time.Second)
+	checker.Add("database", check(StatusFail))
+	handler := AdminHandler(checker)
+
+	get := func(path string) (*httptest.ResponseRecorder, Report) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		var report Report
+		json.Unmarshal(rec.Body.Bytes(), &report)
+		return rec, report
+	}
+
+	rec, report := get("/healthz")
+	assert.Equal(t, http.StatusOK, rec.Code, "the process is alive even when the database is down")
+	assert.Equal(t, StatusOK, report.Status)
+	assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+
+	rec, report = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, StatusFail, report.Components["database"].Status)
+
+	rec, _ = get("/v2/contacts")
+	assert.Equal(t, http.StatusNotFound, rec.Code, "nothing but the probes on the admin port")
+}
@@ -0,0 +1,285 @@
+// Idempotency-Key support, so clients can safely retry requests that change something
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golangphonebook/internal"
+	"golangphonebook/pkg/auth"
+	"golangphonebook/pkg/contacts"
+	"golangphonebook/pkg/fieldcrypt"
+	"golangphonebook/pkg/problem"
+	"io"
+	"net/http"
+	"time"
+)
+
+const Header = "Idempotency-Key"
+
+// Set on responses that were replayed from an earlier request with the same key
+const ReplayedHeader = "Idempotent-Replayed"
+
+// How long keys are kept by default
+const DefaultTTL = 24 * time.Hour
+
+// A request with a key that is still running after this is assumed to have died with its instance
+const lockTimeout = time.Minute
+
+const (
+	maxKeyLength = 255
+	maxBodySize  = 1 << 20
+)
+
+// Name stored responses are encrypted under, like the name of a contact field
+const bodyField = "idempotent_response"
+
+var (
+	ErrKeyReused = errors.New("idempotency key reused for a different request")
+	ErrKeyInUse  = errors.New("idempotency key in use")
+)
+
+func init() {
+	problem.Register(ErrKeyReused, http.StatusUnprocessableEntity, "idempotency-key-reused", "Idempotency key reused")
+	problem.Register(ErrKeyInUse, http.StatusConflict, "idempotency-key-in-use", "Idempotency key in use")
+}
+
+// A key and the response to the first request made with it
+type Record struct {
+	ID          uint      `gorm:"primaryKey;autoIncrement"`
+	Tenant      string    `gorm:"size:100;not null;uniqueIndex:idx_idempotency_key,priority:1"`
+	Key         string    `gorm:"size:255;not null;uniqueIndex:idx_idempotency_key,priority:2"`
+	RequestHash string    `gorm:"size:64;not null"` // Method, URL and body of the first request
+	Status      int       // Zero while the first request is running
+	Header      string    `gorm:"type:text"` // Response headers as JSON
+	Body        string    `gorm:"type:text"` // Encrypted when field encryption is enabled, responses can hold personal data
+	CreatedAt   time.Time `gorm:"not null"`
+	ExpiresAt   time.Time `gorm:"not null;index"`
+}
+
+func (Record) TableName() string {
+	return "idempotency_keys"
+}
+
+// Where keys are kept, Store in production
+type Backend interface {
+	// Add a record, false when the tenant already has one with the key
+	Insert(rec *Record) (bool, error)
+	Find(tenant string, key string) (*Record, error)
+	// Replace an expired or abandoned record, false when another request changed it first
+	TakeOver(old *Record, rec *Record) (bool, error)
+	Save(rec *Record) error
+	Delete(rec *Record) error
+}
+
+func hashRequest(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.RequestURI())
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func validKey(key string) bool {
+	if len(key) > maxKeyLength {
+		return false
+	}
+	for _, c := range key {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// Keep what the handler responds with, while passing it on
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+		rr.header = rr.ResponseWriter.Header().Clone()
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.WriteHeader(http.StatusOK)
+	}
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
+
+func sealBody(body []byte) (string, error) {
+	if e := contacts.FieldEncryptionSettings(); e != nil && len(body) > 0 {
+		return e.Cipher.Encrypt(bodyField, string(body))
+	}
+	return string(body), nil
+}
+
+func openBody(body string) ([]byte, error) {
+	if !fieldcrypt.IsEncrypted(body) {
+		return []byte(body), nil
+	}
+	e := contacts.FieldEncryptionSettings()
+	if e == nil {
+		return nil, contacts.ErrEncryptionNotConfigured
+	}
+	plaintext, err := e.Cipher.Decrypt(bodyField, body)
+	return []byte(plaintext), err
+}
+
+// Keep the response of the first request made with an Idempotency-Key, and send it again for every retry with
+// the same key within the TTL. Requests without the header are passed through. Keys are scoped to the tenant.
+func Middleware(backend Backend, ttl time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(Header)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if !validKey(key) {
+			problem.Write(w, r, fmt.Errorf("invalid %s, must be at most %d printable characters without spaces: %w", Header, maxKeyLength, contacts.ErrInvalidRequest))
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			problem.Error(w, r, fmt.Sprintf("Request bodies sent with an %s can be at most %d bytes", Header, tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			problem.Write(w, r, fmt.Errorf("invalid request body: %w", contacts.ErrInvalidRequest))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		id, _ := auth.FromContext(r.Context())
+		// Postgres keeps microseconds, and records are matched on when they were created
+		now := time.Now().UTC().Truncate(time.Microsecond)
+		rec := &Record{Tenant: id.Tenant, Key: key, RequestHash: hashRequest(r, body), CreatedAt: now, ExpiresAt: now.Add(ttl)}
+
+		existing, err := claim(backend, rec, now)
+		if err != nil {
+			internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to claim idempotency key: %v", err))
+			problem.Error(w, r, "Failed to check the idempotency key", http.StatusInternalServerError)
+			return
+		}
+		if existing != nil {
+			replay(w, r, existing, rec.RequestHash)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		next(recorder, r)
+		complete(backend, rec, recorder)
+	}
+}
+
+// Claim the key for this request, or return the record of the earlier request that holds it
+func claim(backend Backend, rec *Record, now time.Time) (*Record, error) {
+	// Another request can take the key between any two of these steps, so try again a few times
+	for range 3 {
+		inserted, err := backend.Insert(rec)
+		if err != nil || inserted {
+			return nil, err
+		}
+		existing, err := backend.Find(rec.Tenant, rec.Key)
+		if err != nil {
+			return nil, err
+		}
+		if existing == nil {
+			continue
+		}
+
+		expired := !existing.ExpiresAt.After(now)
+		abandoned := existing.Status == 0 && existing.RequestHash == rec.RequestHash && now.Sub(existing.CreatedAt) > lockTimeout
+		if !expired && !abandoned {
+			return existing, nil
+		}
+		tookOver, err := backend.TakeOver(existing, rec)
+		if err != nil || tookOver {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("idempotency key %q keeps changing", rec.Key)
+}
+
+// Store the response for retries. Server errors aren't kept, so retrying can still succeed.
+func complete(backend Backend, rec *Record, recorder *responseRecorder) {
+	status := recorder.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status >= http.StatusInternalServerError {
+		if err := backend.Delete(rec); err != nil {
+			internal.Logger.Error(fmt.Sprintf("Failed to release idempotency key after a %d: %v", status, err))
+		}
+		return
+	}
+
+	// The request ID belongs to each request, not to the response
+	header := recorder.header
+	if header == nil {
+		header = recorder.Header().Clone()
+	}
+	header.Del("X-Request-ID")
+	headerJSON, err := json.Marshal(header)
+	if err == nil {
+		rec.Header = string(headerJSON)
+		rec.Body, err = sealBody(recorder.body.Bytes())
+	}
+	if err == nil {
+		rec.Status = status
+		err = backend.Save(rec)
+	}
+	if err != nil {
+		// Without the response a retry has to be treated like a new request
+		internal.Logger.Error(fmt.Sprintf("Failed to store the response for an idempotency key: %v", err))
+		if err := backend.Delete(rec); err != nil {
+			internal.Logger.Error(fmt.Sprintf("Failed to release idempotency key: %v", err))
+		}
+	}
+}
+
+// Respond to a request whose key is already taken
+func replay(w http.ResponseWriter, r *http.Request, existing *Record, hash string) {
+	if existing.RequestHash != hash {
+		internal.Logger.WarnContext(r.Context(), fmt.Sprintf("Rejected reuse of an idempotency key for a different %s %s", r.Method, r.URL.Path))
+		problem.Write(w, r, fmt.Errorf("the %s was already used for a request with a different method, URL or body, use a new key for a new request: %w", Header, ErrKeyReused))
+		return
+	}
+	if existing.Status == 0 {
+		w.Header().Set("Retry-After", "1")
+		problem.Write(w, r, fmt.Errorf("a request with the same %s is still running, retry once it is done: %w", Header, ErrKeyInUse))
+		return
+	}
+
+	var header http.Header
+	responseBody, err := openBody(existing.Body)
+	if err == nil {
+		err = json.Unmarshal([]byte(existing.Header), &header)
+	}
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to read the stored response for an idempotency key: %v", err))
+		problem.Error(w, r, "Failed to replay the response for the idempotency key", http.StatusInternalServerError)
+		return
+	}
+
+	for name, values := range header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(existing.Status)
+	w.Write(responseBody)
+	internal.Logger.InfoContext(r.Context(), fmt.Sprintf("Replayed the %d response of an earlier %s %s", existing.Status, r.Method, r.URL.Path))
+}
@@ -0,0 +1,257 @@
+package idempotency
+
+import (
+	"fmt"
+	"golangphonebook/pkg/auth"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Keys in memory, behaving like Store
+type memoryBackend struct {
+	mu      sync.Mutex
+	records map[string]*Record
+	nextID  uint
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{records: map[string]*Record{}}
+}
+
+func (m *memoryBackend) Insert(rec *Record) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.records[rec.Tenant+"/"+rec.Key]; ok {
+		return false, nil
+	}
+	m.nextID++
+	rec.ID = m.nextID
+	stored := *rec
+	m.records[rec.Tenant+"/"+rec.Key] = &stored
+	return true, nil
+}
+
+func (m *memoryBackend) Find(tenant string, key string) (*Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if rec, ok := m.records[tenant+"/"+key]; ok {
+		found := *rec
+		return &found, nil
+	}
+	return nil, nil
+}
+
+func (m *memoryBackend) TakeOver(old *Record, rec *Record) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.records[old.Tenant+"/"+old.Key]
+	if !ok || stored.ID != old.ID || stored.Status != old.Status || !stored.CreatedAt.Equal(old.CreatedAt) {
+		return false, nil
+	}
+	rec.ID = old.ID
+	replaced := *rec
+	m.records[rec.Tenant+"/"+rec.Key] = &replaced
+	return true, nil
+}
+
+func (m *memoryBackend) Save(rec *Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	saved := *rec
+	m.records[rec.Tenant+"/"+rec.Key] = &saved
+	return nil
+}
+
+func (m *memoryBackend) Delete(rec *Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if stored, ok := m.records[rec.Tenant+"/"+rec.Key]; ok && stored.ID == rec.ID {
+		delete(m.records, rec.Tenant+"/"+rec.Key)
+	}
+	return nil
+}
+
+// Adds a contact per call, like PutContact
+type countingHandler struct {
+	calls  int
+	status int
+}
+
+func (h *countingHandler) serve(w http.ResponseWriter, r *http.Request) {
+	h.calls++
+	body, _ := io.ReadAll(r.Body)
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("X-Request-ID", fmt.Sprintf("req-%d", h.calls))
+	w.WriteHeader(h.status)
+	fmt.Fprintf(w, "call %d: %s", h.calls, body)
+}
+
+func request(t *testing.T, handler http.HandlerFunc, tenant, method, target, key, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(Header, key)
+	}
+	req = req.WithContext(auth.WithIdentity(req.Context(), auth.Identity{CommonName: "editor", Role: auth.RoleEditor, Tenant: tenant}))
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr
+}
+
+func TestMiddleware(t *testing.T) {
+	body := `{"first_name":"Ada","phone":"+441234567890"}`
+
+	t.Run("Replays The First Response", func(t *testing.T) {
+		h := &countingHandler{status: http.StatusCreated}
+		handler := Middleware(newMemoryBackend(), time.Hour, h.serve)
+
+		first := request(t, handler, "acme", "PUT", "/addContact", "key-1", body)
+		second := request(t, handler, "acme", "PUT", "/addContact", "key-1", body)
+
+		assert.Equal(t, 1, h.calls)
+		assert.Equal(t, http.StatusCreated, second.Code)
+		assert.Equal(t, first.Body.String(), second.Body.String())
+		assert.Equal(t, "text/plain", second.Header().Get("Content-Type"))
+		assert.Equal(t, "true", second.Header().Get(ReplayedHeader))
+		assert.Empty(t, first.Header().Get(ReplayedHeader))
+		assert.Empty(t, second.Header().Get("X-Request-ID"))
+	})
+
+	t.Run("Rejects A Body That Is Too Large", func(t *testing.T) {
+		h := &countingHandler{status: http.StatusOK}
+		handler := Middleware(newMemoryBackend(), time.Hour, h.serve)
+
+		rr := request(t, handler, "acme", "PUT", "/addContacts", "key-1", strings.Repeat("x", maxBodySize+1))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+		assert.Equal(t, 0, h.calls)
+	})
+
+	t.Run("Rejects A Different Body", func(t *testing.T) {
+		h := &countingHandler{status: http.StatusOK}
+		handler := Middleware(newMemoryBackend(), time.Hour, h.serve)
+
+		request(t, handler, "acme", "PUT", "/addContact", "key-1", body)
+		rr := request(t, handler, "acme", "PUT", "/addContact", "key-1", `{"first_name":"Grace"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+		assert.Contains(t, rr.Body.String(), "idempotency-key-reused")
+
+		rr = request(t, handler, "acme", "DELETE", "/deleteContact/1", "key-1", "")
+		assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+		assert.Equal(t, 1, h.calls)
+	})
+
+	t.Run("Without A Key", func(t *testing.T) {
+		h := &countingHandler{status: http.StatusOK}
+		handler := Middleware(newMemoryBackend(), time.Hour, h.serve)
+
+		request(t, handler, "acme", "PUT", "/addContact", "", body)
+		request(t, handler, "acme", "PUT", "/addContact", "", body)
+		assert.Equal(t, 2, h.calls)
+	})
+
+	t.Run("Keys Are Scoped To The Tenant", func(t *testing.T) {
+		h := &countingHandler{status: http.StatusOK}
+		handler := Middleware(newMemoryBackend(), time.Hour, h.serve)
+
+		request(t, handler, "acme", "PUT", "/addContact", "key-1", body)
+		rr := request(t, handler, "globex", "PUT", "/addContact", "key-1", body)
+		assert.Equal(t, 2, h.calls)
+		assert.Empty(t, rr.Header().Get(ReplayedHeader))
+	})
+
+	t.Run("Client Errors Are Replayed", func(t *testing.T) {
+		h := &countingHandler{status: http.StatusBadRequest}
+		handler := Middleware(newMemoryBackend(), time.Hour, h.serve)
+
+		request(t, handler, "acme", "PUT", "/addContact", "key-1", body)
+		rr := request(t, handler, "acme", "PUT", "/addContact", "key-1", body)
+		assert.Equal(t, 1, h.calls)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("Server Errors Can Be Retried", func(t *testing.T) {
+		h := &countingHandler{status: http.StatusInternalServerError}
+		handler := Middleware(newMemoryBackend(), time.Hour, h.serve)
+
+		request(t, handler, "acme", "PUT", "/addContact", "key-1", body)
+		h.status = http.StatusOK
+		rr := request(t, handler, "acme", "PUT", "/addContact", "key-1", body)
+		assert.Equal(t, 2, h.calls)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("Expired Keys Start Over", func(t *testing.T) {
+		h := &countingHandler{status: http.StatusOK}
+		handler := Middleware(newMemoryBackend(), -time.Second, h.serve)
+
+		request(t, handler, "acme", "PUT", "/addContact", "key-1", body)
+		rr := request(t, handler, "acme", "PUT", "/addContact", "key-1", `{"first_name":"Grace"}`)
+		assert.Equal(t, 2, h.calls)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("Invalid Key", func(t *testing.T) {
+		h := &countingHandler{status: http.StatusOK}
+		handler := Middleware(newMemoryBackend(), time.Hour, h.serve)
+
+		rr := request(t, handler, "acme", "PUT", "/addContact", "has space", body)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		rr = request(t, handler, "acme", "PUT", "/addContact", strings.Repeat("k", maxKeyLength+1), body)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Zero(t, h.calls)
+	})
+}
+
+func TestInProgress(t *testing.T) {
+	backend := newMemoryBackend()
+	body := `{"first_name":"Ada"}`
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := Middleware(backend, time.Hour, func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		request(t, handler, "acme", "PUT", "/addContact", "key-1", body)
+	}()
+	<-started
+
+	rr := request(t, handler, "acme", "PUT", "/addContact", "key-1", body)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "idempotency-key-in-use")
+	assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+
+	close(release)
+	<-done
+	rr = request(t, handler, "acme", "PUT", "/addContact", "key-1", body)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "true", rr.Header().Get(ReplayedHeader))
+}
+
+func TestAbandonedKey(t *testing.T) {
+	backend := newMemoryBackend()
+	h := &countingHandler{status: http.StatusOK}
+	handler := Middleware(backend, time.Hour, h.serve)
+	body := `{"first_name":"Ada"}`
+	req := httptest.NewRequest("PUT", "/addContact", nil)
+
+	// An instance died while running the request
+	started := time.Now().UTC().Add(-2 * lockTimeout)
+	_, err := backend.Insert(&Record{Tenant: "acme", Key: "key-1", RequestHash: hashRequest(req, []byte(body)), CreatedAt: started, ExpiresAt: started.Add(time.Hour)})
+	require.NoError(t, err)
+
+	rr := request(t, handler, "acme", "PUT", "/addContact", "key-1", body)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 1, h.calls)
+}
@@ -0,0 +1,120 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golangphonebook/internal"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// How often expired keys are removed by default
+const DefaultPurgeInterval = time.Hour
+
+// Keys in Postgres, shared by every instance
+type Store struct {
+	DB *gorm.DB
+}
+
+// NewStore creates a new instance of Store
+func NewStore(db *gorm.DB) *Store {
+	return &Store{DB: db}
+}
+
+func (s *Store) Insert(rec *Record) (bool, error) {
+	result := s.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(rec)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (s *Store) Find(tenant string, key string) (*Record, error) {
+	var rec Record
+	err := s.DB.Where("tenant = ? AND key = ?", tenant, key).First(&rec).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// Only replaces the record when it is still the way old has it
+func (s *Store) TakeOver(old *Record, rec *Record) (bool, error) {
+	result := s.DB.Model(&Record{}).
+		Where("id = ? AND status = ? AND created_at = ?", old.ID, old.Status, old.CreatedAt).
+		Updates(map[string]any{
+			"request_hash": rec.RequestHash,
+			"status":       0,
+			"header":       "",
+			"body":         "",
+			"created_at":   rec.CreatedAt,
+			"expires_at":   rec.ExpiresAt,
+		})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	rec.ID = old.ID
+	return true, nil
+}
+
+func (s *Store) Save(rec *Record) error {
+	return s.DB.Model(&Record{}).Where("id = ?", rec.ID).
+		Updates(map[string]any{"status": rec.Status, "header": rec.Header, "body": rec.Body}).Error
+}
+
+func (s *Store) Delete(rec *Record) error {
+	return s.DB.Where("id = ? AND created_at = ?", rec.ID, rec.CreatedAt).Delete(&Record{}).Error
+}
+
+// Clear the stored responses of the tenant's keys whose body matches, for erasure. Retries still get the status
+// and headers of the first response, without its body. Bodies can be encrypted, so they are matched here rather
+// than in SQL. Returns how many were cleared.
+func (s *Store) EraseResponses(tenant string, match func(body []byte) bool) (int64, error) {
+	var recs []Record
+	if err := s.DB.Where("tenant = ? AND body <> ''", tenant).Find(&recs).Error; err != nil {
+		return 0, err
+	}
+	var ids []uint
+	for _, rec := range recs {
+		body, err := openBody(rec.Body)
+		if err != nil {
+			return 0, err
+		}
+		if match(body) {
+			ids = append(ids, rec.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	result := s.DB.Model(&Record{}).Where("id IN ?", ids).Update("body", "")
+	return result.RowsAffected, result.Error
+}
+
+// Remove keys that expired before now
+func (s *Store) Purge(now time.Time) (int64, error) {
+	result := s.DB.Where("expires_at <= ?", now).Delete(&Record{})
+	return result.RowsAffected, result.Error
+}
+
+// Remove expired keys every interval until the context is done. Expired keys are never replayed either way,
+// purging only keeps the table small.
+func (s *Store) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if purged, err := s.Purge(time.Now().UTC()); err != nil {
+			internal.Logger.ErrorContext(ctx, fmt.Sprintf("Failed to purge expired idempotency keys: %v", err))
+		} else if purged > 0 {
+			internal.Logger.InfoContext(ctx, fmt.Sprintf("Purged %d expired idempotency keys", purged))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,20 @@
+// Call counts and latencies of the gRPC methods
+package metrics
+
+import (
+	"strconv"
+	"time"
+)
+
+var (
+	grpcCalls = NewCounter("phonebook_grpc_calls_total",
+		"gRPC calls answered, by method and the HTTP status their code maps to", "method", "status")
+	grpcDuration = NewHistogram("phonebook_grpc_call_duration_seconds",
+		"Time taken to answer gRPC calls, by method and status. Streams count until they end.", DefaultBuckets, "method", "status")
+)
+
+// Count and time a call to the full method name, status is its code mapped to HTTP like in the audit log
+func ObserveCall(method string, status int, elapsed time.Duration) {
+	grpcCalls.Inc(method, strconv.Itoa(status))
+	grpcDuration.Observe(elapsed.Seconds(), method, strconv.Itoa(status))
+}
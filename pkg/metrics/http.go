@@ -0,0 +1,30 @@
+// Request counts and latencies of the HTTP routes
+package metrics
+
+import (
+	"golangphonebook/internal"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	httpRequests = NewCounter("phonebook_http_requests_total",
+		"HTTP requests answered, by route and status", "method", "route", "status")
+	httpDuration = NewHistogram("phonebook_http_request_duration_seconds",
+		"Time taken to answer HTTP requests, by route and status. Event streams count until they end.", DefaultBuckets, "method", "route", "status")
+)
+
+// Count and time every request to the route, route is its path template so IDs don't make a series each
+func Middleware(method, route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := internal.NewStatusRecorder(w)
+		next(rec, r)
+
+		// Hijacked WebSockets count as 200, like handlers that wrote nothing
+		status := rec.Status()
+		httpRequests.Inc(method, route, strconv.Itoa(status))
+		httpDuration.Observe(time.Since(start).Seconds(), method, route, strconv.Itoa(status))
+	}
+}
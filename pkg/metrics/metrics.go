@@ -0,0 +1,307 @@
+// Counters, gauges and histograms exposed in the Prometheus text format
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Buckets in seconds, from a millisecond up to 10 seconds
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics served together on one endpoint
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+	hooks   []func(ctx context.Context)
+}
+
+// Registry the package level constructors register with, served by main
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]metric{}}
+}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+// Metric names are unique, registering one twice is a programming error
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[name]; ok {
+		panic(fmt.Sprintf("metric %s registered twice", name))
+	}
+	r.metrics[name] = m
+}
+
+// Run hook before every scrape, for gauges that are cheaper to read on demand than to keep up to date
+func (r *Registry) BeforeScrape(hook func(ctx context.Context)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks = append(r.hooks, hook)
+}
+
+// Write every metric, sorted by name
+func (r *Registry) Write(ctx context.Context, w io.Writer) {
+	r.mu.Lock()
+	hooks := slices.Clone(r.hooks)
+	r.mu.Unlock()
+	for _, hook := range hooks {
+		hook(ctx)
+	}
+
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]metric, len(names))
+	for i, name := range names {
+		metrics[i] = r.metrics[name]
+	}
+	r.mu.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Serve the metrics for Prometheus to scrape
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	r.Write(req.Context(), w)
+}
+
+// Name, help and label names shared by every kind of metric
+type desc struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (d desc) header(w io.Writer, kind string) {
+	help := strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, help, d.name, kind)
+}
+
+// Key of the series with these label values, panics when their number doesn't match the label names
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s takes %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Label pairs like {route="/v2/contacts",status="200"}, with extra pairs appended as they are
+func (d desc) labelPairs(values []string, extra ...string) string {
+	pairs := make([]string, 0, len(values)+len(extra))
+	for i, value := range values {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, d.labels[i], labelEscaper.Replace(value)))
+	}
+	pairs = append(pairs, extra...)
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Values of every series of a counter or gauge, by label values
+type values struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*sample
+}
+
+type sample struct {
+	labels []string
+	value  float64
+}
+
+func (v *values) add(delta float64, labels []string) {
+	key := v.key(labels)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = &sample{labels: slices.Clone(labels)}
+		v.series[key] = s
+	}
+	s.value += delta
+}
+
+func (v *values) set(value float64, labels []string) {
+	key := v.key(labels)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.series[key] = &sample{labels: slices.Clone(labels), value: value}
+}
+
+func (v *values) write(w io.Writer, kind string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.header(w, kind)
+	for _, key := range sortedKeys(v.series) {
+		s := v.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", v.name, v.labelPairs(s.labels), formatValue(s.value))
+	}
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Only goes up, like requests served
+type Counter struct {
+	values
+}
+
+// NewCounter creates a counter with the given label names and registers it with Default
+func NewCounter(name, help string, labels ...string) *Counter {
+	return Default.NewCounter(name, help, labels...)
+}
+
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{values{desc: desc{name, help, labels}, series: map[string]*sample{}}}
+	r.register(name, c)
+	return c
+}
+
+func (c *Counter) Inc(labels ...string) {
+	c.add(1, labels)
+}
+
+// Add n, which can't be negative
+func (c *Counter) Add(n float64, labels ...string) {
+	if n < 0 {
+		panic(fmt.Sprintf("counter %s can't go down", c.name))
+	}
+	c.add(n, labels)
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.values.write(w, "counter")
+}
+
+// Goes up and down, like the number of contacts
+type Gauge struct {
+	values
+}
+
+// NewGauge creates a gauge with the given label names and registers it with Default
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return Default.NewGauge(name, help, labels...)
+}
+
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{values{desc: desc{name, help, labels}, series: map[string]*sample{}}}
+	r.register(name, g)
+	return g
+}
+
+func (g *Gauge) Set(value float64, labels ...string) {
+	g.set(value, labels)
+}
+
+// Drop every series, for gauges that are rebuilt from scratch
+func (g *Gauge) Reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.series = map[string]*sample{}
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.values.write(w, "gauge")
+}
+
+// Counts observations into buckets, like request latencies
+type Histogram struct {
+	desc
+	buckets []float64 // Upper bounds, ascending, without +Inf
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64 // Per bucket, not cumulative, with +Inf last
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram creates a histogram with the given buckets and label names and registers it with Default
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{desc: desc{name, help, labels}, buckets: slices.Sorted(slices.Values(buckets)), series: map[string]*histogramSeries{}}
+	r.register(name, h)
+	return h
+}
+
+func (h *Histogram) Observe(value float64, labels ...string) {
+	key := h.key(labels)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labels: slices.Clone(labels), counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	i, _ := slices.BinarySearch(h.buckets, value)
+	s.counts[i]++
+	s.sum += value
+	s.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w, "histogram")
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, count := range s.counts {
+			cumulative += count
+			le := "+Inf"
+			if i < len(h.buckets) {
+				le = formatValue(h.buckets[i])
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(s.labels, fmt.Sprintf(`le="%s"`, le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(s.labels), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(s.labels), s.count)
+	}
+}
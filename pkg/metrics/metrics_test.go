@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func scrape(r *Registry) string {
+	var b strings.Builder
+	r.Write(context.Background(), &b)
+	return b.String()
+}
+
+func TestCounter(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("requests_total", "Requests\nanswered", "route", "status")
+	c.Inc("/v2/contacts", "200")
+	c.Inc("/v2/contacts", "200")
+	c.Add(3, `/say "hi"`, "404")
+
+	assert.Equal(t, `# HELP requests_total Requests\nanswered
+# TYPE requests_total counter
+requests_total{route="/say \"hi\"",status="404"} 3
+requests_total{route="/v2/contacts",status="200"} 2
+`, scrape(r))
+
+	assert.Panics(t, func() { c.Add(-1, "/v2/contacts", "200") }, "counters only go up")
+	assert.Panics(t, func() { c.Inc("/v2/contacts") }, "every label needs a value")
+	assert.Panics(t, func() { r.NewCounter("requests_total", "Again") }, "names are unique")
+}
+
+func TestGauge(t *testing.T) {
+	r := NewRegistry()
+	g := r.NewGauge("contacts", "Contacts stored", "tenant")
+	g.Set(5, "acme")
+	g.Set(2, "acme")
+	assert.Contains(t, scrape(r), "contacts{tenant=\"acme\"} 2\n")
+
+	g.Reset()
+	assert.Equal(t, "# HELP contacts Contacts stored\n# TYPE contacts gauge\n", scrape(r))
+}
+
+func TestHistogram(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogram("latency_seconds", "Latency", []float64{1, 0.1}, "method")
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		h.Observe(v, "GetContact")
+	}
+
+	assert.Equal(t, `# HELP latency_seconds Latency
+# TYPE latency_seconds histogram
+latency_seconds_bucket{method="GetContact",le="0.1"} 2
+latency_seconds_bucket{method="GetContact",le="1"} 3
+latency_seconds_bucket{method="GetContact",le="+Inf"} 4
+latency_seconds_sum{method="GetContact"} 3.65
+latency_seconds_count{method="GetContact"} 4
+`, scrape(r), "buckets are cumulative and include their upper bound")
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	g := r.NewGauge("b_gauge", "Set before each scrape")
+	r.NewCounter("a_total", "Comes first").Inc()
+	scrapes := 0
+	r.BeforeScrape(func(ctx context.Context) {
+		scrapes++
+		g.Set(float64(scrapes))
+	})
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+	body := rec.Body.String()
+	assert.Less(t, strings.Index(body, "a_total"), strings.Index(body, "b_gauge"), "sorted by name")
+	assert.Contains(t, body, "b_gauge 1\n")
+	assert.Contains(t, scrape(r), "b_gauge 2\n")
+}
+
+func TestMiddleware(t *testing.T) {
+	handler := Middleware("GET", "/test/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/test/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("ok"))
+		// Event streams flush through the recorder
+		assert.NoError(t, http.NewResponseController(w).Flush())
+	})
+	for _, path := range []string{"/test/1", "/test/2", "/test/missing"} {
+		handler(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	body := scrape(Default)
+	assert.Contains(t, body, `phonebook_http_requests_total{method="GET",route="/test/{id}",status="200"} 2`)
+	assert.Contains(t, body, `phonebook_http_requests_total{method="GET",route="/test/{id}",status="404"} 1`)
+	assert.Contains(t, body, `phonebook_http_request_duration_seconds_count{method="GET",route="/test/{id}",status="200"} 2`)
+}
+
+func TestObserveCall(t *testing.T) {
+	ObserveCall("/test.Service/Get", 200, 10*time.Millisecond)
+	ObserveCall("/test.Service/Get", 404, time.Millisecond)
+
+	body := scrape(Default)
+	assert.Contains(t, body, `phonebook_grpc_calls_total{method="/test.Service/Get",status="200"} 1`)
+	assert.Contains(t, body, `phonebook_grpc_calls_total{method="/test.Service/Get",status="404"} 1`)
+	assert.Contains(t, body, `phonebook_grpc_call_duration_seconds_count{method="/test.Service/Get",status="200"} 1`)
+}
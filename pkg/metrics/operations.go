@@ -0,0 +1,12 @@
+// Durations of the operations handlers and API methods time themselves
+package metrics
+
+import "time"
+
+var operationDuration = NewHistogram("phonebook_operation_duration_seconds",
+	"Time taken by handlers and API operations, by the name they time themselves with", DefaultBuckets, "operation")
+
+// Record how long an operation took, main sets it as internal.OperationObserver
+func ObserveOperation(name string, elapsed time.Duration) {
+	operationDuration.Observe(elapsed.Seconds(), name)
+}
@@ -0,0 +1,301 @@
+// Versioned schema migrations, applied in order and recorded in the schema_migrations table
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golangphonebook/internal"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Table the applied migrations are recorded in
+const Table = "schema_migrations"
+
+// Held while migrating, so instances started together don't apply the same migration twice
+const lockKey = 7_290_050
+
+var (
+	ErrUnknownVersion = errors.New("unknown migration version")
+	ErrIrreversible   = errors.New("migration can't be reverted")
+)
+
+// One step of the schema. Up is the SQL applying it and Down the SQL reverting it, both run in a transaction
+// with the version's row in schema_migrations. Once released a migration is never changed, the schema moves on
+// with a new one.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string // Empty when the migration can't be reverted
+}
+
+// Where a migration stands in the database
+type State struct {
+	Migration
+	AppliedAt *time.Time // Nil while pending
+	Unknown   bool       // Applied by a newer build, this one doesn't know it
+}
+
+// Row of schema_migrations
+type applied struct {
+	Version   int64     `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"type:text;not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+func (applied) TableName() string {
+	return Table
+}
+
+// A migration to apply, or to revert when down is set
+type step struct {
+	Migration
+	down bool
+}
+
+// Applies and reverts migrations on a database
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// New migrator for the migrations, in order of their versions. Panics when the versions aren't increasing,
+// the list is part of the program rather than something read at runtime.
+func New(db *gorm.DB, migrations []Migration) *Migrator {
+	for i, m := range migrations {
+		if m.Version <= 0 || i > 0 && m.Version <= migrations[i-1].Version {
+			panic(fmt.Sprintf("migration %d %s is out of order", m.Version, m.Name))
+		}
+	}
+	return &Migrator{db: db, migrations: migrations}
+}
+
+// Version of the newest migration
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Every migration with whether and when it was applied, in order of their versions
+func (m *Migrator) Status(ctx context.Context) ([]State, error) {
+	done, err := m.applied(m.db.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	var states []State
+	for _, migration := range m.migrations {
+		state := State{Migration: migration}
+		if row, ok := done[migration.Version]; ok {
+			state.AppliedAt = &row.AppliedAt
+			delete(done, migration.Version)
+		}
+		states = append(states, state)
+	}
+	for _, row := range done {
+		states = append(states, State{Migration: Migration{Version: row.Version, Name: row.Name}, AppliedAt: &row.AppliedAt, Unknown: true})
+	}
+	slices.SortFunc(states, func(a, b State) int { return int(a.Version - b.Version) })
+	return states, nil
+}
+
+// Whether the database is at the latest version, reporting pending and unknown migrations otherwise
+func (m *Migrator) Check(ctx context.Context) error {
+	states, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	var pending, unknown []string
+	for _, state := range states {
+		if state.Unknown {
+			unknown = append(unknown, fmt.Sprint(state.Version))
+		} else if state.AppliedAt == nil {
+			pending = append(pending, fmt.Sprint(state.Version))
+		}
+	}
+	var problems []string
+	if len(pending) > 0 {
+		problems = append(problems, "pending migrations "+strings.Join(pending, ", "))
+	}
+	if len(unknown) > 0 {
+		problems = append(problems, "unknown migrations "+strings.Join(unknown, ", ")+" applied by a newer version")
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// Apply every pending migration, returns how many were. Migrations of a newer version are left alone,
+// so an older version can still start after a newer one migrated the database.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	return m.migrate(ctx, false, func(map[int64]applied) (int64, error) {
+		return m.Latest(), nil
+	})
+}
+
+// Revert the latest steps applied migrations, returns how many were
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	return m.migrate(ctx, true, func(done map[int64]applied) (int64, error) {
+		versions := make([]int64, 0, len(done))
+		for version := range done {
+			versions = append(versions, version)
+		}
+		slices.Sort(versions)
+		if steps >= len(versions) {
+			return 0, nil
+		}
+		return versions[len(versions)-steps-1], nil
+	})
+}
+
+// Apply or revert migrations until the database is at version, 0 reverts every migration. Returns how many ran.
+func (m *Migrator) To(ctx context.Context, version int64) (int, error) {
+	return m.migrate(ctx, true, func(map[int64]applied) (int64, error) {
+		return version, nil
+	})
+}
+
+// Run the steps to the version target picks, holding the migration lock on one connection throughout.
+// Without revert only pending migrations are applied.
+func (m *Migrator) migrate(ctx context.Context, revert bool, target func(done map[int64]applied) (int64, error)) (int, error) {
+	ran := 0
+	err := m.db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		unlock, err := lock(ctx, conn)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		if err := conn.Exec(`CREATE TABLE IF NOT EXISTS ` + Table + ` ("version" bigint PRIMARY KEY, "name" text NOT NULL, "applied_at" timestamptz NOT NULL)`).Error; err != nil {
+			return fmt.Errorf("failed to create %s: %w", Table, err)
+		}
+		done, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+		version, err := target(done)
+		if err != nil {
+			return err
+		}
+		steps, err := plan(m.migrations, done, version, revert)
+		if err != nil {
+			return err
+		}
+		for _, s := range steps {
+			if err := run(conn, s); err != nil {
+				return err
+			}
+			ran++
+		}
+		return nil
+	})
+	return ran, err
+}
+
+// Take the migration lock, waiting for another instance that holds it to finish
+func lock(ctx context.Context, conn *gorm.DB) (func(), error) {
+	var locked bool
+	if err := conn.Raw("SELECT pg_try_advisory_lock(?)", lockKey).Scan(&locked).Error; err != nil {
+		return nil, fmt.Errorf("failed to take the migration lock: %w", err)
+	}
+	if !locked {
+		internal.Logger.InfoContext(ctx, "Waiting for another instance to finish migrating")
+		if err := conn.Exec("SELECT pg_advisory_lock(?)", lockKey).Error; err != nil {
+			return nil, fmt.Errorf("failed to take the migration lock: %w", err)
+		}
+	}
+	return func() {
+		// Released with the connection too, should it have been lost
+		conn.WithContext(context.WithoutCancel(ctx)).Exec("SELECT pg_advisory_unlock(?)", lockKey)
+	}, nil
+}
+
+// Migrations recorded in schema_migrations by version, none before it exists
+func (m *Migrator) applied(db *gorm.DB) (map[int64]applied, error) {
+	done := make(map[int64]applied)
+	if !db.Migrator().HasTable(Table) {
+		return done, nil
+	}
+	var rows []applied
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to read the applied migrations: %w", err)
+	}
+	for _, row := range rows {
+		done[row.Version] = row
+	}
+	return done, nil
+}
+
+// Steps taking the database from the done migrations to target: the pending ones up to it in order,
+// then with revert the applied ones after it, newest first
+func plan(migrations []Migration, done map[int64]applied, target int64, revert bool) ([]step, error) {
+	known := target == 0
+	for _, m := range migrations {
+		known = known || m.Version == target
+	}
+	if !known {
+		return nil, fmt.Errorf("%w %d", ErrUnknownVersion, target)
+	}
+
+	var steps []step
+	for _, m := range migrations {
+		if _, ok := done[m.Version]; !ok && m.Version <= target {
+			steps = append(steps, step{Migration: m})
+		}
+	}
+	if !revert {
+		return steps, nil
+	}
+	var after []int64
+	for version := range done {
+		if version > target {
+			after = append(after, version)
+		}
+	}
+	slices.Sort(after)
+	for _, version := range slices.Backward(after) {
+		i := slices.IndexFunc(migrations, func(m Migration) bool { return m.Version == version })
+		if i < 0 {
+			return nil, fmt.Errorf("%w %d, it was applied by a newer version and has to be reverted by it", ErrUnknownVersion, version)
+		}
+		if migrations[i].Down == "" {
+			return nil, fmt.Errorf("%w: %d %s", ErrIrreversible, version, migrations[i].Name)
+		}
+		steps = append(steps, step{Migration: migrations[i], down: true})
+	}
+	return steps, nil
+}
+
+// Apply or revert the migration and record it, in one transaction
+func run(conn *gorm.DB, s step) error {
+	ctx := conn.Statement.Context
+	start := time.Now()
+	err := conn.Transaction(func(tx *gorm.DB) error {
+		if s.down {
+			if err := tx.Exec(s.Down).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&applied{}, s.Version).Error
+		}
+		if err := tx.Exec(s.Up).Error; err != nil {
+			return err
+		}
+		return tx.Create(&applied{Version: s.Version, Name: s.Name, AppliedAt: time.Now().UTC()}).Error
+	})
+	verb, done := "apply", "Applied"
+	if s.down {
+		verb, done = "revert", "Reverted"
+	}
+	if err != nil {
+		return fmt.Errorf("failed to %s migration %d %s: %w", verb, s.Version, s.Name, err)
+	}
+	internal.Logger.InfoContext(ctx, fmt.Sprintf("%s migration %d %s in %s", done, s.Version, s.Name, time.Since(start).Round(time.Millisecond)))
+	return nil
+}
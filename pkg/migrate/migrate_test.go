@@ -0,0 +1,77 @@
+package migrate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testMigrations = []Migration{
+	{Version: 1, Name: "one", Up: "CREATE 1", Down: "DROP 1"},
+	{Version: 2, Name: "two", Up: "CREATE 2"},
+	{Version: 5, Name: "five", Up: "CREATE 5", Down: "DROP 5"},
+}
+
+func appliedVersions(versions ...int64) map[int64]applied {
+	done := make(map[int64]applied)
+	for _, v := range versions {
+		done[v] = applied{Version: v}
+	}
+	return done
+}
+
+// Versions of the steps, negative for the reverted ones
+func versions(steps []step) []int64 {
+	var result []int64
+	for _, s := range steps {
+		if s.down {
+			result = append(result, -s.Version)
+		} else {
+			result = append(result, s.Version)
+		}
+	}
+	return result
+}
+
+func TestPlan(t *testing.T) {
+	tests := []struct {
+		name    string
+		done    map[int64]applied
+		target  int64
+		up      bool // Only apply, like Up
+		want    []int64
+		wantErr error
+	}{
+		{"Up From Nothing", appliedVersions(), 5, false, []int64{1, 2, 5}, nil},
+		{"Up Part Of The Way", appliedVersions(), 2, false, []int64{1, 2}, nil},
+		{"Pending In The Middle", appliedVersions(1, 5), 5, false, []int64{2}, nil},
+		{"Already There", appliedVersions(1, 2, 5), 5, false, nil, nil},
+		{"Down", appliedVersions(1, 2, 5), 2, false, []int64{-5}, nil},
+		{"Irreversible", appliedVersions(1, 2, 5), 1, false, nil, ErrIrreversible},
+		{"Unknown Target", appliedVersions(1), 3, false, nil, ErrUnknownVersion},
+		{"Applied By A Newer Version", appliedVersions(1, 2, 5, 6), 5, false, nil, ErrUnknownVersion},
+		{"Newer Versions Left Alone Going Up", appliedVersions(1, 6), 5, true, []int64{2, 5}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps, err := plan(testMigrations, tt.done, tt.target, !tt.up)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, versions(steps))
+		})
+	}
+}
+
+func TestNewOrder(t *testing.T) {
+	assert.Equal(t, int64(5), New(nil, testMigrations).Latest())
+	assert.Panics(t, func() {
+		New(nil, []Migration{{Version: 2}, {Version: 1}})
+	})
+	assert.Panics(t, func() {
+		New(nil, []Migration{{Version: 1}, {Version: 1}})
+	})
+}
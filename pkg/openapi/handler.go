@@ -0,0 +1,46 @@
+// Serve the OpenAPI document and a docs page that renders it
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"golangphonebook/internal"
+	"golangphonebook/pkg/problem"
+	"html/template"
+	"net/http"
+)
+
+func ServeSpec(w http.ResponseWriter, r *http.Request, doc *Document) {
+	defer internal.Timer("ServeSpec")()
+
+	response, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to serialize OpenAPI document: %v", err))
+		problem.Error(w, r, "Failed to serialize OpenAPI document", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// The page has no external scripts or styles, so it works without internet access
+//
+//go:embed docs.html
+var docsPage string
+
+var docsTemplate = template.Must(template.New("docs").Parse(docsPage))
+
+// Docs page that loads the document from specURL
+func ServeDocs(w http.ResponseWriter, r *http.Request, specURL string) {
+	defer internal.Timer("ServeDocs")()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; connect-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if err := docsTemplate.Execute(w, map[string]string{"SpecURL": specURL}); err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to render docs page: %v", err))
+	}
+}
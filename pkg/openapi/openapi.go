@@ -0,0 +1,314 @@
+// Generate an OpenAPI 3.1 document from the route table and the Go types handlers read and write
+package openapi
+
+import (
+	"fmt"
+	"golangphonebook/pkg/problem"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const Version = "3.1.0"
+
+type Document struct {
+	OpenAPI    string                `json:"openapi"`
+	Info       Info                  `json:"info"`
+	Paths      map[string]PathItem   `json:"paths"`
+	Components Components            `json:"components"`
+	Security   []map[string][]string `json:"security,omitempty"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// Operations of one path, keyed by the lower case method
+type PathItem map[string]*OperationObject
+
+type OperationObject struct {
+	OperationID  string               `json:"operationId"`
+	Summary      string               `json:"summary,omitempty"`
+	Description  string               `json:"description,omitempty"`
+	Tags         []string             `json:"tags,omitempty"`
+	Parameters   []Parameter          `json:"parameters,omitempty"`
+	RequestBody  *RequestBody         `json:"requestBody,omitempty"`
+	Responses    map[string]*Response `json:"responses"`
+	Deprecated   bool                 `json:"deprecated,omitempty"`
+	RequiredRole string               `json:"x-required-role,omitempty"` // Minimum role the client certificate has to grant
+	AuditAction  string               `json:"x-audit-action,omitempty"`  // Action the request is recorded as in the audit log
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"` // query, header or path, query when empty
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Headers     map[string]Header    `json:"headers,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+}
+
+// How one route is documented. Request and response bodies are given as values of the Go types the handler
+// decodes and encodes, so the schemas follow the types.
+type Operation struct {
+	Method       string
+	Path         string // mux path template, like /v2/contacts/{id}
+	Summary      string
+	Description  string
+	Tag          string
+	Role         string
+	AuditAction  string
+	Deprecated   bool
+	Parameters   []Parameter // Path parameters that aren't listed are documented as integer IDs
+	Body         any         // Request body, nil when there is none
+	BodyType     string      // Content type of the request body, application/json when empty
+	Response     any         // Success response body, nil when there is none
+	ResponseType string      // Content type of the success response, application/json when empty
+	Status       int         // Success status, 200 when zero
+	Headers      map[string]string
+	Partial      map[int]string // Other statuses with the same body as the success response, with their description
+	Errors       []int          // Statuses of the problem+json responses besides the ones every route can return
+}
+
+// Problem responses every authenticated route can return
+var commonErrors = []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusInternalServerError}
+
+// Matches {name} and {name:pattern} in mux path templates
+var pathVariable = regexp.MustCompile(`\{([^}:]+)(?::[^}]*)?\}`)
+
+// Path variables of a mux path template, in order
+func PathVariables(path string) []string {
+	var names []string
+	for _, match := range pathVariable.FindAllStringSubmatch(path, -1) {
+		names = append(names, match[1])
+	}
+	return names
+}
+
+// OpenAPI path for a mux path template, which drops the patterns of path variables
+func OpenAPIPath(path string) string {
+	return pathVariable.ReplaceAllString(path, "{$1}")
+}
+
+func Generate(info Info, operations []Operation, options Options) (*Document, error) {
+	gen := newGenerator(options)
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   make(map[string]PathItem),
+		Components: Components{
+			Schemas: gen.schemas,
+			SecuritySchemes: map[string]SecurityScheme{
+				"clientCertificate": {Type: "mutualTLS", Description: "Client certificate signed by the server's CA, mapped to a role and tenant"},
+			},
+		},
+		Security: []map[string][]string{{"clientCertificate": {}}},
+	}
+	gen.schemas["Problem"] = problemSchema()
+
+	for _, op := range operations {
+		path := OpenAPIPath(op.Path)
+		method := strings.ToLower(op.Method)
+		item, ok := doc.Paths[path]
+		if !ok {
+			item = make(PathItem)
+			doc.Paths[path] = item
+		}
+		if _, exists := item[method]; exists {
+			return nil, fmt.Errorf("%s %s is documented twice", op.Method, op.Path)
+		}
+
+		operation, err := gen.operation(op)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", op.Method, op.Path, err)
+		}
+		item[method] = operation
+	}
+	return doc, nil
+}
+
+func (g *generator) operation(op Operation) (*OperationObject, error) {
+	result := &OperationObject{
+		OperationID:  operationID(op.Method, op.Path),
+		Summary:      op.Summary,
+		Description:  op.Description,
+		Deprecated:   op.Deprecated,
+		RequiredRole: op.Role,
+		AuditAction:  op.AuditAction,
+		Responses:    make(map[string]*Response),
+	}
+	if op.Tag != "" {
+		result.Tags = []string{op.Tag}
+	}
+
+	documented := make(map[string]bool)
+	for _, param := range op.Parameters {
+		if param.In == "" {
+			param.In = "query"
+		}
+		if param.In == "path" {
+			param.Required = true
+		}
+		documented[param.Name] = true
+		result.Parameters = append(result.Parameters, param)
+	}
+	for _, name := range PathVariables(op.Path) {
+		if !documented[name] {
+			result.Parameters = append(result.Parameters, Parameter{Name: name, In: "path", Required: true, Schema: Type("integer")})
+		}
+	}
+	for _, param := range result.Parameters {
+		if param.In == "path" && !contains(PathVariables(op.Path), param.Name) {
+			return nil, fmt.Errorf("path parameter %s isn't in the path", param.Name)
+		}
+	}
+
+	if op.Body != nil {
+		schema, err := g.schemaFor(op.Body)
+		if err != nil {
+			return nil, err
+		}
+		result.RequestBody = &RequestBody{Required: true, Content: map[string]MediaType{contentType(op.BodyType): {Schema: schema}}}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := &Response{Description: http.StatusText(status)}
+	if op.Response != nil {
+		schema, err := g.schemaFor(op.Response)
+		if err != nil {
+			return nil, err
+		}
+		success.Content = map[string]MediaType{contentType(op.ResponseType): {Schema: schema}}
+	}
+	for name, description := range op.Headers {
+		if success.Headers == nil {
+			success.Headers = make(map[string]Header)
+		}
+		success.Headers[name] = Header{Description: description, Schema: Type("string")}
+	}
+	result.Responses[fmt.Sprint(status)] = success
+	for code, description := range op.Partial {
+		result.Responses[fmt.Sprint(code)] = &Response{Description: description, Content: success.Content}
+	}
+
+	for _, code := range append(append([]int(nil), op.Errors...), commonErrors...) {
+		result.Responses[fmt.Sprint(code)] = problemResponse(code)
+	}
+	return result, nil
+}
+
+func contentType(value string) string {
+	if value == "" {
+		return "application/json"
+	}
+	return value
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Stable ID from the method and path, like getV2ContactsById for GET /v2/contacts/{id}
+func operationID(method string, path string) string {
+	id := strings.ToLower(method)
+	for _, segment := range strings.Split(OpenAPIPath(path), "/") {
+		if segment == "" {
+			continue
+		}
+		if strings.HasPrefix(segment, "{") {
+			id += "By"
+			segment = strings.Trim(segment, "{}")
+		}
+		id += strings.ToUpper(segment[:1]) + segment[1:]
+	}
+	return id
+}
+
+// The problem types a status can be reported with, from the problem registry
+func problemResponse(status int) *Response {
+	var types []string
+	for _, kind := range problem.Kinds() {
+		if kind.Status == status {
+			types = append(types, problem.TypeFor(kind.Slug))
+		}
+	}
+	sort.Strings(types)
+
+	description := http.StatusText(status)
+	if len(types) > 0 {
+		description += ", one of " + strings.Join(types, ", ")
+	}
+	return &Response{
+		Description: description,
+		Content:     map[string]MediaType{problem.ContentType: {Schema: Ref("Problem")}},
+	}
+}
+
+// RFC 7807 problem details as written by the problem package
+func problemSchema() *Schema {
+	var types []any
+	for _, kind := range problem.Kinds() {
+		types = append(types, problem.TypeFor(kind.Slug))
+	}
+	return &Schema{
+		Type:     "object",
+		Required: []string{"type", "title", "status"},
+		Properties: map[string]*Schema{
+			"type":     {Type: "string", Description: "Problem type, stable across releases. Statuses without a registered type use /problems/<status text>.", Examples: types},
+			"title":    {Type: "string"},
+			"status":   {Type: "integer"},
+			"detail":   {Type: "string"},
+			"instance": {Type: "string"},
+			"errors": {Type: "array", Items: &Schema{
+				Type:     "object",
+				Required: []string{"field", "rule", "message"},
+				Properties: map[string]*Schema{
+					"field":   {Type: "string"},
+					"rule":    {Type: "string"},
+					"param":   {Type: "string"},
+					"message": {Type: "string"},
+				},
+			}},
+		},
+	}
+}
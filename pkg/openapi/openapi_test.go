@@ -0,0 +1,100 @@
+package openapi_test
+
+import (
+	"golangphonebook/pkg/openapi"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type book struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Title     string    `json:"title" validate:"required,max=100"`
+	Code      string    `json:"code" validate:"required,isbn"`
+	Tags      []string  `json:"tags" validate:"max=5"`
+	Rating    int       `json:"rating" validate:"min=1,max=5"`
+	Format    string    `json:"format" validate:"oneof=paper ebook"`
+	Internal  string    `json:"-"`
+	Published time.Time `json:"published"`
+	Previous  *book     `json:"previous,omitempty"`
+	Note      *string   `json:"note"`
+}
+
+type shelf struct {
+	Books []book `json:"books"`
+	Count int64  `json:"count"`
+}
+
+var options = openapi.Options{Patterns: map[string]string{"isbn": "^[0-9]{13}$"}}
+
+func generate(t *testing.T, ops ...openapi.Operation) *openapi.Document {
+	doc, err := openapi.Generate(openapi.Info{Title: "Books", Version: "1"}, ops, options)
+	require.NoError(t, err)
+	return doc
+}
+
+func TestSchemaFromTags(t *testing.T) {
+	doc := generate(t,
+		openapi.Operation{Method: "GET", Path: "/shelf", Response: shelf{}},
+		openapi.Operation{Method: "PUT", Path: "/books/{id}", Body: book{}, Response: book{}, Errors: []int{http.StatusNotFound}},
+	)
+
+	schema := doc.Components.Schemas["book"]
+	require.NotNil(t, schema)
+	assert.Equal(t, []string{"title", "code"}, schema.Required)
+	assert.NotContains(t, schema.Properties, "Internal")
+	assert.True(t, schema.Properties["id"].ReadOnly)
+	assert.Equal(t, 1, *schema.Properties["title"].MinLength)
+	assert.Equal(t, 100, *schema.Properties["title"].MaxLength)
+	assert.Equal(t, "^[0-9]{13}$", schema.Properties["code"].Pattern)
+	assert.Equal(t, 5, *schema.Properties["tags"].MaxItems)
+	assert.Equal(t, 1.0, *schema.Properties["rating"].Minimum)
+	assert.Equal(t, 5.0, *schema.Properties["rating"].Maximum)
+	assert.Equal(t, []any{"paper", "ebook"}, schema.Properties["format"].Enum)
+	assert.Equal(t, "date-time", schema.Properties["published"].Format)
+	assert.Equal(t, "#/components/schemas/book", schema.Properties["previous"].Ref)
+	assert.Equal(t, []any{"string", "null"}, schema.Properties["note"].Type)
+
+	shelfSchema := doc.Components.Schemas["shelf"]
+	assert.Equal(t, "#/components/schemas/book", shelfSchema.Properties["books"].Items.Ref)
+
+	put := doc.Paths["/books/{id}"]["put"]
+	assert.Equal(t, "putBooksById", put.OperationID)
+	require.Len(t, put.Parameters, 1)
+	assert.Equal(t, "path", put.Parameters[0].In)
+	assert.True(t, put.Parameters[0].Required)
+	assert.Contains(t, put.Responses, "404")
+	assert.Contains(t, put.Responses, "401")
+	assert.Contains(t, put.Responses["404"].Content, "application/problem+json")
+}
+
+func TestUnknownValidateRule(t *testing.T) {
+	_, err := openapi.Generate(openapi.Info{}, []openapi.Operation{{Method: "POST", Path: "/books", Body: book{}}}, openapi.Options{})
+	assert.ErrorContains(t, err, "isbn")
+}
+
+func TestDocumentedTwice(t *testing.T) {
+	_, err := openapi.Generate(openapi.Info{}, []openapi.Operation{{Method: "GET", Path: "/shelf"}, {Method: "GET", Path: "/shelf"}}, options)
+	assert.Error(t, err)
+}
+
+func TestPathVariables(t *testing.T) {
+	assert.Equal(t, []string{"id", "rev"}, openapi.PathVariables("/books/{id:[0-9]+}/revisions/{rev}"))
+	assert.Equal(t, "/books/{id}/revisions/{rev}", openapi.OpenAPIPath("/books/{id:[0-9]+}/revisions/{rev}"))
+}
+
+func TestServeDocs(t *testing.T) {
+	rr := httptest.NewRecorder()
+	openapi.ServeDocs(rr, httptest.NewRequest("GET", "/docs", nil), "/openapi.json")
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, rr.Body.String(), `var specURL = "/openapi.json"`)
+	// Self-contained, nothing is loaded from anywhere else
+	assert.False(t, strings.Contains(rr.Body.String(), "http://") || strings.Contains(rr.Body.String(), "https://"))
+}
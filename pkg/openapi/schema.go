@@ -0,0 +1,307 @@
+// JSON schemas for Go types, following the json, validate and gorm struct tags
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 any                `json:"type,omitempty"` // A type name, or a list of them for nullable values
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Examples             []any              `json:"examples,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	ReadOnly             bool               `json:"readOnly,omitempty"`
+}
+
+func Type(name string) *Schema {
+	return &Schema{Type: name}
+}
+
+func Enum(values ...string) *Schema {
+	schema := &Schema{Type: "string"}
+	for _, v := range values {
+		schema.Enum = append(schema.Enum, v)
+	}
+	return schema
+}
+
+func Ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+type Options struct {
+	// Regular expressions of custom validate rules, like customPhone
+	Patterns map[string]string
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// Builds schemas and collects every named struct as a component
+type generator struct {
+	options Options
+	schemas map[string]*Schema
+	names   map[reflect.Type]string
+}
+
+func newGenerator(options Options) *generator {
+	return &generator{options: options, schemas: make(map[string]*Schema), names: make(map[reflect.Type]string)}
+}
+
+// Schema of a value's type, for example a Contact{} or a []Contact{}
+func (g *generator) schemaFor(value any) (*Schema, error) {
+	return g.schema(reflect.TypeOf(value))
+}
+
+func (g *generator) schema(t reflect.Type) (*Schema, error) {
+	if t.Kind() == reflect.Pointer {
+		return g.schema(t.Elem())
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}, nil
+	case t == rawMessageType:
+		return &Schema{}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return Type("string"), nil
+	case reflect.Bool:
+		return Type("boolean"), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer", Format: intFormat(t)}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		zero := 0.0
+		return &Schema{Type: "integer", Format: intFormat(t), Minimum: &zero}, nil
+	case reflect.Float32, reflect.Float64:
+		return Type("number"), nil
+	case reflect.Interface:
+		return &Schema{}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}, nil
+		}
+		items, err := g.schema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "array", Items: items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("map keys of %s aren't strings", t)
+		}
+		values, err := g.schema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "object", AdditionalProperties: values}, nil
+	case reflect.Struct:
+		return g.component(t)
+	}
+	return nil, fmt.Errorf("no JSON schema for %s", t)
+}
+
+func intFormat(t reflect.Type) string {
+	if t.Bits() == 64 {
+		return "int64"
+	}
+	return "int32"
+}
+
+// Named structs become components, so a type used by several routes is described once
+func (g *generator) component(t reflect.Type) (*Schema, error) {
+	if t.Name() == "" {
+		return g.object(t)
+	}
+	if name, ok := g.names[t]; ok {
+		return Ref(name), nil
+	}
+
+	name := t.Name()
+	if _, taken := g.schemas[name]; taken {
+		pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	// Reserve the name first, types can refer to themselves
+	g.names[t] = name
+	g.schemas[name] = nil
+
+	schema, err := g.object(t)
+	if err != nil {
+		return nil, err
+	}
+	g.schemas[name] = schema
+	return Ref(name), nil
+}
+
+func (g *generator) object(t reflect.Type) (*Schema, error) {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	if err := g.addFields(schema, t); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+func (g *generator) addFields(schema *Schema, t reflect.Type) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+
+		// Untagged embedded structs are flattened by encoding/json
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			if err := g.addFields(schema, field.Type); err != nil {
+				return err
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property, err := g.schema(field.Type)
+		if err != nil {
+			return fmt.Errorf("field %s of %s: %w", field.Name, t, err)
+		}
+		if field.Type.Kind() == reflect.Pointer && property.Ref == "" {
+			property.Type = []any{property.Type, "null"}
+		}
+		required, err := g.applyRules(property, field.Type, field.Tag.Get("validate"))
+		if err != nil {
+			return fmt.Errorf("field %s of %s: %w", field.Name, t, err)
+		}
+		if required {
+			schema.Required = append(schema.Required, name)
+		}
+		if readOnly(field.Tag.Get("gorm")) {
+			property.ReadOnly = true
+		}
+		schema.Properties[name] = property
+	}
+	return nil
+}
+
+// IDs and timestamps the database sets are ignored in requests
+func readOnly(gormTag string) bool {
+	for _, setting := range strings.Split(gormTag, ";") {
+		switch strings.ToLower(strings.TrimSpace(setting)) {
+		case "primarykey", "autoupdatetime", "autocreatetime":
+			return true
+		}
+	}
+	return false
+}
+
+// Constraints from the validate tag, returns whether the field is required
+func (g *generator) applyRules(schema *Schema, t reflect.Type, tag string) (bool, error) {
+	if tag == "" || tag == "-" {
+		return false, nil
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	required := false
+	for _, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "required":
+			required = true
+			// Empty strings fail the rule too
+			if t.Kind() == reflect.String && schema.MinLength == nil {
+				one := 1
+				schema.MinLength = &one
+			}
+		case "omitempty":
+		case "dive":
+			// Rules after dive apply to the elements
+			return required, nil
+		case "min", "max", "len":
+			n, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				return false, fmt.Errorf("invalid %s rule %q", name, rule)
+			}
+			applyBound(schema, t, name, n)
+		case "gt", "gte", "lt", "lte":
+			n, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				return false, fmt.Errorf("invalid %s rule %q", name, rule)
+			}
+			if name == "gt" || name == "gte" {
+				schema.Minimum = &n
+			} else {
+				schema.Maximum = &n
+			}
+		case "oneof":
+			schema.Enum = nil
+			for _, value := range strings.Fields(param) {
+				schema.Enum = append(schema.Enum, value)
+			}
+		case "email":
+			schema.Format = "email"
+		case "url", "uri":
+			schema.Format = "uri"
+		case "uuid":
+			schema.Format = "uuid"
+		default:
+			pattern, ok := g.options.Patterns[name]
+			if !ok {
+				return false, fmt.Errorf("validate rule %s has no schema, add its pattern to the options", name)
+			}
+			schema.Pattern = pattern
+		}
+	}
+	return required, nil
+}
+
+// min, max and len limit the length of strings and slices, and the value of numbers
+func applyBound(schema *Schema, t reflect.Type, rule string, n float64) {
+	count := int(n)
+	switch t.Kind() {
+	case reflect.String:
+		if rule != "max" {
+			schema.MinLength = &count
+		}
+		if rule != "min" {
+			schema.MaxLength = &count
+		}
+	case reflect.Slice, reflect.Array, reflect.Map:
+		if rule != "max" {
+			schema.MinItems = &count
+		}
+		if rule != "min" {
+			schema.MaxItems = &count
+		}
+	default:
+		if rule != "max" {
+			schema.Minimum = &n
+		}
+		if rule != "min" {
+			schema.Maximum = &n
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// handle HTTP requests for subject access exports and erasures
+package privacy
+
+import (
+	"encoding/json"
+	"fmt"
+	"golangphonebook/internal"
+	"golangphonebook/pkg/audit"
+	"golangphonebook/pkg/auth"
+	"golangphonebook/pkg/contacts"
+	"golangphonebook/pkg/problem"
+	"net/http"
+)
+
+func callerTenant(r *http.Request) string {
+	id, _ := auth.FromContext(r.Context())
+	return id.Tenant
+}
+
+// Every contact, revision and audit record matching the phone, first_name and last_name parameters as one JSON file
+func ExportSubject(w http.ResponseWriter, r *http.Request, service *Service) {
+	defer internal.Timer("ExportSubject")()
+
+	params := r.URL.Query()
+	subject := contacts.Subject{Phone: params.Get("phone"), FirstName: params.Get("first_name"), LastName: params.Get("last_name")}
+	if !subject.Valid() {
+		problem.Error(w, r, "Invalid subject, phone, first_name or last_name must be defined", http.StatusBadRequest)
+		return
+	}
+
+	bundle, err := service.Find(r.Context(), callerTenant(r), subject)
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to export subject data: %v", err))
+		if contacts.IsCanceled(err) {
+			problem.Write(w, r, err)
+			return
+		}
+		problem.Error(w, r, "Failed to export subject data", http.StatusInternalServerError)
+		return
+	}
+
+	response, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to serialize subject data: %v", err))
+		problem.Error(w, r, "Failed to serialize subject data", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="subject-export-%s.json"`, bundle.GeneratedAt.Format("20060102T150405Z")))
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+func EraseSubject(w http.ResponseWriter, r *http.Request, service *Service) {
+	defer internal.Timer("EraseSubject")()
+
+	var subject contacts.Subject
+	if err := json.NewDecoder(r.Body).Decode(&subject); err != nil || !subject.Valid() {
+		problem.Error(w, r, "Invalid request body, phone, first_name or last_name must be defined", http.StatusBadRequest)
+		return
+	}
+
+	actor, requestID := "anonymous", ""
+	if id, ok := auth.FromContext(r.Context()); ok {
+		actor = id.String()
+	}
+	entry, audited := audit.FromContext(r.Context())
+	if audited {
+		requestID = entry.RequestID
+	}
+
+	receipt, err := service.Erase(r.Context(), callerTenant(r), actor, requestID, subject)
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to erase subject data: %v", err))
+		// Nothing was erased, the transaction is rolled back
+		if contacts.IsCanceled(err) {
+			problem.Write(w, r, err)
+			return
+		}
+		problem.Error(w, r, fmt.Sprintf("Failed to erase subject data with error %v", err), http.StatusInternalServerError)
+		return
+	}
+	// The audit record of this request points at the tombstone, without anything about the subject
+	if audited {
+		entry.RecordChange(receipt.ID, nil, nil)
+	}
+
+	response, err := json.Marshal(receipt)
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to serialize erasure receipt: %v", err))
+		problem.Error(w, r, "Failed to serialize erasure receipt", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+func GetErasures(w http.ResponseWriter, r *http.Request, service *Service) {
+	defer internal.Timer("GetErasures")()
+
+	erasures, err := service.ListErasures(callerTenant(r))
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to list erasures: %v", err))
+		problem.Error(w, r, "Failed to list erasures", http.StatusInternalServerError)
+		return
+	}
+
+	response, err := json.Marshal(erasures)
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to serialize erasures: %v", err))
+		problem.Error(w, r, "Failed to serialize erasures", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
@@ -0,0 +1,55 @@
+package privacy
+
+import (
+	"golangphonebook/pkg/audit"
+	"golangphonebook/pkg/contacts"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMentions(t *testing.T) {
+	subject := contacts.Subject{Phone: "+15551234567"}
+	tests := []struct {
+		name     string
+		record   audit.Record
+		ids      map[uint]bool
+		expected bool
+	}{
+		{"Added Contact", audit.Record{Before: `[null]`, After: `[{"id":3,"first_name":"John","phone":"+15551234567"}]`}, nil, true},
+		{"Deleted Contact", audit.Record{Before: `[{"id":3,"first_name":"John","phone":"5551234567"}]`, After: `[null]`}, nil, true},
+		{"Phone Changed Away", audit.Record{Before: `[{"id":3,"first_name":"John","phone":"+15550000000"}]`, After: `[{"id":3,"first_name":"John","phone":"+15550000001"}]`}, map[uint]bool{3: true}, true},
+		{"Other Contact", audit.Record{Before: `[null]`, After: `[{"id":4,"first_name":"Jane","phone":"+15559999999"}]`}, map[uint]bool{3: true}, false},
+		// Address books have IDs too, but they aren't contacts
+		{"Address Book With Same ID", audit.Record{Before: `[null]`, After: `[{"id":3,"name":"work"}]`}, map[uint]bool{3: true}, false},
+		{"Erased Payload", audit.Record{}, map[uint]bool{3: true}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, mentions(tt.record, subject, tt.ids))
+		})
+	}
+}
+
+func TestResponseMentions(t *testing.T) {
+	subject := contacts.Subject{Phone: "+15551234567"}
+	tests := []struct {
+		name     string
+		body     string
+		expected bool
+	}{
+		{"Created Contact", `{"id":3,"first_name":"John","phone":"+15551234567"}`, true},
+		{"Contact By ID", `{"id":7,"first_name":"John","phone":"+15550000000"}`, true},
+		{"Failed Batch Contact", `{"added":1,"failed_contacts":["{\"first_name\":\"John\",\"phone\":\"555 123 4567\"}"]}`, true},
+		{"Other Contact", `{"id":4,"first_name":"Jane","phone":"+15559999999"}`, false},
+		{"Message", `{"message":"Contact deleted successfully"}`, false},
+		{"Not JSON", `Contact deleted`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, responseMentions([]byte(tt.body), subject, map[uint]bool{7: true}))
+		})
+	}
+}
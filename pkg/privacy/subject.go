@@ -0,0 +1,272 @@
+// Subject access exports and right to erasure requests for one person's data
+package privacy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golangphonebook/internal"
+	"golangphonebook/pkg/audit"
+	"golangphonebook/pkg/contacts"
+	"golangphonebook/pkg/idempotency"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Tombstone of an erasure. It only records that an erasure happened and how much it removed, never who it was about.
+type Erasure struct {
+	ID           uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	Tenant       string    `json:"tenant" gorm:"size:100;index"`
+	Actor        string    `json:"actor" gorm:"size:255"`
+	RequestID    string    `json:"request_id" gorm:"size:100"`
+	ErasedAt     time.Time `json:"erased_at" gorm:"not null;index"`
+	Contacts     int64     `json:"contacts"`      // Contacts deleted
+	Revisions    int64     `json:"revisions"`     // Merge history snapshots deleted
+	AuditRecords int64     `json:"audit_records"` // Audit records whose payload was erased
+}
+
+// Everything held on a subject, in the format handed out for subject access requests
+type Bundle struct {
+	GeneratedAt  time.Time              `json:"generated_at"`
+	Tenant       string                 `json:"tenant"`
+	Subject      contacts.Subject       `json:"subject"`
+	Contacts     []contacts.Contact     `json:"contacts"`
+	Revisions    []contacts.MergeRecord `json:"revisions"`     // Snapshots from the merge history
+	AuditRecords []audit.Record         `json:"audit_records"` // Audit records with the subject in their payload
+}
+
+func (b *Bundle) empty() bool {
+	return len(b.Contacts) == 0 && len(b.Revisions) == 0 && len(b.AuditRecords) == 0
+}
+
+// Proof of an erasure, returned to whoever requested it
+type Receipt struct {
+	Erasure
+	Verified   bool                `json:"verified"`    // Searching again after the erasure found nothing
+	AuditChain *audit.Verification `json:"audit_chain"` // The audit log still verifies with the erased payloads
+}
+
+type Service struct {
+	DB     *gorm.DB
+	Audit  *audit.Store
+	Events contacts.ChangePublisher // Optional, told about every erased contact without its data and made to forget what it kept
+	Outbox contacts.ChangeOutbox    // Optional, given every erased contact without its data, in the erasure's transaction
+}
+
+// Publishers that keep past events, like events.Bus, drop the data of erased contacts from them
+type eventHistory interface {
+	Forget(tenant string, ids []uint)
+}
+
+// NewService creates a new instance of Service
+func NewService(db *gorm.DB, auditStore *audit.Store) *Service {
+	return &Service{DB: db, Audit: auditStore}
+}
+
+// Everything the tenant holds on the subject
+func (s *Service) Find(ctx context.Context, tenant string, subject contacts.Subject) (*Bundle, error) {
+	if !subject.Valid() {
+		return nil, errors.New("subject needs a phone or a name")
+	}
+	return find(ctx, s.DB, tenant, subject)
+}
+
+func find(ctx context.Context, db *gorm.DB, tenant string, subject contacts.Subject) (*Bundle, error) {
+	bundle := &Bundle{
+		GeneratedAt:  time.Now().UTC(),
+		Tenant:       tenant,
+		Subject:      subject,
+		Contacts:     []contacts.Contact{},
+		Revisions:    []contacts.MergeRecord{},
+		AuditRecords: []audit.Record{},
+	}
+
+	found, revisions, err := contacts.NewSQLContactRepository(db).ForTenant(tenant).FindSubject(ctx, subject)
+	if err != nil {
+		return nil, err
+	}
+	bundle.Contacts = append(bundle.Contacts, found...)
+	bundle.Revisions = append(bundle.Revisions, revisions...)
+
+	// Contacts that were deleted or merged away only live on in the audit log, under their old IDs
+	ids := make(map[uint]bool)
+	var targetIDs []uint
+	for _, c := range found {
+		ids[c.ID] = true
+		targetIDs = append(targetIDs, c.ID)
+	}
+	for _, r := range revisions {
+		ids[r.MergedID] = true
+		targetIDs = append(targetIDs, r.MergedID)
+	}
+
+	candidates, err := audit.NewStore(db).Mentioning(tenant, targetIDs, subject.SearchTerms())
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range candidates {
+		if mentions(rec, subject, ids) {
+			bundle.AuditRecords = append(bundle.AuditRecords, rec)
+		}
+	}
+	return bundle, nil
+}
+
+// Does the payload of an audit record hold a contact that is, or was, the subject
+func mentions(rec audit.Record, subject contacts.Subject, ids map[uint]bool) bool {
+	for _, payload := range []string{rec.Before, rec.After} {
+		var values []json.RawMessage
+		if err := json.Unmarshal([]byte(payload), &values); err != nil {
+			continue
+		}
+		for _, value := range values {
+			var c contacts.Contact
+			// Other payloads like address books don't have a phone, and every contact does
+			if err := json.Unmarshal(value, &c); err != nil || c.Phone == "" {
+				continue
+			}
+			if ids[c.ID] || subject.Matches(c) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Does a response kept for an idempotency key hold a contact that is, or was, the subject. Contacts can be
+// anywhere in it, and batch responses carry the request bodies of failed contacts as strings.
+func responseMentions(body []byte, subject contacts.Subject, ids map[uint]bool) bool {
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return false
+	}
+	return valueMentions(value, subject, ids)
+}
+
+func valueMentions(value any, subject contacts.Subject, ids map[uint]bool) bool {
+	switch v := value.(type) {
+	case map[string]any:
+		if _, ok := v["phone"]; ok {
+			var c contacts.Contact
+			if data, err := json.Marshal(v); err == nil && json.Unmarshal(data, &c) == nil && (ids[c.ID] || subject.Matches(c)) {
+				return true
+			}
+		}
+		for _, field := range v {
+			if valueMentions(field, subject, ids) {
+				return true
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if valueMentions(item, subject, ids) {
+				return true
+			}
+		}
+	case string:
+		if strings.HasPrefix(v, "{") || strings.HasPrefix(v, "[") {
+			return responseMentions([]byte(v), subject, ids)
+		}
+	}
+	return false
+}
+
+// Delete every contact and revision of the subject and erase the payload of every audit record about them,
+// then check nothing is left. Audit records keep their place in the hash chain as tombstones.
+func (s *Service) Erase(ctx context.Context, tenant string, actor string, requestID string, subject contacts.Subject) (*Receipt, error) {
+	if !subject.Valid() {
+		return nil, errors.New("subject needs a phone or a name")
+	}
+
+	erasure := Erasure{Tenant: tenant, Actor: actor, RequestID: requestID, ErasedAt: time.Now().UTC()}
+	var contactIDs []uint
+	var responses int64
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		bundle, err := find(ctx, tx, tenant, subject)
+		if err != nil {
+			return err
+		}
+
+		var revisionIDs, auditIDs []uint
+		for _, c := range bundle.Contacts {
+			contactIDs = append(contactIDs, c.ID)
+		}
+		for _, r := range bundle.Revisions {
+			revisionIDs = append(revisionIDs, r.ID)
+		}
+		for _, rec := range bundle.AuditRecords {
+			auditIDs = append(auditIDs, rec.ID)
+		}
+
+		erasure.Contacts, erasure.Revisions, err = contacts.NewSQLContactRepository(tx).ForTenant(tenant).EraseSubjectData(ctx, contactIDs, revisionIDs)
+		if err != nil {
+			return err
+		}
+		erasure.AuditRecords, err = audit.NewStore(tx).ErasePayloads(auditIDs, erasure.ErasedAt)
+		if err != nil {
+			return err
+		}
+		// Retries with an idempotency key would otherwise send the subject's data again
+		erasedIDs := make(map[uint]bool)
+		for _, c := range bundle.Contacts {
+			erasedIDs[c.ID] = true
+		}
+		for _, r := range bundle.Revisions {
+			erasedIDs[r.MergedID] = true
+		}
+		responses, err = idempotency.NewStore(tx).EraseResponses(tenant, func(body []byte) bool {
+			return responseMentions(body, subject, erasedIDs)
+		})
+		if err != nil {
+			return err
+		}
+		if s.Outbox != nil {
+			for _, id := range contactIDs {
+				if err := s.Outbox.Enqueue(tx, tenant, contacts.ContactDeleted, id, nil); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Nothing is committed unless searching again comes up empty
+		remaining, err := find(ctx, tx, tenant, subject)
+		if err != nil {
+			return err
+		}
+		if !remaining.empty() {
+			return fmt.Errorf("erasure incomplete, %d contacts, %d revisions and %d audit records remain",
+				len(remaining.Contacts), len(remaining.Revisions), len(remaining.AuditRecords))
+		}
+		return tx.Create(&erasure).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Events != nil {
+		if history, ok := s.Events.(eventHistory); ok {
+			history.Forget(tenant, contactIDs)
+		}
+		for _, id := range contactIDs {
+			s.Events.Publish(tenant, contacts.ContactDeleted, id, nil)
+		}
+	}
+	internal.Logger.Info(fmt.Sprintf("Erasure %d removed %d contacts, %d revisions, %d audit payloads and %d kept responses",
+		erasure.ID, erasure.Contacts, erasure.Revisions, erasure.AuditRecords, responses))
+
+	chain, err := s.Audit.Verify()
+	if err != nil {
+		return nil, err
+	}
+	return &Receipt{Erasure: erasure, Verified: true, AuditChain: chain}, nil
+}
+
+// Tombstones of the tenant's erasures, newest first
+func (s *Service) ListErasures(tenant string) ([]Erasure, error) {
+	var erasures []Erasure
+	err := s.DB.Where("tenant = ?", tenant).Order("erased_at DESC, id DESC").Find(&erasures).Error
+	return erasures, err
+}
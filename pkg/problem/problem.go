@@ -0,0 +1,121 @@
+// RFC 7807 problem details, the body of every error response
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golangphonebook/internal"
+	"net/http"
+	"strings"
+)
+
+const ContentType = "application/problem+json"
+
+// Problem types are relative URIs, resolved against the API's own address
+const typePrefix = "/problems/"
+
+// One invalid field of a request body
+type FieldError struct {
+	Field   string `json:"field"`           // json name of the field
+	Rule    string `json:"rule"`            // Validation rule that failed, like required or max
+	Param   string `json:"param,omitempty"` // Parameter of the rule, like 50 for max=50
+	Message string `json:"message"`
+}
+
+type Details struct {
+	Type     string       `json:"type"`  // Machine-readable kind of problem, stable across releases
+	Title    string       `json:"title"` // Short summary of the kind of problem, the same for every occurrence
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"` // What went wrong this time
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"` // Every invalid field, for validation problems
+}
+
+// Implemented by errors that know which fields of the request were invalid
+type FieldErrors interface {
+	FieldErrors() []FieldError
+}
+
+// A kind of error and how it is reported
+type Kind struct {
+	Err    error
+	Status int
+	Slug   string // Last part of the problem type
+	Title  string
+}
+
+var kinds []Kind
+
+// Report errors that match err with errors.Is as this kind of problem. Packages register their sentinel errors on init.
+func Register(err error, status int, slug string, title string) {
+	kinds = append(kinds, Kind{Err: err, Status: status, Slug: slug, Title: title})
+}
+
+// Every registered kind, for documentation
+func Kinds() []Kind {
+	return append([]Kind(nil), kinds...)
+}
+
+func TypeFor(slug string) string {
+	return typePrefix + slug
+}
+
+// Problem for an error. Registered errors keep their message as the detail,
+// anything else is an internal error whose message is only logged.
+func FromError(err error) Details {
+	for _, kind := range kinds {
+		if errors.Is(err, kind.Err) {
+			details := Details{Type: TypeFor(kind.Slug), Title: kind.Title, Status: kind.Status, Detail: err.Error()}
+			var fields FieldErrors
+			if errors.As(err, &fields) {
+				details.Errors = fields.FieldErrors()
+			}
+			return details
+		}
+	}
+
+	internal.Logger.Error(fmt.Sprintf("Internal error: %v", err))
+	return fromStatus(http.StatusInternalServerError, "internal server error")
+}
+
+// Problem for a status code without a more specific kind
+func fromStatus(status int, detail string) Details {
+	title := http.StatusText(status)
+	return Details{
+		Type:   TypeFor(strings.ReplaceAll(strings.ToLower(title), " ", "-")),
+		Title:  title,
+		Status: status,
+		Detail: detail,
+	}
+}
+
+func write(w http.ResponseWriter, r *http.Request, details Details) {
+	if r != nil {
+		details.Instance = r.URL.Path
+	}
+	response, _ := json.Marshal(details)
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(details.Status)
+	w.Write(response)
+}
+
+// Respond with the problem for an error
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	write(w, r, FromError(err))
+}
+
+// Respond with the problem for an error but another status than its kind's, for routes that promised
+// that status before the kind existed
+func WriteStatus(w http.ResponseWriter, r *http.Request, err error, status int) {
+	details := FromError(err)
+	details.Status = status
+	write(w, r, details)
+}
+
+// Respond with a problem for a status code, a drop-in replacement for http.Error
+func Error(w http.ResponseWriter, r *http.Request, detail string, status int) {
+	write(w, r, fromStatus(status, detail))
+}
@@ -0,0 +1,91 @@
+package problem_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golangphonebook/pkg/problem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errMissing = errors.New("thing not found")
+
+type invalidFields struct{}
+
+func (invalidFields) Error() string {
+	return "validation failed"
+}
+
+func (invalidFields) FieldErrors() []problem.FieldError {
+	return []problem.FieldError{{Field: "name", Rule: "required", Message: "name is required"}}
+}
+
+func init() {
+	problem.Register(errMissing, http.StatusNotFound, "thing-not-found", "Thing not found")
+	problem.Register(invalidFields{}, http.StatusBadRequest, "invalid-thing", "Invalid thing")
+}
+
+func decode(t *testing.T, rr *httptest.ResponseRecorder) problem.Details {
+	assert.Equal(t, problem.ContentType, rr.Header().Get("Content-Type"))
+	var details problem.Details
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &details))
+	assert.Equal(t, rr.Code, details.Status)
+	return details
+}
+
+func TestWrite(t *testing.T) {
+	t.Run("Registered Error", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		problem.Write(rr, httptest.NewRequest("GET", "/things/3", nil), fmt.Errorf("no thing with ID 3: %w", errMissing))
+
+		details := decode(t, rr)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.Equal(t, "/problems/thing-not-found", details.Type)
+		assert.Equal(t, "Thing not found", details.Title)
+		assert.Equal(t, "no thing with ID 3: thing not found", details.Detail)
+		assert.Equal(t, "/things/3", details.Instance)
+	})
+
+	t.Run("Another Status", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		problem.WriteStatus(rr, httptest.NewRequest("GET", "/things/3", nil), errMissing, http.StatusBadRequest)
+
+		details := decode(t, rr)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, "/problems/thing-not-found", details.Type)
+	})
+
+	t.Run("Field Errors", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		problem.Write(rr, httptest.NewRequest("PUT", "/things", nil), fmt.Errorf("invalid body: %w", invalidFields{}))
+
+		details := decode(t, rr)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, []problem.FieldError{{Field: "name", Rule: "required", Message: "name is required"}}, details.Errors)
+	})
+
+	t.Run("Unknown Error", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		problem.Write(rr, httptest.NewRequest("GET", "/things", nil), errors.New("pq: connection refused"))
+
+		details := decode(t, rr)
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+		assert.Equal(t, "/problems/internal-server-error", details.Type)
+		// Internal details stay in the logs
+		assert.Equal(t, "internal server error", details.Detail)
+	})
+}
+
+func TestError(t *testing.T) {
+	rr := httptest.NewRecorder()
+	problem.Error(rr, httptest.NewRequest("GET", "/things", nil), "a valid client certificate is required", http.StatusUnauthorized)
+
+	details := decode(t, rr)
+	assert.Equal(t, "/problems/unauthorized", details.Type)
+	assert.Equal(t, "Unauthorized", details.Title)
+	assert.Equal(t, "a valid client certificate is required", details.Detail)
+}
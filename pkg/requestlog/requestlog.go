@@ -0,0 +1,64 @@
+// Give every request an ID and log it with the request's fields once it is answered
+package requestlog
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"golangphonebook/internal"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const Header = "X-Request-ID"
+
+// Longest request ID taken from a client, audit records keep up to 100 characters
+const maxIDLength = 100
+
+func NewID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// The ID the client sent when it is short printable ASCII, so it can't forge log lines or overflow the audit log,
+// and a new one otherwise
+func FromHeader(id string) string {
+	if id == "" || len(id) > maxIDLength {
+		return NewID()
+	}
+	for _, c := range []byte(id) {
+		if c <= ' ' || c > '~' {
+			return NewID()
+		}
+	}
+	return id
+}
+
+// Take the caller's X-Request-ID or make one up, send it back, and add it to every log line of the request
+// along with the route and client certificate. Logs the request with its status and latency once it is answered.
+func Middleware(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := FromHeader(r.Header.Get(Header))
+		// Handlers further in, like the audit log, read the ID from the header
+		r.Header.Set(Header, id)
+		w.Header().Set(Header, id)
+
+		fields := []any{"request_id", id, "method", r.Method, "route", route}
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			fields = append(fields, "client", r.TLS.PeerCertificates[0].Subject.String())
+		}
+		ctx := internal.WithLogFields(r.Context(), fields...)
+
+		rec := internal.NewStatusRecorder(w)
+		next(rec, r.WithContext(ctx))
+
+		status := rec.Status()
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		internal.Logger.Slog().Log(ctx, level, "Request answered", "status", status, "latency_ms", time.Since(start).Milliseconds())
+	}
+}
@@ -0,0 +1,68 @@
+package requestlog
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"golangphonebook/internal"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromHeader(t *testing.T) {
+	assert.Equal(t, "req-1", FromHeader("req-1"))
+	for name, id := range map[string]string{
+		"Missing":   "",
+		"Too Long":  strings.Repeat("a", maxIDLength+1),
+		"Spaces":    "req 1",
+		"Newline":   "req\nlevel=ERROR",
+		"Non ASCII": "réq",
+	} {
+		t.Run(name, func(t *testing.T) {
+			generated := FromHeader(id)
+			assert.NotEqual(t, id, generated)
+			assert.Len(t, generated, 32)
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, internal.Logger.Configure(internal.FormatText, &out, &out))
+	t.Cleanup(func() { internal.Logger.Configure(internal.FormatText, os.Stdout, os.Stderr) })
+
+	var seen string
+	handler := Middleware("/v2/contacts/{id}", func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Get(Header)
+		internal.Logger.InfoContext(r.Context(), "Handling")
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	t.Run("Propagated", func(t *testing.T) {
+		out.Reset()
+		req := httptest.NewRequest("GET", "/v2/contacts/7", nil)
+		req.Header.Set(Header, "req-1")
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "alice"}}}}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		assert.Equal(t, "req-1", rec.Header().Get(Header))
+		assert.Equal(t, "req-1", seen, "handlers further in see the same ID")
+		assert.Contains(t, out.String(), `msg=Handling request_id=req-1 method=GET route=/v2/contacts/{id} client="CN=alice"`)
+		assert.Regexp(t, `msg="Request answered" status=404 latency_ms=\d+ request_id=req-1 method=GET route=/v2/contacts/\{id\} client="CN=alice"`, out.String())
+	})
+
+	t.Run("Generated", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", "/v2/contacts/7", nil))
+		assert.Len(t, rec.Header().Get(Header), 32)
+		assert.Equal(t, rec.Header().Get(Header), seen)
+	})
+}
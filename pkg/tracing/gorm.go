@@ -0,0 +1,92 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const spanKey = "tracing:span"
+
+// The span of a running query, and the context it was started from
+type querySpan struct {
+	span   trace.Span
+	parent context.Context
+}
+
+// Gorm plugin giving every query a client span, a child of the span of the context the query runs with.
+// Register with db.Use(tracing.GormPlugin{}).
+type GormPlugin struct{}
+
+func (GormPlugin) Name() string {
+	return "tracing"
+}
+
+func (GormPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	for _, register := range []func() error{
+		func() error {
+			return cb.Create().Before("gorm:create").Register("tracing:before_create", startQuery("INSERT"))
+		},
+		func() error { return cb.Create().After("gorm:create").Register("tracing:after_create", endQuery) },
+		func() error {
+			return cb.Query().Before("gorm:query").Register("tracing:before_query", startQuery("SELECT"))
+		},
+		func() error { return cb.Query().After("gorm:query").Register("tracing:after_query", endQuery) },
+		func() error {
+			return cb.Update().Before("gorm:update").Register("tracing:before_update", startQuery("UPDATE"))
+		},
+		func() error { return cb.Update().After("gorm:update").Register("tracing:after_update", endQuery) },
+		func() error {
+			return cb.Delete().Before("gorm:delete").Register("tracing:before_delete", startQuery("DELETE"))
+		},
+		func() error { return cb.Delete().After("gorm:delete").Register("tracing:after_delete", endQuery) },
+		func() error { return cb.Row().Before("gorm:row").Register("tracing:before_row", startQuery("SELECT")) },
+		func() error { return cb.Row().After("gorm:row").Register("tracing:after_row", endQuery) },
+		func() error { return cb.Raw().Before("gorm:raw").Register("tracing:before_raw", startQuery("RAW")) },
+		func() error { return cb.Raw().After("gorm:raw").Register("tracing:after_raw", endQuery) },
+	} {
+		if err := register(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func startQuery(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		parent := db.Statement.Context
+		ctx, span := tracer.Start(parent, "db "+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBOperationName(operation),
+		))
+		db.Statement.Context = ctx
+		db.InstanceSet(spanKey, querySpan{span, parent})
+	}
+}
+
+func endQuery(db *gorm.DB) {
+	value, ok := db.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	query := value.(querySpan)
+	span := query.span
+	defer span.End()
+	// Statements can be run again, their next query is not a child of this one
+	db.Statement.Context = query.parent
+
+	// Only the statement with placeholders, the values are personal data
+	attributes := []attribute.KeyValue{semconv.DBQueryText(db.Statement.SQL.String()), attribute.Int64("db.rows_affected", db.Statement.RowsAffected)}
+	if db.Statement.Table != "" {
+		attributes = append(attributes, semconv.DBCollectionName(db.Statement.Table))
+	}
+	span.SetAttributes(attributes...)
+	if !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		recordError(span, db.Error)
+	}
+}
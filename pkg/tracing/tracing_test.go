@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"context"
+	"golangphonebook/internal"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Spans of the test, in the order they ended. The package's tracer is bound to the first global provider,
+// so every test shares one and starts by clearing it.
+var exporter = sync.OnceValue(func() *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return exporter
+})
+
+func recordSpans() *tracetest.InMemoryExporter {
+	exporter().Reset()
+	return exporter()
+}
+
+func attributes(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	values := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes {
+		values[kv.Key] = kv.Value
+	}
+	return values
+}
+
+func TestMiddleware(t *testing.T) {
+	recorder := recordSpans()
+
+	var fields []slog.Attr
+	handler := Middleware("GET", "/v2/contacts/{id}", func(w http.ResponseWriter, r *http.Request) {
+		fields = internal.LogFields(r.Context())
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	req := httptest.NewRequest("GET", "/v2/contacts/7?phone=555", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler(httptest.NewRecorder(), req)
+
+	spans := recorder.GetSpans()
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "GET /v2/contacts/{id}", span.Name)
+	assert.Equal(t, trace.SpanKindServer, span.SpanKind)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext.TraceID().String(), "the caller's trace is continued")
+	assert.Equal(t, "00f067aa0ba902b7", span.Parent.SpanID().String())
+	assert.Equal(t, codes.Error, span.Status.Code)
+
+	values := attributes(span)
+	assert.Equal(t, "/v2/contacts/{id}", values["http.route"].AsString())
+	assert.Equal(t, int64(503), values["http.response.status_code"].AsInt64())
+	for _, value := range values {
+		assert.NotContains(t, value.Emit(), "555", "queries aren't recorded")
+	}
+	assert.Equal(t, []slog.Attr{slog.String("trace_id", "4bf92f3577b34da6a3ce929d0e0e4736")}, fields)
+}
+
+func TestGormPlugin(t *testing.T) {
+	recorder := recordSpans()
+
+	// Dry runs build the statements without a database to run them on
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	require.NoError(t, err)
+	require.NoError(t, db.Use(GormPlugin{}))
+
+	type Contact struct {
+		ID    uint
+		Phone string
+	}
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+	db.WithContext(ctx).Where("phone = ?", "555").Find(&[]Contact{})
+	parent.End()
+
+	spans := recorder.GetSpans()
+	require.Len(t, spans, 2)
+	query := spans[0]
+	assert.Equal(t, "db SELECT", query.Name)
+	assert.Equal(t, trace.SpanKindClient, query.SpanKind)
+	assert.Equal(t, parent.SpanContext().SpanID(), query.Parent.SpanID())
+
+	values := attributes(query)
+	assert.Equal(t, "postgresql", values["db.system"].AsString())
+	assert.Equal(t, `SELECT * FROM "contacts" WHERE phone = $1`, values["db.query.text"].AsString(), "values aren't recorded")
+	assert.Equal(t, "contacts", values["db.collection.name"].AsString())
+}
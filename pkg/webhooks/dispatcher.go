@@ -0,0 +1,241 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"golangphonebook/internal"
+	"golangphonebook/pkg/events"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Sends the deliveries in the outbox that are due, retrying failed ones with exponential backoff.
+// Several instances can run against the same database, each delivery is claimed by one of them at a time.
+type Dispatcher struct {
+	DB          *gorm.DB
+	Client      *http.Client
+	Interval    time.Duration // How often the outbox is checked
+	BatchSize   int           // Deliveries claimed at a time
+	MaxAttempts int           // Attempts before a delivery fails for good
+	MinBackoff  time.Duration // Wait after the first failed attempt, doubling with every attempt after it
+	MaxBackoff  time.Duration
+}
+
+// NewDispatcher creates a dispatcher that retries for about a day
+func NewDispatcher(db *gorm.DB) *Dispatcher {
+	return &Dispatcher{
+		DB:          db,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		Interval:    time.Second,
+		BatchSize:   20,
+		MaxAttempts: 10,
+		MinBackoff:  30 * time.Second,
+		MaxBackoff:  6 * time.Hour,
+	}
+}
+
+// Send due deliveries until the context is done, finishing the send in progress
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	for {
+		for {
+			sent, err := d.DeliverDue(ctx)
+			if err != nil {
+				internal.Logger.ErrorContext(ctx, fmt.Sprintf("Failed to send webhook deliveries: %v", err))
+			}
+			// A full batch means more are probably waiting
+			if err != nil || sent < d.BatchSize {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Wait before the next attempt, after the given number of failed attempts. Up to a fifth is taken off at random,
+// so deliveries that failed together don't all come back at once.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.MinBackoff
+	for i := 1; i < attempts && wait < d.MaxBackoff; i++ {
+		wait *= 2
+	}
+	wait = min(wait, d.MaxBackoff)
+	return wait - time.Duration(rand.Int64N(int64(wait)/5+1))
+}
+
+// Claim the deliveries that are due and send them, returns how many were sent
+func (d *Dispatcher) DeliverDue(ctx context.Context) (int, error) {
+	if ctx.Err() != nil {
+		return 0, nil
+	}
+	due, err := d.claim()
+	if err != nil || len(due) == 0 {
+		return 0, err
+	}
+
+	ids := make([]uint, 0, len(due))
+	for _, delivery := range due {
+		ids = append(ids, delivery.SubscriptionID)
+	}
+	var subs []Subscription
+	if err := d.DB.Where("id IN ?", ids).Find(&subs).Error; err != nil {
+		return 0, err
+	}
+	byID := make(map[uint]Subscription, len(subs))
+	for _, sub := range subs {
+		byID[sub.ID] = sub
+	}
+
+	for i := range due {
+		if ctx.Err() != nil {
+			// Stopping, the rest can be sent by another instance straight away
+			if err := d.release(due[i:]); err != nil {
+				internal.Logger.ErrorContext(ctx, fmt.Sprintf("Failed to release %d webhook deliveries: %v", len(due)-i, err))
+			}
+			return i, nil
+		}
+		delivery := &due[i]
+		sub, ok := byID[delivery.SubscriptionID]
+		if !ok {
+			// Deleted while the delivery was claimed, its deliveries are gone too
+			continue
+		}
+		// A send that started is finished, so the receiver isn't cut off halfway and the attempt is recorded
+		attempt := d.send(context.WithoutCancel(ctx), sub, delivery)
+		d.next(delivery, attempt)
+		if err := d.save(delivery, attempt); err != nil {
+			internal.Logger.ErrorContext(ctx, fmt.Sprintf("Failed to record attempt %d of webhook delivery %d: %v", attempt.Number, delivery.ID, err))
+		}
+	}
+	return len(due), nil
+}
+
+// Lock due deliveries and push their next attempt back, so no other instance sends them meanwhile.
+// Deliveries of an instance that stops while sending are picked up again once the lease runs out.
+func (d *Dispatcher) claim() ([]Delivery, error) {
+	var due []Delivery
+	lease := 2*d.Client.Timeout + time.Minute
+	err := d.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now().UTC()
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", StatusPending, now).
+			Order("next_attempt_at").Limit(d.BatchSize).Find(&due).Error
+		if err != nil || len(due) == 0 {
+			return err
+		}
+		ids := make([]uint, len(due))
+		for i, delivery := range due {
+			ids[i] = delivery.ID
+		}
+		return tx.Model(&Delivery{}).Where("id IN ?", ids).Update("next_attempt_at", now.Add(lease)).Error
+	})
+	return due, err
+}
+
+// Give claimed deliveries back before their lease runs out
+func (d *Dispatcher) release(claimed []Delivery) error {
+	ids := make([]uint, len(claimed))
+	for i, delivery := range claimed {
+		ids[i] = delivery.ID
+	}
+	return d.DB.Model(&Delivery{}).Where("id IN ? AND status = ?", ids, StatusPending).
+		Update("next_attempt_at", time.Now().UTC()).Error
+}
+
+// Post a delivery to its subscription, signed with the subscription's secret
+func (d *Dispatcher) send(ctx context.Context, sub Subscription, delivery *Delivery) Attempt {
+	attempt := Attempt{DeliveryID: delivery.ID, Number: delivery.Attempts + 1, AttemptedAt: time.Now().UTC()}
+	fail := func(err error) Attempt {
+		attempt.Error = err.Error()
+		attempt.DurationMS = time.Since(attempt.AttemptedAt).Milliseconds()
+		return attempt
+	}
+
+	contact, err := openPayload(delivery.Payload)
+	if err != nil {
+		return fail(fmt.Errorf("unable to decrypt payload: %w", err))
+	}
+	body, err := json.Marshal(events.Event{
+		ID:        uint64(delivery.ID),
+		Type:      delivery.EventType,
+		ContactID: delivery.ContactID,
+		Contact:   contact,
+		Time:      delivery.CreatedAt.UTC(),
+	})
+	if err != nil {
+		return fail(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return fail(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Phonebook-Webhooks/1.0")
+	req.Header.Set(EventHeader, string(delivery.EventType))
+	req.Header.Set(DeliveryHeader, fmt.Sprint(delivery.ID))
+	req.Header.Set(SignatureHeader, Sign(sub.Secret, attempt.AttemptedAt, body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return fail(err)
+	}
+	// Drain a little of the body so the connection can be reused, receivers have nothing to tell us in it
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+
+	attempt.StatusCode = resp.StatusCode
+	attempt.DurationMS = time.Since(attempt.AttemptedAt).Milliseconds()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("receiver answered with %s", resp.Status)
+	}
+	return attempt
+}
+
+// Move a delivery on after an attempt. Done deliveries lose their payload.
+func (d *Dispatcher) next(delivery *Delivery, attempt Attempt) {
+	delivery.Attempts = attempt.Number
+	switch {
+	case attempt.Error == "":
+		delivery.Status = StatusDelivered
+		delivery.DeliveredAt = &attempt.AttemptedAt
+		delivery.Payload = ""
+	case attempt.Number >= d.MaxAttempts:
+		delivery.Status = StatusFailed
+		delivery.Payload = ""
+		internal.Logger.Warn(fmt.Sprintf("Gave up on webhook delivery %d after %d attempts: %s", delivery.ID, attempt.Number, attempt.Error))
+	default:
+		delivery.NextAttemptAt = attempt.AttemptedAt.Add(d.backoff(attempt.Number))
+	}
+}
+
+func (d *Dispatcher) save(delivery *Delivery, attempt Attempt) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&attempt).Error; err != nil {
+			return err
+		}
+		// The contact may have been erased while the delivery was being sent
+		updates := map[string]any{
+			"status":          delivery.Status,
+			"attempts":        delivery.Attempts,
+			"next_attempt_at": delivery.NextAttemptAt,
+			"delivered_at":    delivery.DeliveredAt,
+		}
+		if delivery.Payload == "" {
+			updates["payload"] = ""
+		}
+		return tx.Model(&Delivery{}).Where("id = ?", delivery.ID).Updates(updates).Error
+	})
+}
@@ -0,0 +1,148 @@
+// handle HTTP requests to manage webhook subscriptions and inspect their deliveries
+package webhooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"golangphonebook/internal"
+	"golangphonebook/pkg/audit"
+	"golangphonebook/pkg/auth"
+	"golangphonebook/pkg/contacts"
+	"golangphonebook/pkg/problem"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func callerTenant(r *http.Request) string {
+	id, _ := auth.FromContext(r.Context())
+	return id.Tenant
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, value interface{}) {
+	response, err := json.Marshal(value)
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to serialize response: %v", err))
+		problem.Error(w, r, "Failed to serialize response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(response)
+}
+
+// ID from the URL path /webhooks/{id}
+func pathID(r *http.Request) (uint, error) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid ID %q, IDs can only be positive integers: %w", mux.Vars(r)["id"], contacts.ErrInvalidRequest)
+	}
+	return uint(id), nil
+}
+
+func CreateWebhook(w http.ResponseWriter, r *http.Request, store *Store) {
+	defer internal.Timer("CreateWebhook")()
+
+	var sub Subscription
+	if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+		problem.Write(w, r, fmt.Errorf("invalid request body, a url is required: %w", contacts.ErrInvalidRequest))
+		return
+	}
+
+	created, err := store.Create(callerTenant(r), sub)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	// The secret stays out of the audit log
+	if entry, ok := audit.FromContext(r.Context()); ok {
+		entry.RecordChange(created.ID, nil, created.Subscription)
+	}
+	internal.Logger.InfoContext(r.Context(), fmt.Sprintf("Webhook %d created", created.ID))
+
+	w.Header().Set("Location", fmt.Sprintf("/webhooks/%d", created.ID))
+	writeJSON(w, r, http.StatusCreated, created)
+}
+
+func GetWebhooks(w http.ResponseWriter, r *http.Request, store *Store) {
+	defer internal.Timer("GetWebhooks")()
+
+	subs, err := store.List(callerTenant(r))
+	if err != nil {
+		internal.Logger.ErrorContext(r.Context(), fmt.Sprintf("Failed to list webhooks: %v", err))
+		problem.Error(w, r, "Failed to list webhooks", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, append([]Subscription{}, subs...))
+}
+
+func GetWebhook(w http.ResponseWriter, r *http.Request, store *Store) {
+	defer internal.Timer("GetWebhook")()
+
+	id, err := pathID(r)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	sub, err := store.Get(callerTenant(r), id)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, sub)
+}
+
+func DeleteWebhook(w http.ResponseWriter, r *http.Request, store *Store) {
+	defer internal.Timer("DeleteWebhook")()
+
+	id, err := pathID(r)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	sub, err := store.Delete(callerTenant(r), id)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	if entry, ok := audit.FromContext(r.Context()); ok {
+		entry.RecordChange(sub.ID, *sub, nil)
+	}
+	internal.Logger.InfoContext(r.Context(), fmt.Sprintf("Webhook %d deleted", id))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Delivery log of a subscription, 50 per page, newest first
+func GetDeliveries(w http.ResponseWriter, r *http.Request, store *Store) {
+	defer internal.Timer("GetDeliveries")()
+
+	id, err := pathID(r)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && !slices.Contains([]string{StatusPending, StatusDelivered, StatusFailed}, status) {
+		problem.Write(w, r, fmt.Errorf("invalid status %q, must be pending, delivered or failed: %w", status, contacts.ErrInvalidRequest))
+		return
+	}
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	deliveries, count, err := store.Deliveries(callerTenant(r), id, status, page)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, PaginatedDeliveries{
+		Deliveries:  append([]Delivery{}, deliveries...),
+		TotalPages:  int((count + pageSize - 1) / pageSize),
+		CurrentPage: page,
+		TotalCount:  count,
+	})
+}
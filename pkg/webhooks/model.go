@@ -0,0 +1,153 @@
+// Tell other systems about changes to contacts with signed HTTP callbacks, sent from an outbox
+package webhooks
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"golangphonebook/pkg/contacts"
+	"golangphonebook/pkg/problem"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("webhook not found")
+
+func init() {
+	problem.Register(ErrNotFound, http.StatusNotFound, "webhook-not-found", "Webhook not found")
+}
+
+// States of a delivery
+const (
+	StatusPending   = "pending"   // Waiting for its next attempt
+	StatusDelivered = "delivered" // The receiver answered with a 2xx status
+	StatusFailed    = "failed"    // Gave up after the last attempt
+)
+
+var changeTypes = []contacts.ChangeType{contacts.ContactCreated, contacts.ContactUpdated, contacts.ContactDeleted}
+
+// Event types of a subscription, stored with leading and trailing commas like ",contact.created,contact.deleted,"
+type EventTypes []contacts.ChangeType
+
+func (t EventTypes) Value() (driver.Value, error) {
+	if len(t) == 0 {
+		return "", nil
+	}
+	parts := make([]string, len(t))
+	for i, change := range t {
+		parts[i] = string(change)
+	}
+	return "," + strings.Join(parts, ",") + ",", nil
+}
+
+func (t *EventTypes) Scan(value any) error {
+	var stored string
+	switch value := value.(type) {
+	case string:
+		stored = value
+	case []byte:
+		stored = string(value)
+	case nil:
+	default:
+		return fmt.Errorf("cannot scan %T into event types", value)
+	}
+
+	*t = nil
+	for _, part := range strings.Split(strings.Trim(stored, ","), ",") {
+		if part != "" {
+			*t = append(*t, contacts.ChangeType(part))
+		}
+	}
+	return nil
+}
+
+type Subscription struct {
+	ID            uint       `json:"id" gorm:"primaryKey;autoIncrement"`
+	TenantID      string     `json:"-" gorm:"size:100;not null;index"`
+	URL           string     `json:"url" gorm:"type:text;not null"`                       // http or https URL the events are posted to
+	Events        EventTypes `json:"events" gorm:"type:text"`                             // Event types to send, every type when empty
+	AddressBookID uint       `json:"address_book_id,omitempty" gorm:"not null;default:0"` // Only send events for contacts in this address book
+	Secret        string     `json:"-" gorm:"size:100;not null"`                          // Signs every delivery, only shown when the subscription is created
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+func (Subscription) TableName() string {
+	return "webhook_subscriptions"
+}
+
+// Returned once, when a subscription is created
+type CreatedSubscription struct {
+	Subscription
+	Secret string `json:"secret"`
+}
+
+// Check the URL and event types, reporting every invalid field like contacts do
+func (s Subscription) Validate() error {
+	var fields []problem.FieldError
+	u, err := url.Parse(s.URL)
+	switch {
+	case s.URL == "":
+		fields = append(fields, problem.FieldError{Field: "url", Rule: "required", Message: "url is required"})
+	case len(s.URL) > 2000:
+		fields = append(fields, problem.FieldError{Field: "url", Rule: "max", Param: "2000", Message: "url must be at most 2000 characters"})
+	case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+		fields = append(fields, problem.FieldError{Field: "url", Rule: "url", Message: "url must be an absolute http or https URL"})
+	}
+	for _, change := range s.Events {
+		if !slices.Contains(changeTypes, change) {
+			fields = append(fields, problem.FieldError{Field: "events", Rule: "oneof", Param: "contact.created contact.updated contact.deleted",
+				Message: fmt.Sprintf("events must only contain contact.created, contact.updated or contact.deleted, not %q", change)})
+			break
+		}
+	}
+	if len(fields) > 0 {
+		return &contacts.ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// One event for one subscription. Pending deliveries are the outbox, the rest are the delivery log.
+type Delivery struct {
+	ID             uint                `json:"id" gorm:"primaryKey;autoIncrement"` // Sent as the event ID, the same for every attempt
+	TenantID       string              `json:"-" gorm:"size:100;not null;index"`
+	SubscriptionID uint                `json:"subscription_id" gorm:"not null;index"`
+	EventType      contacts.ChangeType `json:"event_type" gorm:"size:50;not null"`
+	ContactID      uint                `json:"contact_id" gorm:"not null;index"`
+	// Contact as JSON, encrypted when field encryption is enabled. Removed once the delivery is done, and when the contact is erased.
+	Payload       string     `json:"-" gorm:"type:text"`
+	Status        string     `json:"status" gorm:"size:20;not null;index:idx_webhook_due,priority:1"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"not null;index:idx_webhook_due,priority:2"`
+	CreatedAt     time.Time  `json:"created_at"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+	History       []Attempt  `json:"history" gorm:"foreignKey:DeliveryID"`
+}
+
+func (Delivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+// What happened when a delivery was sent
+type Attempt struct {
+	ID          uint      `json:"-" gorm:"primaryKey;autoIncrement"`
+	DeliveryID  uint      `json:"-" gorm:"not null;index"`
+	Number      int       `json:"number"` // Starting at 1
+	AttemptedAt time.Time `json:"attempted_at"`
+	StatusCode  int       `json:"status_code,omitempty"` // Missing when no response came back
+	Error       string    `json:"error,omitempty" gorm:"type:text"`
+	DurationMS  int64     `json:"duration_ms"`
+}
+
+func (Attempt) TableName() string {
+	return "webhook_attempts"
+}
+
+type PaginatedDeliveries struct {
+	Deliveries  []Delivery `json:"deliveries"`
+	TotalPages  int        `json:"total_pages"`
+	CurrentPage int        `json:"current_page"`
+	TotalCount  int64      `json:"total_count"`
+}
@@ -0,0 +1,67 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers sent with every delivery
+const (
+	SignatureHeader = "X-Phonebook-Signature" // t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">
+	EventHeader     = "X-Phonebook-Event"     // Event type, like contact.created
+	DeliveryHeader  = "X-Phonebook-Delivery"  // Delivery ID, the same for every attempt
+)
+
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+func mac(secret string, timestamp int64, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(h, "%d.", timestamp)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Signature header of a body sent at the given time. The timestamp is signed too, so a captured delivery can't be replayed later.
+func Sign(secret string, at time.Time, body []byte) string {
+	timestamp := at.Unix()
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, mac(secret, timestamp, body))
+}
+
+// Check the signature header of a delivery, for receivers. Signatures older than tolerance are rejected.
+func Verify(secret string, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var timestamp int64
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("timestamp %q is not a number: %w", value, ErrInvalidSignature)
+			}
+			timestamp = parsed
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == 0 || len(signatures) == 0 {
+		return fmt.Errorf("header needs a timestamp and a v1 signature: %w", ErrInvalidSignature)
+	}
+	if age := now.Sub(time.Unix(timestamp, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("timestamp is %s off, more than the tolerance of %s: %w", age.Round(time.Second), tolerance, ErrInvalidSignature)
+	}
+
+	expected := mac(secret, timestamp, body)
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), []byte(expected)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("no signature matches the body: %w", ErrInvalidSignature)
+}
@@ -0,0 +1,206 @@
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golangphonebook/pkg/contacts"
+	"golangphonebook/pkg/events"
+	"golangphonebook/pkg/fieldcrypt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Page size when listing deliveries
+const pageSize = 50
+
+// Name the payload is encrypted under, like the name of a contact field
+const payloadField = "webhook_payload"
+
+type Store struct {
+	DB *gorm.DB
+}
+
+// NewStore creates a new instance of Store
+func NewStore(db *gorm.DB) *Store {
+	return &Store{DB: db}
+}
+
+func newSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(secret), nil
+}
+
+// Encrypt a contact for the outbox the way contacts are encrypted, when field encryption is enabled
+func sealPayload(contact *contacts.Contact) (string, error) {
+	if contact == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(contact)
+	if err != nil {
+		return "", err
+	}
+	if e := contacts.FieldEncryptionSettings(); e != nil {
+		return e.Cipher.Encrypt(payloadField, string(data))
+	}
+	return string(data), nil
+}
+
+func openPayload(payload string) (*contacts.Contact, error) {
+	if payload == "" {
+		return nil, nil
+	}
+	if fieldcrypt.IsEncrypted(payload) {
+		e := contacts.FieldEncryptionSettings()
+		if e == nil {
+			return nil, contacts.ErrEncryptionNotConfigured
+		}
+		var err error
+		if payload, err = e.Cipher.Decrypt(payloadField, payload); err != nil {
+			return nil, err
+		}
+	}
+	var contact contacts.Contact
+	if err := json.Unmarshal([]byte(payload), &contact); err != nil {
+		return nil, err
+	}
+	return &contact, nil
+}
+
+// Add a subscription for the tenant, with a new secret to sign its deliveries
+func (s *Store) Create(tenant string, sub Subscription) (*CreatedSubscription, error) {
+	if err := sub.Validate(); err != nil {
+		return nil, err
+	}
+	if sub.AddressBookID != 0 {
+		err := s.DB.Where("id = ? AND tenant_id = ?", sub.AddressBookID, tenant).First(&contacts.AddressBook{}).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, contacts.ErrAddressBookNotFound
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+	sub.ID = 0
+	sub.TenantID = tenant
+	sub.Secret = secret
+	if err := s.DB.Create(&sub).Error; err != nil {
+		return nil, err
+	}
+	return &CreatedSubscription{Subscription: sub, Secret: secret}, nil
+}
+
+func (s *Store) List(tenant string) ([]Subscription, error) {
+	var subs []Subscription
+	err := s.DB.Where("tenant_id = ?", tenant).Order("id").Find(&subs).Error
+	return subs, err
+}
+
+func (s *Store) Get(tenant string, id uint) (*Subscription, error) {
+	var sub Subscription
+	err := s.DB.Where("id = ? AND tenant_id = ?", id, tenant).First(&sub).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("no webhook found with ID %d: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// Delete a subscription with its deliveries, pending ones are never sent
+func (s *Store) Delete(tenant string, id uint) (*Subscription, error) {
+	sub, err := s.Get(tenant, id)
+	if err != nil {
+		return nil, err
+	}
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		deliveries := tx.Model(&Delivery{}).Select("id").Where("subscription_id = ?", id)
+		if err := tx.Where("delivery_id IN (?)", deliveries).Delete(&Attempt{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("subscription_id = ?", id).Delete(&Delivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(sub).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// Deliveries of a subscription with their attempts, newest first, optionally only the ones in a status
+func (s *Store) Deliveries(tenant string, id uint, status string, page int) ([]Delivery, int64, error) {
+	if _, err := s.Get(tenant, id); err != nil {
+		return nil, 0, err
+	}
+
+	query := s.DB.Model(&Delivery{}).Where("subscription_id = ?", id)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var deliveries []Delivery
+	err := query.Preload("History", func(db *gorm.DB) *gorm.DB { return db.Order("number") }).
+		Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&deliveries).Error
+	return deliveries, count, err
+}
+
+// Add a delivery for every subscription of the tenant that wants the change, in the transaction that makes it.
+// Implements contacts.ChangeOutbox.
+func (s *Store) Enqueue(tx *gorm.DB, tenant string, change contacts.ChangeType, id uint, contact *contacts.Contact) error {
+	// Erased contacts take what is still waiting to be sent about them along
+	if contact == nil {
+		err := tx.Model(&Delivery{}).Where("tenant_id = ? AND contact_id = ? AND payload <> ''", tenant, id).Update("payload", "").Error
+		if err != nil {
+			return err
+		}
+	}
+
+	var subs []Subscription
+	if err := tx.Where("tenant_id = ?", tenant).Find(&subs).Error; err != nil {
+		return err
+	}
+	event := events.Event{Type: change, Tenant: tenant, ContactID: id, Contact: contact}
+	var deliveries []Delivery
+	for _, sub := range subs {
+		if !sub.filter().Matches(event) {
+			continue
+		}
+		deliveries = append(deliveries, Delivery{TenantID: tenant, SubscriptionID: sub.ID, EventType: change, ContactID: id, Status: StatusPending})
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	payload, err := sealPayload(contact)
+	if err != nil {
+		return fmt.Errorf("unable to encrypt webhook payload: %w", err)
+	}
+	now := time.Now().UTC()
+	for i := range deliveries {
+		deliveries[i].Payload = payload
+		deliveries[i].NextAttemptAt = now
+	}
+	return tx.Create(&deliveries).Error
+}
+
+// Same matching as the event streams
+func (s Subscription) filter() events.Filter {
+	return events.Filter{Types: s.Events, AddressBookID: s.AddressBookID}
+}
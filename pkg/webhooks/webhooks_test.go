@@ -0,0 +1,188 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"golangphonebook/pkg/contacts"
+	"golangphonebook/pkg/events"
+	"golangphonebook/pkg/fieldcrypt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignature(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	now := time.Now()
+	header := Sign("whsec_test", now, body)
+
+	assert.NoError(t, Verify("whsec_test", header, body, 5*time.Minute, now))
+	assert.ErrorIs(t, Verify("whsec_other", header, body, 5*time.Minute, now), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("whsec_test", header, []byte(`{"id":2}`), 5*time.Minute, now), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("whsec_test", header, body, 5*time.Minute, now.Add(10*time.Minute)), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("whsec_test", "v1=abc", body, 5*time.Minute, now), ErrInvalidSignature)
+}
+
+func TestEventTypes(t *testing.T) {
+	types := EventTypes{contacts.ContactCreated, contacts.ContactDeleted}
+	stored, err := types.Value()
+	require.NoError(t, err)
+	assert.Equal(t, ",contact.created,contact.deleted,", stored)
+
+	var scanned EventTypes
+	require.NoError(t, scanned.Scan(stored))
+	assert.Equal(t, types, scanned)
+	require.NoError(t, scanned.Scan(""))
+	assert.Empty(t, scanned)
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Subscription{URL: "https://crm.example.com/hooks"}.Validate())
+	assert.NoError(t, Subscription{URL: "http://tickets.internal:8080/phonebook", Events: EventTypes{contacts.ContactUpdated}}.Validate())
+
+	for name, sub := range map[string]Subscription{
+		"Missing URL":   {},
+		"Relative URL":  {URL: "/hooks"},
+		"Other Scheme":  {URL: "ftp://example.com/hooks"},
+		"Unknown Event": {URL: "https://example.com", Events: EventTypes{"contact.moved"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, sub.Validate(), contacts.ErrValidation)
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{MinBackoff: 10 * time.Second, MaxBackoff: time.Minute}
+	for attempts, full := range map[int]time.Duration{1: 10 * time.Second, 2: 20 * time.Second, 3: 40 * time.Second, 4: time.Minute, 20: time.Minute} {
+		wait := d.backoff(attempts)
+		assert.LessOrEqual(t, wait, full)
+		assert.GreaterOrEqual(t, wait, full*4/5)
+	}
+}
+
+// A receiver like the CRM's, checking signatures the way the README tells them to
+type receiver struct {
+	server   *httptest.Server
+	status   int
+	received []events.Event
+	headers  []http.Header
+}
+
+func newReceiver(t *testing.T, secret string) *receiver {
+	rec := &receiver{status: http.StatusOK}
+	rec.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := Verify(secret, r.Header.Get(SignatureHeader), body, 5*time.Minute, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		var e events.Event
+		require.NoError(t, json.Unmarshal(body, &e))
+		rec.received = append(rec.received, e)
+		rec.headers = append(rec.headers, r.Header.Clone())
+		w.WriteHeader(rec.status)
+	}))
+	t.Cleanup(rec.server.Close)
+	return rec
+}
+
+func pending(t *testing.T, contact *contacts.Contact) *Delivery {
+	payload, err := sealPayload(contact)
+	require.NoError(t, err)
+	return &Delivery{ID: 42, SubscriptionID: 1, EventType: contacts.ContactCreated, ContactID: 7, Payload: payload,
+		Status: StatusPending, CreatedAt: time.Now()}
+}
+
+func TestSend(t *testing.T) {
+	d := NewDispatcher(nil)
+	d.MaxAttempts = 2
+	rec := newReceiver(t, "whsec_test")
+	sub := Subscription{ID: 1, URL: rec.server.URL, Secret: "whsec_test"}
+
+	t.Run("Delivered", func(t *testing.T) {
+		delivery := pending(t, &contacts.Contact{ID: 7, FirstName: "Ada", Phone: "+441234567890"})
+		attempt := d.send(context.Background(), sub, delivery)
+		assert.Equal(t, http.StatusOK, attempt.StatusCode)
+		assert.Empty(t, attempt.Error)
+
+		require.Len(t, rec.received, 1)
+		assert.Equal(t, uint64(42), rec.received[0].ID)
+		assert.Equal(t, "Ada", rec.received[0].Contact.FirstName)
+		assert.Equal(t, "contact.created", rec.headers[0].Get(EventHeader))
+		assert.Equal(t, "42", rec.headers[0].Get(DeliveryHeader))
+
+		d.next(delivery, attempt)
+		assert.Equal(t, StatusDelivered, delivery.Status)
+		assert.Equal(t, 1, delivery.Attempts)
+		assert.NotNil(t, delivery.DeliveredAt)
+		assert.Empty(t, delivery.Payload)
+	})
+
+	t.Run("Retried Then Failed", func(t *testing.T) {
+		rec.status = http.StatusServiceUnavailable
+		delivery := pending(t, &contacts.Contact{ID: 7, FirstName: "Ada"})
+
+		attempt := d.send(context.Background(), sub, delivery)
+		assert.Equal(t, http.StatusServiceUnavailable, attempt.StatusCode)
+		assert.Contains(t, attempt.Error, "503")
+		d.next(delivery, attempt)
+		assert.Equal(t, StatusPending, delivery.Status)
+		assert.True(t, delivery.NextAttemptAt.After(attempt.AttemptedAt))
+		assert.NotEmpty(t, delivery.Payload)
+
+		attempt = d.send(context.Background(), sub, delivery)
+		assert.Equal(t, 2, attempt.Number)
+		d.next(delivery, attempt)
+		assert.Equal(t, StatusFailed, delivery.Status)
+		assert.Empty(t, delivery.Payload)
+	})
+
+	t.Run("Wrong Secret", func(t *testing.T) {
+		rec.status = http.StatusOK
+		attempt := d.send(context.Background(), Subscription{URL: rec.server.URL, Secret: "whsec_other"}, pending(t, nil))
+		assert.Equal(t, http.StatusUnauthorized, attempt.StatusCode)
+		assert.NotEmpty(t, attempt.Error)
+	})
+
+	t.Run("Unreachable", func(t *testing.T) {
+		closed := httptest.NewServer(http.NotFoundHandler())
+		closed.Close()
+		attempt := d.send(context.Background(), Subscription{URL: closed.URL, Secret: "whsec_test"}, pending(t, nil))
+		assert.Zero(t, attempt.StatusCode)
+		assert.NotEmpty(t, attempt.Error)
+	})
+}
+
+func TestEncryptedPayload(t *testing.T) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	encoded := base64.StdEncoding.EncodeToString(key)
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf("current: k1\nindex_key: %s\nkeys:\n  k1: %s\n", encoded, encoded)), 0600))
+	keys, err := fieldcrypt.LoadKeyFile(path)
+	require.NoError(t, err)
+
+	contacts.EnableFieldEncryption(fieldcrypt.NewCipher(keys), false)
+	defer contacts.DisableFieldEncryption()
+
+	payload, err := sealPayload(&contacts.Contact{ID: 7, Phone: "+441234567890"})
+	require.NoError(t, err)
+	assert.True(t, fieldcrypt.IsEncrypted(payload))
+	assert.NotContains(t, payload, "441234567890")
+
+	contact, err := openPayload(payload)
+	require.NoError(t, err)
+	assert.Equal(t, "+441234567890", contact.Phone)
+}
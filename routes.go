@@ -0,0 +1,425 @@
+// Route table, every route is registered and documented from here
+package main
+
+import (
+	"context"
+	"fmt"
+	"golangphonebook/pkg/audit"
+	"golangphonebook/pkg/auth"
+	"golangphonebook/pkg/contacts"
+	"golangphonebook/pkg/deadline"
+	"golangphonebook/pkg/events"
+	"golangphonebook/pkg/graphqlapi"
+	"golangphonebook/pkg/health"
+	"golangphonebook/pkg/idempotency"
+	"golangphonebook/pkg/metrics"
+	"golangphonebook/pkg/openapi"
+	"golangphonebook/pkg/privacy"
+	"golangphonebook/pkg/requestlog"
+	"golangphonebook/pkg/tracing"
+	"golangphonebook/pkg/webhooks"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Everything the handlers need
+type server struct {
+	db         *gorm.DB
+	repo       *contacts.SQLContactRepository
+	authz      *auth.Authorizer
+	auditStore *audit.Store
+	privacy    *privacy.Service
+	graphql    *graphqlapi.Handler
+	events     *events.Bus
+	webhooks   *webhooks.Store
+	health     *health.Checker
+	keyFile    string
+	spec       *openapi.Document
+
+	idempotency    idempotency.Backend
+	idempotencyTTL time.Duration
+
+	requestTimeout time.Duration            // Deadline of every request, none when zero
+	routeTimeouts  map[string]time.Duration // Deadlines of single routes, keyed like "GET /getContacts"
+}
+
+type route struct {
+	doc        openapi.Operation // Method, path and audit action are also used to register the route
+	role       auth.Role
+	handler    http.HandlerFunc
+	idempotent bool // Takes an Idempotency-Key, retries with the same key get the first response again
+	stream     bool // Runs until the client goes away, without a deadline or write timeout
+}
+
+var specInfo = openapi.Info{
+	Title:       "Phonebook API",
+	Version:     "2.0.0",
+	Description: "Contacts in tenant scoped address books. Clients authenticate with a certificate that maps to a role and tenant.",
+}
+
+// Every request only sees the address books of its own tenant, and reports its changes to the audit log
+func (s *server) requestRepo(ctx context.Context) contacts.ContactRepository {
+	id, _ := auth.FromContext(ctx)
+	scoped := s.repo.ForTenant(id.Tenant)
+	if entry, ok := audit.FromContext(ctx); ok {
+		scoped = scoped.WithRecorder(entry)
+	}
+	return scoped
+}
+
+func (s *server) contacts(handler func(http.ResponseWriter, *http.Request, contacts.ContactRepository)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) { handler(w, r, s.requestRepo(r.Context())) }
+}
+
+// Query parameters shared by several routes
+var (
+	pageParam      = openapi.Parameter{Name: "page", Description: "Page to return, starting at 1, out of range pages return the first page", Schema: openapi.Type("integer")}
+	contactFilters = []openapi.Parameter{
+		{Name: "first_name", Description: "Only contacts whose first name contains this", Schema: openapi.Type("string")},
+		{Name: "last_name", Description: "Only contacts whose last name contains this", Schema: openapi.Type("string")},
+		{Name: "address", Description: "Only contacts whose address contains this", Schema: openapi.Type("string")},
+		{Name: "phone", Description: "Only contacts whose phone number contains this", Schema: openapi.Type("string")},
+		{Name: "address_book_id", Description: "Only contacts in this address book", Schema: openapi.Type("integer")},
+		{Name: "sort_by", Description: "Sort field, last_modified always sorts newest first", Schema: openapi.Enum(string(contacts.SortByFirstName), string(contacts.SortByLastName), string(contacts.SortByLastModified))},
+		{Name: "asc_dec", Description: "Sort direction", Schema: openapi.Enum("asc", "dec")},
+		pageParam,
+	}
+	auditFilters = []openapi.Parameter{
+		{Name: "actor", Description: "Client certificate that made the request", Schema: openapi.Type("string")},
+		{Name: "action", Description: "Like add_contact or delete_contacts", Schema: openapi.Type("string")},
+		{Name: "outcome", Schema: openapi.Enum("success", "failure", "denied")},
+		{Name: "target_id", Description: "Only records that changed this contact", Schema: openapi.Type("integer")},
+		{Name: "from", Description: "RFC 3339 timestamp", Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+		{Name: "to", Description: "RFC 3339 timestamp", Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+	}
+	subjectFilters = []openapi.Parameter{
+		{Name: "phone", Description: "Compared on digits, like duplicates", Schema: openapi.Type("string")},
+		{Name: "first_name", Description: "Case insensitive", Schema: openapi.Type("string")},
+		{Name: "last_name", Description: "Case insensitive", Schema: openapi.Type("string")},
+	}
+	idempotencyKey = openapi.Parameter{Name: idempotency.Header, In: "header",
+		Description: "Unique key for the request, like a UUID. Retries with the same key get the first response again instead of repeating the change, for as long as the key is kept. Reusing a key for a different request fails with 422.",
+		Schema:      &openapi.Schema{Type: "string", MaxLength: integer(255)}}
+	eventFilters = []openapi.Parameter{
+		{Name: "types", Description: "Comma separated event types, every type when empty", Schema: openapi.Type("string")},
+		{Name: "contact_id", Description: "Only events for this contact", Schema: openapi.Type("integer")},
+		{Name: "address_book_id", Description: "Only events for contacts in this address book", Schema: openapi.Type("integer")},
+		{Name: "last_event_id", Description: "Replay the events after this one first", Schema: openapi.Type("integer")},
+		{Name: "Last-Event-ID", In: "header", Description: "Sent by browsers when they reconnect, takes precedence over last_event_id", Schema: openapi.Type("integer")},
+	}
+)
+
+func (s *server) routes() []route {
+	const (
+		v2     = "Contacts v2"
+		v1     = "Contacts v1"
+		books  = "Address books"
+		dupes  = "Duplicates"
+		audits = "Audit log"
+		dsr    = "Data subjects"
+		admin  = "Administration"
+		gql    = "GraphQL"
+		feed   = "Events"
+		hooks  = "Webhooks"
+	)
+	text := "text/plain"
+
+	return []route{
+		// v2, one resource with the usual methods
+		{role: auth.RoleReader, handler: s.contacts(contacts.ListContactsV2), doc: openapi.Operation{
+			Method: "GET", Path: "/v2/contacts", Tag: v2, Summary: fmt.Sprintf("List contacts, %d per page", contacts.PageSize),
+			Parameters: contactFilters, Response: contacts.PaginatedContacts{},
+		}},
+		{role: auth.RoleEditor, handler: s.contacts(contacts.CreateContactV2), idempotent: true, doc: openapi.Operation{
+			Method: "POST", Path: "/v2/contacts", Tag: v2, Summary: "Create a contact", AuditAction: "add_contact",
+			Body: contacts.Contact{}, Response: contacts.Contact{}, Status: http.StatusCreated,
+			Headers: map[string]string{"Location": "URL of the new contact"},
+			Errors:  []int{http.StatusBadRequest, http.StatusConflict},
+		}},
+		{role: auth.RoleReader, handler: s.contacts(contacts.GetContactV2), doc: openapi.Operation{
+			Method: "GET", Path: "/v2/contacts/{id}", Tag: v2, Summary: "Get a contact",
+			Response: contacts.Contact{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound},
+		}},
+		{role: auth.RoleEditor, handler: s.contacts(contacts.ReplaceContactV2), doc: openapi.Operation{
+			Method: "PUT", Path: "/v2/contacts/{id}", Tag: v2, Summary: "Replace a contact, fields missing from the body are cleared", AuditAction: "update_contact",
+			Body: contacts.Contact{}, Response: contacts.Contact{},
+			Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict},
+		}},
+		{role: auth.RoleEditor, handler: s.contacts(contacts.PatchContactV2), doc: openapi.Operation{
+			Method: "PATCH", Path: "/v2/contacts/{id}", Tag: v2, Summary: "Change some fields of a contact with a JSON merge patch, null clears a field", AuditAction: "update_contact",
+			Body: contacts.Contact{}, BodyType: "application/merge-patch+json", Response: contacts.Contact{},
+			Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict},
+		}},
+		{role: auth.RoleEditor, handler: s.contacts(contacts.DeleteContactV2), idempotent: true, doc: openapi.Operation{
+			Method: "DELETE", Path: "/v2/contacts/{id}", Tag: v2, Summary: "Delete a contact", AuditAction: "delete_contact",
+			Status: http.StatusNoContent, Errors: []int{http.StatusBadRequest, http.StatusNotFound},
+		}},
+
+		{role: auth.RoleReader, handler: s.contacts(contacts.SyncContacts), doc: openapi.Operation{
+			Method: "GET", Path: "/v2/sync", Tag: v2, Summary: "Contacts created, changed or deleted since an earlier sync",
+			Description: "Without a token every contact is returned, as if they had all just been created. Keep the sync_token of the response and pass it as token next time. Sync again straight away while has_more is true.",
+			Parameters: []openapi.Parameter{
+				{Name: "token", Description: "sync_token of an earlier sync", Schema: openapi.Type("string")},
+				{Name: "limit", Description: "Most contacts and deletions to return, 100 by default", Schema: &openapi.Schema{Type: "integer", Minimum: float(1), Maximum: float(contacts.MaxSyncLimit)}},
+			},
+			Response: contacts.SyncResult{}, Errors: []int{http.StatusBadRequest},
+		}},
+
+		// v1, kept for existing clients
+		{role: auth.RoleEditor, handler: s.contacts(contacts.PutContact), idempotent: true, doc: openapi.Operation{
+			Method: "PUT", Path: "/addContact", Tag: v1, Summary: "Add a contact", AuditAction: "add_contact",
+			Description: "A duplicate of another contact is a 400 of type /problems/duplicate-contact, as v1 always answered it.",
+			Body:        contacts.Contact{}, Response: "", ResponseType: text,
+			Errors: []int{http.StatusBadRequest},
+		}},
+		{role: auth.RoleEditor, handler: s.contacts(contacts.PutContacts), idempotent: true, doc: openapi.Operation{
+			Method: "PUT", Path: "/addContacts", Tag: v1, Summary: fmt.Sprintf("Add up to %d contacts", contacts.MaxBatchSize), AuditAction: "add_contacts",
+			Description: "Each contact is added on its own. The response lists the ones that failed, with a 400 status and the same body when all of them did.",
+			Body:        []contacts.Contact{}, Response: contacts.BatchResult{},
+			Partial: map[int]string{http.StatusPartialContent: "Some of the contacts were added"},
+			Errors:  []int{http.StatusBadRequest},
+		}},
+		{role: auth.RoleReader, handler: s.contacts(contacts.GetContacts), doc: openapi.Operation{
+			Method: "GET", Path: "/getContacts", Tag: v1, Summary: fmt.Sprintf("List contacts, %d per page", contacts.PageSize),
+			Parameters: contactFilters, Response: contacts.PaginatedContacts{},
+		}},
+		{role: auth.RoleEditor, handler: s.contacts(contacts.UpdateContact), doc: openapi.Operation{
+			Method: "POST", Path: "/updateContact/{id}", Tag: v1, Summary: "Update a contact, empty fields are left alone", AuditAction: "update_contact",
+			Description: "A duplicate of another contact is a 400 of type /problems/duplicate-contact, as v1 always answered it.",
+			Body:        contacts.Contact{}, Response: "", ResponseType: text,
+			Errors: []int{http.StatusBadRequest, http.StatusNotFound},
+		}},
+		{role: auth.RoleEditor, handler: s.contacts(contacts.DeleteContact), idempotent: true, doc: openapi.Operation{
+			Method: "DELETE", Path: "/deleteContact/{id}", Tag: v1, Summary: "Delete a contact", AuditAction: "delete_contact",
+			Response: "", ResponseType: text, Errors: []int{http.StatusBadRequest, http.StatusNotFound},
+		}},
+		{role: auth.RoleAdmin, handler: s.contacts(contacts.DeleteContacts), idempotent: true, doc: openapi.Operation{
+			Method: "DELETE", Path: "/deleteContacts", Tag: v1, Summary: fmt.Sprintf("Delete up to %d contacts", contacts.MaxBatchSize), AuditAction: "delete_contacts",
+			Parameters: []openapi.Parameter{{Name: "ids", Description: "Comma separated contact IDs", Required: true, Schema: openapi.Type("string")}},
+			Response:   "", ResponseType: text, Errors: []int{http.StatusBadRequest, http.StatusNotFound},
+		}},
+
+		// Duplicates
+		{role: auth.RoleReader, handler: s.contacts(contacts.FindDuplicates), doc: openapi.Operation{
+			Method: "GET", Path: "/findDuplicates", Tag: dupes, Summary: "Find clusters of likely duplicate contacts",
+			Parameters: []openapi.Parameter{{Name: "threshold", Description: "Similarity the names need, between 0 and 1", Schema: &openapi.Schema{Type: "number", Minimum: float(0), Maximum: float(1)}}},
+			Response:   contacts.DuplicateClusters{}, Errors: []int{http.StatusBadRequest},
+		}},
+		{role: auth.RoleAdmin, handler: s.contacts(contacts.MergeContacts), doc: openapi.Operation{
+			Method: "POST", Path: "/mergeContacts", Tag: dupes, Summary: "Merge contacts into the first one", AuditAction: "merge_contacts",
+			Description: "A merged contact that duplicates another contact is a 400 of type /problems/duplicate-contact, like on the other v1 routes.",
+			Body:        contacts.MergeRequest{}, Response: contacts.Contact{},
+			Errors: []int{http.StatusBadRequest, http.StatusNotFound},
+		}},
+		{role: auth.RoleReader, handler: s.contacts(contacts.GetMergeHistory), doc: openapi.Operation{
+			Method: "GET", Path: "/mergeHistory/{id}", Tag: dupes, Summary: "Snapshots of the contacts merged into a contact",
+			Response: []contacts.MergeRecord{}, Errors: []int{http.StatusBadRequest},
+		}},
+
+		// Address books
+		{role: auth.RoleReader, handler: s.contacts(contacts.GetAddressBooks), doc: openapi.Operation{
+			Method: "GET", Path: "/getAddressBooks", Tag: books, Summary: "List the tenant's address books",
+			Response: []contacts.AddressBook{},
+		}},
+		{role: auth.RoleAdmin, handler: s.contacts(contacts.PutAddressBook), doc: openapi.Operation{
+			Method: "PUT", Path: "/addAddressBook", Tag: books, Summary: "Add an address book to the tenant", AuditAction: "add_address_book",
+			Description: "A name the tenant already uses is a 400 of type /problems/duplicate-address-book, like duplicates on the other v1 routes.",
+			Body:        contacts.AddressBook{}, Response: contacts.AddressBook{},
+			Errors: []int{http.StatusBadRequest},
+		}},
+
+		// Audit
+		{role: auth.RoleAdmin, handler: func(w http.ResponseWriter, r *http.Request) { audit.GetAuditRecords(w, r, s.auditStore) }, doc: openapi.Operation{
+			Method: "GET", Path: "/getAuditRecords", Tag: audits, Summary: "Search the tenant's audit records, 50 per page",
+			Parameters: append(append([]openapi.Parameter(nil), auditFilters...), pageParam),
+			Response:   audit.PaginatedRecords{}, Errors: []int{http.StatusBadRequest},
+		}},
+		{role: auth.RoleAdmin, handler: func(w http.ResponseWriter, r *http.Request) { audit.ExportAudit(w, r, s.auditStore) }, doc: openapi.Operation{
+			Method: "GET", Path: "/exportAudit", Tag: audits, Summary: "Export matching audit records as newline delimited JSON, oldest first",
+			Parameters: auditFilters, Response: audit.Record{}, ResponseType: "application/x-ndjson",
+			Errors: []int{http.StatusBadRequest},
+		}},
+		{role: auth.RoleAdmin, handler: func(w http.ResponseWriter, r *http.Request) { audit.VerifyAudit(w, r, s.auditStore) }, doc: openapi.Operation{
+			Method: "GET", Path: "/verifyAudit", Tag: audits, Summary: "Verify the hash chain of the audit log",
+			Response: audit.Verification{},
+		}},
+
+		// Data subjects
+		{role: auth.RoleAdmin, handler: func(w http.ResponseWriter, r *http.Request) { privacy.ExportSubject(w, r, s.privacy) }, doc: openapi.Operation{
+			Method: "GET", Path: "/exportSubject", Tag: dsr, Summary: "Export everything held on a person", AuditAction: "export_subject",
+			Parameters: subjectFilters, Response: privacy.Bundle{}, Errors: []int{http.StatusBadRequest},
+		}},
+		{role: auth.RoleAdmin, handler: func(w http.ResponseWriter, r *http.Request) { privacy.EraseSubject(w, r, s.privacy) }, doc: openapi.Operation{
+			Method: "POST", Path: "/eraseSubject", Tag: dsr, Summary: "Erase everything held on a person", AuditAction: "erase_subject",
+			Body: contacts.Subject{}, Response: privacy.Receipt{}, Errors: []int{http.StatusBadRequest},
+		}},
+		{role: auth.RoleAdmin, handler: func(w http.ResponseWriter, r *http.Request) { privacy.GetErasures(w, r, s.privacy) }, doc: openapi.Operation{
+			Method: "GET", Path: "/getErasures", Tag: dsr, Summary: "List the tenant's erasure tombstones",
+			Response: []privacy.Erasure{},
+		}},
+
+		// Encryption
+		{role: auth.RoleAdmin, handler: func(w http.ResponseWriter, r *http.Request) { contacts.RotateEncryptionKeys(w, r, s.db, s.keyFile) }, doc: openapi.Operation{
+			Method: "POST", Path: "/rotateEncryption", Tag: admin, Summary: "Reload the key file and encrypt everything with its current key", AuditAction: "rotate_encryption",
+			Response: contacts.RotationResult{}, Errors: []int{http.StatusConflict},
+		}},
+
+		// GraphQL, mutations check their own role and are audited one by one
+		{role: auth.RoleReader, handler: func(w http.ResponseWriter, r *http.Request) { s.graphql.ServeHTTP(w, r) }, doc: openapi.Operation{
+			Method: "POST", Path: "/graphql", Tag: gql, Summary: "Query and change contacts and address books with GraphQL",
+			Description: "Mutations need the same role as the matching REST route. Queries that could resolve more than 1000 fields, counting every item of every list, or nest more than 10 levels deep are rejected before they run.",
+			Body:        graphqlapi.Request{}, Response: graphqlapi.Response{}, Errors: []int{http.StatusBadRequest},
+		}},
+
+		// Events, streamed until the client goes away
+		{role: auth.RoleReader, stream: true, handler: func(w http.ResponseWriter, r *http.Request) { events.ServeSSE(w, r, s.events) }, doc: openapi.Operation{
+			Method: "GET", Path: "/events", Tag: feed, Summary: "Stream changes to the tenant's contacts as server-sent events",
+			Description: "Every event is sent with its ID and type, the data is the event as JSON. Resuming after an event that is no longer kept, only the latest 1000 are, fails with 410.",
+			Parameters:  eventFilters, Response: events.Event{}, ResponseType: "text/event-stream",
+			Errors: []int{http.StatusBadRequest, http.StatusGone, http.StatusServiceUnavailable},
+		}},
+		{role: auth.RoleReader, stream: true, handler: func(w http.ResponseWriter, r *http.Request) { events.ServeWebSocket(w, r, s.events) }, doc: openapi.Operation{
+			Method: "GET", Path: "/events/ws", Tag: feed, Summary: "Stream changes to the tenant's contacts over a WebSocket",
+			Description: "Every event is a JSON text message, like the data of the server-sent events. Clients that fall behind are closed with code 1013 and can reconnect with last_event_id.",
+			Parameters:  eventFilters, Status: http.StatusSwitchingProtocols,
+			Errors: []int{http.StatusBadRequest, http.StatusGone, http.StatusServiceUnavailable},
+		}},
+
+		// Webhooks
+		{role: auth.RoleAdmin, handler: func(w http.ResponseWriter, r *http.Request) { webhooks.CreateWebhook(w, r, s.webhooks) }, doc: openapi.Operation{
+			Method: "POST", Path: "/webhooks", Tag: hooks, Summary: "Subscribe a URL to changes to the tenant's contacts", AuditAction: "add_webhook",
+			Description: "The response holds the secret deliveries are signed with. It is only ever shown here.",
+			Body:        webhooks.Subscription{}, Response: webhooks.CreatedSubscription{}, Status: http.StatusCreated,
+			Headers: map[string]string{"Location": "URL of the new webhook"},
+			Errors:  []int{http.StatusBadRequest},
+		}},
+		{role: auth.RoleAdmin, handler: func(w http.ResponseWriter, r *http.Request) { webhooks.GetWebhooks(w, r, s.webhooks) }, doc: openapi.Operation{
+			Method: "GET", Path: "/webhooks", Tag: hooks, Summary: "List the tenant's webhooks",
+			Response: []webhooks.Subscription{},
+		}},
+		{role: auth.RoleAdmin, handler: func(w http.ResponseWriter, r *http.Request) { webhooks.GetWebhook(w, r, s.webhooks) }, doc: openapi.Operation{
+			Method: "GET", Path: "/webhooks/{id}", Tag: hooks, Summary: "Get a webhook",
+			Response: webhooks.Subscription{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound},
+		}},
+		{role: auth.RoleAdmin, handler: func(w http.ResponseWriter, r *http.Request) { webhooks.DeleteWebhook(w, r, s.webhooks) }, doc: openapi.Operation{
+			Method: "DELETE", Path: "/webhooks/{id}", Tag: hooks, Summary: "Delete a webhook with its delivery log, pending deliveries are not sent", AuditAction: "delete_webhook",
+			Status: http.StatusNoContent, Errors: []int{http.StatusBadRequest, http.StatusNotFound},
+		}},
+		{role: auth.RoleAdmin, handler: func(w http.ResponseWriter, r *http.Request) { webhooks.GetDeliveries(w, r, s.webhooks) }, doc: openapi.Operation{
+			Method: "GET", Path: "/webhooks/{id}/deliveries", Tag: hooks, Summary: "Delivery log of a webhook with every attempt, newest first, 50 per page",
+			Parameters: []openapi.Parameter{{Name: "status", Schema: openapi.Enum(webhooks.StatusPending, webhooks.StatusDelivered, webhooks.StatusFailed)}, pageParam},
+			Response:   webhooks.PaginatedDeliveries{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound},
+		}},
+
+		// Probes, also served without a client certificate on the admin port when it is configured
+		{role: auth.RoleReader, handler: health.Liveness, doc: openapi.Operation{
+			Method: "GET", Path: "/healthz", Tag: admin, Summary: "Whether the process is up",
+			Response: health.Report{},
+		}},
+		{role: auth.RoleReader, handler: func(w http.ResponseWriter, r *http.Request) { health.Readiness(w, r, s.health) }, doc: openapi.Operation{
+			Method: "GET", Path: "/readyz", Tag: admin, Summary: "Whether the server can take traffic, with the state of each component",
+			Description: "Checks that the database answers, that its schema is up to date, and that the server certificate and client CA are valid. Certificates that expire within 14 days are reported with a warning but don't fail the check.",
+			Response:    health.Report{},
+			Partial:     map[int]string{http.StatusServiceUnavailable: "Not ready, the failed components are marked"},
+		}},
+
+		{role: auth.RoleAdmin, handler: metrics.Default.ServeHTTP, doc: openapi.Operation{
+			Method: "GET", Path: "/metrics", Tag: admin, Summary: "Metrics in the Prometheus text format, also served on the admin port when it is configured",
+			Response: "", ResponseType: "text/plain",
+		}},
+
+		// Documentation
+		{role: auth.RoleReader, handler: func(w http.ResponseWriter, r *http.Request) { openapi.ServeSpec(w, r, s.spec) }, doc: openapi.Operation{
+			Method: "GET", Path: "/openapi.json", Tag: admin, Summary: "This OpenAPI document",
+			Response: map[string]any{},
+		}},
+		{role: auth.RoleReader, handler: func(w http.ResponseWriter, r *http.Request) { openapi.ServeDocs(w, r, "/openapi.json") }, doc: openapi.Operation{
+			Method: "GET", Path: "/docs", Tag: admin, Summary: "Docs page for the OpenAPI document",
+			Response: "", ResponseType: "text/html",
+		}},
+	}
+}
+
+func float(value float64) *float64 {
+	return &value
+}
+
+func integer(value int) *int {
+	return &value
+}
+
+// Document every route, with the constraints of the validate tags
+func generateSpec(routes []route) (*openapi.Document, error) {
+	operations := make([]openapi.Operation, 0, len(routes))
+	for _, rt := range routes {
+		op := rt.doc
+		op.Role = string(rt.role)
+		if rt.idempotent {
+			op.Parameters = append(slices.Clip(op.Parameters), idempotencyKey)
+			op.Errors = append(slices.Clip(op.Errors), http.StatusConflict, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity)
+		}
+		if !rt.stream {
+			op.Errors = append(slices.Clip(op.Errors), http.StatusGatewayTimeout)
+		}
+		slices.Sort(op.Errors)
+		op.Errors = slices.Compact(op.Errors)
+		operations = append(operations, op)
+	}
+	return openapi.Generate(specInfo, operations, openapi.Options{Patterns: map[string]string{"customPhone": contacts.PhonePattern}})
+}
+
+// Register every route behind its role check, logged with a request ID and counted in the metrics, audited when it has an audit action,
+// taking an Idempotency-Key when it is idempotent and with a deadline unless it streams
+func (s *server) router() (*mux.Router, error) {
+	routes := s.routes()
+	spec, err := generateSpec(routes)
+	if err != nil {
+		return nil, err
+	}
+	s.spec = spec
+
+	// A deadline for a route that doesn't exist is most likely a typo
+	known := make(map[string]bool, len(routes))
+	for _, rt := range routes {
+		known[rt.doc.Method+" "+rt.doc.Path] = true
+	}
+	for key := range s.routeTimeouts {
+		if !known[key] {
+			return nil, fmt.Errorf("route timeout for unknown route %q", key)
+		}
+	}
+
+	router := mux.NewRouter()
+	for _, rt := range routes {
+		handler := rt.handler
+		// Inside the audit log, so replays are recorded too
+		if rt.idempotent {
+			handler = idempotency.Middleware(s.idempotency, s.idempotencyTTL, handler)
+		}
+		if rt.stream {
+			handler = deadline.Unlimited(handler)
+		} else {
+			timeout, ok := s.routeTimeouts[rt.doc.Method+" "+rt.doc.Path]
+			if !ok {
+				timeout = s.requestTimeout
+			}
+			handler = deadline.Middleware(timeout, handler)
+		}
+		handler = s.authz.Require(rt.role, handler)
+		// Outside the role check, so denied attempts are recorded too
+		if rt.doc.AuditAction != "" {
+			handler = audit.Middleware(s.auditStore, rt.doc.AuditAction, handler)
+		}
+		// Outside the role check, so rejected requests are counted and logged too
+		handler = metrics.Middleware(rt.doc.Method, rt.doc.Path, handler)
+		handler = requestlog.Middleware(rt.doc.Path, handler)
+		// Outermost, so the log lines of the request carry its trace ID
+		handler = tracing.Middleware(rt.doc.Method, rt.doc.Path, handler)
+		router.HandleFunc(rt.doc.Path, handler).Methods(rt.doc.Method)
+	}
+	return router, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"golangphonebook/pkg/openapi"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "rewrite api/openapi.json from the route table")
+
+const specFile = "api/openapi.json"
+
+// Every route the router serves, as "METHOD /path"
+func registeredRoutes(t *testing.T, router *mux.Router) []string {
+	var routes []string
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			t.Errorf("%s is registered without a method", path)
+			return nil
+		}
+		for _, method := range methods {
+			routes = append(routes, method+" "+openapi.OpenAPIPath(path))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	sort.Strings(routes)
+	return routes
+}
+
+func documentedRoutes(doc *openapi.Document) []string {
+	var routes []string
+	for path, item := range doc.Paths {
+		for method := range item {
+			routes = append(routes, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(routes)
+	return routes
+}
+
+func TestRoutesMatchSpec(t *testing.T) {
+	s := &server{}
+	router, err := s.router()
+	require.NoError(t, err)
+
+	assert.Equal(t, registeredRoutes(t, router), documentedRoutes(s.spec))
+
+	for path, item := range s.spec.Paths {
+		for method, op := range item {
+			assert.NotEmpty(t, op.RequiredRole, "%s %s has no role", method, path)
+			var params []string
+			for _, p := range op.Parameters {
+				if p.In == "path" {
+					params = append(params, p.Name)
+				}
+			}
+			assert.Equal(t, openapi.PathVariables(path), params, "path parameters of %s %s", method, path)
+		}
+	}
+}
+
+func TestUnknownRouteTimeout(t *testing.T) {
+	s := &server{routeTimeouts: map[string]time.Duration{"GET /getContacts": time.Second}}
+	_, err := s.router()
+	assert.NoError(t, err)
+
+	s.routeTimeouts["GET /getContact"] = time.Second
+	_, err = s.router()
+	assert.ErrorContains(t, err, `unknown route "GET /getContact"`)
+}
+
+// The published document has to follow the handlers and types, run with -update after changing them
+func TestSpecUpToDate(t *testing.T) {
+	spec, err := generateSpec((&server{}).routes())
+	require.NoError(t, err)
+	generated, err := json.MarshalIndent(spec, "", "  ")
+	require.NoError(t, err)
+	generated = append(generated, '\n')
+
+	if *update {
+		require.NoError(t, os.MkdirAll("api", 0o755))
+		require.NoError(t, os.WriteFile(specFile, generated, 0o644))
+	}
+
+	published, err := os.ReadFile(specFile)
+	require.NoError(t, err, "run go test -run TestSpecUpToDate -update to create %s", specFile)
+	assert.JSONEq(t, string(published), string(generated), "%s is out of date, run go test -run TestSpecUpToDate -update", specFile)
+}
@@ -0,0 +1,96 @@
+// Stop cleanly on SIGTERM and SIGINT
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golangphonebook/internal"
+	"golangphonebook/pkg/contacts"
+	"golangphonebook/pkg/events"
+	"golangphonebook/pkg/health"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"gorm.io/gorm"
+)
+
+// Everything that has to stop before the process exits
+type services struct {
+	https   *http.Server
+	admin   *http.Server // Nil without an admin port
+	grpc    *grpc.Server
+	events  *events.Bus
+	health  *health.Checker
+	workers *sync.WaitGroup // Background workers, stopped by the context they were started with
+	db      *gorm.DB
+	traces  func(context.Context) error // Flushes the spans still buffered
+}
+
+// Spans are flushed last and get a moment of their own, a shutdown that ran out of time is worth tracing most
+const traceFlushTimeout = 5 * time.Second
+
+// Stop taking connections, let the requests in flight and the background workers finish within the timeout,
+// then close the database pool. Whatever is still running when the timeout is up is cut off.
+func (s services) shutdown(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	internal.Logger.Info(fmt.Sprintf("Shutting down, waiting up to %v for requests and background work", timeout))
+	// Probes on the admin port keep answering, not ready, until the end
+	s.health.Drain()
+
+	var errs []error
+	step := func(name string, err error) {
+		if err != nil {
+			internal.Logger.Error(fmt.Sprintf("Failed to stop %s cleanly: %v", name, err))
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	// gRPC stops alongside HTTPS, and is cut off with it when the time is up
+	grpcStopped := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(grpcStopped)
+	}()
+
+	// Event streams never finish on their own, Shutdown ends them through the bus.
+	// WebSockets are hijacked connections that Shutdown doesn't wait for, the bus does.
+	s.https.RegisterOnShutdown(s.events.Close)
+	step("HTTPS", s.https.Shutdown(ctx))
+	step("event streams", s.events.Wait(ctx))
+	select {
+	case <-grpcStopped:
+	case <-ctx.Done():
+		s.grpc.Stop()
+		step("gRPC", ctx.Err())
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		s.https.Close()
+	}
+
+	step("background workers", internal.Wait(ctx, s.workers))
+	step("cache prefetches", contacts.WaitForPrefetches(ctx))
+
+	if s.admin != nil {
+		step("admin port", s.admin.Shutdown(ctx))
+	}
+
+	// Pooled connections are closed even when something is still running, it would fail either way once the process exits
+	sqlDB, err := s.db.DB()
+	if err == nil {
+		err = sqlDB.Close()
+	}
+	step("database pool", err)
+
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), traceFlushTimeout)
+	defer cancelFlush()
+	step("trace export", s.traces(flushCtx))
+
+	if len(errs) == 0 {
+		internal.Logger.Info("Shut down cleanly")
+	}
+	return errors.Join(errs...)
+}
@@ -2,10 +2,12 @@ package test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"golangphonebook/db"
 	"golangphonebook/internal"
+	"golangphonebook/pkg/config"
 	"golangphonebook/pkg/contacts"
 	"io"
 	"net/http"
@@ -18,8 +20,17 @@ import (
 
 var testServer *httptest.Server
 
+// Settings from the environment, like the server's in docker-compose
+func testConfig() *config.Config {
+	cfg, err := config.Load(nil)
+	if err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
 func setupRouter() *mux.Router {
-	db, err := db.DBInit()
+	db, err := db.DBInit(testConfig().DB)
 	if err != nil {
 		internal.Logger.Error("Failed to initialize test database")
 		panic(err)
@@ -37,6 +48,17 @@ func setupRouter() *mux.Router {
 	// D
 	router.HandleFunc("/deleteContact/{id}", func(w http.ResponseWriter, r *http.Request) { contacts.DeleteContact(w, r, repo) }).Methods("DELETE")
 	router.HandleFunc("/deleteContacts", func(w http.ResponseWriter, r *http.Request) { contacts.DeleteContacts(w, r, repo) }).Methods("DELETE")
+	// Duplicates
+	router.HandleFunc("/findDuplicates", func(w http.ResponseWriter, r *http.Request) { contacts.FindDuplicates(w, r, repo) }).Methods("GET")
+	router.HandleFunc("/mergeContacts", func(w http.ResponseWriter, r *http.Request) { contacts.MergeContacts(w, r, repo) }).Methods("POST")
+	router.HandleFunc("/mergeHistory/{id}", func(w http.ResponseWriter, r *http.Request) { contacts.GetMergeHistory(w, r, repo) }).Methods("GET")
+	// v2
+	router.HandleFunc("/v2/contacts", func(w http.ResponseWriter, r *http.Request) { contacts.ListContactsV2(w, r, repo) }).Methods("GET")
+	router.HandleFunc("/v2/contacts", func(w http.ResponseWriter, r *http.Request) { contacts.CreateContactV2(w, r, repo) }).Methods("POST")
+	router.HandleFunc("/v2/contacts/{id}", func(w http.ResponseWriter, r *http.Request) { contacts.GetContactV2(w, r, repo) }).Methods("GET")
+	router.HandleFunc("/v2/contacts/{id}", func(w http.ResponseWriter, r *http.Request) { contacts.ReplaceContactV2(w, r, repo) }).Methods("PUT")
+	router.HandleFunc("/v2/contacts/{id}", func(w http.ResponseWriter, r *http.Request) { contacts.PatchContactV2(w, r, repo) }).Methods("PATCH")
+	router.HandleFunc("/v2/contacts/{id}", func(w http.ResponseWriter, r *http.Request) { contacts.DeleteContactV2(w, r, repo) }).Methods("DELETE")
 	return router
 }
 
@@ -64,6 +86,71 @@ func TestE2E(t *testing.T) {
 
 	resetDatabase()
 	t.Run("SearchContactsWithUpdates", testSearchContactsWithUpdates)
+
+	resetDatabase()
+	t.Run("ContactsV2", testContactsV2)
+}
+
+func testContactsV2(t *testing.T) {
+	send := func(method, path, body string) *http.Response {
+		req, err := http.NewRequest(method, testServer.URL+path, bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	// Create
+	resp := send("POST", "/v2/contacts", `{"first_name": "John", "last_name": "Doe", "phone": "+1234567890", "address": "123 Main St"}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	var created contacts.Contact
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	resp.Body.Close()
+	location := resp.Header.Get("Location")
+	assert.Equal(t, fmt.Sprintf("/v2/contacts/%d", created.ID), location)
+
+	// Same contact again is a conflict
+	resp = send("POST", "/v2/contacts", `{"first_name": "John", "last_name": "Doe", "phone": "+1234567890"}`)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp.Body.Close()
+
+	// Patch only changes what's in the body
+	resp = send("PATCH", location, `{"address": null, "phone": "+1987654321"}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+
+	resp = send("GET", location, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var fetched contacts.Contact
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&fetched))
+	resp.Body.Close()
+	assert.Equal(t, "Doe", fetched.LastName)
+	assert.Equal(t, "+1987654321", fetched.Phone)
+	assert.Equal(t, "", fetched.Address)
+
+	// Put replaces everything
+	resp = send("PUT", location, `{"first_name": "Jane", "phone": "+1987654321"}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&fetched))
+	resp.Body.Close()
+	assert.Equal(t, "Jane", fetched.FirstName)
+	assert.Equal(t, "", fetched.LastName)
+
+	// The list is shared with v1
+	resp = send("GET", "/v2/contacts?first_name=Jane", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var page contacts.PaginatedContacts
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+	resp.Body.Close()
+	assert.Equal(t, int64(1), page.TotalCount)
+
+	resp = send("DELETE", location, "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp.Body.Close()
+
+	resp = send("GET", location, "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp.Body.Close()
 }
 
 func testCreateContact(t *testing.T) {
@@ -86,6 +173,7 @@ func testCreateContact(t *testing.T) {
 	resp, err = http.Post(testServer.URL+"/addContact", "application/json", bytes.NewBuffer(contactJSON))
 	assert.NoError(t, err)                                  // No network or request error
 	assert.Equal(t, http.StatusBadRequest, resp.StatusCode) // Expecting a 400 Bad Request
+	assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
 
 	// Read the response body
 	body, err := io.ReadAll(resp.Body)
@@ -618,19 +706,18 @@ func setupTestServer() {
 }
 
 func resetDatabase() {
-	db, err := db.DBInit()
+	conn, err := db.DBInit(testConfig().DB)
 	if err != nil {
 		internal.Logger.Error("Failed to initialize test database")
 		panic(err)
 	}
 
 	// Drop and recreate the schema
-	db.Exec("DROP SCHEMA public CASCADE;")
-	db.Exec("CREATE SCHEMA public;")
+	conn.Exec("DROP SCHEMA public CASCADE;")
+	conn.Exec("CREATE SCHEMA public;")
 
-	// Run migrations to create the table
-	err = db.AutoMigrate(&contacts.Contact{})
-	if err != nil {
+	// Run migrations to create the tables
+	if _, err := db.Migrator(conn).Up(context.Background()); err != nil {
 		internal.Logger.Error("Failed to migrate schema for test database")
 		panic(err)
 	}